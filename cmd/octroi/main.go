@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	// Budget windows name time zones; the runtime image has no zoneinfo.
+	_ "time/tzdata"
 
 	"github.com/spf13/cobra"
 )
@@ -15,8 +15,11 @@ import (
 	"github.com/alecgard/octroi/internal/auth"
 	"github.com/alecgard/octroi/internal/config"
 	"github.com/alecgard/octroi/internal/crypto"
+	"github.com/alecgard/octroi/internal/egress"
+	"github.com/alecgard/octroi/internal/health"
 	"github.com/alecgard/octroi/internal/metering"
 	"github.com/alecgard/octroi/internal/metrics"
+	"github.com/alecgard/octroi/internal/notify"
 	"github.com/alecgard/octroi/internal/proxy"
 	"github.com/alecgard/octroi/internal/ratelimit"
 	"github.com/alecgard/octroi/internal/registry"
@@ -66,12 +69,30 @@ func runServe(cmd *cobra.Command, args []string) error {
 		slog.Info("auth_config encryption enabled")
 	}
 
+	egressPolicy, err := egress.New(cfg.Proxy.Egress)
+	if err != nil {
+		return fmt.Errorf("initializing egress policy: %w", err)
+	}
+
 	toolStore := registry.NewStore(pool, cipher)
 	toolService := registry.NewService(toolStore)
+	toolService.SetEgressPolicy(egressPolicy)
+	grantStore := registry.NewGrantStore(pool)
 	agentStore := agent.NewStore(pool)
 	budgetStore := agent.NewBudgetStore(pool)
+	budgetStore.SetReservationTTL(cfg.Budgets.ReservationTTL)
 	meterStore := metering.NewStore(pool)
-	collector := metering.NewCollector(meterStore, cfg.Metering.BatchSize, cfg.Metering.FlushInterval)
+
+	// Budget checks take committed spend from an in-memory ledger fed by the
+	// collector, instead of summing transactions on every request. Until it
+	// loads they fall back to the database.
+	ledger := agent.NewLedger(pool)
+	if err := ledger.Reconcile(ctx); err != nil {
+		slog.Warn("loading spend ledger failed", "error", err)
+	}
+	budgetStore.SetLedger(ledger)
+	go ledger.Start(ctx, cfg.Budgets.ReconcileInterval)
+	collector := metering.NewCollector(ledger.Inserter(meterStore), cfg.Metering.BatchSize, cfg.Metering.FlushInterval)
 
 	// Metrics.
 	m := metrics.New()
@@ -95,6 +116,24 @@ func runServe(cmd *cobra.Command, args []string) error {
 
 	go collector.Start(ctx)
 
+	// Budget warnings: each threshold reached in a budget's window is logged,
+	// counted and sent to the configured notification channels once.
+	var channels []notify.Channel
+	for _, wh := range cfg.Notifications.Webhooks {
+		channels = append(channels, notify.NewWebhook(wh.URL))
+	}
+	notifier := notify.NewDispatcher(channels, cfg.Notifications.Timeout)
+	go notifier.Start(ctx)
+	budgetStore.SetWarningThresholds(cfg.Budgets.WarningThresholds)
+	budgetStore.SetAlertHandler(func(_ context.Context, a agent.BudgetAlert) {
+		slog.Warn("budget threshold reached",
+			"budget", a.Kind, "window", a.Window, "threshold", a.Threshold,
+			"spent", a.Spent, "limit", a.Limit,
+			"agent_id", a.AgentID, "tool_id", a.ToolID, "team", a.Team)
+		m.IncBudgetAlert(a.Kind, a.Threshold)
+		notifier.Dispatch(notify.Event{Type: "budget_threshold", Text: a.String(), Data: a})
+	})
+
 	userStore := user.NewStore(pool)
 
 	// Periodic session cleanup every hour.
@@ -116,15 +155,84 @@ func runServe(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
+	// Reclaim budget reservations abandoned by requests that never finished,
+	// e.g. on a replica that stopped mid-request, and forget budget alerts
+	// from past windows.
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := budgetStore.ReclaimExpired(ctx)
+				if err != nil {
+					slog.Warn("reclaiming budget reservations failed", "error", err)
+				} else if n > 0 {
+					slog.Info("reclaimed expired budget reservations", "count", n)
+				}
+				if _, err := budgetStore.PruneAlerts(ctx); err != nil {
+					slog.Warn("pruning budget alerts failed", "error", err)
+				}
+			}
+		}
+	}()
+
 	limiter := ratelimit.New(cfg.RateLimit.Default, cfg.RateLimit.Window)
+	if cfg.RateLimit.Backend == "postgres" {
+		limiter.SetBackend(ratelimit.NewPostgresBackend(pool))
+	} else {
+		limiter.SetBackend(ratelimit.NewMemoryBackend(cfg.RateLimit.MaxBuckets))
+	}
+	limiter.SetAlgorithm(cfg.RateLimit.Algorithm, cfg.RateLimit.Burst)
+	limiter.SetMetrics(m)
+	go limiter.Start(ctx, time.Duration(cfg.RateLimit.IdleWindows)*cfg.RateLimit.Window)
+
 	authService := auth.NewService(agent.NewAuthAdapter(agentStore))
 
 	toolRateLimitStore := ratelimit.NewToolRateLimitStore(pool)
 	toolRateLimiter := ratelimit.NewToolRateLimiter(toolRateLimitStore, limiter)
+	toolRateLimitStore.OnDelete(toolRateLimiter.ForgetOverride)
+	toolService.OnToolDelete(toolRateLimiter.ForgetTool)
+	agentStore.OnDelete(toolRateLimiter.ForgetAgent)
+	concurrencyStore := ratelimit.NewToolConcurrencyLimitStore(pool)
+	concurrencyLimiter := ratelimit.NewToolConcurrencyLimiter(concurrencyStore, cfg.Proxy.Concurrency.QueueSize, cfg.Proxy.Concurrency.QueueTimeout)
+	concurrencyLimiter.SetMetrics(m)
 
 	proxyHandler := proxy.NewHandler(toolStore, budgetStore, collector, cfg.Proxy.Timeout, cfg.Proxy.MaxRequestSize)
+	proxyHandler.SetStreamIdleTimeout(cfg.Proxy.StreamIdleTimeout)
 	proxyHandler.SetToolRateLimitChecker(toolRateLimiter)
+	proxyHandler.SetConcurrencyLimiter(concurrencyLimiter)
+	proxyHandler.SetToolAccessChecker(grantStore)
 	proxyHandler.SetMetrics(m)
+	proxyHandler.SetEgressPolicy(egressPolicy)
+	if cb := cfg.Proxy.CircuitBreaker; cb.Enabled {
+		proxyHandler.SetCircuitBreaker(proxy.BreakerSettings{
+			FailureRate:    cb.FailureRate,
+			MinRequests:    cb.MinRequests,
+			Window:         cb.Window,
+			OpenDuration:   cb.OpenDuration,
+			HalfOpenProbes: cb.HalfOpenProbes,
+		})
+	}
+	if c := cfg.Proxy.Cache; c.MaxBytes > 0 {
+		proxyHandler.SetResponseCache(proxy.CacheSettings{
+			MaxBytes:      c.MaxBytes,
+			MaxEntryBytes: c.MaxEntryBytes,
+		})
+	}
+	toolService.OnToolChange(proxyHandler.InvalidateTool)
+	toolService.OnToolChange(budgetStore.InvalidateTool)
+
+	// Active health checks of tools with a health_path.
+	healthStore := registry.NewHealthStore(pool)
+	var prober *health.Prober
+	if hc := cfg.HealthChecks; hc.Enabled {
+		prober = health.NewProber(proxyHandler, toolStore, healthStore, hc.Interval, hc.Timeout, hc.Retention)
+		prober.SetMetrics(m)
+		go prober.Start(ctx)
+	}
 
 	router := api.NewRouter(api.RouterDeps{
 		DBPool:             pool,
@@ -139,6 +247,10 @@ func runServe(cmd *cobra.Command, args []string) error {
 		Proxy:              proxyHandler,
 		UserStore:          userStore,
 		ToolRateLimitStore: toolRateLimitStore,
+		ConcurrencyStore:   concurrencyStore,
+		GrantStore:         grantStore,
+		HealthStore:        healthStore,
+		Health:             prober,
 		AllowedOrigins:     cfg.CORS.AllowedOrigins,
 		Metrics:            m,
 	})
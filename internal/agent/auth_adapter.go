@@ -23,9 +23,10 @@ func (a *AuthAdapter) GetByKeyHash(ctx context.Context, hash string) (*auth.Agen
 		return nil, err
 	}
 	return &auth.Agent{
-		ID:        ag.ID,
-		Name:      ag.Name,
-		Team:      ag.Team,
-		RateLimit: ag.RateLimit,
+		ID:              ag.ID,
+		Name:            ag.Name,
+		Team:            ag.Team,
+		RateLimit:       ag.RateLimit,
+		RateLimitWaitMs: ag.RateLimitWaitMs,
 	}, nil
 }
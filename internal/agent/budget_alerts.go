@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alecgard/octroi/internal/budget/window"
+)
+
+// alertRetention is how long raised alerts are remembered. It outlasts the
+// longest budget window, monthly or window.MaxRolling, so an alert is never
+// raised twice in one.
+const alertRetention = 32 * 24 * time.Hour
+
+// BudgetAlert reports that spend against a budget reached a warning threshold
+// for the first time in the budget's current window. Threshold is the
+// threshold reached, which may be below the highest one Spent has reached
+// when several are passed at once.
+type BudgetAlert struct {
+	BudgetUsage
+	AgentID     string    `json:"agent_id"`
+	ToolID      string    `json:"tool_id"`
+	Team        string    `json:"team,omitempty"`
+	WindowStart time.Time `json:"window_start"`
+
+	budget string // names the budget and window in budget_alerts
+}
+
+// String describes the alert for people.
+func (a BudgetAlert) String() string {
+	var whose string
+	switch a.Kind {
+	case BudgetAgent:
+		whose = fmt.Sprintf("agent %s's budget for tool %s", a.AgentID, a.ToolID)
+	case BudgetAgentTotal:
+		whose = fmt.Sprintf("agent %s's budget across all tools", a.AgentID)
+	case BudgetTeam:
+		whose = fmt.Sprintf("team %s's budget", a.Team)
+	default:
+		whose = fmt.Sprintf("tool %s's global budget", a.ToolID)
+	}
+	return fmt.Sprintf("%s has reached %d%% of its %s limit (%.2f of %.2f)", whose, a.Threshold, a.Window, a.Spent, a.Limit)
+}
+
+// alertKey identifies one alert: a threshold of a budget in a window.
+type alertKey struct {
+	budget      string
+	windowStart int64
+	threshold   int
+}
+
+// SetWarningThresholds sets the ascending percentages of a budget's limit at
+// which reservations report a warning and alerts are raised.
+func (s *BudgetStore) SetWarningThresholds(thresholds []int) {
+	s.warningThresholds = thresholds
+}
+
+// SetAlertHandler sets the optional function called with each alert raised.
+// Each alert is raised once across all instances sharing the database.
+func (s *BudgetStore) SetAlertHandler(fn func(context.Context, BudgetAlert)) {
+	s.onAlert = fn
+}
+
+// reachedThreshold returns the highest of thresholds that spent has reached,
+// in percent of limit, or 0 if none.
+func reachedThreshold(thresholds []int, limit, spent float64) int {
+	reached := 0
+	for _, t := range thresholds {
+		if spent*100 >= float64(t)*limit-1e-9 {
+			reached = t
+		}
+	}
+	return reached
+}
+
+// alertWindowStart returns the start of the window holding now that alerts
+// are raised once in. A rolling window has no fixed start, so its alerts are
+// raised once per fixed span of the window's length.
+func alertWindowStart(w window.Window, now time.Time) time.Time {
+	if span, rolling := w.Rolling(); rolling {
+		return now.Truncate(span)
+	}
+	return w.Start(now)
+}
+
+// raiseAlerts raises an alert for each warning threshold up to the one each
+// of pending has reached that has not been raised in its window yet. The
+// alert is claimed in the database first, so only one instance raises it.
+// Failures are logged and retried by the next reservation.
+func (s *BudgetStore) raiseAlerts(ctx context.Context, pending []BudgetAlert) {
+	for _, p := range pending {
+		for _, t := range s.warningThresholds {
+			if t > p.Threshold {
+				break
+			}
+			k := alertKey{budget: p.budget, windowStart: p.WindowStart.Unix(), threshold: t}
+			s.alertMu.Lock()
+			_, done := s.alerted[k]
+			s.alertMu.Unlock()
+			if done {
+				continue
+			}
+
+			tag, err := s.pool.Exec(ctx,
+				`INSERT INTO budget_alerts (budget, window_start, threshold)
+				 VALUES ($1, $2, $3)
+				 ON CONFLICT DO NOTHING`,
+				p.budget, p.WindowStart, t,
+			)
+			if err != nil {
+				slog.Warn("recording budget alert failed", "budget", p.budget, "error", err)
+				continue
+			}
+			s.alertMu.Lock()
+			s.alerted[k] = struct{}{}
+			s.alertMu.Unlock()
+
+			if tag.RowsAffected() == 1 && s.onAlert != nil {
+				a := p
+				a.Threshold = t
+				s.onAlert(ctx, a)
+			}
+		}
+	}
+}
+
+// PruneAlerts forgets alerts raised longer ago than any budget window lasts
+// and returns how many were deleted from the database.
+func (s *BudgetStore) PruneAlerts(ctx context.Context) (int64, error) {
+	cutoff := time.Now().Add(-alertRetention)
+	s.alertMu.Lock()
+	for k := range s.alerted {
+		if k.windowStart < cutoff.Unix() {
+			delete(s.alerted, k)
+		}
+	}
+	s.alertMu.Unlock()
+
+	tag, err := s.pool.Exec(ctx, `DELETE FROM budget_alerts WHERE window_start < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("pruning budget alerts: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/budget/window"
+)
+
+func TestReachedThreshold(t *testing.T) {
+	thresholds := []int{50, 80, 95}
+	tests := []struct {
+		name         string
+		limit, spent float64
+		want         int
+	}{
+		{"below every threshold", 10, 4.99, 0},
+		{"exactly at a threshold", 10, 5, 50},
+		{"between thresholds", 10, 9, 80},
+		{"over the limit", 10, 12, 95},
+		{"rounding at a threshold", 0.3, 0.1 + 0.1 + 0.04, 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reachedThreshold(thresholds, tt.limit, tt.spent); got != tt.want {
+				t.Errorf("reachedThreshold(%v, %v) = %d, want %d", tt.limit, tt.spent, got, tt.want)
+			}
+		})
+	}
+	if got := reachedThreshold(nil, 10, 10); got != 0 {
+		t.Errorf("expected no threshold without thresholds, got %d", got)
+	}
+}
+
+func TestBudgetAlertString(t *testing.T) {
+	a := BudgetAlert{
+		BudgetUsage: BudgetUsage{Kind: BudgetTeam, Window: "monthly", Limit: 50, Spent: 41.2, Threshold: 80},
+		Team:        "ml",
+	}
+	if got, want := a.String(), "team ml's budget has reached 80% of its monthly limit (41.20 of 50.00)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAlertWindowStart(t *testing.T) {
+	rolling, err := window.Parse("rolling:24h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	morning, evening := time.Date(2024, 3, 15, 1, 0, 0, 0, time.UTC), time.Date(2024, 3, 15, 23, 0, 0, 0, time.UTC)
+	if a, b := alertWindowStart(rolling, morning), alertWindowStart(rolling, evening); !a.Equal(b) {
+		t.Errorf("rolling: expected one alert window a day, got %v and %v", a, b)
+	}
+	if a, b := alertWindowStart(rolling, evening), alertWindowStart(rolling, evening.Add(2*time.Hour)); a.Equal(b) {
+		t.Errorf("rolling: expected a new alert window the next day, got %v for both", a)
+	}
+
+	weekly, err := window.Parse("weekly@Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := alertWindowStart(weekly, evening), weekly.Start(evening); !got.Equal(want) {
+		t.Errorf("weekly: got %v, want the window's start %v", got, want)
+	}
+}
+
+// TestBudgetAlertsRaisedOnce checks that each threshold raises one alert per
+// window however many replicas see it reached.
+func TestBudgetAlertsRaisedOnce(t *testing.T) {
+	pool := openTestPool(t)
+	ctx := context.Background()
+	agentID, toolID := insertBudgetFixture(t, pool)
+
+	var mu sync.Mutex
+	var alerts []BudgetAlert
+	var replicas []*BudgetStore
+	for i := 0; i < 2; i++ {
+		s := NewBudgetStore(pool)
+		s.SetWarningThresholds([]int{50, 80, 95})
+		s.SetAlertHandler(func(_ context.Context, a BudgetAlert) {
+			mu.Lock()
+			defer mu.Unlock()
+			alerts = append(alerts, a)
+		})
+		replicas = append(replicas, s)
+	}
+	if _, err := replicas[0].SetAgentBudget(ctx, AgentBudget{AgentID: agentID, DailyLimit: 1}); err != nil {
+		t.Fatalf("setting agent budget: %v", err)
+	}
+
+	for i, estimate := range []float64{0.4, 0.2, 0.3, 0.01} {
+		res, err := replicas[i%2].Reserve(ctx, agentID, toolID, estimate)
+		if err != nil || res.Exceeded != "" {
+			t.Fatalf("reserving %v: %q %v", estimate, res.Exceeded, err)
+		}
+	}
+
+	// 0.6 reaches 50%, 0.9 reaches 80%, and 0.91 reaches nothing new.
+	if len(alerts) != 2 || alerts[0].Threshold != 50 || alerts[1].Threshold != 80 {
+		t.Fatalf("expected alerts at 50%% and 80%%, got %+v", alerts)
+	}
+	if a := alerts[1]; a.Kind != BudgetAgentTotal || a.Window != "daily" || a.AgentID != agentID {
+		t.Errorf("unexpected alert: %+v", a)
+	}
+	t.Cleanup(func() {
+		pool.Exec(ctx, `DELETE FROM budget_alerts WHERE budget LIKE $1`, "agent:"+agentID+"%")
+	})
+}
@@ -2,36 +2,97 @@ package agent
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/alecgard/octroi/internal/budget/window"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// BudgetStore provides database operations for agent-tool budgets.
+// DefaultReservationTTL is how long a budget reservation is held when no TTL
+// is configured.
+const DefaultReservationTTL = 5 * time.Minute
+
+// BudgetStore provides database operations for agent, team and tool budgets.
 type BudgetStore struct {
-	pool *pgxpool.Pool
+	pool           *pgxpool.Pool
+	reservationTTL time.Duration
+	ledger         *Ledger
+	// replica tags the reservations this instance makes, so its ledger can
+	// tell them from other replicas'.
+	replica string
+
+	warningThresholds []int
+	onAlert           func(context.Context, BudgetAlert)
+	alertMu           sync.Mutex
+	alerted           map[alertKey]struct{} // alerts this instance knows were raised
+
+	// noBudgets caches the agent and tool pairs no budget applies to, so
+	// Reserve can skip the database for them. Any budget or tool change
+	// through this instance empties it and bumps noBudgetsGen.
+	noBudgetsMu  sync.Mutex
+	noBudgets    map[noBudgetKey]time.Time // -> when the entry expires
+	noBudgetsGen uint64
+}
+
+// noBudgetKey names an agent calling a tool.
+type noBudgetKey struct {
+	agentID string
+	toolID  string
 }
 
+// noBudgetTTL bounds how long Reserve trusts that no budget applies to an
+// agent and tool. Budgets set through this instance apply at once; those set
+// on another replica, or gained by an agent moving team, within the TTL.
+const noBudgetTTL = 10 * time.Second
+
+// maxNoBudgets caps the no-budget cache, which is emptied when it fills.
+const maxNoBudgets = 100_000
+
 // NewBudgetStore creates a new budget store backed by the given connection pool.
 func NewBudgetStore(pool *pgxpool.Pool) *BudgetStore {
-	return &BudgetStore{pool: pool}
+	return &BudgetStore{
+		pool:           pool,
+		reservationTTL: DefaultReservationTTL,
+		replica:        rand.Text(),
+		alerted:        make(map[alertKey]struct{}),
+		noBudgets:      make(map[noBudgetKey]time.Time),
+	}
+}
+
+// SetLedger sets the optional spend ledger budget checks take committed spend
+// from instead of summing transactions. The ledger must only be fed by this
+// instance's collector.
+func (s *BudgetStore) SetLedger(l *Ledger) {
+	s.ledger = l
+}
+
+// SetReservationTTL sets how long a reservation is held before it is treated
+// as abandoned. It should outlast the slowest request plus a metering flush.
+func (s *BudgetStore) SetReservationTTL(ttl time.Duration) {
+	s.reservationTTL = ttl
 }
 
 // Set upserts a budget for the given agent/tool combination.
 func (s *BudgetStore) Set(ctx context.Context, in CreateBudgetInput) (*Budget, error) {
 	b := &Budget{}
 	err := s.pool.QueryRow(ctx,
-		`INSERT INTO agent_tool_budgets (agent_id, tool_id, daily_limit, monthly_limit)
-		 VALUES ($1, $2, $3, $4)
+		`INSERT INTO agent_tool_budgets (agent_id, tool_id, daily_limit, monthly_limit, window_limit, budget_window)
+		 VALUES ($1, $2, $3, $4, $5, $6)
 		 ON CONFLICT (agent_id, tool_id)
-		 DO UPDATE SET daily_limit = EXCLUDED.daily_limit, monthly_limit = EXCLUDED.monthly_limit
-		 RETURNING id, agent_id, tool_id, daily_limit, monthly_limit`,
-		in.AgentID, in.ToolID, in.DailyLimit, in.MonthlyLimit,
-	).Scan(&b.ID, &b.AgentID, &b.ToolID, &b.DailyLimit, &b.MonthlyLimit)
+		 DO UPDATE SET daily_limit = EXCLUDED.daily_limit, monthly_limit = EXCLUDED.monthly_limit,
+		               window_limit = EXCLUDED.window_limit, budget_window = EXCLUDED.budget_window
+		 RETURNING id, agent_id, tool_id, daily_limit, monthly_limit, window_limit, budget_window`,
+		in.AgentID, in.ToolID, in.DailyLimit, in.MonthlyLimit, in.WindowLimit, in.BudgetWindow,
+	).Scan(&b.ID, &b.AgentID, &b.ToolID, &b.DailyLimit, &b.MonthlyLimit, &b.WindowLimit, &b.BudgetWindow)
 	if err != nil {
 		return nil, fmt.Errorf("upserting budget: %w", err)
 	}
+	s.forgetNoBudgets()
 	return b, nil
 }
 
@@ -39,11 +100,11 @@ func (s *BudgetStore) Set(ctx context.Context, in CreateBudgetInput) (*Budget, e
 func (s *BudgetStore) Get(ctx context.Context, agentID, toolID string) (*Budget, error) {
 	b := &Budget{}
 	err := s.pool.QueryRow(ctx,
-		`SELECT id, agent_id, tool_id, daily_limit, monthly_limit
+		`SELECT id, agent_id, tool_id, daily_limit, monthly_limit, window_limit, budget_window
 		 FROM agent_tool_budgets
 		 WHERE agent_id = $1 AND tool_id = $2`,
 		agentID, toolID,
-	).Scan(&b.ID, &b.AgentID, &b.ToolID, &b.DailyLimit, &b.MonthlyLimit)
+	).Scan(&b.ID, &b.AgentID, &b.ToolID, &b.DailyLimit, &b.MonthlyLimit, &b.WindowLimit, &b.BudgetWindow)
 	if err != nil {
 		return nil, fmt.Errorf("getting budget: %w", err)
 	}
@@ -53,7 +114,7 @@ func (s *BudgetStore) Get(ctx context.Context, agentID, toolID string) (*Budget,
 // ListByAgent returns all budgets for the given agent.
 func (s *BudgetStore) ListByAgent(ctx context.Context, agentID string) ([]*Budget, error) {
 	rows, err := s.pool.Query(ctx,
-		`SELECT id, agent_id, tool_id, daily_limit, monthly_limit
+		`SELECT id, agent_id, tool_id, daily_limit, monthly_limit, window_limit, budget_window
 		 FROM agent_tool_budgets
 		 WHERE agent_id = $1
 		 ORDER BY tool_id`,
@@ -67,7 +128,7 @@ func (s *BudgetStore) ListByAgent(ctx context.Context, agentID string) ([]*Budge
 	var budgets []*Budget
 	for rows.Next() {
 		b := &Budget{}
-		if err := rows.Scan(&b.ID, &b.AgentID, &b.ToolID, &b.DailyLimit, &b.MonthlyLimit); err != nil {
+		if err := rows.Scan(&b.ID, &b.AgentID, &b.ToolID, &b.DailyLimit, &b.MonthlyLimit, &b.WindowLimit, &b.BudgetWindow); err != nil {
 			return nil, fmt.Errorf("scanning budget row: %w", err)
 		}
 		budgets = append(budgets, b)
@@ -90,113 +151,526 @@ func (s *BudgetStore) Delete(ctx context.Context, agentID, toolID string) error
 	return nil
 }
 
-// CheckBudget verifies whether the agent is within its daily and monthly budget
-// for the given tool. A limit of 0 means unlimited. It returns whether the
-// request is allowed, plus the remaining daily and monthly amounts.
-func (s *BudgetStore) CheckBudget(ctx context.Context, agentID, toolID string) (allowed bool, remainingDaily float64, remainingMonthly float64, err error) {
-	budget, err := s.Get(ctx, agentID, toolID)
+// SetAgentBudget upserts an agent's budget across all tools.
+func (s *BudgetStore) SetAgentBudget(ctx context.Context, in AgentBudget) (*AgentBudget, error) {
+	b := &AgentBudget{}
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO agent_budgets (agent_id, daily_limit, monthly_limit, window_limit, budget_window)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (agent_id)
+		 DO UPDATE SET daily_limit = EXCLUDED.daily_limit, monthly_limit = EXCLUDED.monthly_limit,
+		               window_limit = EXCLUDED.window_limit, budget_window = EXCLUDED.budget_window
+		 RETURNING agent_id, daily_limit, monthly_limit, window_limit, budget_window`,
+		in.AgentID, in.DailyLimit, in.MonthlyLimit, in.WindowLimit, in.BudgetWindow,
+	).Scan(&b.AgentID, &b.DailyLimit, &b.MonthlyLimit, &b.WindowLimit, &b.BudgetWindow)
 	if err != nil {
-		return false, 0, 0, fmt.Errorf("checking budget: %w", err)
+		return nil, fmt.Errorf("upserting agent budget: %w", err)
 	}
+	s.forgetNoBudgets()
+	return b, nil
+}
 
-	now := time.Now().UTC()
-	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+// GetAgentBudget retrieves an agent's budget across all tools.
+func (s *BudgetStore) GetAgentBudget(ctx context.Context, agentID string) (*AgentBudget, error) {
+	b := &AgentBudget{}
+	err := s.pool.QueryRow(ctx,
+		`SELECT agent_id, daily_limit, monthly_limit, window_limit, budget_window FROM agent_budgets WHERE agent_id = $1`,
+		agentID,
+	).Scan(&b.AgentID, &b.DailyLimit, &b.MonthlyLimit, &b.WindowLimit, &b.BudgetWindow)
+	if err != nil {
+		return nil, fmt.Errorf("getting agent budget: %w", err)
+	}
+	return b, nil
+}
+
+// DeleteAgentBudget removes an agent's budget across all tools.
+func (s *BudgetStore) DeleteAgentBudget(ctx context.Context, agentID string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM agent_budgets WHERE agent_id = $1`, agentID)
+	if err != nil {
+		return fmt.Errorf("deleting agent budget: %w", err)
+	}
+	return nil
+}
+
+// SetTeamBudget upserts a team's budget.
+func (s *BudgetStore) SetTeamBudget(ctx context.Context, in TeamBudget) (*TeamBudget, error) {
+	b := &TeamBudget{}
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO team_budgets (team, daily_limit, monthly_limit, window_limit, budget_window)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (team)
+		 DO UPDATE SET daily_limit = EXCLUDED.daily_limit, monthly_limit = EXCLUDED.monthly_limit,
+		               window_limit = EXCLUDED.window_limit, budget_window = EXCLUDED.budget_window
+		 RETURNING team, daily_limit, monthly_limit, window_limit, budget_window`,
+		in.Team, in.DailyLimit, in.MonthlyLimit, in.WindowLimit, in.BudgetWindow,
+	).Scan(&b.Team, &b.DailyLimit, &b.MonthlyLimit, &b.WindowLimit, &b.BudgetWindow)
+	if err != nil {
+		return nil, fmt.Errorf("upserting team budget: %w", err)
+	}
+	s.forgetNoBudgets()
+	return b, nil
+}
 
-	var dailySpend, monthlySpend float64
+// GetTeamBudget retrieves a team's budget.
+func (s *BudgetStore) GetTeamBudget(ctx context.Context, team string) (*TeamBudget, error) {
+	b := &TeamBudget{}
+	err := s.pool.QueryRow(ctx,
+		`SELECT team, daily_limit, monthly_limit, window_limit, budget_window FROM team_budgets WHERE team = $1`,
+		team,
+	).Scan(&b.Team, &b.DailyLimit, &b.MonthlyLimit, &b.WindowLimit, &b.BudgetWindow)
+	if err != nil {
+		return nil, fmt.Errorf("getting team budget: %w", err)
+	}
+	return b, nil
+}
 
-	err = s.pool.QueryRow(ctx,
-		`SELECT COALESCE(SUM(cost), 0)
-		 FROM transactions
-		 WHERE agent_id = $1 AND tool_id = $2 AND timestamp >= $3`,
-		agentID, toolID, startOfDay,
-	).Scan(&dailySpend)
+// ListTeamBudgets returns every team's budget.
+func (s *BudgetStore) ListTeamBudgets(ctx context.Context) ([]*TeamBudget, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT team, daily_limit, monthly_limit, window_limit, budget_window FROM team_budgets ORDER BY team`,
+	)
 	if err != nil {
-		return false, 0, 0, fmt.Errorf("summing daily spend: %w", err)
+		return nil, fmt.Errorf("listing team budgets: %w", err)
 	}
+	defer rows.Close()
 
-	err = s.pool.QueryRow(ctx,
-		`SELECT COALESCE(SUM(cost), 0)
-		 FROM transactions
-		 WHERE agent_id = $1 AND tool_id = $2 AND timestamp >= $3`,
-		agentID, toolID, startOfMonth,
-	).Scan(&monthlySpend)
+	var budgets []*TeamBudget
+	for rows.Next() {
+		b := &TeamBudget{}
+		if err := rows.Scan(&b.Team, &b.DailyLimit, &b.MonthlyLimit, &b.WindowLimit, &b.BudgetWindow); err != nil {
+			return nil, fmt.Errorf("scanning team budget row: %w", err)
+		}
+		budgets = append(budgets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating team budget rows: %w", err)
+	}
+	return budgets, nil
+}
+
+// DeleteTeamBudget removes a team's budget.
+func (s *BudgetStore) DeleteTeamBudget(ctx context.Context, team string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM team_budgets WHERE team = $1`, team)
 	if err != nil {
-		return false, 0, 0, fmt.Errorf("summing monthly spend: %w", err)
+		return fmt.Errorf("deleting team budget: %w", err)
 	}
+	return nil
+}
 
-	allowed = true
+// rowQuerier is satisfied by both the pool and a transaction.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// ledgerMargin is how far before a ledger snapshot reservations committed by
+// other replicas still count. A flush marks its reservations committed just
+// before its database transaction commits, so a snapshot taken in between
+// misses transactions whose reservations look committed before it.
+const ledgerMargin = 10 * time.Second
 
-	if budget.DailyLimit > 0 {
-		remainingDaily = budget.DailyLimit - dailySpend
-		if remainingDaily < 0 {
-			remainingDaily = 0
+// spent returns the spend under k in the window holding now: the cost of
+// committed transactions plus the amounts held by reservations for requests
+// whose transactions have not been written yet.
+func (s *BudgetStore) spent(ctx context.Context, q rowQuerier, k ledgerKey, w window.Window, now time.Time) (float64, error) {
+	var conds []string
+	var args []any
+	if k.toolID != "" {
+		args = append(args, k.toolID)
+		conds = append(conds, fmt.Sprintf("tool_id = $%d", len(args)))
+	}
+	if k.agentID != "" {
+		args = append(args, k.agentID)
+		conds = append(conds, fmt.Sprintf("agent_id = $%d", len(args)))
+	}
+	return s.spentWhere(ctx, q, strings.Join(conds, " AND "), args, w, now, func() (float64, error) {
+		return s.ledger.spend(k, w, now), nil
+	})
+}
+
+// teamSpent returns the spend by the agents now in team across all tools in
+// the window holding now, as spent does. An agent's spend moves with it when
+// it changes team.
+func (s *BudgetStore) teamSpent(ctx context.Context, q rowQuerier, team string, w window.Window, now time.Time) (float64, error) {
+	cond := "agent_id IN (SELECT id FROM agents WHERE team = $1)"
+	return s.spentWhere(ctx, q, cond, []any{team}, w, now, func() (float64, error) {
+		var agentIDs []string
+		err := q.QueryRow(ctx,
+			`SELECT COALESCE(array_agg(id::text), '{}') FROM agents WHERE team = $1`, team,
+		).Scan(&agentIDs)
+		if err != nil {
+			return 0, err
 		}
-		if dailySpend >= budget.DailyLimit {
-			allowed = false
+		var total float64
+		for _, id := range agentIDs {
+			total += s.ledger.spend(ledgerKey{agentID: id}, w, now)
 		}
-	}
+		return total, nil
+	})
+}
+
+// spentWhere returns the spend by the transactions and reservations matching
+// cond, over args, in the window holding now.
+//
+// Committed spend comes from ledgerSpend while the ledger is fresh and keeps
+// the window. The ledger already has the transactions this instance wrote,
+// but those other replicas wrote since its snapshot are only known by their
+// committed reservations, which are counted instead. The ledger's hourly
+// totals miss the part-hour a rolling window starts with, which is summed
+// from the transactions table, a scan of under an hour. Without a fresh
+// ledger, or for windows it does not keep yet, committed spend is summed from
+// the transactions table in the same statement as the reservations, so a
+// flush that commits a reservation is never counted twice or not at all.
+func (s *BudgetStore) spentWhere(ctx context.Context, q rowQuerier, cond string, args []any, w window.Window, now time.Time, ledgerSpend func() (float64, error)) (float64, error) {
+	n := len(args)
+	args = append(args, w.Start(now))
 
-	if budget.MonthlyLimit > 0 {
-		remainingMonthly = budget.MonthlyLimit - monthlySpend
-		if remainingMonthly < 0 {
-			remainingMonthly = 0
+	if snapshot, ok := s.ledgerSnapshot(); ok && s.ledger.keeps(w) {
+		var uncounted float64
+		err := q.QueryRow(ctx, fmt.Sprintf(
+			`SELECT
+				(SELECT COALESCE(SUM(amount), 0) FROM budget_reservations
+				 WHERE %[1]s AND created_at >= $%[2]d
+				   AND ((committed_at IS NULL AND expires_at > now())
+				        OR (committed_at >= $%[3]d AND replica <> $%[4]d)))
+				+ (SELECT COALESCE(SUM(cost), 0) FROM transactions
+				 WHERE %[1]s AND timestamp >= $%[2]d AND timestamp < $%[5]d)`,
+			cond, n+1, n+2, n+3, n+4), append(args, snapshot.Add(-ledgerMargin), s.replica, ledgerFrom(w, now))...,
+		).Scan(&uncounted)
+		if err != nil {
+			return 0, err
 		}
-		if monthlySpend >= budget.MonthlyLimit {
-			allowed = false
+		// Read the ledger after the reservations, so a flush in between is
+		// counted twice rather than not at all.
+		committed, err := ledgerSpend()
+		if err != nil {
+			return 0, err
 		}
+		return uncounted + committed, nil
+	}
+
+	var spend float64
+	err := q.QueryRow(ctx, fmt.Sprintf(
+		`SELECT
+			(SELECT COALESCE(SUM(cost), 0) FROM transactions
+			 WHERE %[1]s AND timestamp >= $%[2]d)
+			+ (SELECT COALESCE(SUM(amount), 0) FROM budget_reservations
+			 WHERE %[1]s AND created_at >= $%[2]d AND committed_at IS NULL AND expires_at > now())`,
+		cond, n+1), args...,
+	).Scan(&spend)
+	if err != nil {
+		return 0, err
+	}
+	return spend, nil
+}
+
+// ledgerSnapshot returns the database time of the ledger's last reconcile if
+// there is a ledger and it is fresh. A ledger that has not reconciled for
+// close to the reservation TTL may be missing spend whose committed
+// reservations have already been reclaimed.
+func (s *BudgetStore) ledgerSnapshot() (time.Time, bool) {
+	if s.ledger == nil {
+		return time.Time{}, false
 	}
+	snapshot, reconciled := s.ledger.state()
+	if reconciled.IsZero() || time.Since(reconciled) > s.reservationTTL-ledgerMargin {
+		return time.Time{}, false
+	}
+	return snapshot, true
+}
+
+// hasRoom reports whether a budget of limit with spend already counted
+// against it can take a further estimate. A limit of 0 means unlimited. An
+// exhausted budget has no room even for a zero estimate. The tolerance
+// absorbs float rounding of the database's six decimal places.
+func hasRoom(limit, spend, estimate float64) bool {
+	if limit <= 0 {
+		return true
+	}
+	return spend < limit && spend+estimate <= limit+1e-9
+}
+
+// Budget kinds named when a reservation is refused.
+const (
+	BudgetAgent      = "agent"       // the agent's budget for the tool
+	BudgetAgentTotal = "agent_total" // the agent's budget across all tools
+	BudgetTeam       = "team"        // the agent's team's budget
+	BudgetGlobal     = "global"      // the tool's budget across all agents
+)
 
-	return allowed, remainingDaily, remainingMonthly, nil
+// budgetLimit is one limit a reservation must fit within.
+type budgetLimit struct {
+	kind   string // named as exceeded when there is no room
+	lock   string // advisory lock serialising reservations against it
+	limit  float64
+	window window.Window
+	spent  spendFunc
 }
 
-// CheckToolGlobalBudget checks whether the total spend for a tool across all
-// agents is within the tool's configured budget_limit and budget_window.
-func (s *BudgetStore) CheckToolGlobalBudget(ctx context.Context, toolID string) (allowed bool, remaining float64, err error) {
-	var budgetLimit float64
-	var budgetWindow string
+// spendFunc returns the spend a limit counts in the window holding now.
+type spendFunc func(ctx context.Context, q rowQuerier, w window.Window, now time.Time) (float64, error)
 
-	err = s.pool.QueryRow(ctx,
-		`SELECT budget_limit, budget_window FROM tools WHERE id = $1`,
-		toolID,
-	).Scan(&budgetLimit, &budgetWindow)
+// limitsFor returns the limits that apply to agentID calling toolID, in the
+// order their locks are taken: the agent's budget for the tool, the agent's
+// budget across tools, its team's budget, then the tool's global budget.
+// Limits of 0 are left out. It also returns the agent's team.
+func (s *BudgetStore) limitsFor(ctx context.Context, q rowQuerier, agentID, toolID string) (limits []budgetLimit, team string, err error) {
+	var daily, monthly, agentDaily, agentMonthly, teamDaily, teamMonthly, global float64
+	var windowed, agentWindowed, teamWindowed float64
+	var toolWindow, agentWindow, teamWindow, globalWindow string
+	err = q.QueryRow(ctx,
+		`SELECT COALESCE(b.daily_limit, 0), COALESCE(b.monthly_limit, 0),
+		        COALESCE(b.window_limit, 0), COALESCE(b.budget_window, ''),
+		        COALESCE(ab.daily_limit, 0), COALESCE(ab.monthly_limit, 0),
+		        COALESCE(ab.window_limit, 0), COALESCE(ab.budget_window, ''),
+		        COALESCE(a.team, ''), COALESCE(tb.daily_limit, 0), COALESCE(tb.monthly_limit, 0),
+		        COALESCE(tb.window_limit, 0), COALESCE(tb.budget_window, ''),
+		        COALESCE(t.budget_limit, 0), COALESCE(t.budget_window, '')
+		 FROM tools t
+		 JOIN agents a ON a.id = $1
+		 LEFT JOIN agent_tool_budgets b ON b.tool_id = t.id AND b.agent_id = a.id
+		 LEFT JOIN agent_budgets ab ON ab.agent_id = a.id
+		 LEFT JOIN team_budgets tb ON tb.team = a.team
+		 WHERE t.id = $2`,
+		agentID, toolID,
+	).Scan(&daily, &monthly, &windowed, &toolWindow,
+		&agentDaily, &agentMonthly, &agentWindowed, &agentWindow,
+		&team, &teamDaily, &teamMonthly, &teamWindowed, &teamWindow,
+		&global, &globalWindow)
 	if err != nil {
-		return false, 0, fmt.Errorf("getting tool budget config: %w", err)
+		return nil, "", fmt.Errorf("getting budget limits: %w", err)
 	}
 
-	// A limit of 0 means unlimited.
-	if budgetLimit == 0 {
-		return true, 0, nil
+	agentTool := func(ctx context.Context, q rowQuerier, w window.Window, now time.Time) (float64, error) {
+		return s.spent(ctx, q, ledgerKey{agentID: agentID, toolID: toolID}, w, now)
+	}
+	agentTotal := func(ctx context.Context, q rowQuerier, w window.Window, now time.Time) (float64, error) {
+		return s.spent(ctx, q, ledgerKey{agentID: agentID}, w, now)
+	}
+	teamTotal := func(ctx context.Context, q rowQuerier, w window.Window, now time.Time) (float64, error) {
+		return s.teamSpent(ctx, q, team, w, now)
+	}
+	toolTotal := func(ctx context.Context, q rowQuerier, w window.Window, now time.Time) (float64, error) {
+		return s.spent(ctx, q, ledgerKey{toolID: toolID}, w, now)
+	}
+	all := []struct {
+		kind, lock string
+		limit      float64
+		window     string
+		spent      spendFunc
+	}{
+		{BudgetAgent, "agent:" + agentID + ":" + toolID, daily, "daily", agentTool},
+		{BudgetAgent, "agent:" + agentID + ":" + toolID, monthly, "monthly", agentTool},
+		{BudgetAgent, "agent:" + agentID + ":" + toolID, windowed, toolWindow, agentTool},
+		{BudgetAgentTotal, "agent:" + agentID, agentDaily, "daily", agentTotal},
+		{BudgetAgentTotal, "agent:" + agentID, agentMonthly, "monthly", agentTotal},
+		{BudgetAgentTotal, "agent:" + agentID, agentWindowed, agentWindow, agentTotal},
+		{BudgetTeam, "team:" + team, teamDaily, "daily", teamTotal},
+		{BudgetTeam, "team:" + team, teamMonthly, "monthly", teamTotal},
+		{BudgetTeam, "team:" + team, teamWindowed, teamWindow, teamTotal},
+		{BudgetGlobal, "tool:" + toolID, global, globalWindow, toolTotal},
+	}
+	for _, l := range all {
+		if l.limit <= 0 || (l.kind == BudgetTeam && team == "") {
+			continue
+		}
+		w, err := window.Parse(l.window)
+		if err != nil {
+			return nil, "", fmt.Errorf("getting %s budget limits: %w", l.kind, err)
+		}
+		limits = append(limits, budgetLimit{l.kind, l.lock, l.limit, w, l.spent})
 	}
+	return limits, team, nil
+}
+
+// Reservation is the outcome of Reserve.
+type Reservation struct {
+	// ID identifies the spend held, or is "" if no budget has a limit or one
+	// had no room.
+	ID string
+	// Exceeded names the kind of budget that had no room (BudgetAgent,
+	// BudgetAgentTotal, BudgetTeam or BudgetGlobal), or is "".
+	Exceeded string
+	// Budgets are the limits the spend is held against, with what has been
+	// spent against each including the reservation.
+	Budgets []BudgetUsage
+}
 
-	now := time.Now().UTC()
-	var windowStart time.Time
-	switch budgetWindow {
-	case "daily":
-		windowStart = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
-	case "monthly":
-		windowStart = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
-	default:
-		// Default to monthly if window is unrecognized.
-		windowStart = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+// BudgetUsage is the spend against one budget limit.
+type BudgetUsage struct {
+	Kind   string  `json:"budget"` // BudgetAgent, BudgetAgentTotal, BudgetTeam or BudgetGlobal
+	Window string  `json:"window"` // e.g. "daily", "monthly" or "rolling:24h"
+	Limit  float64 `json:"limit"`
+	Spent  float64 `json:"spent"`
+	// Threshold is the highest warning threshold, in percent of Limit, that
+	// Spent has reached, or 0 if none.
+	Threshold int `json:"threshold"`
+}
+
+// Reserve checks every budget that applies to the agent calling the tool —
+// the agent's for the tool, the agent's across tools, its team's and the
+// tool's global budget — and, if all have room for estimate on top of
+// committed and reserved spend, holds estimate against them until the
+// request's transaction is written, the reservation is released, or it
+// expires. When a budget has no room, the reservation's Exceeded names its
+// kind and nothing is held. Warning thresholds reached for the first time
+// in a window raise alerts.
+//
+// Reservations against the same budget are serialised with transaction-level
+// advisory locks, so concurrent requests on any replica cannot both take the
+// last of a budget. When no budget applies, Reserve holds nothing and skips
+// the transaction, and remembers so for a while.
+func (s *BudgetStore) Reserve(ctx context.Context, agentID, toolID string, estimate float64) (Reservation, error) {
+	key := noBudgetKey{agentID: agentID, toolID: toolID}
+	gen, known := s.knownNoBudgets(key)
+	if known {
+		return Reservation{}, nil
+	}
+	limits, team, err := s.limitsFor(ctx, s.pool, agentID, toolID)
+	if err != nil {
+		return Reservation{}, fmt.Errorf("reserving budget: %w", err)
+	}
+	if len(limits) == 0 {
+		s.cacheNoBudgets(key, gen)
+		return Reservation{}, nil
 	}
 
-	var totalSpend float64
-	err = s.pool.QueryRow(ctx,
-		`SELECT COALESCE(SUM(cost), 0)
-		 FROM transactions
-		 WHERE tool_id = $1 AND timestamp >= $2`,
-		toolID, windowStart,
-	).Scan(&totalSpend)
+	var res Reservation
+	var alerts []BudgetAlert
+	err = pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Locks are always taken in the order limitsFor returns them, so two
+		// reservations never wait on each other's locks.
+		now := time.Now().UTC()
+		var locked string
+		for _, l := range limits {
+			if l.lock != locked {
+				if err := lockBudget(ctx, tx, l.lock); err != nil {
+					return err
+				}
+				locked = l.lock
+			}
+			spend, err := l.spent(ctx, tx, l.window, now)
+			if err != nil {
+				return fmt.Errorf("summing %s spend: %w", l.kind, err)
+			}
+			if !hasRoom(l.limit, spend, estimate) {
+				res.Exceeded = l.kind
+				return nil
+			}
+			usage := BudgetUsage{
+				Kind:      l.kind,
+				Window:    l.window.String(),
+				Limit:     l.limit,
+				Spent:     spend + estimate,
+				Threshold: reachedThreshold(s.warningThresholds, l.limit, spend+estimate),
+			}
+			res.Budgets = append(res.Budgets, usage)
+			if usage.Threshold > 0 {
+				alerts = append(alerts, BudgetAlert{
+					BudgetUsage: usage,
+					AgentID:     agentID,
+					ToolID:      toolID,
+					Team:        team,
+					WindowStart: alertWindowStart(l.window, now),
+					budget:      l.lock + ":" + usage.Window,
+				})
+			}
+		}
+
+		err = tx.QueryRow(ctx,
+			`INSERT INTO budget_reservations (agent_id, tool_id, amount, expires_at, replica)
+			 VALUES ($1, $2, $3, now() + $4::interval, $5)
+			 RETURNING id`,
+			agentID, toolID, estimate, s.reservationTTL, s.replica,
+		).Scan(&res.ID)
+		if err != nil {
+			return fmt.Errorf("inserting reservation: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return false, 0, fmt.Errorf("summing tool global spend: %w", err)
+		return Reservation{}, fmt.Errorf("reserving budget: %w", err)
+	}
+	if res.Exceeded == "" {
+		s.raiseAlerts(ctx, alerts)
 	}
+	return res, nil
+}
 
-	remaining = budgetLimit - totalSpend
-	if remaining < 0 {
-		remaining = 0
+// knownNoBudgets reports whether k is cached as having no budget, and returns
+// the cache generation to pass to cacheNoBudgets otherwise.
+func (s *BudgetStore) knownNoBudgets(k noBudgetKey) (gen uint64, known bool) {
+	s.noBudgetsMu.Lock()
+	defer s.noBudgetsMu.Unlock()
+	expires, ok := s.noBudgets[k]
+	if ok && time.Now().Before(expires) {
+		return s.noBudgetsGen, true
+	}
+	if ok {
+		delete(s.noBudgets, k)
 	}
+	return s.noBudgetsGen, false
+}
+
+// cacheNoBudgets caches k as having no budget, unless the cache has been
+// emptied since gen was read, when the limits may predate the change.
+func (s *BudgetStore) cacheNoBudgets(k noBudgetKey, gen uint64) {
+	s.noBudgetsMu.Lock()
+	defer s.noBudgetsMu.Unlock()
+	if gen != s.noBudgetsGen {
+		return
+	}
+	if len(s.noBudgets) >= maxNoBudgets {
+		clear(s.noBudgets)
+	}
+	s.noBudgets[k] = time.Now().Add(noBudgetTTL)
+}
+
+// forgetNoBudgets empties the no-budget cache after a budget is set.
+func (s *BudgetStore) forgetNoBudgets() {
+	s.noBudgetsMu.Lock()
+	defer s.noBudgetsMu.Unlock()
+	clear(s.noBudgets)
+	s.noBudgetsGen++
+}
 
-	allowed = totalSpend < budgetLimit
-	return allowed, remaining, nil
+// InvalidateTool forgets which agents have no budget for toolID, whose
+// global budget may have changed. It is called when the tool is updated or
+// deleted.
+func (s *BudgetStore) InvalidateTool(toolID string) {
+	s.forgetNoBudgets()
+}
+
+// lockBudget takes a transaction-level advisory lock on the named budget.
+func lockBudget(ctx context.Context, tx pgx.Tx, name string) error {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, "octroi_budget:"+name); err != nil {
+		return fmt.Errorf("locking budget: %w", err)
+	}
+	return nil
+}
+
+// Release drops a reservation for a request that will not be metered.
+func (s *BudgetStore) Release(ctx context.Context, id string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM budget_reservations WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("releasing reservation: %w", err)
+	}
+	return nil
+}
+
+// ReclaimExpired deletes reservations past their expiry, e.g. those left by
+// a replica that stopped mid-request, and committed reservations older than
+// the TTL, by when every replica's ledger has reconciled past them. It
+// returns how many were deleted. Expired reservations are already ignored by
+// budget checks.
+func (s *BudgetStore) ReclaimExpired(ctx context.Context) (int64, error) {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM budget_reservations
+		 WHERE (committed_at IS NULL AND expires_at <= now())
+		    OR committed_at <= now() - $1::interval`,
+		s.reservationTTL,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("reclaiming expired reservations: %w", err)
+	}
+	return tag.RowsAffected(), nil
 }
@@ -0,0 +1,309 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/metering"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestHasRoom(t *testing.T) {
+	tests := []struct {
+		name                   string
+		limit, spend, estimate float64
+		want                   bool
+	}{
+		{"unlimited", 0, 100, 5, true},
+		{"room for the estimate", 1, 0.5, 0.5, true},
+		{"estimate would overspend", 1, 0.6, 0.5, false},
+		{"exhausted with no estimate", 1, 1, 0, false},
+		{"rounding at the limit", 0.3, 0.1 + 0.1, 0.1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasRoom(tt.limit, tt.spend, tt.estimate); got != tt.want {
+				t.Errorf("hasRoom(%v, %v, %v) = %v, want %v", tt.limit, tt.spend, tt.estimate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoBudgetsCache(t *testing.T) {
+	s := NewBudgetStore(nil)
+	k := noBudgetKey{agentID: "agent-1", toolID: "tool-1"}
+
+	gen, known := s.knownNoBudgets(k)
+	if known {
+		t.Fatal("expected an empty cache")
+	}
+	s.cacheNoBudgets(k, gen)
+	if _, known := s.knownNoBudgets(k); !known {
+		t.Fatal("expected the pair cached as having no budget")
+	}
+
+	s.InvalidateTool("tool-1")
+	if _, known := s.knownNoBudgets(k); known {
+		t.Fatal("expected a tool change to empty the cache")
+	}
+
+	// Limits read before a budget change must not be cached after it.
+	gen, _ = s.knownNoBudgets(k)
+	s.forgetNoBudgets()
+	s.cacheNoBudgets(k, gen)
+	if _, known := s.knownNoBudgets(k); known {
+		t.Error("expected limits read before the change not cached")
+	}
+
+	s.noBudgets[k] = time.Now().Add(-time.Second)
+	if _, known := s.knownNoBudgets(k); known {
+		t.Error("expected an expired entry ignored")
+	}
+}
+
+// insertBudgetFixture inserts an agent and a tool with a daily global budget
+// of 10, removed along with their transactions when the test ends.
+func insertBudgetFixture(tb testing.TB, pool *pgxpool.Pool) (agentID, toolID string) {
+	tb.Helper()
+	ctx := context.Background()
+	err := pool.QueryRow(ctx,
+		`INSERT INTO agents (name, api_key_hash, api_key_prefix) VALUES ($1, $1, 'test') RETURNING id`,
+		fmt.Sprintf("budget-test-%d", time.Now().UnixNano()),
+	).Scan(&agentID)
+	if err != nil {
+		tb.Fatalf("inserting agent: %v", err)
+	}
+	err = pool.QueryRow(ctx,
+		`INSERT INTO tools (name, description, endpoint, budget_limit, budget_window)
+		 VALUES ('budget-test', 'test', 'https://example.com', 10, 'daily') RETURNING id`,
+	).Scan(&toolID)
+	if err != nil {
+		tb.Fatalf("inserting tool: %v", err)
+	}
+	tb.Cleanup(func() {
+		pool.Exec(ctx, `DELETE FROM agents WHERE id = $1`, agentID)
+		pool.Exec(ctx, `DELETE FROM tools WHERE id = $1`, toolID)
+	})
+	return agentID, toolID
+}
+
+// remainingDaily returns what is left of the agent's daily budget for the
+// tool, as a reservation of nothing sees it.
+func remainingDaily(tb testing.TB, s *BudgetStore, agentID, toolID string) float64 {
+	tb.Helper()
+	ctx := context.Background()
+	res, err := s.Reserve(ctx, agentID, toolID, 0)
+	if err != nil {
+		tb.Fatalf("reserving: %v", err)
+	}
+	if res.ID != "" {
+		defer s.Release(ctx, res.ID)
+	}
+	if res.Exceeded != "" {
+		return 0
+	}
+	for _, b := range res.Budgets {
+		if b.Kind == BudgetAgent && b.Window == "daily" {
+			return b.Limit - b.Spent
+		}
+	}
+	tb.Fatalf("no daily agent budget in %+v", res.Budgets)
+	return 0
+}
+
+// TestReserveConcurrent runs against the migrated database named by
+// OCTROI_TEST_DATABASE_URL, e.g. the docker compose Postgres. Requests racing
+// through several replicas must never reserve more than the budget.
+func TestReserveConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	var replicas []*BudgetStore
+	for i := 0; i < 3; i++ {
+		replicas = append(replicas, NewBudgetStore(openTestPool(t)))
+	}
+	pool := replicas[0].pool
+
+	agentID, toolID := insertBudgetFixture(t, pool)
+	if _, err := replicas[0].Set(ctx, CreateBudgetInput{AgentID: agentID, ToolID: toolID, DailyLimit: 1}); err != nil {
+		t.Fatalf("setting budget: %v", err)
+	}
+
+	var mu sync.Mutex
+	var ids []string
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(s *BudgetStore) {
+			defer wg.Done()
+			res, err := s.Reserve(ctx, agentID, toolID, 0.1)
+			if err != nil {
+				t.Errorf("reserving: %v", err)
+				return
+			}
+			if res.Exceeded == "" {
+				mu.Lock()
+				ids = append(ids, res.ID)
+				mu.Unlock()
+			} else if res.Exceeded != BudgetAgent {
+				t.Errorf("expected the agent budget exceeded, got %q", res.Exceeded)
+			}
+		}(replicas[i%len(replicas)])
+	}
+	wg.Wait()
+	if len(ids) != 10 {
+		t.Fatalf("expected 10 reservations of 0.1 against a budget of 1, got %d", len(ids))
+	}
+
+	// Metering a request below its estimate settles its reservation and
+	// makes room again; releasing frees it all.
+	err := metering.NewStore(pool).BatchInsert(ctx, []metering.Transaction{{
+		AgentID: agentID, ToolID: toolID, Timestamp: time.Now(), Method: "GET", Path: "/",
+		StatusCode: 200, Success: true, Cost: 0.05, ReservationID: ids[0],
+	}})
+	if err != nil {
+		t.Fatalf("inserting transaction: %v", err)
+	}
+	if res, _ := replicas[2].Reserve(ctx, agentID, toolID, 0.1); res.Exceeded == "" {
+		t.Fatal("expected no room for 0.1 after settling 0.05 below the estimate")
+	}
+	if res, _ := replicas[2].Reserve(ctx, agentID, toolID, 0.05); res.Exceeded != "" {
+		t.Fatal("expected room for 0.05 after settling")
+	}
+	if err := replicas[1].Release(ctx, ids[1]); err != nil {
+		t.Fatalf("releasing: %v", err)
+	}
+	if remaining := remainingDaily(t, replicas[0], agentID, toolID); math.Abs(remaining-0.1) > 1e-9 {
+		t.Fatalf("expected 0.1 remaining after the release, got %v", remaining)
+	}
+
+	// Expired reservations stop counting and are reclaimed.
+	if _, err := pool.Exec(ctx, `UPDATE budget_reservations SET expires_at = now() WHERE agent_id = $1`, agentID); err != nil {
+		t.Fatalf("expiring reservations: %v", err)
+	}
+	if remaining := remainingDaily(t, replicas[0], agentID, toolID); math.Abs(remaining-0.95) > 1e-9 {
+		t.Fatalf("expected all but the metered 0.05 back once reservations expired, got %v", remaining)
+	}
+	n, err := replicas[0].ReclaimExpired(ctx)
+	if err != nil || n < 9 {
+		t.Fatalf("expected at least 9 reservations reclaimed, got %d %v", n, err)
+	}
+}
+
+// TestReserveNoBudgets checks that a reservation with no budget to hold it
+// against holds nothing, and that setting a budget takes effect at once.
+func TestReserveNoBudgets(t *testing.T) {
+	pool := openTestPool(t)
+	ctx := context.Background()
+	store := NewBudgetStore(pool)
+	agentID, toolID := insertBudgetFixture(t, pool)
+	if _, err := pool.Exec(ctx, `UPDATE tools SET budget_limit = 0 WHERE id = $1`, toolID); err != nil {
+		t.Fatalf("clearing tool budget: %v", err)
+	}
+
+	for range 2 {
+		res, err := store.Reserve(ctx, agentID, toolID, 5)
+		if err != nil {
+			t.Fatalf("reserving: %v", err)
+		}
+		if res.ID != "" || res.Exceeded != "" || len(res.Budgets) != 0 {
+			t.Fatalf("expected nothing held without budgets, got %+v", res)
+		}
+	}
+
+	if _, err := store.Set(ctx, CreateBudgetInput{AgentID: agentID, ToolID: toolID, DailyLimit: 1}); err != nil {
+		t.Fatalf("setting budget: %v", err)
+	}
+	if res, _ := store.Reserve(ctx, agentID, toolID, 5); res.Exceeded != BudgetAgent {
+		t.Fatalf("expected the new budget enforced at once, got %+v", res)
+	}
+}
+
+// TestReserveAgentAndTeamBudgets checks that an agent's budget across tools
+// and its team's budget are enforced alongside its per-tool budgets.
+func TestReserveAgentAndTeamBudgets(t *testing.T) {
+	pool := openTestPool(t)
+	ctx := context.Background()
+	store := NewBudgetStore(pool)
+
+	team := fmt.Sprintf("budget-test-%d", time.Now().UnixNano())
+	agentID, toolID := insertBudgetFixture(t, pool)
+	otherAgentID, otherToolID := insertBudgetFixture(t, pool)
+	if _, err := pool.Exec(ctx, `UPDATE agents SET team = $1 WHERE id = ANY($2::uuid[])`, team, []string{agentID, otherAgentID}); err != nil {
+		t.Fatalf("setting team: %v", err)
+	}
+	t.Cleanup(func() { store.DeleteTeamBudget(ctx, team) })
+
+	if _, err := store.SetAgentBudget(ctx, AgentBudget{AgentID: agentID, DailyLimit: 1}); err != nil {
+		t.Fatalf("setting agent budget: %v", err)
+	}
+	if _, err := store.SetTeamBudget(ctx, TeamBudget{Team: team, MonthlyLimit: 1.5}); err != nil {
+		t.Fatalf("setting team budget: %v", err)
+	}
+
+	reserve := func(agentID, toolID string, estimate float64, want string) {
+		t.Helper()
+		res, err := store.Reserve(ctx, agentID, toolID, estimate)
+		if err != nil {
+			t.Fatalf("reserving: %v", err)
+		}
+		if res.Exceeded != want {
+			t.Fatalf("reserving %v: got exceeded %q, want %q", estimate, res.Exceeded, want)
+		}
+	}
+	reserve(agentID, toolID, 0.6, "")
+	reserve(agentID, otherToolID, 0.6, BudgetAgentTotal)
+	reserve(agentID, otherToolID, 0.4, "")
+	reserve(otherAgentID, otherToolID, 0.4, "")
+	reserve(otherAgentID, toolID, 0.2, BudgetTeam)
+	reserve(otherAgentID, toolID, 0.1, "")
+}
+
+// TestReserveWindowBudgets checks that budgets over configurable windows
+// count only the spend inside them.
+func TestReserveWindowBudgets(t *testing.T) {
+	pool := openTestPool(t)
+	ctx := context.Background()
+	store := NewBudgetStore(pool)
+	agentID, toolID := insertBudgetFixture(t, pool)
+
+	if _, err := pool.Exec(ctx, `UPDATE tools SET budget_limit = 1, budget_window = 'rolling:1h' WHERE id = $1`, toolID); err != nil {
+		t.Fatalf("setting tool budget: %v", err)
+	}
+	for _, tx := range []struct {
+		ago  time.Duration
+		cost float64
+	}{{2 * time.Hour, 5}, {30 * time.Minute, 0.6}} {
+		_, err := pool.Exec(ctx,
+			`INSERT INTO transactions (agent_id, tool_id, timestamp, method, path, status_code, latency_ms, success, cost)
+			 VALUES ($1, $2, now() - $3::interval, 'GET', '/', 200, 10, true, $4)`,
+			agentID, toolID, tx.ago, tx.cost,
+		)
+		if err != nil {
+			t.Fatalf("inserting transaction: %v", err)
+		}
+	}
+
+	reserve := func(estimate float64, want string) {
+		t.Helper()
+		res, err := store.Reserve(ctx, agentID, toolID, estimate)
+		if err != nil {
+			t.Fatalf("reserving: %v", err)
+		}
+		if res.Exceeded != want {
+			t.Fatalf("reserving %v: got exceeded %q, want %q", estimate, res.Exceeded, want)
+		}
+	}
+	// Only the last hour's 0.6 counts against the tool's rolling hour.
+	reserve(0.5, BudgetGlobal)
+	reserve(0.4, "")
+
+	// Three hours hold all 6 spent and reserved.
+	if _, err := store.SetAgentBudget(ctx, AgentBudget{AgentID: agentID, WindowLimit: 5, BudgetWindow: "rolling:3h"}); err != nil {
+		t.Fatalf("setting agent budget: %v", err)
+	}
+	reserve(0, BudgetAgentTotal)
+}
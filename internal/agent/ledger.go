@@ -0,0 +1,458 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecgard/octroi/internal/budget/window"
+	"github.com/alecgard/octroi/internal/metering"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ledgerKey names a spend total: an agent's on one tool, or, with agentID
+// empty, a tool's across all agents, or, with toolID empty, an agent's across
+// all tools.
+type ledgerKey struct {
+	agentID string
+	toolID  string
+}
+
+// ledgerEntry is the spend under a key in the current period of each
+// calendar window the ledger keeps, and per UTC hour for rolling windows.
+// Spend from earlier periods has been dropped.
+type ledgerEntry struct {
+	windows map[string]windowTotal // window, as its String, -> spend in its latest period
+	hours   map[int64]float64      // hours since the Unix epoch -> spend
+}
+
+// windowTotal is the spend in the period of a window starting at start.
+type windowTotal struct {
+	start time.Time
+	total float64
+}
+
+func newLedgerEntry() *ledgerEntry {
+	return &ledgerEntry{windows: make(map[string]windowTotal), hours: make(map[int64]float64)}
+}
+
+// merge adds o's totals into e. A later period of a window replaces the one
+// e holds; spend from an earlier one is dropped.
+func (e *ledgerEntry) merge(o *ledgerEntry) {
+	for name, ot := range o.windows {
+		t, ok := e.windows[name]
+		switch {
+		case !ok || ot.start.After(t.start):
+			e.windows[name] = ot
+		case ot.start.Equal(t.start):
+			t.total += ot.total
+			e.windows[name] = t
+		}
+	}
+	for h, spend := range o.hours {
+		e.hours[h] += spend
+	}
+}
+
+// spend returns the entry's spend in the period of w holding now: the
+// period's total for a calendar window, or the hourly totals from the
+// window's first whole hour for a rolling one.
+func (e *ledgerEntry) spend(w window.Window, now time.Time) float64 {
+	if _, rolling := w.Rolling(); rolling {
+		from := unixHour(ledgerFrom(w, now))
+		var total float64
+		for h, spend := range e.hours {
+			if h >= from {
+				total += spend
+			}
+		}
+		return total
+	}
+	if t, ok := e.windows[w.String()]; ok && t.start.Equal(w.Start(now)) {
+		return t.total
+	}
+	return 0
+}
+
+// ledgerFrom returns when the ledger's spend in the period of w holding now
+// starts: the period's start for a calendar window, or the first whole UTC
+// hour of a rolling one. Spend in a rolling window's part-hour before it is
+// not in the ledger.
+func ledgerFrom(w window.Window, now time.Time) time.Time {
+	start := w.Start(now)
+	if _, rolling := w.Rolling(); !rolling {
+		return start
+	}
+	from := start.Truncate(time.Hour)
+	if from.Before(start) {
+		from = from.Add(time.Hour)
+	}
+	return from
+}
+
+// unixHour returns the number of whole hours from the Unix epoch to t.
+func unixHour(t time.Time) int64 {
+	return t.Unix() / 3600
+}
+
+// defaultLedgerWindows returns the windows the ledger always keeps: the UTC
+// day and month of per-day and per-month limits.
+func defaultLedgerWindows() map[string]window.Window {
+	daily, _ := window.Parse("daily")
+	monthly, _ := window.Parse("monthly")
+	return map[string]window.Window{daily.String(): daily, monthly.String(): monthly}
+}
+
+// Ledger keeps committed spend per agent and tool, per tool and per agent in
+// memory, so budget checks need not sum the transactions table. It keeps a
+// total for the current period of the UTC day and month and of every other
+// calendar window a budget uses, and hourly totals covering the longest
+// rolling window a budget uses. It is loaded from the database with
+// Reconcile, adds the transactions this instance writes as they are flushed,
+// and is reconciled periodically to pick up spend written by other replicas
+// and windows newly in use.
+type Ledger struct {
+	pool *pgxpool.Pool
+
+	// flushing is held for reading while a batch is written and for writing
+	// while Reconcile starts a new local total, so no batch straddles the
+	// database snapshot that replaces the base.
+	flushing sync.RWMutex
+
+	mu    sync.Mutex
+	base  map[ledgerKey]*ledgerEntry // as of the last reconcile
+	local map[ledgerKey]*ledgerEntry // flushed by this instance since
+	// windows are the calendar windows totals are kept for, and rolling
+	// the longest rolling window the hourly totals cover, as of the last
+	// reconcile. Flushed spend is counted in every window of counting,
+	// which also holds those a reconcile in progress is loading.
+	windows  map[string]window.Window
+	counting map[string]window.Window
+	rolling  time.Duration
+	// snapshot is the database time of the last reconcile; reconciled is
+	// the local time it completed. Both are zero until the first.
+	snapshot   time.Time
+	reconciled time.Time
+}
+
+// NewLedger creates an empty ledger. Call Reconcile to load it before use.
+func NewLedger(pool *pgxpool.Pool) *Ledger {
+	return &Ledger{
+		pool:     pool,
+		base:     make(map[ledgerKey]*ledgerEntry),
+		local:    make(map[ledgerKey]*ledgerEntry),
+		windows:  defaultLedgerWindows(),
+		counting: defaultLedgerWindows(),
+	}
+}
+
+// Inserter wraps next so that the transactions it writes are added to the
+// ledger. Use it as the collector's store.
+func (l *Ledger) Inserter(next metering.BatchInserter) metering.BatchInserter {
+	return &ledgerInserter{ledger: l, next: next}
+}
+
+type ledgerInserter struct {
+	ledger *Ledger
+	next   metering.BatchInserter
+}
+
+// BatchInsert adds txns to the ledger before writing them, so that no budget
+// check sees them neither reserved nor spent, and takes them back out if the
+// write fails.
+func (i *ledgerInserter) BatchInsert(ctx context.Context, txns []metering.Transaction) error {
+	i.ledger.flushing.RLock()
+	defer i.ledger.flushing.RUnlock()
+
+	i.ledger.add(txns, 1)
+	if err := i.next.BatchInsert(ctx, txns); err != nil {
+		i.ledger.add(txns, -1)
+		return err
+	}
+	return nil
+}
+
+// add adds (sign 1) or removes (sign -1) txns from the local totals.
+func (l *Ledger) add(txns []metering.Transaction, sign float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, tx := range txns {
+		if tx.Cost == 0 {
+			continue
+		}
+		e := newLedgerEntry()
+		for name, w := range l.counting {
+			e.windows[name] = windowTotal{start: w.Start(tx.Timestamp), total: sign * tx.Cost}
+		}
+		e.hours[unixHour(tx.Timestamp)] = sign * tx.Cost
+		for _, k := range ledgerKeys(tx.AgentID, tx.ToolID) {
+			mergeEntry(l.local, k, e)
+		}
+	}
+}
+
+// keeps reports whether the ledger keeps spend for w.
+func (l *Ledger) keeps(w window.Window) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if span, rolling := w.Rolling(); rolling {
+		return span <= l.rolling
+	}
+	_, ok := l.windows[w.String()]
+	return ok
+}
+
+// spend returns the committed spend under k in the period of w holding now,
+// from ledgerFrom on.
+func (l *Ledger) spend(k ledgerKey, w window.Window, now time.Time) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total float64
+	if e, ok := l.base[k]; ok {
+		total += e.spend(w, now)
+	}
+	if e, ok := l.local[k]; ok {
+		total += e.spend(w, now)
+	}
+	return total
+}
+
+// state returns the database time of the last reconcile and the local time
+// it completed, both zero if there has been none.
+func (l *Ledger) state() (snapshot, reconciled time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.snapshot, l.reconciled
+}
+
+// Reconcile replaces the ledger's totals with the database's, for the
+// windows budgets now use. Transactions this instance flushes while it runs
+// are kept on top, and may be counted twice until the next reconcile, but
+// never left out.
+func (l *Ledger) Reconcile(ctx context.Context) error {
+	windows, rolling, err := l.loadWindows(ctx)
+	if err != nil {
+		return err
+	}
+
+	l.flushing.Lock()
+	l.mu.Lock()
+	prev := l.local
+	l.local = make(map[ledgerKey]*ledgerEntry)
+	// Count flushed spend in the windows being loaded too, so that they hold
+	// it once the reconcile completes, while the old windows still do if it
+	// fails.
+	for name, w := range windows {
+		l.counting[name] = w
+	}
+	l.mu.Unlock()
+	l.flushing.Unlock()
+
+	base, snapshot, err := l.load(ctx, windows, rolling)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		// Keep counting what was flushed since the last snapshot.
+		for k, e := range prev {
+			mergeEntry(l.local, k, e)
+		}
+		return err
+	}
+	l.base = base
+	l.windows, l.counting, l.rolling = windows, maps.Clone(windows), rolling
+	l.snapshot = snapshot
+	l.reconciled = time.Now()
+	return nil
+}
+
+// Start reconciles the ledger every interval until ctx is cancelled.
+func (l *Ledger) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Reconcile(ctx); err != nil {
+				slog.Warn("reconciling spend ledger failed", "error", err)
+			}
+		}
+	}
+}
+
+// ledgerKeys returns the keys spend by agentID on toolID counts under.
+func ledgerKeys(agentID, toolID string) []ledgerKey {
+	return []ledgerKey{{agentID: agentID, toolID: toolID}, {toolID: toolID}, {agentID: agentID}}
+}
+
+// mergeEntry merges e into the entry under k in m.
+func mergeEntry(m map[ledgerKey]*ledgerEntry, k ledgerKey, e *ledgerEntry) {
+	into, ok := m[k]
+	if !ok {
+		into = newLedgerEntry()
+		m[k] = into
+	}
+	into.merge(e)
+}
+
+// loadWindows returns the calendar windows budgets use, with the UTC day and
+// month, and the longest rolling window they use, or 0.
+func (l *Ledger) loadWindows(ctx context.Context) (map[string]window.Window, time.Duration, error) {
+	rows, err := l.pool.Query(ctx,
+		`SELECT DISTINCT COALESCE(budget_window, '') FROM (
+			SELECT budget_window FROM tools WHERE budget_limit > 0
+			UNION ALL SELECT budget_window FROM agent_tool_budgets WHERE window_limit > 0
+			UNION ALL SELECT budget_window FROM agent_budgets WHERE window_limit > 0
+			UNION ALL SELECT budget_window FROM team_budgets WHERE window_limit > 0
+		) w`,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing budget windows: %w", err)
+	}
+	defer rows.Close()
+
+	windows := defaultLedgerWindows()
+	var rolling time.Duration
+	for rows.Next() {
+		var spec string
+		if err := rows.Scan(&spec); err != nil {
+			return nil, 0, fmt.Errorf("scanning budget window: %w", err)
+		}
+		w, err := window.Parse(spec)
+		if err != nil {
+			// Budgets with an invalid window fail their checks anyway.
+			continue
+		}
+		if span, ok := w.Rolling(); ok {
+			rolling = max(rolling, span)
+		} else {
+			windows[w.String()] = w
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("listing budget windows: %w", err)
+	}
+	return windows, rolling, nil
+}
+
+// load sums spend per agent and tool, per tool and per agent from one
+// snapshot, in the current period of each of windows and per hour over the
+// last rolling, returning the totals and the database time of the snapshot.
+func (l *Ledger) load(ctx context.Context, windows map[string]window.Window, rolling time.Duration) (map[ledgerKey]*ledgerEntry, time.Time, error) {
+	base := make(map[ledgerKey]*ledgerEntry)
+	var snapshot time.Time
+	err := pgx.BeginTxFunc(ctx, l.pool, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, `SELECT now()`).Scan(&snapshot); err != nil {
+			return fmt.Errorf("reading snapshot time: %w", err)
+		}
+		if err := loadWindowTotals(ctx, tx, base, windows, snapshot); err != nil {
+			return err
+		}
+		if rolling > 0 {
+			return loadHourlyTotals(ctx, tx, base, snapshot.Add(-rolling))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("loading spend ledger: %w", err)
+	}
+	return base, snapshot, nil
+}
+
+// loadWindowTotals sums spend per agent and tool in the period of each of
+// windows holding now into base.
+func loadWindowTotals(ctx context.Context, tx pgx.Tx, base map[ledgerKey]*ledgerEntry, windows map[string]window.Window, now time.Time) error {
+	names := slices.Sorted(maps.Keys(windows))
+	starts := make([]time.Time, len(names))
+	sums := make([]string, len(names))
+	args := make([]any, 0, len(names)+1)
+	earliest := now
+	for i, name := range names {
+		starts[i] = windows[name].Start(now)
+		earliest = minTime(earliest, starts[i])
+		args = append(args, starts[i])
+		sums[i] = fmt.Sprintf("COALESCE(SUM(cost) FILTER (WHERE timestamp >= $%d), 0)", i+1)
+	}
+	args = append(args, earliest)
+
+	rows, err := tx.Query(ctx, fmt.Sprintf(
+		`SELECT agent_id, tool_id, %s
+		 FROM transactions
+		 WHERE timestamp >= $%d
+		 GROUP BY agent_id, tool_id`,
+		strings.Join(sums, ", "), len(args)), args...,
+	)
+	if err != nil {
+		return fmt.Errorf("summing spend: %w", err)
+	}
+	defer rows.Close()
+
+	var agentID, toolID string
+	totals := make([]float64, len(names))
+	dest := []any{&agentID, &toolID}
+	for i := range totals {
+		dest = append(dest, &totals[i])
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("scanning spend row: %w", err)
+		}
+		e := newLedgerEntry()
+		for i, name := range names {
+			if totals[i] != 0 {
+				e.windows[name] = windowTotal{start: starts[i], total: totals[i]}
+			}
+		}
+		for _, k := range ledgerKeys(agentID, toolID) {
+			mergeEntry(base, k, e)
+		}
+	}
+	return rows.Err()
+}
+
+// loadHourlyTotals sums spend per agent and tool per UTC hour from the hour
+// holding since into base.
+func loadHourlyTotals(ctx context.Context, tx pgx.Tx, base map[ledgerKey]*ledgerEntry, since time.Time) error {
+	rows, err := tx.Query(ctx,
+		`SELECT agent_id, tool_id, floor(extract(epoch FROM timestamp) / 3600)::bigint AS hour,
+		        COALESCE(SUM(cost), 0)
+		 FROM transactions
+		 WHERE timestamp >= $1
+		 GROUP BY agent_id, tool_id, hour`,
+		since.Truncate(time.Hour),
+	)
+	if err != nil {
+		return fmt.Errorf("summing hourly spend: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var agentID, toolID string
+		var hour int64
+		var spend float64
+		if err := rows.Scan(&agentID, &toolID, &hour, &spend); err != nil {
+			return fmt.Errorf("scanning hourly spend row: %w", err)
+		}
+		e := newLedgerEntry()
+		e.hours[hour] = spend
+		for _, k := range ledgerKeys(agentID, toolID) {
+			mergeEntry(base, k, e)
+		}
+	}
+	return rows.Err()
+}
+
+// minTime returns the earlier of a and b.
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,367 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/budget/window"
+	"github.com/alecgard/octroi/internal/metering"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// mustParseWindow parses spec, failing the test if it is invalid.
+func mustParseWindow(tb testing.TB, spec string) window.Window {
+	tb.Helper()
+	w, err := window.Parse(spec)
+	if err != nil {
+		tb.Fatalf("window.Parse(%q): %v", spec, err)
+	}
+	return w
+}
+
+func TestLedgerEntryMerge(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	daily := func(start time.Time, total float64) *ledgerEntry {
+		e := newLedgerEntry()
+		e.windows["daily"] = windowTotal{start: start, total: total}
+		return e
+	}
+
+	e := daily(day(15), 1)
+	e.merge(daily(day(15), 2))
+	if got := e.windows["daily"].total; got != 3 {
+		t.Fatalf("same day: got %v, want 3", got)
+	}
+
+	e.merge(daily(day(16), 1))
+	if got := e.windows["daily"]; !got.start.Equal(day(16)) || got.total != 1 {
+		t.Fatalf("next day: got %v %v, want the 16th and 1", got.start, got.total)
+	}
+
+	e.merge(daily(day(15), 4))
+	if got := e.windows["daily"].total; got != 1 {
+		t.Fatalf("earlier day: got %v, want 1", got)
+	}
+	if got := e.spend(mustParseWindow(t, "daily"), day(17)); got != 0 {
+		t.Errorf("spend in a day the entry does not hold: got %v, want 0", got)
+	}
+
+	hourly := newLedgerEntry()
+	hourly.hours[10] = 2
+	e.hours[10] = 1
+	e.merge(hourly)
+	if e.hours[10] != 3 {
+		t.Errorf("same hour: got %v, want 3", e.hours[10])
+	}
+}
+
+func TestLedgerFrom(t *testing.T) {
+	at := func(d, h, m int) time.Time { return time.Date(2024, 3, d, h, m, 0, 0, time.UTC) }
+	tests := []struct {
+		window string
+		now    time.Time
+		want   time.Time
+	}{
+		{"daily", at(15, 12, 30), at(15, 0, 0)},
+		{"daily@Asia/Kolkata", at(15, 12, 30), at(14, 18, 30)},
+		{"rolling:24h", at(15, 12, 0), at(14, 12, 0)},
+		{"rolling:24h", at(15, 12, 30), at(14, 13, 0)},
+	}
+	for _, tt := range tests {
+		if got := ledgerFrom(mustParseWindow(t, tt.window), tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s at %v: got %v, want %v", tt.window, tt.now, got, tt.want)
+		}
+	}
+}
+
+type fakeInserter struct {
+	err   error
+	calls int
+}
+
+func (f *fakeInserter) BatchInsert(_ context.Context, _ []metering.Transaction) error {
+	f.calls++
+	return f.err
+}
+
+func TestLedgerInserter(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC) // a Friday
+	l := NewLedger(nil)
+	weekly := mustParseWindow(t, "weekly@Europe/London")
+	l.windows[weekly.String()] = weekly
+	l.counting[weekly.String()] = weekly
+	l.rolling = 24 * time.Hour
+	next := &fakeInserter{}
+	ins := l.Inserter(next)
+
+	err := ins.BatchInsert(context.Background(), []metering.Transaction{
+		{AgentID: "a1", ToolID: "t1", Timestamp: now, Cost: 0.5},
+		{AgentID: "a2", ToolID: "t1", Timestamp: now, Cost: 0.25},
+		{AgentID: "a1", ToolID: "t1", Timestamp: now.AddDate(0, 0, -1), Cost: 1},
+		{AgentID: "a1", ToolID: "t1", Timestamp: now.Add(-30 * time.Hour), Cost: 2},
+		{AgentID: "a1", ToolID: "t1", Timestamp: now, Cost: 0},
+	})
+	if err != nil {
+		t.Fatalf("inserting: %v", err)
+	}
+	if next.calls != 1 {
+		t.Fatalf("expected the batch passed on once, got %d", next.calls)
+	}
+	tests := []struct {
+		name   string
+		k      ledgerKey
+		window string
+		want   float64
+	}{
+		{"agent daily", ledgerKey{agentID: "a1", toolID: "t1"}, "daily", 0.5},
+		{"agent monthly", ledgerKey{agentID: "a1", toolID: "t1"}, "monthly", 3.5},
+		{"tool daily", ledgerKey{toolID: "t1"}, "daily", 0.75},
+		{"tool monthly", ledgerKey{toolID: "t1"}, "monthly", 3.75},
+		{"agent across tools", ledgerKey{agentID: "a2"}, "daily", 0.25},
+		{"other tool", ledgerKey{toolID: "t2"}, "monthly", 0},
+		{"zoned week", ledgerKey{agentID: "a1", toolID: "t1"}, "weekly@Europe/London", 3.5},
+		// The part-hour the window starts with, holding the 1 spent a day
+		// ago, is summed from the database instead.
+		{"rolling day", ledgerKey{agentID: "a1", toolID: "t1"}, "rolling:24h", 0.5},
+	}
+	for _, tt := range tests {
+		if got := l.spend(tt.k, mustParseWindow(t, tt.window), now); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		window string
+		want   bool
+	}{
+		{"daily", true},
+		{"weekly@Europe/London", true},
+		{"weekly", false},
+		{"rolling:24h", true},
+		{"rolling:2d", false},
+	} {
+		if got := l.keeps(mustParseWindow(t, tt.window)); got != tt.want {
+			t.Errorf("keeps(%s) = %v, want %v", tt.window, got, tt.want)
+		}
+	}
+
+	// A failed write takes its transactions back out.
+	next.err = errors.New("connection refused")
+	err = ins.BatchInsert(context.Background(), []metering.Transaction{
+		{AgentID: "a1", ToolID: "t1", Timestamp: now, Cost: 2},
+	})
+	if err == nil {
+		t.Fatal("expected the write error returned")
+	}
+	for _, window := range []string{"daily", "rolling:24h"} {
+		if got := l.spend(ledgerKey{agentID: "a1", toolID: "t1"}, mustParseWindow(t, window), now); got != 0.5 {
+			t.Errorf("%s after a failed write: got %v, want 0.5", window, got)
+		}
+	}
+}
+
+// BenchmarkLedgerSpend measures the ledger lookup budget checks make in
+// place of summing transactions.
+func BenchmarkLedgerSpend(b *testing.B) {
+	now := time.Now().UTC()
+	l := NewLedger(nil)
+	var txns []metering.Transaction
+	for i := 0; i < 1000; i++ {
+		txns = append(txns, metering.Transaction{
+			AgentID:   fmt.Sprintf("agent-%d", i%100),
+			ToolID:    fmt.Sprintf("tool-%d", i%10),
+			Timestamp: now,
+			Cost:      0.01,
+		})
+	}
+	l.add(txns, 1)
+	k := ledgerKey{agentID: "agent-7", toolID: "tool-7"}
+
+	for _, window := range []string{"monthly", "rolling:24h"} {
+		w := mustParseWindow(b, window)
+		b.Run(window, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					l.spend(k, w, now)
+				}
+			})
+		})
+	}
+}
+
+// openTestPool connects to the migrated database named by
+// OCTROI_TEST_DATABASE_URL, skipping if it is not set.
+func openTestPool(tb testing.TB) *pgxpool.Pool {
+	tb.Helper()
+	url := os.Getenv("OCTROI_TEST_DATABASE_URL")
+	if url == "" {
+		tb.Skip("OCTROI_TEST_DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		tb.Fatalf("connecting to postgres: %v", err)
+	}
+	tb.Cleanup(pool.Close)
+	return pool
+}
+
+// TestLedgerAcrossReplicas checks that spend metered by one replica counts
+// against the budget on another whose ledger has not reconciled since, and is
+// counted once after it has.
+func TestLedgerAcrossReplicas(t *testing.T) {
+	pool := openTestPool(t)
+	ctx := context.Background()
+	agentID, toolID := insertBudgetFixture(t, pool)
+
+	replica := func() (*BudgetStore, *Ledger, metering.BatchInserter) {
+		s, l := NewBudgetStore(pool), NewLedger(pool)
+		if err := l.Reconcile(ctx); err != nil {
+			t.Fatalf("reconciling: %v", err)
+		}
+		s.SetLedger(l)
+		return s, l, l.Inserter(metering.NewStore(pool))
+	}
+	a, ledgerA, _ := replica()
+	b, _, insertB := replica()
+	if _, err := a.Set(ctx, CreateBudgetInput{AgentID: agentID, ToolID: toolID, DailyLimit: 1}); err != nil {
+		t.Fatalf("setting budget: %v", err)
+	}
+
+	res, err := b.Reserve(ctx, agentID, toolID, 0.5)
+	if err != nil || res.Exceeded != "" {
+		t.Fatalf("reserving: %q %v", res.Exceeded, err)
+	}
+	// Writing the transaction settles the reservation at 0.4.
+	id := res.ID
+	err = insertB.BatchInsert(ctx, []metering.Transaction{{
+		AgentID: agentID, ToolID: toolID, Timestamp: time.Now(), Method: "GET", Path: "/",
+		StatusCode: 200, Success: true, Cost: 0.4, ReservationID: id,
+	}})
+	if err != nil {
+		t.Fatalf("inserting transaction: %v", err)
+	}
+
+	check := func(when string) {
+		t.Helper()
+		for name, s := range map[string]*BudgetStore{"writer": b, "other": a} {
+			if remaining := remainingDaily(t, s, agentID, toolID); math.Abs(remaining-0.6) > 1e-9 {
+				t.Errorf("%s, %s: got %v remaining, want 0.6", when, name, remaining)
+			}
+		}
+	}
+	check("before reconciling")
+
+	// Move the commit out of the reconcile margin so it is only in the ledger.
+	if _, err := pool.Exec(ctx, `UPDATE budget_reservations SET committed_at = now() - interval '1 minute' WHERE id = $1`, id); err != nil {
+		t.Fatalf("backdating commit: %v", err)
+	}
+	if err := ledgerA.Reconcile(ctx); err != nil {
+		t.Fatalf("reconciling: %v", err)
+	}
+	check("after reconciling")
+}
+
+// TestLedgerWindows checks that the ledger loads the windows budgets use and
+// that a rolling window read from it counts only the spend inside it.
+func TestLedgerWindows(t *testing.T) {
+	pool := openTestPool(t)
+	ctx := context.Background()
+	agentID, toolID := insertBudgetFixture(t, pool)
+
+	if _, err := pool.Exec(ctx, `UPDATE tools SET budget_limit = 1, budget_window = 'rolling:1h' WHERE id = $1`, toolID); err != nil {
+		t.Fatalf("setting tool budget: %v", err)
+	}
+	store := NewBudgetStore(pool)
+	if _, err := store.SetAgentBudget(ctx, AgentBudget{AgentID: agentID, WindowLimit: 100, BudgetWindow: "weekly@Asia/Kolkata"}); err != nil {
+		t.Fatalf("setting agent budget: %v", err)
+	}
+	for _, tx := range []struct {
+		ago  time.Duration
+		cost float64
+	}{{2 * time.Hour, 5}, {30 * time.Minute, 0.6}} {
+		_, err := pool.Exec(ctx,
+			`INSERT INTO transactions (agent_id, tool_id, timestamp, method, path, status_code, latency_ms, success, cost)
+			 VALUES ($1, $2, now() - $3::interval, 'GET', '/', 200, 10, true, $4)`,
+			agentID, toolID, tx.ago, tx.cost,
+		)
+		if err != nil {
+			t.Fatalf("inserting transaction: %v", err)
+		}
+	}
+
+	ledger := NewLedger(pool)
+	if err := ledger.Reconcile(ctx); err != nil {
+		t.Fatalf("reconciling: %v", err)
+	}
+	for _, window := range []string{"rolling:1h", "weekly@Asia/Kolkata"} {
+		if !ledger.keeps(mustParseWindow(t, window)) {
+			t.Errorf("expected the ledger to keep %s", window)
+		}
+	}
+	store.SetLedger(ledger)
+
+	// Only the last hour's 0.6 counts against the tool's rolling hour.
+	if res, _ := store.Reserve(ctx, agentID, toolID, 0.5); res.Exceeded != BudgetGlobal {
+		t.Fatalf("expected the rolling hour exceeded, got %+v", res)
+	}
+	if res, _ := store.Reserve(ctx, agentID, toolID, 0.4); res.Exceeded != "" {
+		t.Fatalf("expected room in the rolling hour, got %+v", res)
+	}
+}
+
+// BenchmarkReserve compares reservations summing a month of transactions with
+// ones reading the ledger. Run it against the database named by
+// OCTROI_TEST_DATABASE_URL:
+//
+//	go test ./internal/agent -run '^$' -bench Reserve
+func BenchmarkReserve(b *testing.B) {
+	pool := openTestPool(b)
+	ctx := context.Background()
+	agentID, toolID := insertBudgetFixture(b, pool)
+
+	store := NewBudgetStore(pool)
+	if _, err := store.Set(ctx, CreateBudgetInput{AgentID: agentID, ToolID: toolID, DailyLimit: 1e6, MonthlyLimit: 1e6}); err != nil {
+		b.Fatalf("setting budget: %v", err)
+	}
+	_, err := pool.Exec(ctx,
+		`INSERT INTO transactions (agent_id, tool_id, timestamp, method, path, status_code, latency_ms, success, cost)
+		 SELECT $1, $2, date_trunc('month', now()) + (now() - date_trunc('month', now())) * random(),
+		        'GET', '/', 200, 10, true, 0.001
+		 FROM generate_series(1, 50000)`,
+		agentID, toolID,
+	)
+	if err != nil {
+		b.Fatalf("seeding transactions: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `ANALYZE transactions`); err != nil {
+		b.Fatalf("analyzing transactions: %v", err)
+	}
+
+	ledger := NewLedger(pool)
+	if err := ledger.Reconcile(ctx); err != nil {
+		b.Fatalf("reconciling: %v", err)
+	}
+	for _, bc := range []struct {
+		name   string
+		ledger *Ledger
+	}{{"transactions", nil}, {"ledger", ledger}} {
+		b.Run(bc.name, func(b *testing.B) {
+			store.SetLedger(bc.ledger)
+			for i := 0; i < b.N; i++ {
+				res, err := store.Reserve(ctx, agentID, toolID, 0)
+				if err != nil {
+					b.Fatalf("reserving: %v", err)
+				}
+				if err := store.Release(ctx, res.ID); err != nil {
+					b.Fatalf("releasing: %v", err)
+				}
+			}
+		})
+	}
+}
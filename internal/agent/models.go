@@ -4,29 +4,32 @@ import "time"
 
 // Agent represents a registered API agent.
 type Agent struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	APIKeyHash   string    `json:"-"`
-	APIKeyPrefix string    `json:"api_key_prefix"`
-	Team         string    `json:"team"`
-	RateLimit    int       `json:"rate_limit"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	APIKeyHash      string    `json:"-"`
+	APIKeyPrefix    string    `json:"api_key_prefix"`
+	Team            string    `json:"team"`
+	RateLimit       int       `json:"rate_limit"`
+	RateLimitWaitMs int       `json:"rate_limit_wait_ms"`
+	CreatedAt       time.Time `json:"created_at"`
 }
 
 // CreateAgentInput holds the fields required to create a new agent.
 type CreateAgentInput struct {
-	Name         string `json:"name"`
-	APIKeyHash   string `json:"api_key_hash"`
-	APIKeyPrefix string `json:"api_key_prefix"`
-	Team         string `json:"team"`
-	RateLimit    int    `json:"rate_limit"`
+	Name            string `json:"name"`
+	APIKeyHash      string `json:"api_key_hash"`
+	APIKeyPrefix    string `json:"api_key_prefix"`
+	Team            string `json:"team"`
+	RateLimit       int    `json:"rate_limit"`
+	RateLimitWaitMs int    `json:"rate_limit_wait_ms"`
 }
 
 // UpdateAgentInput holds optional fields for a partial agent update.
 type UpdateAgentInput struct {
-	Name      *string `json:"name,omitempty"`
-	Team      *string `json:"team,omitempty"`
-	RateLimit *int    `json:"rate_limit,omitempty"`
+	Name            *string `json:"name,omitempty"`
+	Team            *string `json:"team,omitempty"`
+	RateLimit       *int    `json:"rate_limit,omitempty"`
+	RateLimitWaitMs *int    `json:"rate_limit_wait_ms,omitempty"`
 }
 
 // AgentListParams controls cursor-based pagination for listing agents.
@@ -35,13 +38,17 @@ type AgentListParams struct {
 	Limit  int    `json:"limit"`
 }
 
-// Budget represents a per-agent, per-tool spending limit.
+// Budget represents a per-agent, per-tool spending limit. Besides the UTC
+// daily and monthly limits, WindowLimit caps spend over BudgetWindow (see
+// window.Parse). A limit of 0 means unlimited.
 type Budget struct {
 	ID           string  `json:"id"`
 	AgentID      string  `json:"agent_id"`
 	ToolID       string  `json:"tool_id"`
 	DailyLimit   float64 `json:"daily_limit"`
 	MonthlyLimit float64 `json:"monthly_limit"`
+	WindowLimit  float64 `json:"window_limit"`
+	BudgetWindow string  `json:"budget_window"`
 }
 
 // CreateBudgetInput holds the fields required to create or upsert a budget.
@@ -50,6 +57,28 @@ type CreateBudgetInput struct {
 	ToolID       string  `json:"tool_id"`
 	DailyLimit   float64 `json:"daily_limit"`
 	MonthlyLimit float64 `json:"monthly_limit"`
+	WindowLimit  float64 `json:"window_limit"`
+	BudgetWindow string  `json:"budget_window"`
+}
+
+// AgentBudget is an agent's spending limit across all tools. Its limits
+// are as in Budget.
+type AgentBudget struct {
+	AgentID      string  `json:"agent_id"`
+	DailyLimit   float64 `json:"daily_limit"`
+	MonthlyLimit float64 `json:"monthly_limit"`
+	WindowLimit  float64 `json:"window_limit"`
+	BudgetWindow string  `json:"budget_window"`
+}
+
+// TeamBudget is a spending limit shared by all of a team's agents across all
+// tools.
+type TeamBudget struct {
+	Team         string  `json:"team"`
+	DailyLimit   float64 `json:"daily_limit"`
+	MonthlyLimit float64 `json:"monthly_limit"`
+	WindowLimit  float64 `json:"window_limit"`
+	BudgetWindow string  `json:"budget_window"`
 }
 
 // UsageSummary holds aggregated usage data for an agent or tool.
@@ -7,6 +7,8 @@ import (
 
 	"github.com/alecgard/octroi/internal/agent"
 	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/budget/window"
+	"github.com/alecgard/octroi/internal/ratelimit"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
 )
@@ -26,11 +28,15 @@ func newAgentsHandler(store *agent.Store, budgetStore *agent.BudgetStore) *agent
 
 // createAgentRequest is the JSON body for creating an agent.
 type createAgentRequest struct {
-	Name      string `json:"name"`
-	Team      string `json:"team"`
-	RateLimit int    `json:"rate_limit"`
+	Name            string `json:"name"`
+	Team            string `json:"team"`
+	RateLimit       int    `json:"rate_limit"`
+	RateLimitWaitMs int    `json:"rate_limit_wait_ms"`
 }
 
+// rateLimitWaitMessage explains an out-of-range rate_limit_wait_ms.
+const rateLimitWaitMessage = "rate_limit_wait_ms must be between 0 and 60000"
+
 // CreateAgent handles POST /api/v1/agents (admin).
 // Generates an API key and returns the plaintext key in the response (only time it is shown).
 func (h *agentsHandler) CreateAgent(w http.ResponseWriter, r *http.Request) {
@@ -44,6 +50,10 @@ func (h *agentsHandler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusUnprocessableEntity, "validation_error", "name is required")
 		return
 	}
+	if !ratelimit.ValidWaitMs(req.RateLimitWaitMs) {
+		writeError(w, http.StatusUnprocessableEntity, "validation_error", rateLimitWaitMessage)
+		return
+	}
 
 	apiKey, plaintext, err := auth.GenerateAPIKey()
 	if err != nil {
@@ -52,11 +62,12 @@ func (h *agentsHandler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	input := agent.CreateAgentInput{
-		Name:         req.Name,
-		APIKeyHash:   apiKey.Hash,
-		APIKeyPrefix: apiKey.Prefix,
-		Team:         req.Team,
-		RateLimit:    req.RateLimit,
+		Name:            req.Name,
+		APIKeyHash:      apiKey.Hash,
+		APIKeyPrefix:    apiKey.Prefix,
+		Team:            req.Team,
+		RateLimit:       req.RateLimit,
+		RateLimitWaitMs: req.RateLimitWaitMs,
 	}
 
 	ag, err := h.store.Create(r.Context(), input)
@@ -68,13 +79,14 @@ func (h *agentsHandler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 	auditLog(r, "create", "agent", ag.ID, "name", ag.Name)
 
 	resp := map[string]interface{}{
-		"id":             ag.ID,
-		"name":           ag.Name,
-		"api_key_prefix": ag.APIKeyPrefix,
-		"api_key":        plaintext,
-		"team":           ag.Team,
-		"rate_limit":     ag.RateLimit,
-		"created_at":     ag.CreatedAt,
+		"id":                 ag.ID,
+		"name":               ag.Name,
+		"api_key_prefix":     ag.APIKeyPrefix,
+		"api_key":            plaintext,
+		"team":               ag.Team,
+		"rate_limit":         ag.RateLimit,
+		"rate_limit_wait_ms": ag.RateLimitWaitMs,
+		"created_at":         ag.CreatedAt,
 	}
 	writeJSON(w, http.StatusCreated, resp)
 }
@@ -92,6 +104,10 @@ func (h *agentsHandler) UpdateAgent(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid_body", "failed to parse request body")
 		return
 	}
+	if input.RateLimitWaitMs != nil && !ratelimit.ValidWaitMs(*input.RateLimitWaitMs) {
+		writeError(w, http.StatusUnprocessableEntity, "validation_error", rateLimitWaitMessage)
+		return
+	}
 
 	ag, err := h.store.Update(r.Context(), id, input)
 	if err != nil {
@@ -202,13 +218,14 @@ func (h *agentsHandler) RegenerateKey(w http.ResponseWriter, r *http.Request) {
 	auditLog(r, "regenerate_key", "agent", id)
 
 	resp := map[string]interface{}{
-		"id":             ag.ID,
-		"name":           ag.Name,
-		"api_key_prefix": ag.APIKeyPrefix,
-		"api_key":        plaintext,
-		"team":           ag.Team,
-		"rate_limit":     ag.RateLimit,
-		"created_at":     ag.CreatedAt,
+		"id":                 ag.ID,
+		"name":               ag.Name,
+		"api_key_prefix":     ag.APIKeyPrefix,
+		"api_key":            plaintext,
+		"team":               ag.Team,
+		"rate_limit":         ag.RateLimit,
+		"rate_limit_wait_ms": ag.RateLimitWaitMs,
+		"created_at":         ag.CreatedAt,
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
@@ -222,20 +239,23 @@ func (h *agentsHandler) SetBudget(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var input struct {
-		DailyLimit   float64 `json:"daily_limit"`
-		MonthlyLimit float64 `json:"monthly_limit"`
-	}
+	var input budgetLimitsRequest
 	if err := readJSON(r, &input); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid_body", "failed to parse request body")
 		return
 	}
+	if !input.valid() {
+		writeError(w, http.StatusUnprocessableEntity, "validation_error", budgetLimitsMessage)
+		return
+	}
 
 	budget, err := h.budgetStore.Set(r.Context(), agent.CreateBudgetInput{
 		AgentID:      agentID,
 		ToolID:       toolID,
 		DailyLimit:   input.DailyLimit,
 		MonthlyLimit: input.MonthlyLimit,
+		WindowLimit:  input.WindowLimit,
+		BudgetWindow: input.BudgetWindow,
 	})
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "internal_error", "failed to set budget")
@@ -291,3 +311,102 @@ func (h *agentsHandler) ListBudgets(w http.ResponseWriter, r *http.Request) {
 		"budgets": budgets,
 	})
 }
+
+// budgetLimitsRequest is the JSON body for setting an agent's budget for a
+// tool or across all tools, or a team's budget.
+type budgetLimitsRequest struct {
+	DailyLimit   float64 `json:"daily_limit"`
+	MonthlyLimit float64 `json:"monthly_limit"`
+	WindowLimit  float64 `json:"window_limit"`
+	BudgetWindow string  `json:"budget_window"`
+}
+
+// budgetLimitsMessage explains an unusable budget.
+const budgetLimitsMessage = "daily_limit, monthly_limit and window_limit must not be negative, and budget_window must be daily, weekly, monthly or monthly:<day>, optionally with @<time zone>, or rolling:<n>h or rolling:<n>d up to 31 days"
+
+// valid reports whether the limits are usable; 0 means unlimited.
+func (b budgetLimitsRequest) valid() bool {
+	if b.DailyLimit < 0 || b.MonthlyLimit < 0 || b.WindowLimit < 0 {
+		return false
+	}
+	_, err := window.Parse(b.BudgetWindow)
+	return err == nil
+}
+
+// SetAgentBudget handles PUT /api/v1/admin/agents/{agentID}/budget — the
+// agent's budget across all tools.
+func (h *agentsHandler) SetAgentBudget(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "agentID")
+	if agentID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_params", "agent_id is required")
+		return
+	}
+
+	var input budgetLimitsRequest
+	if err := readJSON(r, &input); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "failed to parse request body")
+		return
+	}
+	if !input.valid() {
+		writeError(w, http.StatusUnprocessableEntity, "validation_error", budgetLimitsMessage)
+		return
+	}
+
+	if _, err := h.store.GetByID(r.Context(), agentID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "agent not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get agent")
+		return
+	}
+
+	budget, err := h.budgetStore.SetAgentBudget(r.Context(), agent.AgentBudget{
+		AgentID:      agentID,
+		DailyLimit:   input.DailyLimit,
+		MonthlyLimit: input.MonthlyLimit,
+		WindowLimit:  input.WindowLimit,
+		BudgetWindow: input.BudgetWindow,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to set budget")
+		return
+	}
+
+	auditLog(r, "set_budget", "agent_total_budget", agentID)
+
+	writeJSON(w, http.StatusOK, budget)
+}
+
+// GetAgentBudget handles GET /api/v1/admin/agents/{agentID}/budget (admin).
+func (h *agentsHandler) GetAgentBudget(w http.ResponseWriter, r *http.Request) {
+	writeAgentBudget(w, r, h.budgetStore, chi.URLParam(r, "agentID"))
+}
+
+// writeAgentBudget writes the agent's budget across all tools.
+func writeAgentBudget(w http.ResponseWriter, r *http.Request, budgetStore *agent.BudgetStore, agentID string) {
+	budget, err := budgetStore.GetAgentBudget(r.Context(), agentID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "budget not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get budget")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, budget)
+}
+
+// DeleteAgentBudget handles DELETE /api/v1/admin/agents/{agentID}/budget (admin).
+func (h *agentsHandler) DeleteAgentBudget(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "agentID")
+	if err := h.budgetStore.DeleteAgentBudget(r.Context(), agentID); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to delete budget")
+		return
+	}
+
+	auditLog(r, "delete_budget", "agent_total_budget", agentID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
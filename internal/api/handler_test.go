@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,6 +9,11 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/registry"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 )
 
 // ---------------------------------------------------------------------------
@@ -898,3 +904,127 @@ func TestIsValidationError(t *testing.T) {
 		})
 	}
 }
+
+func TestBudgetLimitsRequestValid(t *testing.T) {
+	tests := []struct {
+		name string
+		req  budgetLimitsRequest
+		want bool
+	}{
+		{"unlimited", budgetLimitsRequest{}, true},
+		{"daily and monthly", budgetLimitsRequest{DailyLimit: 1, MonthlyLimit: 20}, true},
+		{"weekly in a time zone", budgetLimitsRequest{WindowLimit: 5, BudgetWindow: "weekly@Australia/Sydney"}, true},
+		{"rolling", budgetLimitsRequest{WindowLimit: 5, BudgetWindow: "rolling:6h"}, true},
+		{"negative limit", budgetLimitsRequest{DailyLimit: -1}, false},
+		{"negative window limit", budgetLimitsRequest{WindowLimit: -1, BudgetWindow: "weekly"}, false},
+		{"unknown window", budgetLimitsRequest{WindowLimit: 5, BudgetWindow: "fortnightly"}, false},
+		{"rolling window in a time zone", budgetLimitsRequest{WindowLimit: 5, BudgetWindow: "rolling:1d@UTC"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.valid(); got != tt.want {
+				t.Errorf("valid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Member tool grant tests
+// ---------------------------------------------------------------------------
+
+// fakeGrantStore keeps grants in memory, enforcing who may replace and delete
+// them as registry.GrantStore does.
+type fakeGrantStore struct {
+	grants map[string]registry.Grant
+}
+
+func grantKey(toolID, scope, scopeID string) string {
+	return toolID + "/" + scope + "/" + scopeID
+}
+
+func (f *fakeGrantStore) ListByTool(_ context.Context, toolID string) ([]registry.Grant, error) {
+	var grants []registry.Grant
+	for _, g := range f.grants {
+		if g.ToolID == toolID {
+			grants = append(grants, g)
+		}
+	}
+	return grants, nil
+}
+
+func (f *fakeGrantStore) Get(_ context.Context, toolID, scope, scopeID string) (*registry.Grant, error) {
+	g, ok := f.grants[grantKey(toolID, scope, scopeID)]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return &g, nil
+}
+
+func (f *fakeGrantStore) Set(_ context.Context, toolID, scope, scopeID, effect, source string) (*registry.Grant, error) {
+	key := grantKey(toolID, scope, scopeID)
+	if g, ok := f.grants[key]; ok && source != registry.GrantSourceAdmin && g.Source != source {
+		return nil, registry.ErrAdminGrant
+	}
+	g := registry.Grant{ToolID: toolID, Scope: scope, ScopeID: scopeID, Effect: effect, Source: source}
+	f.grants[key] = g
+	return &g, nil
+}
+
+func (f *fakeGrantStore) Delete(_ context.Context, toolID, scope, scopeID, source string) error {
+	key := grantKey(toolID, scope, scopeID)
+	g, ok := f.grants[key]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	if source != registry.GrantSourceAdmin && g.Source != source {
+		return registry.ErrAdminGrant
+	}
+	delete(f.grants, key)
+	return nil
+}
+
+func TestMemberToolGrants(t *testing.T) {
+	store := &fakeGrantStore{grants: map[string]registry.Grant{
+		grantKey("tool-1", "team", "ml"): {ToolID: "tool-1", Scope: "team", ScopeID: "ml", Effect: "deny", Source: registry.GrantSourceAdmin},
+		grantKey("tool-2", "team", "ml"): {ToolID: "tool-2", Scope: "team", ScopeID: "ml", Effect: "deny", Source: registry.GrantSourceMember},
+	}}
+	h := newToolGrantsHandler(store, nil, nil)
+	teamAdmin := &auth.User{ID: "u1", Role: "member", Teams: []auth.TeamMembership{{Team: "ml", Role: "admin"}}}
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(auth.ContextWithUser(r.Context(), teamAdmin)))
+		})
+	})
+	r.Put("/tools/{toolID}/grants", h.MemberSetToolGrant)
+	r.Delete("/tools/{toolID}/grants/{scope}/{scopeID}", h.MemberDeleteToolGrant)
+
+	tests := []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"allow own team", http.MethodPut, "/tools/tool-3/grants", `{"scope":"team","scope_id":"ml","effect":"allow"}`, http.StatusForbidden},
+		{"delete admin deny", http.MethodDelete, "/tools/tool-1/grants/team/ml", "", http.StatusForbidden},
+		{"delete own deny", http.MethodDelete, "/tools/tool-2/grants/team/ml", "", http.StatusNoContent},
+		{"delete other team's grant", http.MethodDelete, "/tools/tool-1/grants/team/web", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+
+	if _, ok := store.grants[grantKey("tool-3", "team", "ml")]; ok {
+		t.Error("team admin allowed their own team a tool")
+	}
+	if g, ok := store.grants[grantKey("tool-1", "team", "ml")]; !ok || g.Effect != "deny" {
+		t.Errorf("org admin's deny = %+v, %v; want it kept", g, ok)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/alecgard/octroi/internal/agent"
 	"github.com/alecgard/octroi/internal/auth"
 	"github.com/alecgard/octroi/internal/metering"
+	"github.com/alecgard/octroi/internal/ratelimit"
 	"github.com/alecgard/octroi/internal/registry"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
@@ -19,13 +20,15 @@ type memberHandler struct {
 	agentStore  *agent.Store
 	toolService *registry.Service
 	meterStore  *metering.Store
+	budgetStore *agent.BudgetStore
 }
 
-func newMemberHandler(agentStore *agent.Store, toolService *registry.Service, meterStore *metering.Store) *memberHandler {
+func newMemberHandler(agentStore *agent.Store, toolService *registry.Service, meterStore *metering.Store, budgetStore *agent.BudgetStore) *memberHandler {
 	return &memberHandler{
 		agentStore:  agentStore,
 		toolService: toolService,
 		meterStore:  meterStore,
+		budgetStore: budgetStore,
 	}
 }
 
@@ -73,9 +76,10 @@ func (h *memberHandler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Name      string `json:"name"`
-		Team      string `json:"team"`
-		RateLimit int    `json:"rate_limit"`
+		Name            string `json:"name"`
+		Team            string `json:"team"`
+		RateLimit       int    `json:"rate_limit"`
+		RateLimitWaitMs int    `json:"rate_limit_wait_ms"`
 	}
 	if err := readJSON(r, &req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid_body", "failed to parse request body")
@@ -86,6 +90,10 @@ func (h *memberHandler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusUnprocessableEntity, "validation_error", "name is required")
 		return
 	}
+	if !ratelimit.ValidWaitMs(req.RateLimitWaitMs) {
+		writeError(w, http.StatusUnprocessableEntity, "validation_error", rateLimitWaitMessage)
+		return
+	}
 
 	teamNames := u.TeamNames()
 
@@ -115,11 +123,12 @@ func (h *memberHandler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	input := agent.CreateAgentInput{
-		Name:         req.Name,
-		APIKeyHash:   apiKey.Hash,
-		APIKeyPrefix: apiKey.Prefix,
-		Team:         team,
-		RateLimit:    req.RateLimit,
+		Name:            req.Name,
+		APIKeyHash:      apiKey.Hash,
+		APIKeyPrefix:    apiKey.Prefix,
+		Team:            team,
+		RateLimit:       req.RateLimit,
+		RateLimitWaitMs: req.RateLimitWaitMs,
 	}
 
 	ag, err := h.agentStore.Create(r.Context(), input)
@@ -131,13 +140,14 @@ func (h *memberHandler) CreateAgent(w http.ResponseWriter, r *http.Request) {
 	auditLog(r, "create", "agent", ag.ID, "name", ag.Name)
 
 	resp := map[string]interface{}{
-		"id":             ag.ID,
-		"name":           ag.Name,
-		"api_key_prefix": ag.APIKeyPrefix,
-		"api_key":        plaintext,
-		"team":           ag.Team,
-		"rate_limit":     ag.RateLimit,
-		"created_at":     ag.CreatedAt,
+		"id":                 ag.ID,
+		"name":               ag.Name,
+		"api_key_prefix":     ag.APIKeyPrefix,
+		"api_key":            plaintext,
+		"team":               ag.Team,
+		"rate_limit":         ag.RateLimit,
+		"rate_limit_wait_ms": ag.RateLimitWaitMs,
+		"created_at":         ag.CreatedAt,
 	}
 	writeJSON(w, http.StatusCreated, resp)
 }
@@ -178,6 +188,10 @@ func (h *memberHandler) UpdateAgent(w http.ResponseWriter, r *http.Request) {
 	}
 	// Members cannot change team.
 	input.Team = nil
+	if input.RateLimitWaitMs != nil && !ratelimit.ValidWaitMs(*input.RateLimitWaitMs) {
+		writeError(w, http.StatusUnprocessableEntity, "validation_error", rateLimitWaitMessage)
+		return
+	}
 
 	ag, err := h.agentStore.Update(r.Context(), id, input)
 	if err != nil {
@@ -273,16 +287,46 @@ func (h *memberHandler) RegenerateKey(w http.ResponseWriter, r *http.Request) {
 	auditLog(r, "regenerate_key", "agent", id)
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"id":             ag.ID,
-		"name":           ag.Name,
-		"api_key_prefix": ag.APIKeyPrefix,
-		"api_key":        plaintext,
-		"team":           ag.Team,
-		"rate_limit":     ag.RateLimit,
-		"created_at":     ag.CreatedAt,
+		"id":                 ag.ID,
+		"name":               ag.Name,
+		"api_key_prefix":     ag.APIKeyPrefix,
+		"api_key":            plaintext,
+		"team":               ag.Team,
+		"rate_limit":         ag.RateLimit,
+		"rate_limit_wait_ms": ag.RateLimitWaitMs,
+		"created_at":         ag.CreatedAt,
 	})
 }
 
+// GetAgentBudget handles GET /api/v1/member/agents/{id}/budget — the
+// budget across all tools of an agent in the user's teams.
+func (h *memberHandler) GetAgentBudget(w http.ResponseWriter, r *http.Request) {
+	u := auth.UserFromContext(r.Context())
+	if u == nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "not authenticated")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	// Verify ownership.
+	existing, err := h.agentStore.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "agent not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get agent")
+		return
+	}
+	if !u.InTeam(existing.Team) {
+		writeError(w, http.StatusNotFound, "not_found", "agent not found")
+		return
+	}
+
+	writeAgentBudget(w, r, h.budgetStore, id)
+}
+
 // ListTools handles GET /api/v1/member/tools — public tool list.
 func (h *memberHandler) ListTools(w http.ResponseWriter, r *http.Request) {
 	params := registry.ToolListParams{
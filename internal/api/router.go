@@ -11,6 +11,7 @@ import (
 
 	"github.com/alecgard/octroi/internal/agent"
 	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/health"
 	"github.com/alecgard/octroi/internal/metering"
 	"github.com/alecgard/octroi/internal/metrics"
 	"github.com/alecgard/octroi/internal/proxy"
@@ -118,6 +119,10 @@ type RouterDeps struct {
 	Proxy              *proxy.Handler
 	UserStore          *user.Store
 	ToolRateLimitStore *ratelimit.ToolRateLimitStore
+	ConcurrencyStore   *ratelimit.ToolConcurrencyLimitStore
+	GrantStore         *registry.GrantStore
+	HealthStore        *registry.HealthStore
+	Health             *health.Prober // nil when active health checks are disabled
 	AllowedOrigins     []string
 	Metrics            *metrics.Metrics
 }
@@ -138,6 +143,15 @@ func NewRouter(deps RouterDeps) http.Handler {
 
 	// Handlers.
 	tools := newToolsHandler(deps.ToolService)
+	if deps.Proxy != nil {
+		tools.circuits = deps.Proxy
+		tools.cache = deps.Proxy
+	}
+	var toolStatus ToolStatusReader
+	if deps.Health != nil {
+		toolStatus = deps.Health
+		tools.health = deps.Health
+	}
 	agents := newAgentsHandler(deps.AgentStore, deps.BudgetStore)
 	search := newSearchHandler(deps.ToolService)
 	usage := newUsageHandler(deps.MeterStore, deps.AgentStore)
@@ -199,9 +213,11 @@ func NewRouter(deps RouterDeps) http.Handler {
 	// Well-known manifest.
 	r.Get("/.well-known/octroi.json", WellKnownHandler)
 
-	// Public (unauthenticated) routes.
-	r.Get("/api/v1/tools/search", search.SearchTools)
-	r.Get("/api/v1/tools", tools.ListTools)
+	// Public (unauthenticated) routes. Tool listing optionally recognises an
+	// agent key so ?entitled=true can filter to the agent's permitted tools.
+	optionalAgent := auth.OptionalAgentAuthMiddleware(deps.Auth)
+	r.With(optionalAgent).Get("/api/v1/tools/search", search.SearchTools)
+	r.With(optionalAgent).Get("/api/v1/tools", tools.ListTools)
 	r.Get("/api/v1/tools/{id}", tools.GetTool)
 
 	// Public auth routes.
@@ -255,6 +271,9 @@ func NewRouter(deps RouterDeps) http.Handler {
 		ar.Put("/agents/{agentID}/budgets/{toolID}", agents.SetBudget)
 		ar.Get("/agents/{agentID}/budgets/{toolID}", agents.GetBudget)
 		ar.Get("/agents/{agentID}/budgets", agents.ListBudgets)
+		ar.Put("/agents/{agentID}/budget", agents.SetAgentBudget)
+		ar.Get("/agents/{agentID}/budget", agents.GetAgentBudget)
+		ar.Delete("/agents/{agentID}/budget", agents.DeleteAgentBudget)
 
 		// Admin usage queries.
 		ar.Get("/usage", usage.GetUsageAdmin)
@@ -283,17 +302,47 @@ func NewRouter(deps RouterDeps) http.Handler {
 			ar.Delete("/tools/{toolID}/rate-limits/{scope}/{scopeID}", trl.DeleteToolRateLimit)
 		}
 
+		// Tool concurrency limit overrides.
+		if deps.ConcurrencyStore != nil {
+			tcl := newToolConcurrencyLimitsHandler(deps.ConcurrencyStore, deps.ToolStore)
+			ar.Get("/tools/{toolID}/concurrency-limits", tcl.ListToolConcurrencyLimits)
+			ar.Put("/tools/{toolID}/concurrency-limits", tcl.SetToolConcurrencyLimit)
+			ar.Delete("/tools/{toolID}/concurrency-limits/{scope}/{scopeID}", tcl.DeleteToolConcurrencyLimit)
+		}
+
+		// Tool access grants.
+		if deps.GrantStore != nil {
+			tg := newToolGrantsHandler(deps.GrantStore, deps.ToolStore, deps.AgentStore)
+			ar.Get("/tools/{toolID}/grants", tg.ListToolGrants)
+			ar.Put("/tools/{toolID}/grants", tg.SetToolGrant)
+			ar.Get("/tools/{toolID}/grants/{scope}/{scopeID}", tg.GetToolGrant)
+			ar.Delete("/tools/{toolID}/grants/{scope}/{scopeID}", tg.DeleteToolGrant)
+		}
+
+		// Response cache.
+		ar.Delete("/tools/{toolID}/cache", tools.PurgeToolCache)
+
+		// Tool health check history.
+		if deps.HealthStore != nil {
+			th := newToolHealthHandler(deps.HealthStore, deps.ToolStore, toolStatus)
+			ar.Get("/tools/{toolID}/health", th.GetToolHealth)
+		}
+
 		// Teams (admin).
 		if deps.UserStore != nil {
-			teams := newTeamsHandler(deps.AgentStore, deps.UserStore)
+			teams := newTeamsHandler(deps.AgentStore, deps.UserStore, deps.BudgetStore)
 			ar.Get("/teams", teams.AdminListTeams)
+			ar.Get("/teams/budgets", teams.AdminListTeamBudgets)
+			ar.Put("/teams/{team}/budget", teams.SetTeamBudget)
+			ar.Get("/teams/{team}/budget", teams.AdminGetTeamBudget)
+			ar.Delete("/teams/{team}/budget", teams.DeleteTeamBudget)
 		}
 	})
 
 	// Member routes (require any valid session).
 	if deps.UserStore != nil && sessionLookup != nil {
-		member := newMemberHandler(deps.AgentStore, deps.ToolService, deps.MeterStore)
-		teams := newTeamsHandler(deps.AgentStore, deps.UserStore)
+		member := newMemberHandler(deps.AgentStore, deps.ToolService, deps.MeterStore, deps.BudgetStore)
+		teams := newTeamsHandler(deps.AgentStore, deps.UserStore, deps.BudgetStore)
 		users := newUsersHandler(deps.UserStore)
 		r.Route("/api/v1/member", func(mr chi.Router) {
 			mr.Use(auth.MemberAuthMiddleware(sessionLookup, memberAuthFail, memberAuthSuccess))
@@ -303,10 +352,18 @@ func NewRouter(deps RouterDeps) http.Handler {
 			mr.Put("/agents/{id}", member.UpdateAgent)
 			mr.Delete("/agents/{id}", member.DeleteAgent)
 			mr.Post("/agents/{id}/regenerate-key", member.RegenerateKey)
+			mr.Get("/agents/{id}/budget", member.GetAgentBudget)
 			mr.Get("/tools", member.ListTools)
 			mr.Get("/usage", member.GetUsage)
 			mr.Get("/usage/transactions", member.ListTransactions)
 			mr.Get("/teams", teams.MemberListTeams)
+			mr.Get("/teams/{team}/budget", teams.MemberGetTeamBudget)
+			if deps.GrantStore != nil {
+				tg := newToolGrantsHandler(deps.GrantStore, deps.ToolStore, deps.AgentStore)
+				mr.Get("/tools/{toolID}/grants", tg.MemberListToolGrants)
+				mr.Put("/tools/{toolID}/grants", tg.MemberSetToolGrant)
+				mr.Delete("/tools/{toolID}/grants/{scope}/{scopeID}", tg.MemberDeleteToolGrant)
+			}
 			mr.Put("/teams/{team}/members/{userId}", teams.AddTeamMember)
 			mr.Delete("/teams/{team}/members/{userId}", teams.RemoveTeamMember)
 			mr.Get("/users", users.MemberListUsers)
@@ -17,23 +17,31 @@ func newSearchHandler(svc *registry.Service) *searchHandler {
 }
 
 // SearchTools handles GET /api/v1/tools/search?q=...&limit=...&cursor=...
-// This is unauthenticated. Returns tools without endpoint or auth_config
+// This is unauthenticated unless entitled=true is set, which requires an agent
+// key and limits results to the tools that agent may call. Returns tools without endpoint or auth_config
 // (endpoint and auth_config have json:"-" on the Tool struct).
 func (h *searchHandler) SearchTools(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("q")
 	cursor := r.URL.Query().Get("cursor")
 
-	limit := 20
+	params := registry.ToolListParams{
+		Cursor: cursor,
+		Limit:  20,
+		Query:  q,
+	}
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
 		l, err := strconv.Atoi(limitStr)
 		if err != nil || l < 1 {
 			writeError(w, http.StatusBadRequest, "invalid_limit", "limit must be a positive integer")
 			return
 		}
-		limit = l
+		params.Limit = l
+	}
+	if !applyEntitlement(w, r, &params) {
+		return
 	}
 
-	tools, nextCursor, err := h.service.Search(r.Context(), q, limit, cursor)
+	tools, nextCursor, err := h.service.Search(r.Context(), params)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "internal_error", "failed to search tools")
 		return
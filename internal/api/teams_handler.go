@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"sort"
 
@@ -8,18 +9,21 @@ import (
 	"github.com/alecgard/octroi/internal/auth"
 	"github.com/alecgard/octroi/internal/user"
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 )
 
 // teamsHandler groups team-related HTTP handlers.
 type teamsHandler struct {
-	agentStore *agent.Store
-	userStore  *user.Store
+	agentStore  *agent.Store
+	userStore   *user.Store
+	budgetStore *agent.BudgetStore
 }
 
-func newTeamsHandler(agentStore *agent.Store, userStore *user.Store) *teamsHandler {
+func newTeamsHandler(agentStore *agent.Store, userStore *user.Store, budgetStore *agent.BudgetStore) *teamsHandler {
 	return &teamsHandler{
-		agentStore: agentStore,
-		userStore:  userStore,
+		agentStore:  agentStore,
+		userStore:   userStore,
+		budgetStore: budgetStore,
 	}
 }
 
@@ -304,3 +308,98 @@ func (h *teamsHandler) RemoveTeamMember(w http.ResponseWriter, r *http.Request)
 
 	writeJSON(w, http.StatusOK, updated)
 }
+
+// AdminListTeamBudgets handles GET /api/v1/admin/teams/budgets — every
+// team's budget.
+func (h *teamsHandler) AdminListTeamBudgets(w http.ResponseWriter, r *http.Request) {
+	budgets, err := h.budgetStore.ListTeamBudgets(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to list team budgets")
+		return
+	}
+	if budgets == nil {
+		budgets = []*agent.TeamBudget{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"budgets": budgets})
+}
+
+// SetTeamBudget handles PUT /api/v1/admin/teams/{team}/budget — the spending
+// limit shared by all of the team's agents across all tools.
+func (h *teamsHandler) SetTeamBudget(w http.ResponseWriter, r *http.Request) {
+	team := chi.URLParam(r, "team")
+
+	var input budgetLimitsRequest
+	if err := readJSON(r, &input); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "failed to parse request body")
+		return
+	}
+	if !input.valid() {
+		writeError(w, http.StatusUnprocessableEntity, "validation_error", budgetLimitsMessage)
+		return
+	}
+
+	budget, err := h.budgetStore.SetTeamBudget(r.Context(), agent.TeamBudget{
+		Team:         team,
+		DailyLimit:   input.DailyLimit,
+		MonthlyLimit: input.MonthlyLimit,
+		WindowLimit:  input.WindowLimit,
+		BudgetWindow: input.BudgetWindow,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to set team budget")
+		return
+	}
+
+	auditLog(r, "set_budget", "team_budget", team)
+
+	writeJSON(w, http.StatusOK, budget)
+}
+
+// AdminGetTeamBudget handles GET /api/v1/admin/teams/{team}/budget.
+func (h *teamsHandler) AdminGetTeamBudget(w http.ResponseWriter, r *http.Request) {
+	h.writeTeamBudget(w, r, chi.URLParam(r, "team"))
+}
+
+// MemberGetTeamBudget handles GET /api/v1/member/teams/{team}/budget — only
+// for members of the team.
+func (h *teamsHandler) MemberGetTeamBudget(w http.ResponseWriter, r *http.Request) {
+	u := auth.UserFromContext(r.Context())
+	if u == nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "not authenticated")
+		return
+	}
+
+	team := chi.URLParam(r, "team")
+	if !u.InTeam(team) {
+		writeError(w, http.StatusForbidden, "forbidden", "you are not a member of team "+team)
+		return
+	}
+	h.writeTeamBudget(w, r, team)
+}
+
+// writeTeamBudget writes the team's budget.
+func (h *teamsHandler) writeTeamBudget(w http.ResponseWriter, r *http.Request, team string) {
+	budget, err := h.budgetStore.GetTeamBudget(r.Context(), team)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "team budget not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get team budget")
+		return
+	}
+	writeJSON(w, http.StatusOK, budget)
+}
+
+// DeleteTeamBudget handles DELETE /api/v1/admin/teams/{team}/budget.
+func (h *teamsHandler) DeleteTeamBudget(w http.ResponseWriter, r *http.Request) {
+	team := chi.URLParam(r, "team")
+	if err := h.budgetStore.DeleteTeamBudget(r.Context(), team); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to delete team budget")
+		return
+	}
+
+	auditLog(r, "delete_budget", "team_budget", team)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alecgard/octroi/internal/ratelimit"
+	"github.com/alecgard/octroi/internal/registry"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// toolConcurrencyLimitsHandler groups handlers for tool concurrency limit overrides.
+type toolConcurrencyLimitsHandler struct {
+	store     *ratelimit.ToolConcurrencyLimitStore
+	toolStore *registry.Store
+}
+
+func newToolConcurrencyLimitsHandler(store *ratelimit.ToolConcurrencyLimitStore, toolStore *registry.Store) *toolConcurrencyLimitsHandler {
+	return &toolConcurrencyLimitsHandler{store: store, toolStore: toolStore}
+}
+
+// ListToolConcurrencyLimits handles GET /api/v1/admin/tools/{toolID}/concurrency-limits.
+func (h *toolConcurrencyLimitsHandler) ListToolConcurrencyLimits(w http.ResponseWriter, r *http.Request) {
+	toolID := chi.URLParam(r, "toolID")
+	if toolID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_id", "tool id is required")
+		return
+	}
+
+	tool, err := h.toolStore.GetByID(r.Context(), toolID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "tool not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get tool")
+		return
+	}
+
+	overrides, err := h.store.ListByTool(r.Context(), toolID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to list concurrency limit overrides")
+		return
+	}
+	if overrides == nil {
+		overrides = []ratelimit.ToolConcurrencyOverride{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"global_max_concurrency": tool.MaxConcurrency,
+		"overrides":              overrides,
+	})
+}
+
+// SetToolConcurrencyLimit handles PUT /api/v1/admin/tools/{toolID}/concurrency-limits.
+func (h *toolConcurrencyLimitsHandler) SetToolConcurrencyLimit(w http.ResponseWriter, r *http.Request) {
+	toolID := chi.URLParam(r, "toolID")
+	if toolID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_id", "tool id is required")
+		return
+	}
+
+	var input struct {
+		Scope          string `json:"scope"`
+		ScopeID        string `json:"scope_id"`
+		MaxConcurrency int    `json:"max_concurrency"`
+	}
+	if err := readJSON(r, &input); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "failed to parse request body")
+		return
+	}
+
+	if input.Scope != "team" && input.Scope != "agent" {
+		writeError(w, http.StatusBadRequest, "invalid_params", "scope must be 'team' or 'agent'")
+		return
+	}
+	if input.ScopeID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_params", "scope_id is required")
+		return
+	}
+	if input.MaxConcurrency <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_params", "max_concurrency must be a positive integer")
+		return
+	}
+
+	// Verify tool exists.
+	if _, err := h.toolStore.GetByID(r.Context(), toolID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "tool not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to verify tool")
+		return
+	}
+
+	if err := h.store.Set(r.Context(), toolID, input.Scope, input.ScopeID, input.MaxConcurrency); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to set concurrency limit override")
+		return
+	}
+	auditLog(r, "set_concurrency_limit", "tool", toolID, "scope", input.Scope, "scope_id", input.ScopeID, "max_concurrency", input.MaxConcurrency)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteToolConcurrencyLimit handles DELETE /api/v1/admin/tools/{toolID}/concurrency-limits/{scope}/{scopeID}.
+func (h *toolConcurrencyLimitsHandler) DeleteToolConcurrencyLimit(w http.ResponseWriter, r *http.Request) {
+	toolID := chi.URLParam(r, "toolID")
+	scope := chi.URLParam(r, "scope")
+	scopeID := chi.URLParam(r, "scopeID")
+
+	if toolID == "" || scope == "" || scopeID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_params", "toolID, scope, and scopeID are required")
+		return
+	}
+
+	if scope != "team" && scope != "agent" {
+		writeError(w, http.StatusBadRequest, "invalid_params", "scope must be 'team' or 'agent'")
+		return
+	}
+
+	err := h.store.Delete(r.Context(), toolID, scope, scopeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "concurrency limit override not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to delete concurrency limit override")
+		return
+	}
+	auditLog(r, "delete_concurrency_limit", "tool", toolID, "scope", scope, "scope_id", scopeID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/alecgard/octroi/internal/agent"
+	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/registry"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// grantStore is the part of registry.GrantStore the grant handlers use.
+type grantStore interface {
+	ListByTool(ctx context.Context, toolID string) ([]registry.Grant, error)
+	Get(ctx context.Context, toolID, scope, scopeID string) (*registry.Grant, error)
+	Set(ctx context.Context, toolID, scope, scopeID, effect, source string) (*registry.Grant, error)
+	Delete(ctx context.Context, toolID, scope, scopeID, source string) error
+}
+
+// toolGrantsHandler groups handlers for tool access grants.
+type toolGrantsHandler struct {
+	store      grantStore
+	toolStore  *registry.Store
+	agentStore *agent.Store
+}
+
+func newToolGrantsHandler(store grantStore, toolStore *registry.Store, agentStore *agent.Store) *toolGrantsHandler {
+	return &toolGrantsHandler{store: store, toolStore: toolStore, agentStore: agentStore}
+}
+
+// grantInput is the body of a request setting a tool grant.
+type grantInput struct {
+	Scope   string `json:"scope"`
+	ScopeID string `json:"scope_id"`
+	Effect  string `json:"effect"`
+}
+
+// validate returns a message describing what is wrong with the input, or ""
+// when it is valid.
+func (in grantInput) validate() string {
+	if !validGrantScope(in.Scope) {
+		return "scope must be 'team' or 'agent'"
+	}
+	if in.ScopeID == "" {
+		return "scope_id is required"
+	}
+	if in.Effect != "allow" && in.Effect != "deny" {
+		return "effect must be 'allow' or 'deny'"
+	}
+	return ""
+}
+
+// validGrantScope reports whether scope is a scope grants can be set for.
+func validGrantScope(scope string) bool {
+	return scope == "team" || scope == "agent"
+}
+
+// checkTool writes an error and returns false if the tool doesn't exist.
+func (h *toolGrantsHandler) checkTool(w http.ResponseWriter, r *http.Request, toolID string) bool {
+	if _, err := h.toolStore.GetByID(r.Context(), toolID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "tool not found")
+			return false
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get tool")
+		return false
+	}
+	return true
+}
+
+// ListToolGrants handles GET /api/v1/admin/tools/{toolID}/grants.
+func (h *toolGrantsHandler) ListToolGrants(w http.ResponseWriter, r *http.Request) {
+	toolID := chi.URLParam(r, "toolID")
+	if toolID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_id", "tool id is required")
+		return
+	}
+
+	if !h.checkTool(w, r, toolID) {
+		return
+	}
+
+	grants, err := h.store.ListByTool(r.Context(), toolID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to list tool grants")
+		return
+	}
+	if grants == nil {
+		grants = []registry.Grant{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"grants": grants,
+	})
+}
+
+// SetToolGrant handles PUT /api/v1/admin/tools/{toolID}/grants.
+func (h *toolGrantsHandler) SetToolGrant(w http.ResponseWriter, r *http.Request) {
+	toolID := chi.URLParam(r, "toolID")
+	if toolID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_id", "tool id is required")
+		return
+	}
+
+	var input grantInput
+	if err := readJSON(r, &input); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "failed to parse request body")
+		return
+	}
+	if msg := input.validate(); msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_params", msg)
+		return
+	}
+
+	if !h.checkTool(w, r, toolID) {
+		return
+	}
+
+	grant, err := h.store.Set(r.Context(), toolID, input.Scope, input.ScopeID, input.Effect, registry.GrantSourceAdmin)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to set tool grant")
+		return
+	}
+
+	auditLog(r, "set_grant", "tool", toolID, "scope", input.Scope, "scope_id", input.ScopeID, "effect", input.Effect)
+
+	writeJSON(w, http.StatusOK, grant)
+}
+
+// GetToolGrant handles GET /api/v1/admin/tools/{toolID}/grants/{scope}/{scopeID}.
+func (h *toolGrantsHandler) GetToolGrant(w http.ResponseWriter, r *http.Request) {
+	toolID := chi.URLParam(r, "toolID")
+	scope := chi.URLParam(r, "scope")
+	scopeID := chi.URLParam(r, "scopeID")
+
+	if toolID == "" || scope == "" || scopeID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_params", "toolID, scope, and scopeID are required")
+		return
+	}
+
+	if !validGrantScope(scope) {
+		writeError(w, http.StatusBadRequest, "invalid_params", "scope must be 'team' or 'agent'")
+		return
+	}
+
+	grant, err := h.store.Get(r.Context(), toolID, scope, scopeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "tool grant not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get tool grant")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, grant)
+}
+
+// DeleteToolGrant handles DELETE /api/v1/admin/tools/{toolID}/grants/{scope}/{scopeID}.
+func (h *toolGrantsHandler) DeleteToolGrant(w http.ResponseWriter, r *http.Request) {
+	toolID := chi.URLParam(r, "toolID")
+	scope := chi.URLParam(r, "scope")
+	scopeID := chi.URLParam(r, "scopeID")
+
+	if toolID == "" || scope == "" || scopeID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_params", "toolID, scope, and scopeID are required")
+		return
+	}
+
+	if !validGrantScope(scope) {
+		writeError(w, http.StatusBadRequest, "invalid_params", "scope must be 'team' or 'agent'")
+		return
+	}
+
+	err := h.store.Delete(r.Context(), toolID, scope, scopeID, registry.GrantSourceAdmin)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "tool grant not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to delete tool grant")
+		return
+	}
+
+	auditLog(r, "delete_grant", "tool", toolID, "scope", scope, "scope_id", scopeID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MemberListToolGrants handles GET /api/v1/member/tools/{toolID}/grants —
+// the tool's grants for the caller's teams and their agents.
+func (h *toolGrantsHandler) MemberListToolGrants(w http.ResponseWriter, r *http.Request) {
+	u := auth.UserFromContext(r.Context())
+	if u == nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "not authenticated")
+		return
+	}
+
+	toolID := chi.URLParam(r, "toolID")
+	if !h.checkTool(w, r, toolID) {
+		return
+	}
+
+	grants, err := h.store.ListByTool(r.Context(), toolID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to list tool grants")
+		return
+	}
+	agentIDs, err := h.agentStore.ListIDsByTeams(r.Context(), u.TeamNames())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to list agents")
+		return
+	}
+	ownAgents := make(map[string]bool, len(agentIDs))
+	for _, id := range agentIDs {
+		ownAgents[id] = true
+	}
+
+	visible := []registry.Grant{}
+	for _, g := range grants {
+		if (g.Scope == "team" && u.InTeam(g.ScopeID)) || (g.Scope == "agent" && ownAgents[g.ScopeID]) {
+			visible = append(visible, g)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"grants": visible,
+	})
+}
+
+// MemberSetToolGrant handles PUT /api/v1/member/tools/{toolID}/grants — only
+// for admins of the team the grant applies to. Team admins may only deny
+// access, and may not replace a grant an org admin set, so they cannot grant
+// themselves tools the org admins have not.
+func (h *toolGrantsHandler) MemberSetToolGrant(w http.ResponseWriter, r *http.Request) {
+	u := auth.UserFromContext(r.Context())
+	if u == nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "not authenticated")
+		return
+	}
+
+	toolID := chi.URLParam(r, "toolID")
+
+	var input grantInput
+	if err := readJSON(r, &input); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "failed to parse request body")
+		return
+	}
+	if msg := input.validate(); msg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_params", msg)
+		return
+	}
+
+	if input.Effect != "deny" {
+		writeError(w, http.StatusForbidden, "forbidden", "team admins can only deny access to tools")
+		return
+	}
+
+	if !h.checkManage(w, r, u, input.Scope, input.ScopeID) || !h.checkTool(w, r, toolID) {
+		return
+	}
+
+	grant, err := h.store.Set(r.Context(), toolID, input.Scope, input.ScopeID, input.Effect, registry.GrantSourceMember)
+	if err != nil {
+		if errors.Is(err, registry.ErrAdminGrant) {
+			writeError(w, http.StatusForbidden, "forbidden", "this grant was set by an org admin")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to set tool grant")
+		return
+	}
+
+	auditLog(r, "set_grant", "tool", toolID, "scope", input.Scope, "scope_id", input.ScopeID, "effect", input.Effect)
+
+	writeJSON(w, http.StatusOK, grant)
+}
+
+// MemberDeleteToolGrant handles DELETE
+// /api/v1/member/tools/{toolID}/grants/{scope}/{scopeID} — only for admins of
+// the team the grant applies to, and only for grants set through the member
+// API.
+func (h *toolGrantsHandler) MemberDeleteToolGrant(w http.ResponseWriter, r *http.Request) {
+	u := auth.UserFromContext(r.Context())
+	if u == nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "not authenticated")
+		return
+	}
+
+	toolID := chi.URLParam(r, "toolID")
+	scope := chi.URLParam(r, "scope")
+	scopeID := chi.URLParam(r, "scopeID")
+
+	if !validGrantScope(scope) {
+		writeError(w, http.StatusBadRequest, "invalid_params", "scope must be 'team' or 'agent'")
+		return
+	}
+	if !h.checkManage(w, r, u, scope, scopeID) {
+		return
+	}
+
+	if err := h.store.Delete(r.Context(), toolID, scope, scopeID, registry.GrantSourceMember); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "tool grant not found")
+			return
+		}
+		if errors.Is(err, registry.ErrAdminGrant) {
+			writeError(w, http.StatusForbidden, "forbidden", "this grant was set by an org admin")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to delete tool grant")
+		return
+	}
+
+	auditLog(r, "delete_grant", "tool", toolID, "scope", scope, "scope_id", scopeID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkManage writes an error and returns false unless u may manage grants
+// for the team, or the agent's team, the scope names.
+func (h *toolGrantsHandler) checkManage(w http.ResponseWriter, r *http.Request, u *auth.User, scope, scopeID string) bool {
+	team, err := h.grantTeam(r.Context(), scope, scopeID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "agent not found")
+			return false
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get agent")
+		return false
+	}
+	if !u.CanManageTeam(team) {
+		writeError(w, http.StatusForbidden, "forbidden", "you cannot manage team "+team)
+		return false
+	}
+	return true
+}
+
+// grantTeam returns the team a grant's scope belongs to.
+func (h *toolGrantsHandler) grantTeam(ctx context.Context, scope, scopeID string) (string, error) {
+	if scope == "team" {
+		return scopeID, nil
+	}
+	ag, err := h.agentStore.GetByID(ctx, scopeID)
+	if err != nil {
+		return "", err
+	}
+	return ag.Team, nil
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/alecgard/octroi/internal/registry"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// Default and maximum number of health checks returned per request.
+const (
+	defaultHealthCheckLimit = 50
+	maxHealthCheckLimit     = 500
+)
+
+// toolHealthHandler serves the active health check history of tools.
+type toolHealthHandler struct {
+	store     *registry.HealthStore
+	toolStore *registry.Store
+	status    ToolStatusReader
+}
+
+func newToolHealthHandler(store *registry.HealthStore, toolStore *registry.Store, status ToolStatusReader) *toolHealthHandler {
+	return &toolHealthHandler{store: store, toolStore: toolStore, status: status}
+}
+
+// GetToolHealth handles GET /api/v1/admin/tools/{toolID}/health.
+func (h *toolHealthHandler) GetToolHealth(w http.ResponseWriter, r *http.Request) {
+	toolID := chi.URLParam(r, "toolID")
+	if toolID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_id", "tool id is required")
+		return
+	}
+
+	limit := defaultHealthCheckLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 {
+			writeError(w, http.StatusBadRequest, "invalid_limit", "limit must be a positive integer")
+			return
+		}
+		limit = min(l, maxHealthCheckLimit)
+	}
+
+	tool, err := h.toolStore.GetByID(r.Context(), toolID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "tool not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get tool")
+		return
+	}
+
+	checks, err := h.store.ListByTool(r.Context(), toolID, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to list health checks")
+		return
+	}
+
+	status := "unknown"
+	if h.status != nil {
+		status = h.status.ToolStatus(toolID)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      status,
+		"health_path": tool.HealthPath,
+		"checks":      checks,
+	})
+}
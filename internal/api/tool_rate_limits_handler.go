@@ -62,9 +62,12 @@ func (h *toolRateLimitsHandler) SetToolRateLimit(w http.ResponseWriter, r *http.
 	}
 
 	var input struct {
-		Scope     string `json:"scope"`
-		ScopeID   string `json:"scope_id"`
-		RateLimit int    `json:"rate_limit"`
+		Scope     string                  `json:"scope"`
+		ScopeID   string                  `json:"scope_id"`
+		RateLimit int                     `json:"rate_limit"`
+		Algorithm string                  `json:"algorithm"`
+		Burst     int                     `json:"burst"`
+		Windows   []ratelimit.WindowLimit `json:"windows"`
 	}
 	if err := readJSON(r, &input); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid_body", "failed to parse request body")
@@ -79,8 +82,20 @@ func (h *toolRateLimitsHandler) SetToolRateLimit(w http.ResponseWriter, r *http.
 		writeError(w, http.StatusBadRequest, "invalid_params", "scope_id is required")
 		return
 	}
-	if input.RateLimit <= 0 {
-		writeError(w, http.StatusBadRequest, "invalid_params", "rate_limit must be a positive integer")
+	if input.RateLimit < 0 || input.RateLimit == 0 && len(input.Windows) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_params", "rate_limit must be a positive integer unless windows are given")
+		return
+	}
+	if !ratelimit.ValidWindows(input.Windows) {
+		writeError(w, http.StatusBadRequest, "invalid_params", "windows must have positive limits and distinct window_seconds of at most 31 days, up to 10 windows")
+		return
+	}
+	if input.Algorithm != "" && !ratelimit.ValidAlgorithm(input.Algorithm) {
+		writeError(w, http.StatusBadRequest, "invalid_params", "algorithm must be one of: token_bucket, sliding_window, gcra")
+		return
+	}
+	if input.Burst < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_params", "burst must be a non-negative integer")
 		return
 	}
 
@@ -94,7 +109,16 @@ func (h *toolRateLimitsHandler) SetToolRateLimit(w http.ResponseWriter, r *http.
 		return
 	}
 
-	if err := h.store.Set(r.Context(), toolID, input.Scope, input.ScopeID, input.RateLimit); err != nil {
+	override := ratelimit.ToolRateOverride{
+		ToolID:    toolID,
+		Scope:     input.Scope,
+		ScopeID:   input.ScopeID,
+		RateLimit: input.RateLimit,
+		Algorithm: input.Algorithm,
+		Burst:     input.Burst,
+		Windows:   input.Windows,
+	}
+	if err := h.store.Set(r.Context(), override); err != nil {
 		writeError(w, http.StatusInternalServerError, "internal_error", "failed to set rate limit override")
 		return
 	}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/alecgard/octroi/internal/auth"
 	"github.com/alecgard/octroi/internal/registry"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
@@ -12,7 +13,35 @@ import (
 
 // toolsHandler groups tool-related HTTP handlers.
 type toolsHandler struct {
-	service *registry.Service
+	service  *registry.Service
+	circuits CircuitStateReader
+	health   ToolStatusReader
+	cache    CachePurger
+}
+
+// CircuitStateReader reports the state of a tool's circuit breaker.
+type CircuitStateReader interface {
+	CircuitState(toolID string) string
+}
+
+// CachePurger drops a tool's cached responses.
+type CachePurger interface {
+	PurgeToolCache(toolID string) int
+}
+
+// ToolStatusReader reports a tool's status from active health checks.
+type ToolStatusReader interface {
+	ToolStatus(toolID string) string
+}
+
+// setStatus fills in the health check status of tools, if checks are enabled.
+func (h *toolsHandler) setStatus(tools ...*registry.Tool) {
+	if h.health == nil {
+		return
+	}
+	for _, t := range tools {
+		t.Status = h.health.ToolStatus(t.ID)
+	}
 }
 
 func newToolsHandler(svc *registry.Service) *toolsHandler {
@@ -99,6 +128,33 @@ func (h *toolsHandler) DeleteTool(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// PurgeToolCache handles DELETE /api/v1/admin/tools/{toolID}/cache.
+func (h *toolsHandler) PurgeToolCache(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "toolID")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "invalid_id", "tool id is required")
+		return
+	}
+
+	if _, err := h.service.GetByID(r.Context(), id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "not_found", "tool not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal_error", "failed to get tool")
+		return
+	}
+
+	purged := 0
+	if h.cache != nil {
+		purged = h.cache.PurgeToolCache(id)
+	}
+
+	auditLog(r, "purge_cache", "tool", id, "purged", purged)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"purged": purged})
+}
+
 // ListTools handles GET /api/v1/tools (public).
 func (h *toolsHandler) ListTools(w http.ResponseWriter, r *http.Request) {
 	params := registry.ToolListParams{
@@ -114,6 +170,10 @@ func (h *toolsHandler) ListTools(w http.ResponseWriter, r *http.Request) {
 		params.Limit = l
 	}
 
+	if !applyEntitlement(w, r, &params) {
+		return
+	}
+
 	tools, nextCursor, err := h.service.List(r.Context(), params)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "internal_error", "failed to list tools")
@@ -121,6 +181,7 @@ func (h *toolsHandler) ListTools(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Public view: Tool struct already omits endpoint and auth_config via json:"-".
+	h.setStatus(tools...)
 	resp := map[string]interface{}{
 		"tools": tools,
 	}
@@ -149,6 +210,7 @@ func (h *toolsHandler) GetTool(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Public view: Tool struct json:"-" tags hide endpoint and auth_config.
+	h.setStatus(tool)
 	writeJSON(w, http.StatusOK, tool)
 }
 
@@ -176,6 +238,12 @@ func (h *toolsHandler) AdminListTools(w http.ResponseWriter, r *http.Request) {
 	views := make([]map[string]interface{}, len(tools))
 	for i, t := range tools {
 		views[i] = adminToolView(t)
+		if h.circuits != nil {
+			views[i]["circuit_state"] = h.circuits.CircuitState(t.ID)
+		}
+		if h.health != nil {
+			views[i]["status"] = h.health.ToolStatus(t.ID)
+		}
 	}
 	resp := map[string]interface{}{
 		"tools": views,
@@ -186,25 +254,53 @@ func (h *toolsHandler) AdminListTools(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// applyEntitlement restricts params to the tools the calling agent may use when
+// the request sets ?entitled=true. It writes an error and returns false if the
+// request is not authenticated with an agent key.
+func applyEntitlement(w http.ResponseWriter, r *http.Request, params *registry.ToolListParams) bool {
+	if r.URL.Query().Get("entitled") != "true" {
+		return true
+	}
+	ag := auth.AgentFromContext(r.Context())
+	if ag == nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "entitled=true requires an agent api key")
+		return false
+	}
+	params.AgentID = ag.ID
+	params.Team = ag.Team
+	return true
+}
+
 // adminToolView returns a map that includes endpoint and auth_config for admin responses.
 func adminToolView(t *registry.Tool) map[string]interface{} {
 	return map[string]interface{}{
-		"id":               t.ID,
-		"name":             t.Name,
-		"description":      t.Description,
-		"mode":             t.Mode,
-		"endpoint":         t.Endpoint,
-		"auth_type":        t.AuthType,
-		"auth_config":      t.AuthConfig,
-		"variables":        t.Variables,
-		"pricing_model":    t.PricingModel,
-		"pricing_amount":   t.PricingAmount,
-		"pricing_currency": t.PricingCurrency,
-		"rate_limit":       t.RateLimit,
-		"budget_limit":     t.BudgetLimit,
-		"budget_window":    t.BudgetWindow,
-		"created_at":       t.CreatedAt,
-		"updated_at":       t.UpdatedAt,
+		"id":                 t.ID,
+		"name":               t.Name,
+		"description":        t.Description,
+		"mode":               t.Mode,
+		"endpoint":           t.Endpoint,
+		"targets":            t.Targets,
+		"lb_strategy":        t.LBStrategy,
+		"health_path":        t.HealthPath,
+		"auth_type":          t.AuthType,
+		"auth_config":        t.AuthConfig,
+		"tls_config":         t.TLSConfig,
+		"retry_policy":       t.RetryPolicy,
+		"cache_policy":       t.CachePolicy,
+		"coalesce_requests":  t.Coalesce,
+		"variables":          t.Variables,
+		"pricing_model":      t.PricingModel,
+		"pricing_amount":     t.PricingAmount,
+		"pricing_currency":   t.PricingCurrency,
+		"cost_estimate":      t.CostEstimate,
+		"rate_limit":         t.RateLimit,
+		"rate_limit_windows": t.RateWindows,
+		"rate_limit_wait_ms": t.RateLimitWaitMs,
+		"max_concurrency":    t.MaxConcurrency,
+		"budget_limit":       t.BudgetLimit,
+		"budget_window":      t.BudgetWindow,
+		"created_at":         t.CreatedAt,
+		"updated_at":         t.UpdatedAt,
 	}
 }
 
@@ -214,6 +310,19 @@ func isValidationError(err error) bool {
 		errors.Is(err, registry.ErrDescriptionRequired) ||
 		errors.Is(err, registry.ErrEndpointInvalid) ||
 		errors.Is(err, registry.ErrAuthTypeInvalid) ||
+		errors.Is(err, registry.ErrAuthConfigInvalid) ||
+		errors.Is(err, registry.ErrTLSConfigInvalid) ||
+		errors.Is(err, registry.ErrRetryPolicyInvalid) ||
+		errors.Is(err, registry.ErrCachePolicyInvalid) ||
+		errors.Is(err, registry.ErrTargetsInvalid) ||
+		errors.Is(err, registry.ErrLBStrategyInvalid) ||
+		errors.Is(err, registry.ErrHealthPathInvalid) ||
+		errors.Is(err, registry.ErrConcurrencyInvalid) ||
+		errors.Is(err, registry.ErrCostEstimateInvalid) ||
+		errors.Is(err, registry.ErrRateWaitInvalid) ||
+		errors.Is(err, registry.ErrRateWindowsInvalid) ||
+		errors.Is(err, registry.ErrBudgetWindowInvalid) ||
 		errors.Is(err, registry.ErrModeInvalid) ||
-		errors.Is(err, registry.ErrVariablesMissing)
+		errors.Is(err, registry.ErrVariablesMissing) ||
+		errors.Is(err, registry.ErrEndpointDenied)
 }
@@ -15,6 +15,9 @@ type Agent struct {
 	Name      string
 	Team      string
 	RateLimit int
+	// RateLimitWaitMs is how long a rate-limited request may wait for a
+	// token before it is rejected; 0 rejects at once.
+	RateLimitWaitMs int
 }
 
 // APIKey holds the hashed key and a short prefix for identification.
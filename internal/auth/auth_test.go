@@ -193,6 +193,50 @@ func TestAgentAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestOptionalAgentAuthMiddleware(t *testing.T) {
+	plaintext := "octroi_validkey1234567890abcdefgh"
+	store := &mockAgentLookup{
+		agents: map[string]*Agent{
+			HashKey(plaintext): {ID: "agent-1", Name: "TestAgent", Team: "platform"},
+		},
+	}
+	svc := NewService(store)
+
+	tests := []struct {
+		name       string
+		authHeader string
+		wantAgent  bool
+	}{
+		{"valid key", "Bearer " + plaintext, true},
+		{"invalid key", "Bearer octroi_wrongkey000000000000000000", false},
+		{"missing header", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAgent *Agent
+			handler := OptionalAgentAuthMiddleware(svc)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAgent = AgentFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected status 200, got %d", rr.Code)
+			}
+			if (gotAgent != nil) != tt.wantAgent {
+				t.Errorf("expected agent in context = %v, got %v", tt.wantAgent, gotAgent)
+			}
+		})
+	}
+}
+
 // assertJSONError checks that the response body contains the expected error JSON structure.
 func assertJSONError(t *testing.T, rr *httptest.ResponseRecorder) {
 	t.Helper()
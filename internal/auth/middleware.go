@@ -78,6 +78,30 @@ func AgentAuthMiddleware(svc *Service, callbacks ...func()) func(http.Handler) h
 	}
 }
 
+// OptionalAgentAuthMiddleware injects the agent into the request context when
+// the Authorization header carries a valid agent API key. Requests without a
+// key, or with a key that does not resolve, continue unauthenticated.
+func OptionalAgentAuthMiddleware(svc *Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := extractBearerToken(r)
+			if token == "" || svc == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			agent, err := svc.store.GetByKeyHash(r.Context(), HashKey(token))
+			if err != nil || agent == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := ContextWithAgent(r.Context(), agent)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 // AdminSessionMiddleware validates the session token and requires org_admin role.
 func AdminSessionMiddleware(sessions SessionLookup, callbacks ...func()) func(http.Handler) http.Handler {
 	var onFailure, onSuccess func()
@@ -0,0 +1,173 @@
+// Package window parses budget windows and computes when they start.
+package window
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRolling is the longest rolling budget window.
+const MaxRolling = 31 * 24 * time.Hour
+
+// windowUnit is the kind of span a budget window covers.
+type windowUnit int
+
+const (
+	unitDay windowUnit = iota
+	unitWeek
+	unitMonth
+	unitRolling
+)
+
+// Window is the span of time spend against a budget is counted over: a
+// calendar day, week (from Monday) or month in a time zone, a month starting
+// on a given day, or a rolling span ending now.
+//
+// Windows are written as daily, weekly, monthly or monthly:<day>, optionally
+// followed by @<IANA time zone>, or as rolling:<n>h or rolling:<n>d. An
+// empty window is monthly, and the time zone defaults to UTC. A month
+// anchored on a day it does not have starts on its last day.
+type Window struct {
+	unit   windowUnit
+	anchor int           // day of the month a monthly window starts on
+	span   time.Duration // length of a rolling window
+	loc    *time.Location
+}
+
+// Parse parses a budget window.
+func Parse(spec string) (Window, error) {
+	w := Window{anchor: 1, loc: time.UTC}
+	if spec == "" {
+		w.unit = unitMonth
+		return w, nil
+	}
+
+	name, zone, zoned := strings.Cut(spec, "@")
+	kind, arg, hasArg := strings.Cut(name, ":")
+	switch {
+	case kind == "daily" && !hasArg:
+		w.unit = unitDay
+	case kind == "weekly" && !hasArg:
+		w.unit = unitWeek
+	case kind == "monthly":
+		w.unit = unitMonth
+		if hasArg {
+			day, err := strconv.Atoi(arg)
+			if err != nil || day < 1 || day > 31 {
+				return Window{}, fmt.Errorf("budget window %q: monthly windows start on a day from 1 to 31", spec)
+			}
+			w.anchor = day
+		}
+	case kind == "rolling" && hasArg:
+		if zoned {
+			return Window{}, fmt.Errorf("budget window %q: rolling windows have no time zone", spec)
+		}
+		span, err := parseSpan(arg)
+		if err != nil || span > MaxRolling {
+			return Window{}, fmt.Errorf("budget window %q: rolling windows are 1h to 744h or 1d to 31d", spec)
+		}
+		w.unit, w.span = unitRolling, span
+	default:
+		return Window{}, fmt.Errorf("budget window %q: must be daily, weekly, monthly, monthly:<day> or rolling:<n>h|d", spec)
+	}
+
+	if zoned {
+		// Local depends on the server, so only named zones are accepted.
+		loc, err := time.LoadLocation(zone)
+		if err != nil || zone == "" || zone == "Local" {
+			return Window{}, fmt.Errorf("budget window %q: unknown time zone %q", spec, zone)
+		}
+		w.loc = loc
+	}
+	return w, nil
+}
+
+// parseSpan parses a rolling window's length: a positive number of hours
+// (24h) or days (7d).
+func parseSpan(s string) (time.Duration, error) {
+	unit := time.Hour
+	switch {
+	case strings.HasSuffix(s, "h"):
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	default:
+		return 0, errors.New("missing unit")
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 1 {
+		return 0, errors.New("invalid length")
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// String returns the window as Parse reads it.
+func (w Window) String() string {
+	var s string
+	switch w.unit {
+	case unitDay:
+		s = "daily"
+	case unitWeek:
+		s = "weekly"
+	case unitMonth:
+		s = "monthly"
+		if w.anchor > 1 {
+			s += ":" + strconv.Itoa(w.anchor)
+		}
+	case unitRolling:
+		if w.span%(24*time.Hour) == 0 {
+			return fmt.Sprintf("rolling:%dd", w.span/(24*time.Hour))
+		}
+		return fmt.Sprintf("rolling:%dh", w.span/time.Hour)
+	}
+	if w.loc != nil && w.loc != time.UTC {
+		s += "@" + w.loc.String()
+	}
+	return s
+}
+
+// Rolling returns the length of a rolling window and true, or 0 and false
+// for a calendar window.
+func (w Window) Rolling() (time.Duration, bool) {
+	return w.span, w.unit == unitRolling
+}
+
+// Start returns the start of the window holding now.
+func (w Window) Start(now time.Time) time.Time {
+	if w.unit == unitRolling {
+		return now.Add(-w.span)
+	}
+	t := now.In(w.loc)
+	y, m, d := t.Date()
+	switch w.unit {
+	case unitDay:
+		return midnight(y, m, d, w.loc)
+	case unitWeek:
+		// Days since Monday.
+		return midnight(y, m, d-(int(t.Weekday())+6)%7, w.loc)
+	}
+	if start := min(w.anchor, daysIn(y, m)); d >= start {
+		return midnight(y, m, start, w.loc)
+	}
+	py, pm, _ := time.Date(y, m, 1, 12, 0, 0, 0, w.loc).AddDate(0, -1, 0).Date()
+	return midnight(py, pm, min(w.anchor, daysIn(py, pm)), w.loc)
+}
+
+// midnight returns the first instant of the date y-m-d, normalised like
+// time.Date, in loc. Where a daylight saving change skips midnight, the day
+// starts when the clocks go forward.
+func midnight(y int, m time.Month, d int, loc *time.Location) time.Time {
+	noon := time.Date(y, m, d, 12, 0, 0, 0, loc)
+	t := time.Date(noon.Year(), noon.Month(), noon.Day(), 0, 0, 0, 0, loc)
+	if t.Day() != noon.Day() {
+		_, t = t.ZoneBounds()
+	}
+	return t
+}
+
+// daysIn returns the number of days in month m of year y.
+func daysIn(y int, m time.Month) int {
+	return time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
@@ -0,0 +1,99 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	valid := []struct {
+		spec, want string
+	}{
+		{"", "monthly"},
+		{"daily", "daily"},
+		{"daily@UTC", "daily"},
+		{"weekly@Europe/London", "weekly@Europe/London"},
+		{"monthly:1", "monthly"},
+		{"monthly:15@America/New_York", "monthly:15@America/New_York"},
+		{"rolling:36h", "rolling:36h"},
+		{"rolling:48h", "rolling:2d"},
+		{"rolling:31d", "rolling:31d"},
+	}
+	for _, tt := range valid {
+		w, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := w.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{
+		"yearly", "Daily", "daily:1", "weekly:monday", "monthly:0", "monthly:32", "monthly:x",
+		"rolling", "rolling:", "rolling:0h", "rolling:5m", "rolling:32d", "rolling:745h", "rolling:1d@UTC",
+		"daily@", "daily@Local", "daily@Nowhere/City", "@UTC",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestWindowStart(t *testing.T) {
+	utc := func(y int, m time.Month, d, h, min int) time.Time { return time.Date(y, m, d, h, min, 0, 0, time.UTC) }
+	tests := []struct {
+		name   string
+		window string
+		now    time.Time
+		want   time.Time
+	}{
+		{"daily", "daily", utc(2024, 3, 15, 18, 30), utc(2024, 3, 15, 0, 0)},
+		{"empty is monthly", "", utc(2024, 3, 15, 18, 30), utc(2024, 3, 1, 0, 0)},
+
+		// New York springs forward on 10 March 2024 and falls back on 3
+		// November; the day starts at local midnight either side.
+		{"day clocks go forward", "daily@America/New_York", utc(2024, 3, 10, 16, 0), utc(2024, 3, 10, 5, 0)},
+		{"evening before clocks go forward", "daily@America/New_York", utc(2024, 3, 10, 3, 30), utc(2024, 3, 9, 5, 0)},
+		{"last hour of the day clocks go back", "daily@America/New_York", utc(2024, 11, 4, 4, 30), utc(2024, 11, 3, 4, 0)},
+		{"day after clocks go back", "daily@America/New_York", utc(2024, 11, 4, 5, 0), utc(2024, 11, 4, 5, 0)},
+		// Havana's clocks go forward at midnight, so the day starts at 01:00.
+		{"midnight skipped", "daily@America/Havana", utc(2024, 3, 10, 16, 0), utc(2024, 3, 10, 5, 0)},
+
+		{"weekly from Monday", "weekly", utc(2024, 3, 18, 0, 0), utc(2024, 3, 18, 0, 0)},
+		{"weekly on Sunday", "weekly", utc(2024, 3, 17, 23, 59), utc(2024, 3, 11, 0, 0)},
+		{"weekly across a year", "weekly", utc(2025, 1, 1, 12, 0), utc(2024, 12, 30, 0, 0)},
+		// London moves to BST on Sunday 31 March 2024, the last day of the week.
+		{"weekly across clocks going forward", "weekly@Europe/London", utc(2024, 3, 31, 11, 0), utc(2024, 3, 25, 0, 0)},
+		{"weekly after clocks go forward", "weekly@Europe/London", utc(2024, 4, 1, 12, 0), utc(2024, 3, 31, 23, 0)},
+
+		{"monthly in a time zone ahead of UTC", "monthly@Asia/Tokyo", utc(2024, 3, 31, 16, 0), utc(2024, 3, 31, 15, 0)},
+		{"monthly in a time zone behind UTC", "monthly@America/Los_Angeles", utc(2024, 4, 1, 3, 0), utc(2024, 3, 1, 8, 0)},
+		{"monthly across clocks going forward", "monthly@Europe/Berlin", utc(2024, 4, 10, 12, 0), utc(2024, 3, 31, 22, 0)},
+
+		{"anchored on its day", "monthly:15", utc(2024, 3, 15, 0, 0), utc(2024, 3, 15, 0, 0)},
+		{"anchored before its day", "monthly:15", utc(2024, 3, 14, 23, 59), utc(2024, 2, 15, 0, 0)},
+		{"anchored across a year", "monthly:5", utc(2024, 1, 3, 12, 0), utc(2023, 12, 5, 0, 0)},
+		{"anchored past the end of February", "monthly:31", utc(2024, 2, 29, 10, 0), utc(2024, 2, 29, 0, 0)},
+		{"anchored day February lacks", "monthly:31", utc(2024, 2, 28, 10, 0), utc(2024, 1, 31, 0, 0)},
+		{"anchored after a short month", "monthly:31", utc(2024, 3, 30, 10, 0), utc(2024, 2, 29, 0, 0)},
+		{"anchored on the day a month has", "monthly:31", utc(2024, 3, 31, 10, 0), utc(2024, 3, 31, 0, 0)},
+		{"anchored past the end of April", "monthly:31", utc(2024, 4, 30, 10, 0), utc(2024, 4, 30, 0, 0)},
+		{"anchored in a time zone", "monthly:15@America/New_York", utc(2024, 11, 15, 3, 0), utc(2024, 10, 15, 4, 0)},
+
+		{"rolling hours", "rolling:24h", utc(2024, 3, 10, 16, 0), utc(2024, 3, 9, 16, 0)},
+		{"rolling days", "rolling:7d", utc(2024, 3, 1, 12, 0), utc(2024, 2, 23, 12, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := Parse(tt.window)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.window, err)
+			}
+			if got := w.Start(tt.now); !got.Equal(tt.want) {
+				t.Errorf("%s.Start(%v) = %v, want %v", tt.window, tt.now, got.UTC(), tt.want)
+			}
+		})
+	}
+}
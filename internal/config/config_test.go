@@ -88,6 +88,7 @@ func TestEnvOverrides(t *testing.T) {
 	t.Setenv("OCTROI_PORT", "3000")
 	t.Setenv("OCTROI_HOST", "10.0.0.1")
 	t.Setenv("OCTROI_ENCRYPTION_KEY", "abc123")
+	t.Setenv("OCTROI_EGRESS_ALLOW_PRIVATE", "true")
 
 	cfg, err := Load("")
 	if err != nil {
@@ -106,6 +107,9 @@ func TestEnvOverrides(t *testing.T) {
 	if cfg.Encryption.Key != "abc123" {
 		t.Errorf("expected encryption key abc123, got %s", cfg.Encryption.Key)
 	}
+	if !cfg.Proxy.Egress.AllowPrivate {
+		t.Error("expected egress allow_private from env")
+	}
 }
 
 func TestValidate(t *testing.T) {
@@ -120,7 +124,36 @@ func TestValidate(t *testing.T) {
 		{"zero read timeout", func(c *Config) { c.Server.ReadTimeout = 0 }, true},
 		{"empty db url", func(c *Config) { c.Database.URL = "" }, true},
 		{"zero proxy timeout", func(c *Config) { c.Proxy.Timeout = 0 }, true},
+		{"zero stream idle timeout", func(c *Config) { c.Proxy.StreamIdleTimeout = 0 }, true},
 		{"zero max request size", func(c *Config) { c.Proxy.MaxRequestSize = 0 }, true},
+		{"invalid egress cidr", func(c *Config) { c.Proxy.Egress.DeniedCIDRs = []string{"10.0.0.0"} }, true},
+		{"valid egress cidrs", func(c *Config) { c.Proxy.Egress.AllowedCIDRs = []string{"10.1.0.0/16", "fd00::/8"} }, false},
+		{"invalid egress redirects", func(c *Config) { c.Proxy.Egress.Redirects = "sometimes" }, true},
+		{"postgres rate limit backend", func(c *Config) { c.RateLimit.Backend = "postgres" }, false},
+		{"unknown rate limit backend", func(c *Config) { c.RateLimit.Backend = "redis" }, true},
+		{"negative rate limit max buckets", func(c *Config) { c.RateLimit.MaxBuckets = -1 }, true},
+		{"zero rate limit idle windows", func(c *Config) { c.RateLimit.IdleWindows = 0 }, true},
+		{"gcra rate limit algorithm", func(c *Config) { c.RateLimit.Algorithm = "gcra" }, false},
+		{"unknown rate limit algorithm", func(c *Config) { c.RateLimit.Algorithm = "leaky_bucket" }, true},
+		{"negative rate limit burst", func(c *Config) { c.RateLimit.Burst = -1 }, true},
+		{"zero budget reservation ttl", func(c *Config) { c.Budgets.ReservationTTL = 0 }, true},
+		{"zero budget reconcile interval", func(c *Config) { c.Budgets.ReconcileInterval = 0 }, true},
+		{"budget reconcile interval beyond reservation ttl", func(c *Config) { c.Budgets.ReconcileInterval = 10 * time.Minute }, true},
+		{"no budget warning thresholds", func(c *Config) { c.Budgets.WarningThresholds = nil }, false},
+		{"budget warning threshold of 100", func(c *Config) { c.Budgets.WarningThresholds = []int{50, 100} }, true},
+		{"budget warning thresholds out of order", func(c *Config) { c.Budgets.WarningThresholds = []int{80, 50} }, true},
+		{"webhook url", func(c *Config) { c.Notifications.Webhooks = []WebhookConfig{{URL: "https://hooks.example.com/x"}} }, false},
+		{"webhook url without scheme", func(c *Config) { c.Notifications.Webhooks = []WebhookConfig{{URL: "hooks.example.com/x"}} }, true},
+		{"zero notification timeout", func(c *Config) { c.Notifications.Timeout = 0 }, true},
+		{"breaker failure rate above 1", func(c *Config) { c.Proxy.CircuitBreaker.FailureRate = 1.5 }, true},
+		{"zero breaker probes", func(c *Config) { c.Proxy.CircuitBreaker.HalfOpenProbes = 0 }, true},
+		{"disabled breaker skips checks", func(c *Config) { c.Proxy.CircuitBreaker = BreakerConfig{} }, false},
+		{"cache entry larger than cache", func(c *Config) { c.Proxy.Cache.MaxEntryBytes = c.Proxy.Cache.MaxBytes + 1 }, true},
+		{"disabled cache skips checks", func(c *Config) { c.Proxy.Cache = CacheConfig{} }, false},
+		{"negative concurrency queue", func(c *Config) { c.Proxy.Concurrency.QueueSize = -1 }, true},
+		{"concurrency queue without timeout", func(c *Config) { c.Proxy.Concurrency = QueueConfig{QueueSize: 10} }, true},
+		{"health check timeout above interval", func(c *Config) { c.HealthChecks.Timeout = time.Minute }, true},
+		{"disabled health checks skip checks", func(c *Config) { c.HealthChecks = HealthCheckConfig{} }, false},
 		{"zero batch size", func(c *Config) { c.Metering.BatchSize = 0 }, true},
 		{"zero flush interval", func(c *Config) { c.Metering.FlushInterval = 0 }, true},
 		{"negative rate limit", func(c *Config) { c.RateLimit.Default = -1 }, true},
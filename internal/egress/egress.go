@@ -0,0 +1,299 @@
+// Package egress decides which upstream destinations the gateway may reach.
+//
+// A Policy is checked when tools are registered and again on every dial, so a
+// hostname that later resolves to a blocked address (DNS rebinding) or a
+// redirect towards one is still refused. A nil *Policy allows everything.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/alecgard/octroi/internal/config"
+)
+
+// ErrDenied is wrapped by every error returned for a blocked destination.
+var ErrDenied = errors.New("egress denied")
+
+// Redirect policies accepted in config.EgressConfig.Redirects.
+const (
+	RedirectFollow   = "follow"
+	RedirectSameHost = "same_host"
+	RedirectNone     = "none"
+)
+
+// maxRedirects matches the net/http default.
+const maxRedirects = 10
+
+// nonPublicNets are ranges blocked unless private egress is allowed; they
+// complement the checks in isNonPublic for ranges net.IP has no helper for.
+var nonPublicNets = mustParseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"64:ff9b::/96",  // NAT64, may embed private IPv4 addresses
+)
+
+// Policy is a compiled egress configuration.
+type Policy struct {
+	allowPrivate bool
+	allowedNets  []*net.IPNet
+	deniedNets   []*net.IPNet
+	allowedHosts []string
+	deniedHosts  []string
+	redirects    string
+	resolver     *net.Resolver
+}
+
+// New compiles cfg into a Policy.
+func New(cfg config.EgressConfig) (*Policy, error) {
+	allowed, err := parseCIDRs(cfg.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := parseCIDRs(cfg.DeniedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	redirects := cfg.Redirects
+	if redirects == "" {
+		redirects = RedirectFollow
+	}
+	return &Policy{
+		allowPrivate: cfg.AllowPrivate,
+		allowedNets:  allowed,
+		deniedNets:   denied,
+		allowedHosts: normalizePatterns(cfg.AllowedHosts),
+		deniedHosts:  normalizePatterns(cfg.DeniedHosts),
+		redirects:    redirects,
+		resolver:     net.DefaultResolver,
+	}, nil
+}
+
+// CheckHost checks a hostname or IP literal against the host patterns and,
+// for IP literals, the address rules.
+func (p *Policy) CheckHost(host string) error {
+	if p == nil {
+		return nil
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range p.deniedHosts {
+		if matchHost(pattern, host) {
+			return fmt.Errorf("%w: host %s is denied", ErrDenied, host)
+		}
+	}
+	if len(p.allowedHosts) > 0 {
+		allowed := false
+		for _, pattern := range p.allowedHosts {
+			if matchHost(pattern, host) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: host %s is not in the allowed hosts", ErrDenied, host)
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+	return nil
+}
+
+// CheckIP checks a resolved address. Denied CIDRs always win; allowed CIDRs
+// re-admit addresses that would otherwise be blocked as non-public.
+func (p *Policy) CheckIP(ip net.IP) error {
+	if p == nil {
+		return nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range p.deniedNets {
+		if n.Contains(ip) {
+			return fmt.Errorf("%w: address %s is in a denied range", ErrDenied, ip)
+		}
+	}
+	for _, n := range p.allowedNets {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	if !p.allowPrivate && isNonPublic(ip) {
+		return fmt.Errorf("%w: address %s is not public", ErrDenied, ip)
+	}
+	return nil
+}
+
+// CheckURL checks an endpoint at registration time: its host, and every
+// address the host currently resolves to. Hosts that do not resolve are let
+// through; the dial-time check still applies once they do.
+func (p *Policy) CheckURL(ctx context.Context, rawURL string) error {
+	if p == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("parsing url: %w", err)
+	}
+	return p.checkResolved(ctx, u.Hostname(), false)
+}
+
+// Proxy wraps an http.Transport Proxy func such as http.ProxyFromEnvironment.
+// Requests sent through a forward proxy are dialled by the proxy, not the
+// gateway, so the dial-time check would only ever see the proxy's address.
+// Instead the request's host and every address it resolves to are checked
+// before the proxy is used, and hosts that do not resolve are refused.
+func (p *Policy) Proxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	if p == nil || proxy == nil {
+		return proxy
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := proxy(req)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+		if err := p.checkResolved(req.Context(), req.URL.Hostname(), true); err != nil {
+			return nil, err
+		}
+		return proxyURL, nil
+	}
+}
+
+// checkResolved checks host and every address it resolves to. Hosts that do
+// not resolve are refused when strict is set and let through otherwise.
+func (p *Policy) checkResolved(ctx context.Context, host string, strict bool) error {
+	if err := p.CheckHost(host); err != nil {
+		return err
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	addrs, err := p.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		if strict {
+			return fmt.Errorf("%w: cannot resolve host %s: %v", ErrDenied, host, err)
+		}
+		return nil
+	}
+	for _, addr := range addrs {
+		if err := p.CheckIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DialContext wraps d so that every connection is checked against the
+// policy: the requested host before resolution, and the exact address being
+// connected to afterwards.
+func (p *Policy) DialContext(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if p == nil {
+		return d.DialContext
+	}
+	dialer := *d
+	dialer.Control = func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrDenied, err)
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("%w: unresolved address %s", ErrDenied, address)
+		}
+		return p.CheckIP(ip)
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.CheckHost(host); err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// CheckRedirect is an http.Client CheckRedirect hook applying the redirect
+// policy. Followed redirects are re-checked against the host rules here and
+// against the address rules when the new connection is dialled.
+func (p *Policy) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if p == nil {
+		return nil
+	}
+	switch p.redirects {
+	case RedirectNone:
+		return http.ErrUseLastResponse
+	case RedirectSameHost:
+		if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+			return fmt.Errorf("%w: redirect to another host %s", ErrDenied, req.URL.Host)
+		}
+	}
+	return p.CheckHost(req.URL.Hostname())
+}
+
+// isNonPublic reports whether ip is loopback, private, link-local,
+// unspecified, multicast or in one of nonPublicNets.
+func isNonPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost matches host against an exact name or a "*.suffix" wildcard,
+// which covers subdomains but not the bare suffix.
+func matchHost(pattern, host string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+func normalizePatterns(patterns []string) []string {
+	out := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		if p = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(p)), "."); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", c, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
@@ -0,0 +1,208 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/alecgard/octroi/internal/config"
+)
+
+func newPolicy(t *testing.T, cfg config.EgressConfig) *Policy {
+	t.Helper()
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCheckIPDefaults(t *testing.T) {
+	p := newPolicy(t, config.EgressConfig{})
+
+	blocked := []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"100.64.0.1", "0.0.0.0", "224.0.0.1", "::1", "fe80::1", "fd00::1",
+		"::ffff:127.0.0.1", "64:ff9b::a00:1",
+	}
+	for _, addr := range blocked {
+		if err := p.CheckIP(net.ParseIP(addr)); !errors.Is(err, ErrDenied) {
+			t.Errorf("CheckIP(%s) = %v, want ErrDenied", addr, err)
+		}
+	}
+	for _, addr := range []string{"93.184.216.34", "8.8.8.8", "2606:4700::1111"} {
+		if err := p.CheckIP(net.ParseIP(addr)); err != nil {
+			t.Errorf("CheckIP(%s) = %v, want nil", addr, err)
+		}
+	}
+}
+
+func TestCheckIPCIDRs(t *testing.T) {
+	p := newPolicy(t, config.EgressConfig{
+		AllowedCIDRs: []string{"10.20.0.0/16"},
+		DeniedCIDRs:  []string{"10.20.5.0/24", "203.0.113.0/24"},
+	})
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"10.20.1.1", true},
+		{"10.20.5.1", false},
+		{"10.30.0.1", false},
+		{"203.0.113.9", false},
+		{"198.51.100.1", true},
+	}
+	for _, tt := range tests {
+		err := p.CheckIP(net.ParseIP(tt.addr))
+		if (err == nil) != tt.allowed {
+			t.Errorf("CheckIP(%s) = %v, want allowed=%v", tt.addr, err, tt.allowed)
+		}
+	}
+
+	private := newPolicy(t, config.EgressConfig{AllowPrivate: true, DeniedCIDRs: []string{"169.254.0.0/16"}})
+	if err := private.CheckIP(net.ParseIP("127.0.0.1")); err != nil {
+		t.Errorf("expected loopback allowed with allow_private, got %v", err)
+	}
+	if err := private.CheckIP(net.ParseIP("169.254.169.254")); err == nil {
+		t.Error("expected denied CIDR to win over allow_private")
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	p := newPolicy(t, config.EgressConfig{
+		AllowedHosts: []string{"api.example.com", "*.trusted.io"},
+		DeniedHosts:  []string{"bad.trusted.io"},
+	})
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"api.example.com", true},
+		{"API.Example.com.", true},
+		{"other.example.com", false},
+		{"x.trusted.io", true},
+		{"trusted.io", false},
+		{"bad.trusted.io", false},
+	}
+	for _, tt := range tests {
+		err := p.CheckHost(tt.host)
+		if (err == nil) != tt.allowed {
+			t.Errorf("CheckHost(%s) = %v, want allowed=%v", tt.host, err, tt.allowed)
+		}
+	}
+}
+
+func TestNilPolicyAllowsEverything(t *testing.T) {
+	var p *Policy
+	if err := p.CheckHost("127.0.0.1"); err != nil {
+		t.Errorf("CheckHost: %v", err)
+	}
+	if err := p.CheckURL(context.Background(), "http://169.254.169.254/"); err != nil {
+		t.Errorf("CheckURL: %v", err)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	p := newPolicy(t, config.EgressConfig{})
+	ctx := context.Background()
+	if err := p.CheckURL(ctx, "http://169.254.169.254/latest/meta-data"); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected metadata address denied, got %v", err)
+	}
+	if err := p.CheckURL(ctx, "http://localhost:8080/"); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected localhost denied, got %v", err)
+	}
+	if err := p.CheckURL(ctx, "https://93.184.216.34/"); err != nil {
+		t.Errorf("expected public address allowed, got %v", err)
+	}
+}
+
+func TestProxyChecksDestination(t *testing.T) {
+	forward, _ := url.Parse("http://proxy.internal:3128")
+	viaProxy := newPolicy(t, config.EgressConfig{}).Proxy(func(*http.Request) (*url.URL, error) { return forward, nil })
+
+	for _, target := range []string{
+		"http://169.254.169.254/latest/meta-data",
+		"http://localhost:8080/",
+		"http://does-not-resolve.invalid/",
+	} {
+		req, _ := http.NewRequest("GET", target, nil)
+		if _, err := viaProxy(req); !errors.Is(err, ErrDenied) {
+			t.Errorf("%s: expected ErrDenied through the proxy, got %v", target, err)
+		}
+	}
+	req, _ := http.NewRequest("GET", "https://93.184.216.34/", nil)
+	if got, err := viaProxy(req); err != nil || got != forward {
+		t.Errorf("expected public address sent through the proxy, got %v %v", got, err)
+	}
+
+	// Direct requests are left to the dial-time check.
+	direct := newPolicy(t, config.EgressConfig{}).Proxy(func(*http.Request) (*url.URL, error) { return nil, nil })
+	req, _ = http.NewRequest("GET", "http://169.254.169.254/", nil)
+	if got, err := direct(req); got != nil || err != nil {
+		t.Errorf("expected no proxy and no error, got %v %v", got, err)
+	}
+
+	var p *Policy
+	if p.Proxy(nil) != nil {
+		t.Error("expected a nil policy to keep a nil proxy func")
+	}
+}
+
+func TestDialContextBlocksResolvedAddress(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	dial := newPolicy(t, config.EgressConfig{}).DialContext(&net.Dialer{})
+	// "localhost" passes the host rules; the dialled address must not.
+	if _, err := dial(context.Background(), "tcp", net.JoinHostPort("localhost", port)); !errors.Is(err, ErrDenied) {
+		t.Fatalf("expected ErrDenied dialling localhost, got %v", err)
+	}
+
+	dial = newPolicy(t, config.EgressConfig{AllowedCIDRs: []string{"127.0.0.0/8", "::1/128"}}).DialContext(&net.Dialer{})
+	conn, err := dial(context.Background(), "tcp", u.Host)
+	if err != nil {
+		t.Fatalf("expected dial allowed by allowed_cidrs, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestCheckRedirect(t *testing.T) {
+	origin, _ := http.NewRequest("GET", "https://api.example.com/a", nil)
+	sameHost, _ := http.NewRequest("GET", "https://api.example.com/b", nil)
+	otherHost, _ := http.NewRequest("GET", "https://cdn.example.net/b", nil)
+	private, _ := http.NewRequest("GET", "http://10.0.0.1/b", nil)
+	via := []*http.Request{origin}
+
+	follow := newPolicy(t, config.EgressConfig{})
+	if err := follow.CheckRedirect(otherHost, via); err != nil {
+		t.Errorf("follow: expected other host allowed, got %v", err)
+	}
+	if err := follow.CheckRedirect(private, via); !errors.Is(err, ErrDenied) {
+		t.Errorf("follow: expected private redirect denied, got %v", err)
+	}
+
+	same := newPolicy(t, config.EgressConfig{Redirects: RedirectSameHost})
+	if err := same.CheckRedirect(sameHost, via); err != nil {
+		t.Errorf("same_host: expected same host allowed, got %v", err)
+	}
+	if err := same.CheckRedirect(otherHost, via); !errors.Is(err, ErrDenied) {
+		t.Errorf("same_host: expected other host denied, got %v", err)
+	}
+
+	none := newPolicy(t, config.EgressConfig{Redirects: RedirectNone})
+	if err := none.CheckRedirect(sameHost, via); !errors.Is(err, http.ErrUseLastResponse) {
+		t.Errorf("none: expected ErrUseLastResponse, got %v", err)
+	}
+}
+
+func TestNewRejectsBadCIDR(t *testing.T) {
+	if _, err := New(config.EgressConfig{AllowedCIDRs: []string{"nope"}}); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+}
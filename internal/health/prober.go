@@ -0,0 +1,257 @@
+// Package health actively checks that registered tools' upstreams are
+// reachable.
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// Tool status values.
+const (
+	StatusUp      = "up"
+	StatusDown    = "down"
+	StatusUnknown = "unknown" // not checked, or no result yet
+)
+
+// maxConcurrentProbes bounds how many tools are checked at once.
+const maxConcurrentProbes = 8
+
+// pruneInterval is how often expired history is deleted.
+const pruneInterval = time.Hour
+
+// Checker sends one health request to a tool's upstream and returns the
+// response status code.
+type Checker interface {
+	Probe(ctx context.Context, tool *registry.Tool, path string) (int, error)
+}
+
+// ToolLister lists the tools that have a health_path.
+type ToolLister interface {
+	ListHealthChecked(ctx context.Context) ([]*registry.Tool, error)
+}
+
+// History stores health check results.
+type History interface {
+	Record(ctx context.Context, c registry.HealthCheck) error
+	Latest(ctx context.Context) (map[string]registry.HealthCheck, error)
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// MetricsRecorder is the subset of metrics.Metrics the prober reports to.
+type MetricsRecorder interface {
+	SetToolUp(toolID, toolName string, up bool)
+	ObserveToolProbe(toolID, toolName string, seconds float64)
+	DeleteToolUp(toolID, toolName string)
+}
+
+// Prober periodically calls each tool's health_path and keeps the latest
+// result per tool.
+type Prober struct {
+	checker   Checker
+	tools     ToolLister
+	history   History
+	metrics   MetricsRecorder
+	interval  time.Duration
+	timeout   time.Duration
+	retention time.Duration
+	now       func() time.Time
+
+	mu        sync.RWMutex
+	latest    map[string]registry.HealthCheck
+	names     map[string]string // tool ID -> name of tools with metric series
+	lastPrune time.Time
+}
+
+// NewProber creates a prober that checks every tool each interval, giving
+// each check up to timeout and keeping history for retention.
+func NewProber(checker Checker, tools ToolLister, history History, interval, timeout, retention time.Duration) *Prober {
+	return &Prober{
+		checker:   checker,
+		tools:     tools,
+		history:   history,
+		interval:  interval,
+		timeout:   timeout,
+		retention: retention,
+		now:       time.Now,
+		latest:    make(map[string]registry.HealthCheck),
+		names:     make(map[string]string),
+	}
+}
+
+// SetMetrics sets the optional metrics recorder.
+func (p *Prober) SetMetrics(m MetricsRecorder) {
+	p.metrics = m
+}
+
+// Start restores the latest results from history, then checks all tools
+// every interval until ctx is cancelled.
+func (p *Prober) Start(ctx context.Context) {
+	p.restore(ctx)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	p.RunOnce(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.RunOnce(ctx)
+		}
+	}
+}
+
+// restore loads the latest stored result of each tool, so statuses survive
+// a restart.
+func (p *Prober) restore(ctx context.Context) {
+	latest, err := p.history.Latest(ctx)
+	if err != nil {
+		slog.Warn("loading tool health history failed", "error", err)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, c := range latest {
+		p.latest[id] = c
+	}
+}
+
+// RunOnce checks every tool with a health_path once.
+func (p *Prober) RunOnce(ctx context.Context) {
+	tools, err := p.tools.ListHealthChecked(ctx)
+	if err != nil {
+		slog.Warn("listing tools for health checks failed", "error", err)
+		return
+	}
+	p.forgetRemoved(tools)
+
+	sem := make(chan struct{}, maxConcurrentProbes)
+	var wg sync.WaitGroup
+	for _, tool := range tools {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			p.check(ctx, tool)
+		}()
+	}
+	wg.Wait()
+
+	p.prune(ctx)
+}
+
+// ToolStatus returns "up" or "down" from the latest check of toolID, or
+// "unknown" if it has not been checked.
+func (p *Prober) ToolStatus(toolID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c, ok := p.latest[toolID]
+	switch {
+	case !ok:
+		return StatusUnknown
+	case c.Up:
+		return StatusUp
+	}
+	return StatusDown
+}
+
+// check probes one tool and records the result.
+func (p *Prober) check(ctx context.Context, tool *registry.Tool) {
+	probeCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	checkedAt := p.now()
+	start := time.Now()
+	code, err := p.checker.Probe(probeCtx, tool, tool.HealthPath)
+	latency := time.Since(start)
+	if ctx.Err() != nil {
+		return // shutting down
+	}
+
+	c := registry.HealthCheck{
+		ToolID:     tool.ID,
+		CheckedAt:  checkedAt,
+		Up:         err == nil && code >= 200 && code < 400,
+		StatusCode: code,
+		LatencyMs:  latency.Milliseconds(),
+	}
+	if err != nil {
+		c.Error = probeError(err)
+	}
+
+	p.mu.Lock()
+	prev, seen := p.latest[tool.ID]
+	p.latest[tool.ID] = c
+	p.names[tool.ID] = tool.Name
+	p.mu.Unlock()
+
+	if seen && prev.Up != c.Up {
+		slog.Info("tool health changed", "tool_id", tool.ID, "tool_name", tool.Name,
+			"up", c.Up, "status_code", c.StatusCode, "error", c.Error)
+	}
+	if p.metrics != nil {
+		p.metrics.SetToolUp(tool.ID, tool.Name, c.Up)
+		p.metrics.ObserveToolProbe(tool.ID, tool.Name, latency.Seconds())
+	}
+	if err := p.history.Record(ctx, c); err != nil {
+		slog.Warn("recording tool health check failed", "tool_id", tool.ID, "error", err)
+	}
+}
+
+// forgetRemoved drops results for tools that are no longer checked, e.g.
+// because they were deleted or their health_path was cleared.
+func (p *Prober) forgetRemoved(tools []*registry.Tool) {
+	checked := make(map[string]bool, len(tools))
+	for _, t := range tools {
+		checked[t.ID] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id := range p.latest {
+		if checked[id] {
+			continue
+		}
+		delete(p.latest, id)
+		if name, ok := p.names[id]; ok {
+			delete(p.names, id)
+			if p.metrics != nil {
+				p.metrics.DeleteToolUp(id, name)
+			}
+		}
+	}
+}
+
+// prune deletes history older than the retention period, at most once per
+// pruneInterval.
+func (p *Prober) prune(ctx context.Context) {
+	now := p.now()
+	if now.Sub(p.lastPrune) < pruneInterval {
+		return
+	}
+	p.lastPrune = now
+	n, err := p.history.Prune(ctx, now.Add(-p.retention))
+	if err != nil {
+		slog.Warn("pruning tool health history failed", "error", err)
+	} else if n > 0 {
+		slog.Info("pruned tool health history", "count", n)
+	}
+}
+
+// probeError describes a failed probe. URL errors are unwrapped so that
+// credentials carried in the query string are not stored.
+func probeError(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return err.Error()
+}
@@ -0,0 +1,149 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+type fakeChecker struct {
+	results map[string]int // tool ID -> status code; missing tools fail
+	paths   sync.Map       // tool ID -> probed path
+}
+
+func (f *fakeChecker) Probe(_ context.Context, tool *registry.Tool, path string) (int, error) {
+	f.paths.Store(tool.ID, path)
+	code, ok := f.results[tool.ID]
+	if !ok {
+		return 0, &url.Error{Op: "Get", URL: "https://api.example.com/health?api_key=secret", Err: errors.New("connection refused")}
+	}
+	return code, nil
+}
+
+type fakeLister struct {
+	tools []*registry.Tool
+}
+
+func (f *fakeLister) ListHealthChecked(context.Context) ([]*registry.Tool, error) {
+	return f.tools, nil
+}
+
+type fakeHistory struct {
+	mu     sync.Mutex
+	checks []registry.HealthCheck
+	latest map[string]registry.HealthCheck
+	pruned []time.Time
+}
+
+func (f *fakeHistory) Record(_ context.Context, c registry.HealthCheck) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checks = append(f.checks, c)
+	return nil
+}
+
+func (f *fakeHistory) Latest(context.Context) (map[string]registry.HealthCheck, error) {
+	return f.latest, nil
+}
+
+func (f *fakeHistory) Prune(_ context.Context, before time.Time) (int64, error) {
+	f.pruned = append(f.pruned, before)
+	return 0, nil
+}
+
+type fakeMetrics struct {
+	mu      sync.Mutex
+	up      map[string]bool
+	deleted []string
+}
+
+func (f *fakeMetrics) SetToolUp(toolID, _ string, up bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.up[toolID] = up
+}
+
+func (f *fakeMetrics) ObserveToolProbe(string, string, float64) {}
+
+func (f *fakeMetrics) DeleteToolUp(toolID, _ string) {
+	f.deleted = append(f.deleted, toolID)
+}
+
+func TestProberRunOnce(t *testing.T) {
+	checker := &fakeChecker{results: map[string]int{"ok": 200, "broken": 503}}
+	lister := &fakeLister{tools: []*registry.Tool{
+		{ID: "ok", Name: "ok-tool", HealthPath: "/healthz"},
+		{ID: "broken", Name: "broken-tool", HealthPath: "/status"},
+		{ID: "gone", Name: "gone-tool", HealthPath: "/"},
+	}}
+	history := &fakeHistory{}
+	m := &fakeMetrics{up: map[string]bool{}}
+	p := NewProber(checker, lister, history, time.Minute, time.Second, time.Hour)
+	p.SetMetrics(m)
+
+	if got := p.ToolStatus("ok"); got != StatusUnknown {
+		t.Fatalf("expected unknown before the first check, got %s", got)
+	}
+	p.RunOnce(context.Background())
+
+	for id, want := range map[string]string{"ok": StatusUp, "broken": StatusDown, "gone": StatusDown, "other": StatusUnknown} {
+		if got := p.ToolStatus(id); got != want {
+			t.Errorf("ToolStatus(%q) = %s, want %s", id, got, want)
+		}
+	}
+	if path, _ := checker.paths.Load("ok"); path != "/healthz" {
+		t.Errorf("expected the tool's health_path probed, got %v", path)
+	}
+	if len(history.checks) != 3 {
+		t.Fatalf("expected 3 checks recorded, got %d", len(history.checks))
+	}
+	for _, c := range history.checks {
+		if strings.Contains(c.Error, "secret") {
+			t.Errorf("expected credentials stripped from error, got %q", c.Error)
+		}
+		if c.ToolID == "gone" && c.Error != "connection refused" {
+			t.Errorf("expected probe error recorded, got %q", c.Error)
+		}
+	}
+	if !m.up["ok"] || m.up["broken"] {
+		t.Errorf("expected octroi_tool_up to follow results, got %v", m.up)
+	}
+	if len(history.pruned) != 1 {
+		t.Errorf("expected history pruned once, got %d", len(history.pruned))
+	}
+
+	// A tool that is no longer checked loses its status and metric series.
+	lister.tools = lister.tools[:2]
+	p.RunOnce(context.Background())
+	if got := p.ToolStatus("gone"); got != StatusUnknown {
+		t.Errorf("expected removed tool to be unknown, got %s", got)
+	}
+	if len(m.deleted) != 1 || m.deleted[0] != "gone" {
+		t.Errorf("expected metric series of removed tool deleted, got %v", m.deleted)
+	}
+	if len(history.pruned) != 1 {
+		t.Errorf("expected pruning limited to once per %v, got %d", pruneInterval, len(history.pruned))
+	}
+}
+
+func TestProberRestoresHistory(t *testing.T) {
+	history := &fakeHistory{latest: map[string]registry.HealthCheck{
+		"up":   {ToolID: "up", Up: true},
+		"down": {ToolID: "down", Up: false},
+	}}
+	p := NewProber(&fakeChecker{}, &fakeLister{}, history, time.Hour, time.Second, time.Hour)
+	p.restore(context.Background())
+
+	if got := p.ToolStatus("up"); got != StatusUp {
+		t.Errorf("expected restored status up, got %s", got)
+	}
+	if got := p.ToolStatus("down"); got != StatusDown {
+		t.Errorf("expected restored status down, got %s", got)
+	}
+}
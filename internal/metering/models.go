@@ -12,12 +12,24 @@ type Transaction struct {
 	Path         string    `json:"path"`
 	StatusCode   int       `json:"status_code"`
 	LatencyMs    int64     `json:"latency_ms"`
+	TTFBMs       int64     `json:"ttfb_ms"`
+	DurationMs   int64     `json:"duration_ms"`
 	RequestSize  int64     `json:"request_size"`
 	ResponseSize int64     `json:"response_size"`
 	Success      bool      `json:"success"`
 	Cost         float64   `json:"cost"`
 	CostSource   string    `json:"cost_source"`
+	Streamed     bool      `json:"streamed"`
+	MessagesIn   int64     `json:"messages_in"`
+	MessagesOut  int64     `json:"messages_out"`
+	Attempts     int       `json:"attempts"`  // upstream attempts, including retries
+	Target       string    `json:"target"`    // host of the upstream target that served the request
+	Coalesced    bool      `json:"coalesced"` // served from another request's upstream call
 	Error        string    `json:"error"`
+
+	// ReservationID is the budget reservation held for the request, if any.
+	// Writing the transaction settles it at Cost and marks it committed.
+	ReservationID string `json:"-"`
 }
 
 // UsageSummary holds aggregate metrics for a set of transactions.
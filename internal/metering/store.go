@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -22,27 +23,40 @@ func NewStore(pool *pgxpool.Pool) *Store {
 }
 
 // BatchInsert writes a slice of transactions to the database in a single
-// multi-row INSERT statement. It is a no-op when txns is empty.
+// multi-row INSERT statement. It is a no-op when txns is empty. Budget
+// reservations held for the transactions are settled at their actual cost and
+// marked committed in the same database transaction, so their spend moves
+// from reserved to committed atomically.
 func (s *Store) BatchInsert(ctx context.Context, txns []Transaction) error {
 	if len(txns) == 0 {
 		return nil
 	}
 
-	const cols = 13 // number of columns per row (excluding server-generated id)
+	const cols = 21 // number of columns per row (excluding server-generated id)
 	args := make([]any, 0, len(txns)*cols)
 	rows := make([]string, 0, len(txns))
+	var reservations []string
+	var reservedCosts []float64
 
 	for i, tx := range txns {
 		base := i * cols
-		rows = append(rows, fmt.Sprintf(
-			"($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
-			base+1, base+2, base+3, base+4, base+5, base+6,
-			base+7, base+8, base+9, base+10, base+11, base+12, base+13,
-		))
+		placeholders := make([]string, cols)
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", base+j+1)
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
 		costSource := tx.CostSource
 		if costSource == "" {
 			costSource = "flat"
 		}
+		attempts := tx.Attempts
+		if attempts < 1 {
+			attempts = 1
+		}
+		if tx.ReservationID != "" {
+			reservations = append(reservations, tx.ReservationID)
+			reservedCosts = append(reservedCosts, tx.Cost)
+		}
 		args = append(args,
 			tx.AgentID,
 			tx.ToolID,
@@ -57,20 +71,49 @@ func (s *Store) BatchInsert(ctx context.Context, txns []Transaction) error {
 			tx.Cost,
 			tx.Error,
 			costSource,
+			tx.TTFBMs,
+			tx.DurationMs,
+			tx.Streamed,
+			tx.MessagesIn,
+			tx.MessagesOut,
+			attempts,
+			tx.Target,
+			tx.Coalesced,
 		)
 	}
 
 	query := `INSERT INTO transactions
 		(agent_id, tool_id, timestamp, method, path, status_code, latency_ms,
-		 request_size, response_size, success, cost, error, cost_source)
+		 request_size, response_size, success, cost, error, cost_source,
+		 ttfb_ms, duration_ms, streamed, messages_in, messages_out, attempts, target,
+		 coalesced)
 		VALUES ` + strings.Join(rows, ", ")
 
-	_, err := s.pool.Exec(ctx, query, args...)
-	if err != nil {
-		return fmt.Errorf("batch inserting transactions: %w", err)
+	if len(reservations) == 0 {
+		if _, err := s.pool.Exec(ctx, query, args...); err != nil {
+			return fmt.Errorf("batch inserting transactions: %w", err)
+		}
+		return nil
 	}
 
-	return nil
+	return pgx.BeginFunc(ctx, s.pool, func(dbtx pgx.Tx) error {
+		if _, err := dbtx.Exec(ctx, query, args...); err != nil {
+			return fmt.Errorf("batch inserting transactions: %w", err)
+		}
+		// Settle each reservation at its request's actual cost. Last, so
+		// the commit follows as closely as possible.
+		_, err := dbtx.Exec(ctx,
+			`UPDATE budget_reservations r
+			 SET amount = c.cost, committed_at = clock_timestamp()
+			 FROM unnest($1::uuid[], $2::numeric[]) AS c(id, cost)
+			 WHERE r.id = c.id`,
+			reservations, reservedCosts,
+		)
+		if err != nil {
+			return fmt.Errorf("committing budget reservations: %w", err)
+		}
+		return nil
+	})
 }
 
 // GetSummary returns aggregate usage metrics matching the given query filters.
@@ -149,7 +192,8 @@ func (s *Store) ListTransactions(ctx context.Context, q UsageQuery) ([]*Transact
 	}
 
 	query := `SELECT id, agent_id, tool_id, timestamp, method, path,
-		status_code, latency_ms, request_size, response_size, success, cost, cost_source, error
+		status_code, latency_ms, request_size, response_size, success, cost, cost_source, error,
+		ttfb_ms, duration_ms, streamed, messages_in, messages_out, attempts, target, coalesced
 	FROM transactions` + where +
 		` ORDER BY timestamp DESC, id DESC LIMIT $` + strconv.Itoa(len(args)+1)
 	args = append(args, limit+1) // fetch one extra to determine if there's a next page
@@ -167,6 +211,7 @@ func (s *Store) ListTransactions(ctx context.Context, q UsageQuery) ([]*Transact
 			&tx.ID, &tx.AgentID, &tx.ToolID, &tx.Timestamp,
 			&tx.Method, &tx.Path, &tx.StatusCode, &tx.LatencyMs,
 			&tx.RequestSize, &tx.ResponseSize, &tx.Success, &tx.Cost, &tx.CostSource, &tx.Error,
+			&tx.TTFBMs, &tx.DurationMs, &tx.Streamed, &tx.MessagesIn, &tx.MessagesOut, &tx.Attempts, &tx.Target, &tx.Coalesced,
 		); err != nil {
 			return nil, "", fmt.Errorf("scanning transaction row: %w", err)
 		}
@@ -25,7 +25,9 @@ type Metrics struct {
 
 	// Rate limiting and budget metrics.
 	RateLimitRejectionsTotal *prometheus.CounterVec
+	RateLimitBuckets         prometheus.Gauge
 	BudgetRejectionsTotal    *prometheus.CounterVec
+	BudgetAlertsTotal        *prometheus.CounterVec
 
 	// Collector (metering) metrics.
 	CollectorBufferSize         prometheus.Gauge
@@ -40,6 +42,33 @@ type Metrics struct {
 	// Proxy upstream error metrics.
 	ProxyUpstreamErrorsTotal *prometheus.CounterVec
 
+	// Upstream credential metrics.
+	ProxyTokenFetchErrorsTotal *prometheus.CounterVec
+
+	// Upstream retry metrics.
+	ProxyUpstreamRetriesTotal *prometheus.CounterVec
+	ProxyUpstreamAttempts     *prometheus.HistogramVec
+
+	// Circuit breaker metrics.
+	ProxyCircuitBreakerState *prometheus.GaugeVec
+
+	// Response cache metrics.
+	ProxyCacheRequestsTotal *prometheus.CounterVec
+	ProxyCacheEntries       prometheus.Gauge
+	ProxyCacheBytes         prometheus.Gauge
+
+	// Request coalescing metrics.
+	ProxyCoalescedRequestsTotal *prometheus.CounterVec
+
+	// Concurrency limit metrics.
+	ProxyConcurrencyQueueDepth      *prometheus.GaugeVec
+	ProxyConcurrencyWait            *prometheus.HistogramVec
+	ProxyConcurrencyRejectionsTotal *prometheus.CounterVec
+
+	// Active health check metrics.
+	ToolUp            *prometheus.GaugeVec
+	ToolProbeDuration *prometheus.HistogramVec
+
 	// Server lifecycle.
 	ServerStartTime prometheus.Gauge
 }
@@ -94,12 +123,21 @@ func New() *Metrics {
 			Name: "octroi_ratelimit_rejections_total",
 			Help: "Total number of rate limit rejections.",
 		}, []string{"limiter_type", "scope"}),
+		RateLimitBuckets: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "octroi_ratelimit_buckets",
+			Help: "Number of live rate limiter token buckets.",
+		}),
 
 		BudgetRejectionsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "octroi_budget_rejections_total",
 			Help: "Total number of budget rejections.",
 		}, []string{"budget_type"}),
 
+		BudgetAlertsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "octroi_budget_alerts_total",
+			Help: "Total number of budget warning thresholds reached.",
+		}, []string{"budget_type", "threshold"}),
+
 		CollectorBufferSize: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "octroi_collector_buffer_size",
 			Help: "Current number of buffered metering transactions.",
@@ -136,6 +174,74 @@ func New() *Metrics {
 			Help: "Total number of upstream request errors by error type.",
 		}, []string{"error_type", "tool_id", "tool_name"}),
 
+		ProxyTokenFetchErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "octroi_proxy_token_fetch_errors_total",
+			Help: "Total number of failed OAuth2 token fetches for upstream tools.",
+		}, []string{"tool_id", "tool_name"}),
+
+		ProxyUpstreamRetriesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "octroi_proxy_upstream_retries_total",
+			Help: "Total number of upstream request retries by reason.",
+		}, []string{"tool_id", "tool_name", "reason"}),
+
+		ProxyUpstreamAttempts: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "octroi_proxy_upstream_attempts",
+			Help:    "Number of upstream attempts per proxied request.",
+			Buckets: []float64{1, 2, 3, 4, 5, 10},
+		}, []string{"tool_id", "tool_name"}),
+
+		ProxyCircuitBreakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "octroi_proxy_circuit_breaker_state",
+			Help: "Circuit breaker state per tool (0 closed, 1 half-open, 2 open).",
+		}, []string{"tool_id", "tool_name"}),
+
+		ProxyCacheRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "octroi_proxy_cache_requests_total",
+			Help: "Total number of cacheable proxy requests by result (hit, miss, revalidated, bypass).",
+		}, []string{"tool_id", "tool_name", "result"}),
+
+		ProxyCacheEntries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "octroi_proxy_cache_entries",
+			Help: "Number of responses in the proxy response cache.",
+		}),
+
+		ProxyCacheBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "octroi_proxy_cache_bytes",
+			Help: "Total size of response bodies in the proxy response cache.",
+		}),
+
+		ProxyCoalescedRequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "octroi_proxy_coalesced_requests_total",
+			Help: "Total number of proxy requests answered from an identical concurrent request's upstream call.",
+		}, []string{"tool_id", "tool_name"}),
+
+		ProxyConcurrencyQueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "octroi_proxy_concurrency_queue_depth",
+			Help: "Number of proxy requests waiting for a concurrency slot.",
+		}, []string{"tool_id"}),
+
+		ProxyConcurrencyWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "octroi_proxy_concurrency_wait_seconds",
+			Help:    "Time queued proxy requests waited for a concurrency slot.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"tool_id"}),
+
+		ProxyConcurrencyRejectionsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "octroi_proxy_concurrency_rejections_total",
+			Help: "Total number of proxy requests rejected by a concurrency limit.",
+		}, []string{"tool_id", "tool_name"}),
+
+		ToolUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "octroi_tool_up",
+			Help: "Whether the latest active health check of a tool succeeded (1) or failed (0).",
+		}, []string{"tool_id", "tool_name"}),
+
+		ToolProbeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "octroi_tool_probe_duration_seconds",
+			Help:    "Latency of active tool health checks.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"tool_id", "tool_name"}),
+
 		ServerStartTime: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "octroi_server_start_time_seconds",
 			Help: "Unix timestamp when the server started.",
@@ -152,7 +258,9 @@ func New() *Metrics {
 		m.ProxyUpstreamDuration,
 		m.ProxyActiveRequests,
 		m.RateLimitRejectionsTotal,
+		m.RateLimitBuckets,
 		m.BudgetRejectionsTotal,
+		m.BudgetAlertsTotal,
 		m.CollectorBufferSize,
 		m.CollectorFlushesTotal,
 		m.CollectorFlushDuration,
@@ -160,6 +268,19 @@ func New() *Metrics {
 		m.AuthFailuresTotal,
 		m.AuthSuccessesTotal,
 		m.ProxyUpstreamErrorsTotal,
+		m.ProxyTokenFetchErrorsTotal,
+		m.ProxyUpstreamRetriesTotal,
+		m.ProxyUpstreamAttempts,
+		m.ProxyCircuitBreakerState,
+		m.ProxyCacheRequestsTotal,
+		m.ProxyCacheEntries,
+		m.ProxyCacheBytes,
+		m.ProxyCoalescedRequestsTotal,
+		m.ProxyConcurrencyQueueDepth,
+		m.ProxyConcurrencyWait,
+		m.ProxyConcurrencyRejectionsTotal,
+		m.ToolUp,
+		m.ToolProbeDuration,
 		m.ServerStartTime,
 	)
 
@@ -218,6 +339,12 @@ func (m *Metrics) IncBudgetRejection(budgetType string) {
 	m.BudgetRejectionsTotal.WithLabelValues(budgetType).Inc()
 }
 
+// IncBudgetAlert increments the budget alert counter for a warning
+// threshold, in percent.
+func (m *Metrics) IncBudgetAlert(budgetType string, threshold int) {
+	m.BudgetAlertsTotal.WithLabelValues(budgetType, fmt.Sprintf("%d", threshold)).Inc()
+}
+
 // IncToolRateLimitRejection increments the tool-level rate limit rejection counter.
 func (m *Metrics) IncToolRateLimitRejection() {
 	m.RateLimitRejectionsTotal.WithLabelValues("tool", "tool").Inc()
@@ -232,3 +359,89 @@ func (m *Metrics) IncAuthSuccess(authType string) {
 func (m *Metrics) IncUpstreamError(errorType, toolID, toolName string) {
 	m.ProxyUpstreamErrorsTotal.WithLabelValues(errorType, toolID, toolName).Inc()
 }
+
+// IncTokenFetchError increments the counter of failed upstream token fetches.
+func (m *Metrics) IncTokenFetchError(toolID, toolName string) {
+	m.ProxyTokenFetchErrorsTotal.WithLabelValues(toolID, toolName).Inc()
+}
+
+// IncUpstreamRetry increments the counter of upstream retries. reason is the
+// retried status code or upstream error class.
+func (m *Metrics) IncUpstreamRetry(toolID, toolName, reason string) {
+	m.ProxyUpstreamRetriesTotal.WithLabelValues(toolID, toolName, reason).Inc()
+}
+
+// ObserveUpstreamAttempts records how many upstream attempts a request took.
+func (m *Metrics) ObserveUpstreamAttempts(toolID, toolName string, attempts int) {
+	m.ProxyUpstreamAttempts.WithLabelValues(toolID, toolName).Observe(float64(attempts))
+}
+
+// SetCircuitBreakerState records a tool's circuit breaker state.
+func (m *Metrics) SetCircuitBreakerState(toolID, toolName string, state int) {
+	m.ProxyCircuitBreakerState.WithLabelValues(toolID, toolName).Set(float64(state))
+}
+
+// DeleteCircuitBreakerState removes a tool's circuit breaker series once its
+// breaker is reset, e.g. because the tool was updated or deleted.
+func (m *Metrics) DeleteCircuitBreakerState(toolID, toolName string) {
+	m.ProxyCircuitBreakerState.DeleteLabelValues(toolID, toolName)
+}
+
+// IncCacheRequest records the cache result of a proxied request.
+func (m *Metrics) IncCacheRequest(toolID, toolName, result string) {
+	m.ProxyCacheRequestsTotal.WithLabelValues(toolID, toolName, result).Inc()
+}
+
+// SetCacheSize records the size of the response cache.
+func (m *Metrics) SetCacheSize(entries int, bytes int64) {
+	m.ProxyCacheEntries.Set(float64(entries))
+	m.ProxyCacheBytes.Set(float64(bytes))
+}
+
+// SetToolUp records the result of a tool's latest health check.
+func (m *Metrics) SetToolUp(toolID, toolName string, up bool) {
+	v := 0.0
+	if up {
+		v = 1
+	}
+	m.ToolUp.WithLabelValues(toolID, toolName).Set(v)
+}
+
+// ObserveToolProbe records the latency of a tool health check.
+func (m *Metrics) ObserveToolProbe(toolID, toolName string, seconds float64) {
+	m.ToolProbeDuration.WithLabelValues(toolID, toolName).Observe(seconds)
+}
+
+// DeleteToolUp removes a tool's health series once it is no longer checked.
+func (m *Metrics) DeleteToolUp(toolID, toolName string) {
+	m.ToolUp.DeleteLabelValues(toolID, toolName)
+	m.ToolProbeDuration.DeleteLabelValues(toolID, toolName)
+}
+
+// IncCoalescedRequest records a request that shared another request's
+// upstream call.
+func (m *Metrics) IncCoalescedRequest(toolID, toolName string) {
+	m.ProxyCoalescedRequestsTotal.WithLabelValues(toolID, toolName).Inc()
+}
+
+// SetConcurrencyQueueDepth records how many requests wait for a concurrency
+// slot of a tool.
+func (m *Metrics) SetConcurrencyQueueDepth(toolID string, depth int) {
+	m.ProxyConcurrencyQueueDepth.WithLabelValues(toolID).Set(float64(depth))
+}
+
+// ObserveConcurrencyWait records how long a request waited for a concurrency
+// slot.
+func (m *Metrics) ObserveConcurrencyWait(toolID string, seconds float64) {
+	m.ProxyConcurrencyWait.WithLabelValues(toolID).Observe(seconds)
+}
+
+// IncConcurrencyRejection records a request rejected by a concurrency limit.
+func (m *Metrics) IncConcurrencyRejection(toolID, toolName string) {
+	m.ProxyConcurrencyRejectionsTotal.WithLabelValues(toolID, toolName).Inc()
+}
+
+// SetRateLimitBuckets records the number of live rate limiter buckets.
+func (m *Metrics) SetRateLimitBuckets(n int) {
+	m.RateLimitBuckets.Set(float64(n))
+}
@@ -0,0 +1,119 @@
+// Package notify delivers operational events, such as budget warnings, to
+// the notification channels operators configure.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// queueSize bounds the events waiting to be delivered; more are dropped.
+const queueSize = 256
+
+// Event is a notification. Text is a human-readable summary; Data carries the
+// event's fields.
+type Event struct {
+	Type string    `json:"type"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Channel delivers events to one destination.
+type Channel interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// Webhook POSTs events as JSON to a URL.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a channel posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{}}
+}
+
+func (w *Webhook) Send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting webhook: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Dispatcher queues events and delivers them to every channel in the
+// background, so callers never wait on a slow channel.
+type Dispatcher struct {
+	channels []Channel
+	timeout  time.Duration
+	queue    chan Event
+}
+
+// NewDispatcher creates a dispatcher delivering to channels, giving each
+// delivery up to timeout. Call Start to begin delivering.
+func NewDispatcher(channels []Channel, timeout time.Duration) *Dispatcher {
+	return &Dispatcher{
+		channels: channels,
+		timeout:  timeout,
+		queue:    make(chan Event, queueSize),
+	}
+}
+
+// Dispatch queues e for delivery. If the queue is full the event is dropped
+// and logged.
+func (d *Dispatcher) Dispatch(e Event) {
+	if len(d.channels) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	select {
+	case d.queue <- e:
+	default:
+		slog.Warn("notification queue full, dropping event", "type", e.Type)
+	}
+}
+
+// Start delivers queued events until ctx is cancelled.
+func (d *Dispatcher) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-d.queue:
+			d.deliver(ctx, e)
+		}
+	}
+}
+
+// deliver sends e to every channel, logging failures.
+func (d *Dispatcher) deliver(ctx context.Context, e Event) {
+	for _, ch := range d.channels {
+		sendCtx, cancel := context.WithTimeout(ctx, d.timeout)
+		if err := ch.Send(sendCtx, e); err != nil {
+			slog.Warn("delivering notification failed", "type", e.Type, "error", err)
+		}
+		cancel()
+	}
+}
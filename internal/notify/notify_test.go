@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSend(t *testing.T) {
+	got := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON POST, got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+		got <- e
+	}))
+	defer srv.Close()
+
+	err := NewWebhook(srv.URL).Send(context.Background(), Event{Type: "budget_threshold", Text: "80% used"})
+	if err != nil {
+		t.Fatalf("sending: %v", err)
+	}
+	if e := <-got; e.Type != "budget_threshold" || e.Text != "80% used" {
+		t.Errorf("unexpected event delivered: %+v", e)
+	}
+}
+
+func TestWebhookSendErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	if err := NewWebhook(srv.URL).Send(context.Background(), Event{Type: "test"}); err == nil {
+		t.Fatal("expected an error for a 500 response")
+	}
+}
+
+type fakeChannel struct {
+	events chan Event
+}
+
+func (f *fakeChannel) Send(_ context.Context, e Event) error {
+	f.events <- e
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	a, b := &fakeChannel{events: make(chan Event, 1)}, &fakeChannel{events: make(chan Event, 1)}
+	d := NewDispatcher([]Channel{a, b}, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Start(ctx)
+
+	d.Dispatch(Event{Type: "budget_threshold"})
+	for _, ch := range []*fakeChannel{a, b} {
+		select {
+		case e := <-ch.events:
+			if e.Type != "budget_threshold" || e.Time.IsZero() {
+				t.Errorf("unexpected event delivered: %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("event not delivered to every channel")
+		}
+	}
+}
+
+func TestDispatcherDropsWhenFull(t *testing.T) {
+	d := NewDispatcher([]Channel{&fakeChannel{}}, time.Second)
+	for i := 0; i < queueSize+10; i++ {
+		d.Dispatch(Event{Type: "test"})
+	}
+	if len(d.queue) != queueSize {
+		t.Fatalf("expected the queue capped at %d, got %d", queueSize, len(d.queue))
+	}
+}
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// BreakerSettings configures the per-tool circuit breakers.
+type BreakerSettings struct {
+	FailureRate    float64       // failure fraction within Window that opens a breaker
+	MinRequests    int           // requests needed within Window before it can open
+	Window         time.Duration // counting window while closed
+	OpenDuration   time.Duration // how long an open breaker rejects requests
+	HalfOpenProbes int           // successful probes needed to close again
+}
+
+// breakerState is the state of one tool's circuit breaker. The numeric values
+// are exported as the octroi_proxy_circuit_breaker_state gauge.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half_open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// outcome is how a request admitted by a breaker ended.
+type outcome int
+
+const (
+	// outcomeSkipped is for requests that never reached the upstream, or
+	// were abandoned by the agent; they neither count nor trip the breaker.
+	outcomeSkipped outcome = iota
+	outcomeSuccess
+	outcomeFailure
+)
+
+// breaker holds one tool's circuit state.
+type breaker struct {
+	toolName    string
+	state       breakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openUntil   time.Time
+	probes      int // half-open probes in flight
+	successes   int // successful half-open probes
+}
+
+// breakerSet holds a circuit breaker per tool. A nil *breakerSet admits
+// every request.
+type breakerSet struct {
+	settings BreakerSettings
+	now      func() time.Time
+	onChange func(toolID, toolName string, state breakerState)
+	onForget func(toolID, toolName string)
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// newBreakerSet returns breakers with settings. onChange is called whenever a
+// breaker changes state and onForget when a tool's breaker is dropped.
+func newBreakerSet(settings BreakerSettings, onChange func(toolID, toolName string, state breakerState), onForget func(toolID, toolName string)) *breakerSet {
+	if settings.HalfOpenProbes < 1 {
+		settings.HalfOpenProbes = 1
+	}
+	return &breakerSet{
+		settings: settings,
+		now:      time.Now,
+		onChange: onChange,
+		onForget: onForget,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// breakerTicket is handed to an admitted request, which must report its
+// outcome through done. Only the first call to done counts.
+type breakerTicket struct {
+	set      *breakerSet
+	tool     *registry.Tool
+	probe    bool
+	reported bool
+}
+
+// allow admits a request to tool, or reports how long the caller should wait
+// before trying again.
+func (s *breakerSet) allow(tool *registry.Tool) (*breakerTicket, time.Duration, bool) {
+	if s == nil {
+		return nil, 0, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b := s.breakers[tool.ID]
+	if b == nil {
+		b = &breaker{toolName: tool.Name, windowStart: now}
+		s.breakers[tool.ID] = b
+		s.changed(tool.ID, b)
+	}
+
+	switch b.state {
+	case breakerOpen:
+		if now.Before(b.openUntil) {
+			return nil, b.openUntil.Sub(now), false
+		}
+		b.state = breakerHalfOpen
+		b.probes, b.successes = 0, 0
+		s.changed(tool.ID, b)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes+b.successes >= s.settings.HalfOpenProbes {
+			return nil, time.Second, false
+		}
+		b.probes++
+		return &breakerTicket{set: s, tool: tool, probe: true}, 0, true
+	}
+	return &breakerTicket{set: s, tool: tool}, 0, true
+}
+
+// done records the request's outcome.
+func (t *breakerTicket) done(outcome outcome) {
+	if t == nil || t.reported {
+		return
+	}
+	t.reported = true
+
+	s := t.set
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.breakers[t.tool.ID]
+	if b == nil {
+		return
+	}
+	now := s.now()
+
+	if t.probe {
+		if b.state != breakerHalfOpen {
+			return
+		}
+		b.probes--
+		switch outcome {
+		case outcomeSuccess:
+			b.successes++
+			if b.successes >= s.settings.HalfOpenProbes {
+				b.state = breakerClosed
+				b.windowStart, b.requests, b.failures = now, 0, 0
+				s.changed(t.tool.ID, b)
+			}
+		case outcomeFailure:
+			s.trip(t.tool.ID, b, now)
+		}
+		return
+	}
+
+	if b.state != breakerClosed || outcome == outcomeSkipped {
+		return
+	}
+	if now.Sub(b.windowStart) >= s.settings.Window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	b.requests++
+	if outcome == outcomeFailure {
+		b.failures++
+	}
+	if b.requests >= s.settings.MinRequests &&
+		float64(b.failures)/float64(b.requests) >= s.settings.FailureRate {
+		s.trip(t.tool.ID, b, now)
+	}
+}
+
+// trip opens b. The caller holds s.mu.
+func (s *breakerSet) trip(toolID string, b *breaker, now time.Time) {
+	b.state = breakerOpen
+	b.openUntil = now.Add(s.settings.OpenDuration)
+	b.requests, b.failures, b.probes, b.successes = 0, 0, 0, 0
+	s.changed(toolID, b)
+}
+
+// changed reports b's new state. The caller holds s.mu.
+func (s *breakerSet) changed(toolID string, b *breaker) {
+	if s.onChange != nil {
+		s.onChange(toolID, b.toolName, b.state)
+	}
+}
+
+// state returns the current state of toolID's breaker.
+func (s *breakerSet) state(toolID string) breakerState {
+	if s == nil {
+		return breakerClosed
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.breakers[toolID]
+	if b == nil {
+		return breakerClosed
+	}
+	if b.state == breakerOpen && !s.now().Before(b.openUntil) {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// reset forgets toolID's breaker, e.g. after its endpoint changed or the tool
+// was deleted, so its state is no longer reported.
+func (s *breakerSet) reset(toolID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b := s.breakers[toolID]; b != nil {
+		delete(s.breakers, toolID)
+		if s.onForget != nil {
+			s.onForget(toolID, b.toolName)
+		}
+	}
+}
+
+// upstreamOutcome classifies an upstream result made under ctx: transport
+// errors, timeouts and 5xx responses are failures, and requests the agent
+// abandoned are not counted.
+func upstreamOutcome(ctx context.Context, statusCode int, err error) outcome {
+	switch {
+	case ctx.Err() != nil && !errors.Is(context.Cause(ctx), errUpstreamTimeout):
+		return outcomeSkipped
+	case err != nil || statusCode >= 500:
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
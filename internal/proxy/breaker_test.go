@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+func newTestBreakers(now *time.Time) (*breakerSet, *[]string) {
+	var changes []string
+	s := newBreakerSet(BreakerSettings{
+		FailureRate:    0.5,
+		MinRequests:    4,
+		Window:         time.Minute,
+		OpenDuration:   10 * time.Second,
+		HalfOpenProbes: 2,
+	}, func(toolID, toolName string, state breakerState) {
+		changes = append(changes, state.String())
+	}, func(toolID, toolName string) {
+		changes = append(changes, "forgotten")
+	})
+	s.now = func() time.Time { return *now }
+	return s, &changes
+}
+
+func admit(t *testing.T, s *breakerSet, tool *registry.Tool) *breakerTicket {
+	t.Helper()
+	ticket, _, ok := s.allow(tool)
+	if !ok {
+		t.Fatalf("expected request admitted in state %s", s.state(tool.ID))
+	}
+	return ticket
+}
+
+func TestBreakerTripsOnFailureRate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s, changes := newTestBreakers(&now)
+	tool := &registry.Tool{ID: "tool-1", Name: "test-tool"}
+
+	// Below min_requests the breaker stays closed however many fail.
+	for i := 0; i < 3; i++ {
+		admit(t, s, tool).done(outcomeFailure)
+	}
+	admit(t, s, tool).done(outcomeSkipped)
+	if got := s.state(tool.ID); got != breakerClosed {
+		t.Fatalf("expected closed below min_requests, got %s", got)
+	}
+
+	admit(t, s, tool).done(outcomeSuccess)
+	if got := s.state(tool.ID); got != breakerOpen {
+		t.Fatalf("expected open at 3/4 failures, got %s", got)
+	}
+
+	now = now.Add(4 * time.Second)
+	_, retryAfter, ok := s.allow(tool)
+	if ok {
+		t.Fatal("expected request rejected while open")
+	}
+	if retryAfter != 6*time.Second {
+		t.Errorf("expected retry after 6s, got %v", retryAfter)
+	}
+	if want := []string{"closed", "open"}; len(*changes) != 2 || (*changes)[1] != want[1] {
+		t.Errorf("expected state changes %v, got %v", want, *changes)
+	}
+}
+
+func TestBreakerWindowResets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s, _ := newTestBreakers(&now)
+	tool := &registry.Tool{ID: "tool-1"}
+
+	for i := 0; i < 3; i++ {
+		admit(t, s, tool).done(outcomeFailure)
+	}
+	now = now.Add(2 * time.Minute)
+	admit(t, s, tool).done(outcomeFailure)
+	if got := s.state(tool.ID); got != breakerClosed {
+		t.Fatalf("expected failures from an old window to be forgotten, got %s", got)
+	}
+}
+
+func TestBreakerResetForgetsState(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s, changes := newTestBreakers(&now)
+	tool := &registry.Tool{ID: "tool-1", Name: "test-tool"}
+
+	s.reset(tool.ID) // nothing to forget yet
+	for i := 0; i < 4; i++ {
+		admit(t, s, tool).done(outcomeFailure)
+	}
+	s.reset(tool.ID)
+
+	want := []string{"closed", "open", "forgotten"}
+	if len(*changes) != len(want) || (*changes)[2] != want[2] {
+		t.Fatalf("expected state changes %v, got %v", want, *changes)
+	}
+	if got := s.state(tool.ID); got != breakerClosed {
+		t.Errorf("expected closed after reset, got %s", got)
+	}
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s, changes := newTestBreakers(&now)
+	tool := &registry.Tool{ID: "tool-1"}
+	trip := func() {
+		for i := 0; i < 4; i++ {
+			admit(t, s, tool).done(outcomeFailure)
+		}
+	}
+
+	trip()
+	now = now.Add(10 * time.Second)
+	if got := s.state(tool.ID); got != breakerHalfOpen {
+		t.Fatalf("expected half_open after open_duration, got %s", got)
+	}
+
+	// Two probes are admitted concurrently; a third request is not.
+	p1 := admit(t, s, tool)
+	p2 := admit(t, s, tool)
+	if _, _, ok := s.allow(tool); ok {
+		t.Fatal("expected requests beyond half_open_probes rejected")
+	}
+
+	// A failed probe reopens the breaker.
+	p1.done(outcomeFailure)
+	if got := s.state(tool.ID); got != breakerOpen {
+		t.Fatalf("expected open after failed probe, got %s", got)
+	}
+	p2.done(outcomeSuccess) // stale probe from the previous half-open period
+	if got := s.state(tool.ID); got != breakerOpen {
+		t.Fatalf("expected stale probe ignored, got %s", got)
+	}
+
+	// Enough successful probes close it.
+	now = now.Add(10 * time.Second)
+	p1 = admit(t, s, tool)
+	p2 = admit(t, s, tool)
+	p1.done(outcomeSuccess)
+	p1.done(outcomeFailure) // only the first report counts
+	if got := s.state(tool.ID); got != breakerHalfOpen {
+		t.Fatalf("expected half_open after one of two probes, got %s", got)
+	}
+	p2.done(outcomeSuccess)
+	if got := s.state(tool.ID); got != breakerClosed {
+		t.Fatalf("expected closed after successful probes, got %s", got)
+	}
+
+	want := []string{"closed", "open", "half_open", "open", "half_open", "closed"}
+	if len(*changes) != len(want) {
+		t.Fatalf("expected state changes %v, got %v", want, *changes)
+	}
+	for i := range want {
+		if (*changes)[i] != want[i] {
+			t.Fatalf("expected state changes %v, got %v", want, *changes)
+		}
+	}
+
+	// Skipped probes free their slot without closing or reopening.
+	trip()
+	now = now.Add(10 * time.Second)
+	admit(t, s, tool).done(outcomeSkipped)
+	admit(t, s, tool).done(outcomeSkipped)
+	if got := s.state(tool.ID); got != breakerHalfOpen {
+		t.Fatalf("expected half_open after skipped probes, got %s", got)
+	}
+}
+
+func TestBreakerRejectsWithToolUnavailable(t *testing.T) {
+	var calls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &fakeCollector{}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+	handler.SetCircuitBreaker(BreakerSettings{
+		FailureRate:    1,
+		MinRequests:    2,
+		Window:         time.Minute,
+		OpenDuration:   30 * time.Second,
+		HalfOpenProbes: 1,
+	})
+	router := setupRouter(handler)
+	do := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, withAgent(httptest.NewRequest("GET", "/proxy/tool-1/data", nil), newTestAgent()))
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := do(); rr.Code != http.StatusInternalServerError {
+			t.Fatalf("request %d: expected 500 from upstream, got %d", i+1, rr.Code)
+		}
+	}
+	if got := handler.CircuitState("tool-1"); got != "open" {
+		t.Fatalf("expected open circuit, got %s", got)
+	}
+
+	rr := do()
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rr.Code)
+	}
+	var errResp proxyError
+	_ = json.NewDecoder(rr.Body).Decode(&errResp)
+	if errResp.Error.Code != "tool_unavailable" {
+		t.Errorf("expected error code tool_unavailable, got %s", errResp.Error.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("expected Retry-After 30, got %q", got)
+	}
+	if calls != 2 {
+		t.Errorf("expected the open circuit to spare the upstream, got %d calls", calls)
+	}
+	if len(collector.transactions) != 2 {
+		t.Errorf("expected rejected request not metered, got %d transactions", len(collector.transactions))
+	}
+
+	handler.InvalidateTool("tool-1")
+	if got := handler.CircuitState("tool-1"); got != "closed" {
+		t.Errorf("expected InvalidateTool to reset the circuit, got %s", got)
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/alecgard/octroi/internal/agent"
+	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// budgetHold is the budget reservation held for a request. It is claimed
+// exactly once: by the transaction that meters the request, whose write
+// settles it, or when the request ends unmetered, which releases it.
+type budgetHold struct {
+	id      string
+	claimed atomic.Bool
+}
+
+type budgetHoldKey struct{}
+
+// budgetHoldFrom returns the reservation held for the request, if any.
+func budgetHoldFrom(ctx context.Context) *budgetHold {
+	hold, _ := ctx.Value(budgetHoldKey{}).(*budgetHold)
+	return hold
+}
+
+// estimateCost returns the cost reserved for a call to tool before it is
+// made: its cost estimate, or else its flat per-request price.
+func estimateCost(tool *registry.Tool) float64 {
+	if tool.CostEstimate > 0 {
+		return tool.CostEstimate
+	}
+	if tool.PricingModel == "per_request" {
+		return tool.PricingAmount
+	}
+	return 0
+}
+
+// budgetExceededMessages holds the rejection message for each kind of budget
+// a reservation can be refused by.
+var budgetExceededMessages = map[string]string{
+	"agent":       "agent budget exceeded for this tool",
+	"agent_total": "agent budget exceeded across all tools",
+	"team":        "team budget exceeded",
+	"global":      "global tool budget exceeded",
+}
+
+// Headers telling the agent how much budget it has left and when spend
+// reaches a warning threshold.
+const (
+	budgetRemainingHeader = "X-Octroi-Budget-Remaining"
+	budgetWarningHeader   = "X-Octroi-Budget-Warning"
+)
+
+// reserveBudget reserves the estimated cost of the request against every
+// budget that applies to it. When a budget has no room it writes the
+// rejection and returns false. Otherwise it sets the budget headers and
+// returns the request to carry on with, holding the reservation in its
+// context; the caller must call releaseBudget once the request is done.
+// Errors fail open.
+func (h *Handler) reserveBudget(w http.ResponseWriter, r *http.Request, tool *registry.Tool, agent *auth.Agent) (*http.Request, bool) {
+	res, err := h.budgets.Reserve(r.Context(), agent.ID, tool.ID, estimateCost(tool))
+	if err != nil {
+		return r, true
+	}
+	if res.Exceeded != "" {
+		if h.metrics != nil {
+			h.metrics.IncBudgetRejection(res.Exceeded)
+		}
+		message, ok := budgetExceededMessages[res.Exceeded]
+		if !ok {
+			message = "budget exceeded"
+		}
+		writeError(w, http.StatusForbidden, "budget_exceeded", message)
+		return r, false
+	}
+	setBudgetHeaders(w.Header(), res.Budgets)
+	if res.ID == "" {
+		return r, true
+	}
+	return r.WithContext(context.WithValue(r.Context(), budgetHoldKey{}, &budgetHold{id: res.ID})), true
+}
+
+// setBudgetHeaders reports the least budget left across budgets and, if any
+// has reached a warning threshold, the one closest to its limit.
+func setBudgetHeaders(header http.Header, budgets []agent.BudgetUsage) {
+	if len(budgets) == 0 {
+		return
+	}
+	remaining := math.Inf(1)
+	var warn *agent.BudgetUsage
+	for i, b := range budgets {
+		remaining = min(remaining, max(b.Limit-b.Spent, 0))
+		if b.Threshold > 0 && (warn == nil || b.Spent/b.Limit > warn.Spent/warn.Limit) {
+			warn = &budgets[i]
+		}
+	}
+	header.Set(budgetRemainingHeader, strconv.FormatFloat(math.Round(remaining*1e6)/1e6, 'f', -1, 64))
+	if warn != nil {
+		header.Set(budgetWarningHeader, fmt.Sprintf("budget=%s; window=%s; used=%d%%; threshold=%d%%",
+			warn.Kind, warn.Window, int(math.Floor(warn.Spent/warn.Limit*100+1e-9)), warn.Threshold))
+	}
+}
+
+// releaseBudget releases the request's reservation if no transaction
+// claimed it.
+func (h *Handler) releaseBudget(r *http.Request) {
+	hold := budgetHoldFrom(r.Context())
+	if hold == nil || !hold.claimed.CompareAndSwap(false, true) {
+		return
+	}
+	_ = h.budgets.Release(context.WithoutCancel(r.Context()), hold.id)
+}
+
+// claimBudget claims the request's reservation for its transaction, which
+// settles it at the actual cost when the collector writes it, and returns
+// its ID, or "" if there is none or a transaction already claimed it.
+func (h *Handler) claimBudget(r *http.Request) string {
+	hold := budgetHoldFrom(r.Context())
+	if hold == nil || !hold.claimed.CompareAndSwap(false, true) {
+		return ""
+	}
+	return hold.id
+}
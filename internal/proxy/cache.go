@@ -0,0 +1,394 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/metering"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// CacheSettings sizes the response cache.
+type CacheSettings struct {
+	MaxBytes      int64 // total size of cached bodies
+	MaxEntryBytes int64 // larger responses are not cached
+}
+
+// cacheHeader tells the agent how the response cache handled its request.
+const cacheHeader = "X-Octroi-Cache"
+
+// Cache results, as reported in octroi_proxy_cache_requests_total and (upper
+// cased) in the X-Octroi-Cache header.
+const (
+	cacheHit         = "hit"
+	cacheMiss        = "miss"
+	cacheRevalidated = "revalidated"
+	cacheBypass      = "bypass"
+)
+
+// cacheEntry is a stored upstream response. Entries are never modified once
+// stored; revalidation stores a replacement.
+type cacheEntry struct {
+	key     string
+	toolID  string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time // when the response was received or last revalidated
+	expires time.Time
+	elem    *list.Element
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return now.Before(e.expires)
+}
+
+func (e *cacheEntry) hasValidators() bool {
+	return e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != ""
+}
+
+// responseCache is an in-memory LRU of upstream responses, bounded by the
+// total size of their bodies. A nil *responseCache caches nothing.
+type responseCache struct {
+	settings CacheSettings
+	now      func() time.Time
+	onChange func(entries int, bytes int64)
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	lru     *list.List // most recently used at the front
+	bytes   int64
+}
+
+func newResponseCache(settings CacheSettings, onChange func(entries int, bytes int64)) *responseCache {
+	return &responseCache{
+		settings: settings,
+		now:      time.Now,
+		onChange: onChange,
+		entries:  make(map[string]*cacheEntry),
+		lru:      list.New(),
+	}
+}
+
+// get returns the entry stored under key, fresh or not.
+func (c *responseCache) get(key string) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e != nil {
+		c.lru.MoveToFront(e.elem)
+	}
+	return e
+}
+
+// put stores e, replacing any entry with the same key and evicting the least
+// recently used entries to stay within MaxBytes.
+func (c *responseCache) put(e *cacheEntry) {
+	if c == nil || int64(len(e.body)) > c.settings.MaxEntryBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old := c.entries[e.key]; old != nil {
+		c.remove(old)
+	}
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.key] = e
+	c.bytes += int64(len(e.body))
+	for c.bytes > c.settings.MaxBytes {
+		c.remove(c.lru.Back().Value.(*cacheEntry))
+	}
+	c.changed()
+}
+
+// purgeTool removes every entry of toolID and returns how many there were.
+func (c *responseCache) purgeTool(toolID string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, e := range c.entries {
+		if e.toolID == toolID {
+			c.remove(e)
+			n++
+		}
+	}
+	if n > 0 {
+		c.changed()
+	}
+	return n
+}
+
+// remove drops e. The caller holds c.mu.
+func (c *responseCache) remove(e *cacheEntry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.key)
+	c.bytes -= int64(len(e.body))
+}
+
+// changed reports the cache's size. The caller holds c.mu.
+func (c *responseCache) changed() {
+	if c.onChange != nil {
+		c.onChange(len(c.entries), c.bytes)
+	}
+}
+
+// cacheLookup is the response cache state of one proxied request.
+type cacheLookup struct {
+	key     string      // empty when the request is not cacheable
+	noStore bool        // the agent asked for the response not to be stored
+	hit     *cacheEntry // fresh entry to serve
+	stale   *cacheEntry // expired entry the upstream may revalidate
+	result  string
+}
+
+// lookupCache finds a cached response for r. Only GET requests to tools with
+// caching enabled are cacheable.
+func (h *Handler) lookupCache(tool *registry.Tool, agent *auth.Agent, r *http.Request, upstreamPath string) cacheLookup {
+	if h.cache == nil || !tool.CachePolicy.Enabled || r.Method != http.MethodGet || isWebSocketUpgrade(r) {
+		return cacheLookup{}
+	}
+	cl := cacheLookup{key: cacheKey(tool, agent, r, upstreamPath), result: cacheMiss}
+	cc := parseCacheControl(r.Header)
+	_, cl.noStore = cc["no-store"]
+	_, noCache := cc["no-cache"]
+	if noCache || cl.noStore {
+		cl.result = cacheBypass
+		return cl
+	}
+	if e := h.cache.get(cl.key); e != nil {
+		if e.fresh(h.cache.now()) {
+			cl.hit, cl.result = e, cacheHit
+		} else if e.hasValidators() {
+			cl.stale = e
+		}
+	}
+	return cl
+}
+
+// cacheKey derives the key of r from the tool, the agent (unless the cache is
+// shared per tool), the method, path, normalised query and vary headers.
+func cacheKey(tool *registry.Tool, agent *auth.Agent, r *http.Request, upstreamPath string) string {
+	scope := agent.ID
+	if tool.CachePolicy.Scope == "tool" {
+		scope = ""
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s\x00%s", tool.ID, scope, r.Method, upstreamPath, r.URL.Query().Encode())
+	vary := make([]string, 0, len(tool.CachePolicy.VaryHeaders))
+	for _, name := range tool.CachePolicy.VaryHeaders {
+		vary = append(vary, textproto.CanonicalMIMEHeaderKey(name))
+	}
+	slices.Sort(vary)
+	for _, name := range vary {
+		fmt.Fprintf(hash, "\x00%s=%s", name, strings.Join(r.Header.Values(name), ","))
+	}
+	return tool.ID + ":" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// parseCacheControl returns the Cache-Control directives in h, lower-cased,
+// with any quoted values unquoted.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// cacheLifetime decides whether a response with headers h may be cached under
+// policy and for how long it is fresh. A zero lifetime with ok set means the
+// response is stored but must be revalidated before it is served again.
+func cacheLifetime(policy registry.CachePolicy, h http.Header, now time.Time) (lifetime time.Duration, ok bool) {
+	cc := parseCacheControl(h)
+	if _, noStore := cc["no-store"]; noStore {
+		return 0, false
+	}
+	if _, private := cc["private"]; private && policy.Scope == "tool" {
+		return 0, false
+	}
+	if h.Get("Set-Cookie") != "" || !varyCovered(policy, h) {
+		return 0, false
+	}
+	validators := h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+	if _, noCache := cc["no-cache"]; noCache {
+		return 0, validators
+	}
+	if policy.TTLSeconds > 0 {
+		return time.Duration(policy.TTLSeconds) * time.Second, true
+	}
+
+	directives := []string{"max-age"}
+	if policy.Scope == "tool" {
+		directives = []string{"s-maxage", "max-age"}
+	}
+	for _, d := range directives {
+		if v, set := cc[d]; set {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs <= 0 {
+				return 0, validators
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+	if v := h.Get("Expires"); v != "" {
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return t.Sub(now), true
+		}
+	}
+	return 0, validators
+}
+
+// varyCovered reports whether every header the upstream varies on is part of
+// the cache key.
+func varyCovered(policy registry.CachePolicy, h http.Header) bool {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" || !slices.ContainsFunc(policy.VaryHeaders, func(s string) bool {
+				return strings.EqualFold(s, name)
+			}) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// storeResponse caches a complete 200 response if its headers allow it.
+func (h *Handler) storeResponse(cl cacheLookup, tool *registry.Tool, resp *http.Response, body []byte) {
+	now := h.cache.now()
+	lifetime, ok := cacheLifetime(tool.CachePolicy, resp.Header, now)
+	if !ok {
+		return
+	}
+	header := resp.Header.Clone()
+	header.Del("X-Octroi-Cost")
+	h.cache.put(&cacheEntry{
+		key:     cl.key,
+		toolID:  tool.ID,
+		status:  resp.StatusCode,
+		header:  header,
+		body:    body,
+		stored:  now,
+		expires: now.Add(lifetime),
+	})
+}
+
+// revalidated turns a 304 answer to a conditional request for cl.stale into
+// the cached response, refreshing the entry with the upstream's new headers.
+func (h *Handler) revalidated(cl cacheLookup, tool *registry.Tool, notModified *http.Response) *http.Response {
+	e := cl.stale
+	header := e.header.Clone()
+	for _, name := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified", "Vary"} {
+		if vs := notModified.Header.Values(name); len(vs) > 0 {
+			header[name] = vs
+		}
+	}
+
+	now := h.cache.now()
+	if lifetime, ok := cacheLifetime(tool.CachePolicy, header, now); ok {
+		h.cache.put(&cacheEntry{
+			key:     e.key,
+			toolID:  e.toolID,
+			status:  e.status,
+			header:  header,
+			body:    e.body,
+			stored:  now,
+			expires: now.Add(lifetime),
+		})
+	}
+
+	resp := &http.Response{
+		StatusCode:    e.status,
+		Header:        header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+	}
+	if cost := notModified.Header.Get("X-Octroi-Cost"); cost != "" {
+		resp.Header.Set("X-Octroi-Cost", cost)
+	}
+	return resp
+}
+
+// serveCached writes a fresh cached response to the agent and meters it at
+// no cost. A matching If-None-Match from the agent gets a 304.
+func (h *Handler) serveCached(w http.ResponseWriter, r *http.Request, tool *registry.Tool, agent *auth.Agent, e *cacheEntry) {
+	start := time.Now()
+	for key, values := range e.header {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	w.Header().Set("Age", strconv.Itoa(int(h.cache.now().Sub(e.stored).Seconds())))
+	w.Header().Set(cacheHeader, strings.ToUpper(cacheHit))
+
+	status := e.status
+	var written int
+	if etag := e.header.Get("ETag"); etag != "" && r.Header.Get("If-None-Match") == etag {
+		status = http.StatusNotModified
+		w.WriteHeader(status)
+	} else {
+		w.WriteHeader(status)
+		written, _ = w.Write(e.body)
+	}
+
+	if h.metrics != nil {
+		h.metrics.IncProxyRequests(tool.ID, tool.Name, agent.ID, r.Method, status)
+		h.metrics.IncCacheRequest(tool.ID, tool.Name, cacheHit)
+	}
+	h.recordTransaction(tool, r, metering.Transaction{
+		AgentID:      agent.ID,
+		StatusCode:   status,
+		DurationMs:   time.Since(start).Milliseconds(),
+		ResponseSize: int64(written),
+		Success:      true,
+		CostSource:   "cache",
+	}, "")
+}
+
+// captureReader copies what is read through it into buf, up to limit bytes.
+type captureReader struct {
+	r        io.Reader
+	limit    int64
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 && !c.overflow {
+		if int64(c.buf.Len()+n) > c.limit {
+			c.overflow = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p[:n])
+		}
+	}
+	return n, err
+}
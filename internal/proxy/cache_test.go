@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+func newCacheHandler(tool *registry.Tool, collector *fakeCollector) *Handler {
+	store := &fakeToolStore{tools: map[string]*registry.Tool{tool.ID: tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	h := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+	h.SetResponseCache(CacheSettings{MaxBytes: 1 << 20, MaxEntryBytes: 1 << 10})
+	return h
+}
+
+func cachedGet(h *Handler, agent *auth.Agent, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	rr := httptest.NewRecorder()
+	setupRouter(h).ServeHTTP(rr, withAgent(req, agent))
+	return rr
+}
+
+func TestCacheHitMetering(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("X-Octroi-Cost", "0.5")
+		fmt.Fprintf(w, "response %d", n)
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.CachePolicy = registry.CachePolicy{Enabled: true}
+	collector := &fakeCollector{}
+	h := newCacheHandler(tool, collector)
+	agent := newTestAgent()
+
+	first := cachedGet(h, agent, "/proxy/tool-1/data?b=2&a=1", nil)
+	second := cachedGet(h, agent, "/proxy/tool-1/data?a=1&b=2", nil)
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", calls.Load())
+	}
+	if got := first.Header().Get(cacheHeader); got != "MISS" {
+		t.Errorf("expected first response to miss, got %q", got)
+	}
+	if got := second.Header().Get(cacheHeader); got != "HIT" {
+		t.Errorf("expected second response to hit, got %q", got)
+	}
+	if second.Body.String() != "response 1" {
+		t.Errorf("expected cached body, got %q", second.Body.String())
+	}
+	if second.Header().Get("X-Octroi-Cost") != "" {
+		t.Error("expected upstream cost header not replayed from the cache")
+	}
+
+	if len(collector.transactions) != 2 {
+		t.Fatalf("expected 2 transactions, got %d", len(collector.transactions))
+	}
+	miss, hit := collector.transactions[0], collector.transactions[1]
+	if miss.CostSource != "reported" || miss.Cost != 0.5 {
+		t.Errorf("expected miss metered at reported cost, got %s %v", miss.CostSource, miss.Cost)
+	}
+	if hit.CostSource != "cache" || hit.Cost != 0 || !hit.Success {
+		t.Errorf("expected hit metered as free cache transaction, got %s %v", hit.CostSource, hit.Cost)
+	}
+}
+
+func TestCacheScope(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	other := &auth.Agent{ID: "agent-2", Name: "other-agent"}
+	tests := []struct {
+		scope     string
+		wantCalls int32
+	}{
+		{"agent", 2},
+		{"tool", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			calls.Store(0)
+			tool := newTestTool(upstream.URL)
+			tool.CachePolicy = registry.CachePolicy{Enabled: true, Scope: tt.scope}
+			h := newCacheHandler(tool, &fakeCollector{})
+
+			cachedGet(h, newTestAgent(), "/proxy/tool-1/data", nil)
+			cachedGet(h, other, "/proxy/tool-1/data", nil)
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("expected %d upstream calls, got %d", tt.wantCalls, calls.Load())
+			}
+		})
+	}
+}
+
+func TestCacheNotStored(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  registry.CachePolicy
+		header  http.Header // upstream response headers
+		request http.Header
+	}{
+		{"caching disabled", registry.CachePolicy{}, http.Header{"Cache-Control": {"max-age=60"}}, nil},
+		{"upstream no-store", registry.CachePolicy{Enabled: true, TTLSeconds: 60}, http.Header{"Cache-Control": {"no-store"}}, nil},
+		{"private with tool scope", registry.CachePolicy{Enabled: true, Scope: "tool"}, http.Header{"Cache-Control": {"private, max-age=60"}}, nil},
+		{"set-cookie", registry.CachePolicy{Enabled: true}, http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, nil},
+		{"uncovered vary", registry.CachePolicy{Enabled: true}, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}, nil},
+		{"no freshness", registry.CachePolicy{Enabled: true}, http.Header{}, nil},
+		{"agent no-store", registry.CachePolicy{Enabled: true}, http.Header{"Cache-Control": {"max-age=60"}}, http.Header{"Cache-Control": {"no-store"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				for k, vs := range tt.header {
+					w.Header()[k] = vs
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer upstream.Close()
+
+			tool := newTestTool(upstream.URL)
+			tool.CachePolicy = tt.policy
+			h := newCacheHandler(tool, &fakeCollector{})
+
+			cachedGet(h, newTestAgent(), "/proxy/tool-1/data", tt.request)
+			cachedGet(h, newTestAgent(), "/proxy/tool-1/data", nil)
+			if calls.Load() != 2 {
+				t.Errorf("expected response not cached, got %d upstream calls", calls.Load())
+			}
+		})
+	}
+}
+
+func TestCacheVaryHeaders(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.CachePolicy = registry.CachePolicy{Enabled: true, VaryHeaders: []string{"accept-language"}}
+	h := newCacheHandler(tool, &fakeCollector{})
+
+	cachedGet(h, newTestAgent(), "/proxy/tool-1/data", http.Header{"Accept-Language": {"en"}})
+	rr := cachedGet(h, newTestAgent(), "/proxy/tool-1/data", http.Header{"Accept-Language": {"fr"}})
+	if rr.Body.String() != "fr" {
+		t.Errorf("expected separate entry per Accept-Language, got %q", rr.Body.String())
+	}
+	cachedGet(h, newTestAgent(), "/proxy/tool-1/data", http.Header{"Accept-Language": {"en"}})
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 upstream calls, got %d", calls.Load())
+	}
+}
+
+func TestCacheRevalidation(t *testing.T) {
+	var calls, conditional atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body"))
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.CachePolicy = registry.CachePolicy{Enabled: true}
+	collector := &fakeCollector{}
+	h := newCacheHandler(tool, collector)
+	now := time.Now()
+	h.cache.now = func() time.Time { return now }
+
+	cachedGet(h, newTestAgent(), "/proxy/tool-1/data", nil)
+	now = now.Add(2 * time.Minute)
+
+	rr := cachedGet(h, newTestAgent(), "/proxy/tool-1/data", nil)
+	if conditional.Load() != 1 {
+		t.Fatalf("expected stale entry revalidated with If-None-Match, got %d conditional calls", conditional.Load())
+	}
+	if rr.Code != http.StatusOK || rr.Body.String() != "body" {
+		t.Errorf("expected cached body served after 304, got %d %q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get(cacheHeader); got != "REVALIDATED" {
+		t.Errorf("expected REVALIDATED, got %q", got)
+	}
+
+	// Revalidation refreshed the entry, so the next request is a hit.
+	rr = cachedGet(h, newTestAgent(), "/proxy/tool-1/data", nil)
+	if calls.Load() != 2 || rr.Header().Get(cacheHeader) != "HIT" {
+		t.Errorf("expected refreshed entry served, got %d upstream calls and %q", calls.Load(), rr.Header().Get(cacheHeader))
+	}
+
+	// An agent holding the same ETag gets a 304 from the cache.
+	rr = cachedGet(h, newTestAgent(), "/proxy/tool-1/data", http.Header{"If-None-Match": {`"v1"`}})
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("expected 304 without body, got %d", rr.Code)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := newResponseCache(CacheSettings{MaxBytes: 10, MaxEntryBytes: 6}, nil)
+	put := func(key, toolID, body string) {
+		c.put(&cacheEntry{key: key, toolID: toolID, body: []byte(body)})
+	}
+
+	put("a", "tool-1", "aaaa")
+	put("b", "tool-1", "bbbb")
+	put("big", "tool-1", "toolarge")
+	if c.get("big") != nil {
+		t.Error("expected entry over max_entry_bytes not stored")
+	}
+	c.get("a")
+	put("c", "tool-2", "cccc")
+	if c.get("b") != nil {
+		t.Error("expected least recently used entry evicted")
+	}
+	if c.get("a") == nil || c.get("c") == nil {
+		t.Error("expected recently used entries kept")
+	}
+
+	if n := c.purgeTool("tool-1"); n != 1 {
+		t.Errorf("expected 1 entry purged, got %d", n)
+	}
+	if c.get("a") != nil || c.get("c") == nil || c.bytes != 4 {
+		t.Errorf("expected only tool-1 purged, %d bytes left", c.bytes)
+	}
+}
+
+func TestCachePurgedOnToolChange(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.CachePolicy = registry.CachePolicy{Enabled: true}
+	h := newCacheHandler(tool, &fakeCollector{})
+
+	cachedGet(h, newTestAgent(), "/proxy/tool-1/data", nil)
+	h.InvalidateTool("tool-1")
+	if n := h.PurgeToolCache("tool-1"); n != 0 {
+		t.Errorf("expected cache purged when the tool changed, %d entries left", n)
+	}
+}
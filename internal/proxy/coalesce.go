@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/metering"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// maxCoalescedBytes bounds the response body the leader of a coalesced call
+// buffers for its waiters. Larger responses are not shared.
+const maxCoalescedBytes = 8 << 20
+
+// coalesceHeader marks responses served from another request's upstream call.
+const coalesceHeader = "X-Octroi-Coalesced"
+
+// coalesceKeyHeaders are the request headers that must match, besides the
+// method, path and query, for two requests to share an upstream call.
+var coalesceKeyHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"If-Modified-Since",
+	"If-None-Match",
+	"Range",
+}
+
+// flight is one upstream call shared by identical concurrent requests. The
+// leader fills in the response, if it can be shared, before closing done.
+type flight struct {
+	done chan struct{}
+
+	shared     bool
+	status     int
+	header     http.Header
+	body       []byte
+	costHeader string
+	target     string
+	latency    time.Duration
+}
+
+// share publishes the leader's complete upstream response to the waiters.
+func (f *flight) share(resp *http.Response, body []byte, costHeader, target string, latency time.Duration) {
+	f.shared = true
+	f.status = resp.StatusCode
+	f.header = resp.Header.Clone()
+	f.body = body
+	f.costHeader = costHeader
+	f.target = target
+	f.latency = latency
+}
+
+// flightGroup tracks the upstream calls in progress per coalescing key.
+type flightGroup struct {
+	onJoin func() // called when a request joins a call in progress, for tests
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// join returns the call in progress for key, or starts one with the caller
+// as its leader.
+func (g *flightGroup) join(key string) (f *flight, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		if g.onJoin != nil {
+			g.onJoin()
+		}
+		return f, false
+	}
+	f = &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	return f, true
+}
+
+// finish ends the leader's call and releases its waiters. Requests arriving
+// afterwards start a new call.
+func (g *flightGroup) finish(key string, f *flight) {
+	g.mu.Lock()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+	g.mu.Unlock()
+	close(f.done)
+}
+
+// coalesceKey returns the key under which r shares an upstream call with
+// identical concurrent requests, or "" if it may not. Only bodiless GET and
+// HEAD requests to tools with coalescing enabled are shared; the agent is not
+// part of the key.
+func coalesceKey(tool *registry.Tool, r *http.Request, upstreamPath string) string {
+	if !tool.Coalesce || (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.ContentLength > 0 || isWebSocketUpgrade(r) {
+		return ""
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s", tool.ID, r.Method, upstreamPath, r.URL.Query().Encode())
+	for _, name := range coalesceKeyHeaders {
+		fmt.Fprintf(hash, "\x00%s", r.Header.Values(name))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// waitCoalesced waits for the leader of f and relays its response. It
+// returns false if the leader could not share a response (it failed, was
+// streamed or too large) and the request must be sent on its own.
+func (h *Handler) waitCoalesced(w http.ResponseWriter, r *http.Request, tool *registry.Tool, agent *auth.Agent, f *flight) bool {
+	start := time.Now()
+	select {
+	case <-f.done:
+	case <-r.Context().Done():
+		return true
+	}
+	if !f.shared {
+		return false
+	}
+
+	for key, values := range f.header {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	w.Header().Set(coalesceHeader, "true")
+	w.WriteHeader(f.status)
+	written, err := w.Write(f.body)
+
+	if h.metrics != nil {
+		h.metrics.IncProxyRequests(tool.ID, tool.Name, agent.ID, r.Method, f.status)
+		h.metrics.IncCoalescedRequest(tool.ID, tool.Name)
+	}
+	tx := metering.Transaction{
+		AgentID:      agent.ID,
+		StatusCode:   f.status,
+		LatencyMs:    f.latency.Milliseconds(),
+		DurationMs:   time.Since(start).Milliseconds(),
+		ResponseSize: int64(written),
+		Success:      f.status >= 200 && f.status < 300,
+		Target:       f.target,
+		Coalesced:    true,
+	}
+	if err != nil {
+		tx.Error = "client_disconnected"
+	}
+	h.recordTransaction(tool, r, tx, f.costHeader)
+	return true
+}
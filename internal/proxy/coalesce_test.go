@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/metering"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+type syncCollector struct {
+	mu           sync.Mutex
+	transactions []metering.Transaction
+}
+
+func (c *syncCollector) Record(tx metering.Transaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transactions = append(c.transactions, tx)
+}
+
+// countJoins reports each request that joins one of g's calls in progress.
+func countJoins(g *flightGroup) <-chan struct{} {
+	joins := make(chan struct{}, 16)
+	g.onJoin = func() { joins <- struct{}{} }
+	return joins
+}
+
+// waitForWaiters blocks until n requests have joined a coalesced call.
+func waitForWaiters(t *testing.T, joins <-chan struct{}, n int) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case <-joins:
+		case <-timeout:
+			t.Fatalf("timed out waiting for %d coalesced requests", n)
+		}
+	}
+}
+
+func TestCoalesceIdenticalRequests(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		w.Header().Set("X-Octroi-Cost", "0.25")
+		w.Write([]byte("shared"))
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.Coalesce = true
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &syncCollector{}
+	h := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+	joins := countJoins(h.flights)
+	router := setupRouter(h)
+
+	const agents = 4
+	recorders := make([]*httptest.ResponseRecorder, agents)
+	var wg sync.WaitGroup
+	call := func(i int) {
+		defer wg.Done()
+		agent := &auth.Agent{ID: string(rune('a' + i))}
+		recorders[i] = httptest.NewRecorder()
+		router.ServeHTTP(recorders[i], withAgent(httptest.NewRequest("GET", "/proxy/tool-1/data?q=1", nil), agent))
+	}
+
+	wg.Add(1)
+	go call(0)
+	<-started
+	for i := 1; i < agents; i++ {
+		wg.Add(1)
+		go call(i)
+	}
+	waitForWaiters(t, joins, agents-1)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected 1 upstream call, got %d", calls.Load())
+	}
+	for i, rr := range recorders {
+		if rr.Code != http.StatusOK || rr.Body.String() != "shared" {
+			t.Errorf("request %d: expected shared response, got %d %q", i, rr.Code, rr.Body.String())
+		}
+		if coalesced := rr.Header().Get(coalesceHeader) == "true"; coalesced != (i > 0) {
+			t.Errorf("request %d: unexpected %s header %q", i, coalesceHeader, rr.Header().Get(coalesceHeader))
+		}
+	}
+
+	if len(collector.transactions) != agents {
+		t.Fatalf("expected a transaction per agent, got %d", len(collector.transactions))
+	}
+	coalesced := 0
+	for _, tx := range collector.transactions {
+		if tx.Cost != 0.25 || tx.CostSource != "reported" {
+			t.Errorf("expected each agent metered at the reported cost, got %v %s", tx.Cost, tx.CostSource)
+		}
+		if tx.Coalesced {
+			coalesced++
+		}
+	}
+	if coalesced != agents-1 {
+		t.Errorf("expected %d coalesced transactions, got %d", agents-1, coalesced)
+	}
+}
+
+func TestCoalesceFallsBackWhenNotShared(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+			// Streamed responses are relayed as they arrive, not shared.
+			w.Header().Set("Content-Type", "text/event-stream")
+		}
+		w.Write([]byte("data"))
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.Coalesce = true
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &syncCollector{}
+	h := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+	joins := countJoins(h.flights)
+	router := setupRouter(h)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		router.ServeHTTP(httptest.NewRecorder(), withAgent(httptest.NewRequest("GET", "/proxy/tool-1/events", nil), newTestAgent()))
+	}()
+	<-started
+	rr := httptest.NewRecorder()
+	go func() {
+		defer wg.Done()
+		router.ServeHTTP(rr, withAgent(httptest.NewRequest("GET", "/proxy/tool-1/events", nil), newTestAgent()))
+	}()
+	waitForWaiters(t, joins, 1)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 2 {
+		t.Errorf("expected the waiting request sent on its own, got %d upstream calls", calls.Load())
+	}
+	if rr.Code != http.StatusOK || rr.Header().Get(coalesceHeader) != "" {
+		t.Errorf("expected an uncoalesced response, got %d %q", rr.Code, rr.Header().Get(coalesceHeader))
+	}
+	for _, tx := range collector.transactions {
+		if tx.Coalesced {
+			t.Error("expected no coalesced transactions")
+		}
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	tool := &registry.Tool{ID: "tool-1", Coalesce: true}
+	req := func(method, target string, header http.Header) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		return r
+	}
+
+	base := coalesceKey(tool, req("GET", "/proxy/tool-1/x?a=1&b=2", nil), "/x")
+	if base == "" {
+		t.Fatal("expected GET to be coalesced")
+	}
+	if got := coalesceKey(tool, req("GET", "/proxy/tool-1/x?b=2&a=1", http.Header{"X-Trace": {"1"}}), "/x"); got != base {
+		t.Error("expected query order and unrelated headers to be ignored")
+	}
+	if got := coalesceKey(tool, req("GET", "/proxy/tool-1/x?a=1&b=2", http.Header{"Accept": {"text/csv"}}), "/x"); got == base {
+		t.Error("expected Accept to be part of the key")
+	}
+	if got := coalesceKey(tool, req("POST", "/proxy/tool-1/x", nil), "/x"); got != "" {
+		t.Error("expected POST not to be coalesced")
+	}
+	if got := coalesceKey(&registry.Tool{ID: "tool-1"}, req("GET", "/proxy/tool-1/x", nil), "/x"); got != "" {
+		t.Error("expected coalescing to be opt-in")
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// HMAC signing defaults, used when the tool's auth config leaves them unset.
+const (
+	defaultHMACAlgorithm       = "sha256"
+	defaultHMACHeader          = "X-Signature"
+	defaultHMACTemplate        = "{signature}"
+	defaultHMACTimestampHeader = "X-Timestamp"
+)
+
+// hmacHashes maps the accepted algorithm names to their hash constructors.
+var hmacHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// signHMAC signs req in place with the tool's shared secret. The signature
+// covers the method, request URI, unix timestamp and body digest joined by
+// newlines:
+//
+//	METHOD\nREQUEST_URI\nTIMESTAMP\nHEX(HASH(body))
+//
+// The timestamp is sent in timestamp_header and the signature is rendered
+// through header_template ({signature}, {timestamp}, {algorithm}, {key_id})
+// into header_name.
+func signHMAC(req *http.Request, body []byte, cfg map[string]string, now time.Time) error {
+	algorithm := cfg["algorithm"]
+	if algorithm == "" {
+		algorithm = defaultHMACAlgorithm
+	}
+	newHash, ok := hmacHashes[algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported hmac algorithm %q", algorithm)
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	bodyHash := newHash()
+	bodyHash.Write(body)
+
+	mac := hmac.New(newHash, []byte(cfg["secret"]))
+	mac.Write([]byte(strings.Join([]string{
+		req.Method,
+		req.URL.RequestURI(),
+		timestamp,
+		hex.EncodeToString(bodyHash.Sum(nil)),
+	}, "\n")))
+
+	var signature string
+	if cfg["encoding"] == "base64" {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	} else {
+		signature = hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tmpl := cfg["header_template"]
+	if tmpl == "" {
+		tmpl = defaultHMACTemplate
+	}
+	value, err := registry.ResolveTemplate(tmpl, map[string]string{
+		"signature": signature,
+		"timestamp": timestamp,
+		"algorithm": algorithm,
+		"key_id":    cfg["key_id"],
+	})
+	if err != nil {
+		return err
+	}
+
+	headerName := cfg["header_name"]
+	if headerName == "" {
+		headerName = defaultHMACHeader
+	}
+	timestampHeader := cfg["timestamp_header"]
+	if timestampHeader == "" {
+		timestampHeader = defaultHMACTimestampHeader
+	}
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(headerName, value)
+	return nil
+}
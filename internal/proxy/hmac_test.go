@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+func TestSignHMAC(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name       string
+		cfg        map[string]string
+		wantHeader string
+		wantTSHdr  string
+	}{
+		{
+			name:       "defaults",
+			cfg:        map[string]string{"secret": "topsecret"},
+			wantHeader: "X-Signature",
+			wantTSHdr:  "X-Timestamp",
+		},
+		{
+			name: "custom header, template and encoding",
+			cfg: map[string]string{
+				"secret":           "topsecret",
+				"algorithm":        "sha512",
+				"encoding":         "base64",
+				"header_name":      "Authorization",
+				"header_template":  "HMAC {key_id}:{algorithm}:{signature}",
+				"timestamp_header": "X-Request-Time",
+				"key_id":           "svc-1",
+			},
+			wantHeader: "Authorization",
+			wantTSHdr:  "X-Request-Time",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "https://svc.internal/v1/items?limit=5", nil)
+			if err := signHMAC(req, []byte(`{"a":1}`), tt.cfg, now); err != nil {
+				t.Fatalf("signHMAC: %v", err)
+			}
+			if got := req.Header.Get(tt.wantTSHdr); got != "1700000000" {
+				t.Errorf("%s = %q, want 1700000000", tt.wantTSHdr, got)
+			}
+			got := req.Header.Get(tt.wantHeader)
+			if got == "" {
+				t.Fatalf("expected %s header to be set", tt.wantHeader)
+			}
+			if tt.cfg["header_template"] != "" {
+				if !bytes.HasPrefix([]byte(got), []byte("HMAC svc-1:sha512:")) {
+					t.Errorf("%s = %q, want template prefix", tt.wantHeader, got)
+				}
+				return
+			}
+
+			bodyHash := sha256.Sum256([]byte(`{"a":1}`))
+			mac := hmac.New(sha256.New, []byte("topsecret"))
+			mac.Write([]byte("POST\n/v1/items?limit=5\n1700000000\n" + hex.EncodeToString(bodyHash[:])))
+			if want := hex.EncodeToString(mac.Sum(nil)); got != want {
+				t.Errorf("%s = %q, want %q", tt.wantHeader, got, want)
+			}
+		})
+	}
+}
+
+func TestSignHMACUnsupportedAlgorithm(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://svc.internal/", nil)
+	if err := signHMAC(req, nil, map[string]string{"secret": "s", "algorithm": "md5"}, time.Now()); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+}
+
+func TestAuthInjectionHMAC(t *testing.T) {
+	var gotSig, gotTS string
+	var gotBody bytes.Buffer
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSig = r.Header.Get("X-Signature")
+		gotTS = r.Header.Get("X-Timestamp")
+		_, _ = gotBody.ReadFrom(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.AuthType = "hmac"
+	tool.AuthConfig = map[string]string{"secret": "topsecret"}
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	handler := NewHandler(store, budgets, &fakeCollector{}, 5*time.Second, 1<<20)
+	router := setupRouter(handler)
+
+	req := httptest.NewRequest("POST", "/proxy/tool-1/v1/items", bytes.NewReader([]byte(`{"a":1}`)))
+	req = withAgent(req, newTestAgent())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if gotBody.String() != `{"a":1}` {
+		t.Errorf("expected body to be forwarded, got %q", gotBody.String())
+	}
+
+	// Verify the signature the way the upstream would.
+	bodyHash := sha256.Sum256([]byte(`{"a":1}`))
+	mac := hmac.New(sha256.New, []byte("topsecret"))
+	mac.Write([]byte("POST\n/v1/items\n" + gotTS + "\n" + hex.EncodeToString(bodyHash[:])))
+	if want := hex.EncodeToString(mac.Sum(nil)); gotSig != want {
+		t.Errorf("X-Signature = %q, want %q", gotSig, want)
+	}
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+const (
+	// tokenExpirySkew treats tokens as expired slightly early so they are
+	// never presented to an upstream right at their expiry.
+	tokenExpirySkew = 10 * time.Second
+
+	// defaultTokenLifetime is assumed when the token endpoint omits expires_in.
+	defaultTokenLifetime = time.Hour
+)
+
+// errTokenFetch wraps every failure to obtain an OAuth2 access token.
+var errTokenFetch = errors.New("oauth2 token fetch failed")
+
+// oauth2Token is a cached access token for one tool.
+type oauth2Token struct {
+	accessToken string
+	expiresAt   time.Time
+	refreshAt   time.Time
+}
+
+// tokenCall is an in-flight token request shared by concurrent callers.
+type tokenCall struct {
+	done  chan struct{}
+	token *oauth2Token
+	err   error
+}
+
+// tokenEntry holds the cached token and any in-flight fetch for one tool.
+// fingerprint identifies the credentials the token was issued for, so edits
+// to the tool's auth config never reuse a stale token.
+type tokenEntry struct {
+	fingerprint string
+	token       *oauth2Token
+	call        *tokenCall
+}
+
+// tokenCache fetches and caches OAuth2 client-credentials tokens per tool.
+// Concurrent requests for the same tool share a single token request, and
+// tokens are refreshed in the background once they enter the last fifth of
+// their lifetime.
+type tokenCache struct {
+	client  *http.Client
+	timeout time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*tokenEntry
+}
+
+func newTokenCache(client *http.Client, timeout time.Duration) *tokenCache {
+	return &tokenCache{
+		client:  client,
+		timeout: timeout,
+		now:     time.Now,
+		entries: make(map[string]*tokenEntry),
+	}
+}
+
+// Token returns a valid access token for tool, fetching one if none is cached.
+func (c *tokenCache) Token(ctx context.Context, tool *registry.Tool) (string, error) {
+	fp := tokenFingerprint(tool.AuthConfig)
+
+	c.mu.Lock()
+	e := c.entries[tool.ID]
+	if e == nil || e.fingerprint != fp {
+		e = &tokenEntry{fingerprint: fp}
+		c.entries[tool.ID] = e
+	}
+	now := c.now()
+	if tok := e.token; tok != nil && now.Before(tok.expiresAt) {
+		if !now.Before(tok.refreshAt) && e.call == nil {
+			c.startFetch(e, tool.AuthConfig)
+		}
+		c.mu.Unlock()
+		return tok.accessToken, nil
+	}
+	call := e.call
+	if call == nil {
+		call = c.startFetch(e, tool.AuthConfig)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return "", call.err
+		}
+		return call.token.accessToken, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Invalidate drops the cached token for toolID if it is still accessToken,
+// so the next Token call fetches a fresh one.
+func (c *tokenCache) Invalidate(toolID, accessToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[toolID]; e != nil && e.token != nil && e.token.accessToken == accessToken {
+		e.token = nil
+	}
+}
+
+// forget drops everything cached for toolID, for when the tool is deleted or
+// its auth config changes.
+func (c *tokenCache) forget(toolID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, toolID)
+}
+
+// startFetch begins a token request for e. The caller must hold c.mu. The
+// request is detached from any single caller's context so that one agent
+// disconnecting does not fail the fetch for everyone waiting on it.
+func (c *tokenCache) startFetch(e *tokenEntry, cfg map[string]string) *tokenCall {
+	call := &tokenCall{done: make(chan struct{})}
+	e.call = call
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		call.token, call.err = c.fetch(ctx, cfg)
+
+		c.mu.Lock()
+		if call.err == nil {
+			e.token = call.token
+		}
+		e.call = nil
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// fetch performs the client-credentials grant against the configured token URL.
+func (c *tokenCache) fetch(ctx context.Context, cfg map[string]string) (*oauth2Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if scopes := oauth2Scopes(cfg["scopes"]); scopes != "" {
+		form.Set("scope", scopes)
+	}
+	if cfg["auth_style"] == "params" {
+		form.Set("client_id", cfg["client_id"])
+		form.Set("client_secret", cfg["client_secret"])
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg["token_url"], strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: building request: %v", errTokenFetch, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if cfg["auth_style"] != "params" {
+		req.SetBasicAuth(url.QueryEscape(cfg["client_id"]), url.QueryEscape(cfg["client_secret"]))
+	}
+
+	issuedAt := c.now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errTokenFetch, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading response: %v", errTokenFetch, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: token endpoint returned %d", errTokenFetch, resp.StatusCode)
+	}
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("%w: decoding response: %v", errTokenFetch, err)
+	}
+	if payload.AccessToken == "" {
+		return nil, fmt.Errorf("%w: response has no access_token", errTokenFetch)
+	}
+
+	lifetime := defaultTokenLifetime
+	if payload.ExpiresIn > 0 {
+		lifetime = time.Duration(payload.ExpiresIn) * time.Second
+	}
+	// Tokens that live no longer than the skew are still cached for half
+	// their lifetime rather than fetched for every request. Short-lived
+	// tokens would otherwise expire before they are due for a refresh, so
+	// the refresh is also brought forward to a little before they expire.
+	keep := max(lifetime-tokenExpirySkew, lifetime/2)
+	refresh := min(lifetime*4/5, keep-min(tokenExpirySkew, keep/5))
+	return &oauth2Token{
+		accessToken: payload.AccessToken,
+		expiresAt:   issuedAt.Add(keep),
+		refreshAt:   issuedAt.Add(refresh),
+	}, nil
+}
+
+// oauth2Scopes normalizes a comma- or space-separated scope list into the
+// space-delimited form the token endpoint expects.
+func oauth2Scopes(s string) string {
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	}), " ")
+}
+
+// tokenFingerprint identifies the credentials a token is issued for.
+func tokenFingerprint(cfg map[string]string) string {
+	return strings.Join([]string{cfg["token_url"], cfg["client_id"], cfg["client_secret"], oauth2Scopes(cfg["scopes"]), cfg["auth_style"]}, "\x00")
+}
@@ -0,0 +1,393 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/metering"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// newTokenServer issues sequential tokens ("tok-1", "tok-2", ...) with the
+// given lifetime, counting every grant.
+func newTokenServer(t *testing.T, expiresIn int, delay time.Duration, grants *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing token request: %v", err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != "client_credentials" {
+			t.Errorf("expected client_credentials grant, got %q", got)
+		}
+		if got := r.PostForm.Get("scope"); got != "read write" {
+			t.Errorf("expected scope %q, got %q", "read write", got)
+		}
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "s3cret" {
+			t.Errorf("expected basic client credentials, got %q/%q", id, secret)
+		}
+		time.Sleep(delay)
+		n := grants.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": fmt.Sprintf("tok-%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		})
+	}))
+}
+
+func newOAuth2Tool(endpoint, tokenURL string) *registry.Tool {
+	tool := newTestTool(endpoint)
+	tool.AuthType = "oauth2_client_credentials"
+	tool.AuthConfig = map[string]string{
+		"token_url":     tokenURL,
+		"client_id":     "client",
+		"client_secret": "s3cret",
+		"scopes":        "read,write",
+	}
+	return tool
+}
+
+func TestOAuth2TokenSharedAcrossConcurrentRequests(t *testing.T) {
+	var grants atomic.Int32
+	tokenSrv := newTokenServer(t, 3600, 50*time.Millisecond, &grants)
+	defer tokenSrv.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer tok-1" {
+			t.Errorf("expected Bearer tok-1, got %q", got)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tool := newOAuth2Tool(upstream.URL, tokenSrv.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	handler := NewHandler(store, budgets, &chanCollector{ch: make(chan metering.Transaction, 10)}, 5*time.Second, 1<<20)
+	router := setupRouter(handler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/data", nil), newTestAgent())
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected 200, got %d", rr.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A later request reuses the cached token.
+	req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/data", nil), newTestAgent())
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := grants.Load(); got != 1 {
+		t.Errorf("expected a single token grant, got %d", got)
+	}
+}
+
+func TestOAuth2RetriesOnceOnUnauthorized(t *testing.T) {
+	var grants atomic.Int32
+	tokenSrv := newTokenServer(t, 3600, 0, &grants)
+	defer tokenSrv.Close()
+
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("Authorization") != "Bearer tok-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+
+	tool := newOAuth2Tool(upstream.URL, tokenSrv.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &fakeCollector{}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+	router := setupRouter(handler)
+
+	req := httptest.NewRequest("POST", "/proxy/tool-1/data", bytes.NewReader([]byte(`{"q":1}`)))
+	req = withAgent(req, newTestAgent())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 after retry, got %d", rr.Code)
+	}
+	if rr.Body.String() != `{"q":1}` {
+		t.Errorf("expected request body to be replayed, got %q", rr.Body.String())
+	}
+	if calls.Load() != 2 || grants.Load() != 2 {
+		t.Errorf("expected 2 upstream calls and 2 grants, got %d and %d", calls.Load(), grants.Load())
+	}
+	if len(collector.transactions) != 1 || collector.transactions[0].StatusCode != http.StatusOK {
+		t.Errorf("expected one successful transaction, got %+v", collector.transactions)
+	}
+}
+
+func TestOAuth2DoesNotRetryTwice(t *testing.T) {
+	var grants atomic.Int32
+	tokenSrv := newTokenServer(t, 3600, 0, &grants)
+	defer tokenSrv.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	tool := newOAuth2Tool(upstream.URL, tokenSrv.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	handler := NewHandler(store, budgets, &fakeCollector{}, 5*time.Second, 1<<20)
+	router := setupRouter(handler)
+
+	req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/data", nil), newTestAgent())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected upstream 401 to be relayed, got %d", rr.Code)
+	}
+	if got := grants.Load(); got != 2 {
+		t.Errorf("expected exactly one refresh, got %d grants", got)
+	}
+}
+
+func TestOAuth2TokenFetchFailure(t *testing.T) {
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer tokenSrv.Close()
+
+	upstreamCalled := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalled = true
+	}))
+	defer upstream.Close()
+
+	tool := newOAuth2Tool(upstream.URL, tokenSrv.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	handler := NewHandler(store, budgets, &fakeCollector{}, 5*time.Second, 1<<20)
+	router := setupRouter(handler)
+
+	req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/data", nil), newTestAgent())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rr.Code)
+	}
+	var errResp proxyError
+	if err := json.NewDecoder(rr.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if errResp.Error.Code != "upstream_auth_failed" {
+		t.Errorf("expected upstream_auth_failed, got %q", errResp.Error.Code)
+	}
+	if upstreamCalled {
+		t.Error("upstream should not be called without a token")
+	}
+}
+
+func TestTokenCacheRefreshesProactively(t *testing.T) {
+	var grants atomic.Int32
+	tokenSrv := newTokenServer(t, 100, 0, &grants)
+	defer tokenSrv.Close()
+
+	now := time.Now()
+	var mu sync.Mutex
+	cache := newTokenCache(&http.Client{}, 5*time.Second)
+	cache.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+
+	tool := newOAuth2Tool("https://upstream.example.com", tokenSrv.URL)
+	ctx := context.Background()
+
+	tok, err := cache.Token(ctx, tool)
+	if err != nil || tok != "tok-1" {
+		t.Fatalf("expected tok-1, got %q (%v)", tok, err)
+	}
+
+	// Inside the refresh window the current token is still served while a
+	// replacement is fetched in the background.
+	advance(85 * time.Second)
+	if tok, _ := cache.Token(ctx, tool); tok != "tok-1" {
+		t.Errorf("expected cached tok-1 during refresh, got %q", tok)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if tok, _ = cache.Token(ctx, tool); tok == "tok-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected refreshed tok-2, got %q", tok)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := grants.Load(); got != 2 {
+		t.Errorf("expected 2 grants, got %d", got)
+	}
+
+	// Equivalent scope spellings share the cached token.
+	tool.AuthConfig["scopes"] = "read write"
+	if tok, _ := cache.Token(ctx, tool); tok != "tok-2" {
+		t.Errorf("expected equivalent scopes to keep tok-2, got %q", tok)
+	}
+}
+
+func TestTokenCacheKeepsShortLivedTokens(t *testing.T) {
+	var grants atomic.Int32
+	tokenSrv := newTokenServer(t, 6, 0, &grants)
+	defer tokenSrv.Close()
+
+	now := time.Now()
+	cache := newTokenCache(&http.Client{}, 5*time.Second)
+	cache.now = func() time.Time { return now }
+	tool := newOAuth2Tool("https://upstream.example.com", tokenSrv.URL)
+	ctx := context.Background()
+
+	// A 6s token is shorter than the expiry skew, so it is kept for 3s.
+	for i := 0; i < 3; i++ {
+		if tok, err := cache.Token(ctx, tool); err != nil || tok != "tok-1" {
+			t.Fatalf("expected cached tok-1, got %q (%v)", tok, err)
+		}
+	}
+	now = now.Add(3 * time.Second)
+	if tok, err := cache.Token(ctx, tool); err != nil || tok != "tok-2" {
+		t.Fatalf("expected tok-2 once half the lifetime passed, got %q (%v)", tok, err)
+	}
+	if got := grants.Load(); got != 2 {
+		t.Errorf("expected 2 grants, got %d", got)
+	}
+}
+
+func TestTokenCacheRefreshesShortLivedTokens(t *testing.T) {
+	var grants atomic.Int32
+	tokenSrv := newTokenServer(t, 20, 0, &grants)
+	defer tokenSrv.Close()
+
+	now := time.Now()
+	var mu sync.Mutex
+	cache := newTokenCache(&http.Client{}, 5*time.Second)
+	cache.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	tool := newOAuth2Tool("https://upstream.example.com", tokenSrv.URL)
+	ctx := context.Background()
+
+	if tok, err := cache.Token(ctx, tool); err != nil || tok != "tok-1" {
+		t.Fatalf("expected tok-1, got %q (%v)", tok, err)
+	}
+
+	// A 20s token is kept for 10s; 80% of its lifetime would be after that,
+	// so it is refreshed in the background shortly before it expires.
+	mu.Lock()
+	now = now.Add(9 * time.Second)
+	mu.Unlock()
+	if tok, _ := cache.Token(ctx, tool); tok != "tok-1" {
+		t.Errorf("expected cached tok-1 during refresh, got %q", tok)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if tok, _ := cache.Token(ctx, tool); tok == "tok-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected tok-2 to be fetched in the background before tok-1 expired")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := grants.Load(); got != 2 {
+		t.Errorf("expected 2 grants, got %d", got)
+	}
+}
+
+func TestInvalidateToolForgetsToken(t *testing.T) {
+	var grants atomic.Int32
+	tokenSrv := newTokenServer(t, 3600, 0, &grants)
+	defer tokenSrv.Close()
+
+	handler := NewHandler(nil, nil, nil, 5*time.Second, 1<<20)
+	tool := newOAuth2Tool("https://upstream.example.com", tokenSrv.URL)
+	if _, err := handler.tokens.Token(context.Background(), tool); err != nil {
+		t.Fatalf("fetching token: %v", err)
+	}
+
+	handler.InvalidateTool(tool.ID)
+	if _, ok := handler.tokens.entries[tool.ID]; ok {
+		t.Error("expected InvalidateTool to drop the cached token")
+	}
+}
+
+// tokenErrorMetrics counts token fetch errors; other metrics are not expected.
+type tokenErrorMetrics struct {
+	MetricsRecorder
+	tokenErrors int
+}
+
+func (m *tokenErrorMetrics) IncTokenFetchError(string, string) { m.tokenErrors++ }
+
+func TestWriteAuthError(t *testing.T) {
+	tests := []struct {
+		name        string
+		authType    string
+		err         error
+		wantStatus  int
+		wantCounted bool
+	}{
+		{"token endpoint failure", "oauth2_client_credentials", errTokenFetch, http.StatusBadGateway, true},
+		{"token fetch timed out", "oauth2_client_credentials", errUpstreamTimeout, http.StatusBadGateway, true},
+		{"signing failure", "hmac", errors.New("reading body"), http.StatusBadGateway, false},
+		{"client went away", "oauth2_client_credentials", context.Canceled, statusClientClosedRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &tokenErrorMetrics{}
+			h := NewHandler(&fakeToolStore{}, &fakeBudgetChecker{}, &fakeCollector{}, time.Second, 1<<20)
+			h.SetMetrics(m)
+
+			rr := httptest.NewRecorder()
+			h.writeAuthError(rr, &registry.Tool{ID: "tool-1", AuthType: tt.authType}, tt.err)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if tt.wantStatus == statusClientClosedRequest && rr.Body.Len() != 0 {
+				t.Errorf("expected no body for a cancelled client, got %q", rr.Body.String())
+			}
+			if counted := m.tokenErrors > 0; counted != tt.wantCounted {
+				t.Errorf("token fetch error counted = %v, want %v", counted, tt.wantCounted)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// Probe sends a GET for path to tool's upstream with the tool's credentials
+// injected, as an active health check, and returns the upstream status code.
+// Metering, rate limits, budgets and the circuit breaker are bypassed.
+func (h *Handler) Probe(ctx context.Context, tool *registry.Tool, path string) (int, error) {
+	upstreamPath, rawQuery, _ := strings.Cut(path, "?")
+	rt, err := h.targets.route(tool, upstreamPath, rawQuery)
+	if err != nil {
+		return 0, fmt.Errorf("resolving endpoint: %w", err)
+	}
+	client, err := h.clientFor(tool)
+	if err != nil {
+		return 0, fmt.Errorf("building TLS config: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rt.url(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("building request: %w", err)
+	}
+	if err := h.egress.CheckHost(req.URL.Hostname()); err != nil {
+		return 0, err
+	}
+	if err := h.injectAuth(ctx, req, tool); err != nil {
+		return 0, fmt.Errorf("injecting credentials: %w", err)
+	}
+
+	for {
+		resp, err := client.Do(req)
+		if err == nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			return resp.StatusCode, nil
+		}
+		// Like proxied requests, try the next target if this one is down.
+		if !isConnectError(err) || !rt.failover() {
+			return 0, err
+		}
+		if req, err = h.retarget(req, rt, tool); err != nil {
+			return 0, err
+		}
+	}
+}
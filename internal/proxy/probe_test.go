@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/healthz" || r.URL.Query().Get("deep") != "1" {
+			t.Errorf("unexpected probe %s", r.URL)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("expected tool credentials injected, got %q", r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL + "/v1")
+	tool.AuthType = "bearer"
+	tool.AuthConfig = map[string]string{"key": "secret"}
+	collector := &fakeCollector{}
+	handler := NewHandler(&fakeToolStore{}, &fakeBudgetChecker{}, collector, 5*time.Second, 1<<20)
+
+	code, err := handler.Probe(context.Background(), tool, "/healthz?deep=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", code)
+	}
+	if len(collector.transactions) != 0 {
+		t.Errorf("expected probes not metered, got %d transactions", len(collector.transactions))
+	}
+
+	down := newTestTool("http://127.0.0.1:1")
+	if _, err := handler.Probe(context.Background(), down, "/"); err == nil {
+		t.Error("expected error probing an unreachable tool")
+	}
+}
@@ -1,18 +1,22 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/alecgard/octroi/internal/agent"
 	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/egress"
 	"github.com/alecgard/octroi/internal/metering"
 	"github.com/alecgard/octroi/internal/registry"
 	"github.com/go-chi/chi/v5"
@@ -23,10 +27,17 @@ type ToolStore interface {
 	GetByID(ctx context.Context, id string) (*registry.Tool, error)
 }
 
-// BudgetChecker is the interface for checking agent and global tool budgets.
+// BudgetChecker is the interface for reserving spend against agent, team and
+// global tool budgets. Reserve holds an estimated cost against every budget
+// the call counts towards and returns the reservation, with the spend against
+// each budget, or, when a budget has no room, which kind: "agent" (the
+// agent's budget for the tool), "agent_total" (the agent's across all tools),
+// "team" or "global". A reservation is settled with the actual cost when the
+// transaction carrying its ID is written, or released if the call is never
+// metered.
 type BudgetChecker interface {
-	CheckBudget(ctx context.Context, agentID, toolID string) (allowed bool, remainingDaily float64, remainingMonthly float64, err error)
-	CheckToolGlobalBudget(ctx context.Context, toolID string) (allowed bool, remaining float64, err error)
+	Reserve(ctx context.Context, agentID, toolID string, estimate float64) (agent.Reservation, error)
+	Release(ctx context.Context, id string) error
 }
 
 // MeteringRecorder is the interface for recording transactions.
@@ -36,7 +47,18 @@ type MeteringRecorder interface {
 
 // ToolRateLimitChecker is the interface for checking per-tool rate limits.
 type ToolRateLimitChecker interface {
-	CheckToolRateLimit(ctx context.Context, toolID, team, agentID string) (allowed bool, limit, remaining int, resetAt time.Time, err error)
+	CheckToolRateLimit(ctx context.Context, toolID, team, agentID string, maxWait time.Duration) (allowed bool, limit, remaining int, resetAt time.Time, retryAfter time.Duration, err error)
+}
+
+// ToolAccessChecker is the interface for checking whether an agent may call a tool.
+type ToolAccessChecker interface {
+	CheckToolAccess(ctx context.Context, toolID, team, agentID string) (allowed bool, err error)
+}
+
+// ConcurrencyLimiter bounds the requests a tool has in flight. When allowed,
+// release must be called once the request is done.
+type ConcurrencyLimiter interface {
+	Acquire(ctx context.Context, toolID, team, agentID string) (release func(), allowed bool, err error)
 }
 
 // MetricsRecorder is an optional interface for recording proxy-level metrics.
@@ -48,27 +70,68 @@ type MetricsRecorder interface {
 	IncBudgetRejection(budgetType string)
 	IncToolRateLimitRejection()
 	IncUpstreamError(errorType, toolID, toolName string)
+	IncTokenFetchError(toolID, toolName string)
+	IncUpstreamRetry(toolID, toolName, reason string)
+	ObserveUpstreamAttempts(toolID, toolName string, attempts int)
+	SetCircuitBreakerState(toolID, toolName string, state int)
+	DeleteCircuitBreakerState(toolID, toolName string)
+	IncCacheRequest(toolID, toolName, result string)
+	SetCacheSize(entries int, bytes int64)
+	IncCoalescedRequest(toolID, toolName string)
+	IncConcurrencyRejection(toolID, toolName string)
 }
 
+// errUpstreamTimeout is the cancellation cause when the upstream exceeds the
+// proxy timeout (or, for streams, the idle-read timeout).
+var errUpstreamTimeout = fmt.Errorf("upstream timed out: %w", context.DeadlineExceeded)
+
 // Handler proxies requests to tool endpoints.
 type Handler struct {
-	tools          ToolStore
-	budgets        BudgetChecker
-	collector      MeteringRecorder
-	toolRateLimits ToolRateLimitChecker
-	client         *http.Client
-	maxRequestSize int64
-	metrics        MetricsRecorder
+	tools             ToolStore
+	budgets           BudgetChecker
+	collector         MeteringRecorder
+	toolRateLimits    ToolRateLimitChecker
+	toolAccess        ToolAccessChecker
+	concurrency       ConcurrencyLimiter
+	client            *http.Client
+	timeout           time.Duration
+	streamIdleTimeout time.Duration
+	maxRequestSize    int64
+	tokens            *tokenCache
+	transports        *transportCache
+	egress            *egress.Policy
+	breakers          *breakerSet
+	targets           *targetPool
+	cache             *responseCache
+	flights           *flightGroup
+	metrics           MetricsRecorder
 }
 
-// NewHandler creates a new proxy handler.
+// NewHandler creates a new proxy handler. timeout bounds each upstream call;
+// streamed responses use it as the idle-read timeout unless overridden with
+// SetStreamIdleTimeout.
 func NewHandler(toolStore ToolStore, budgetStore BudgetChecker, collector MeteringRecorder, timeout time.Duration, maxRequestSize int64) *Handler {
+	client := &http.Client{}
 	return &Handler{
-		tools:          toolStore,
-		budgets:        budgetStore,
-		collector:      collector,
-		client:         &http.Client{Timeout: timeout},
-		maxRequestSize: maxRequestSize,
+		tools:             toolStore,
+		budgets:           budgetStore,
+		collector:         collector,
+		client:            client,
+		timeout:           timeout,
+		streamIdleTimeout: timeout,
+		maxRequestSize:    maxRequestSize,
+		tokens:            newTokenCache(client, timeout),
+		transports:        newTransportCache(),
+		targets:           newTargetPool(),
+		flights:           newFlightGroup(),
+	}
+}
+
+// SetStreamIdleTimeout sets how long a streamed response may go without
+// receiving data from the upstream before it is aborted.
+func (h *Handler) SetStreamIdleTimeout(d time.Duration) {
+	if d > 0 {
+		h.streamIdleTimeout = d
 	}
 }
 
@@ -77,11 +140,90 @@ func (h *Handler) SetToolRateLimitChecker(checker ToolRateLimitChecker) {
 	h.toolRateLimits = checker
 }
 
+// SetToolAccessChecker sets the optional tool access checker.
+func (h *Handler) SetToolAccessChecker(checker ToolAccessChecker) {
+	h.toolAccess = checker
+}
+
+// SetConcurrencyLimiter sets the optional limiter of requests in flight.
+func (h *Handler) SetConcurrencyLimiter(limiter ConcurrencyLimiter) {
+	h.concurrency = limiter
+}
+
 // SetMetrics sets the optional metrics recorder.
 func (h *Handler) SetMetrics(m MetricsRecorder) {
 	h.metrics = m
 }
 
+// SetEgressPolicy restricts the destinations the proxy may connect to,
+// including redirects and OAuth2 token endpoints.
+func (h *Handler) SetEgressPolicy(p *egress.Policy) {
+	h.egress = p
+	h.transports.policy = p
+	h.client.Transport = newTransport(p)
+	h.client.CheckRedirect = p.CheckRedirect
+}
+
+// SetCircuitBreaker enables a circuit breaker per tool. While a tool's breaker
+// is open, requests fail fast with 503 instead of waiting on the upstream.
+func (h *Handler) SetCircuitBreaker(settings BreakerSettings) {
+	h.breakers = newBreakerSet(settings, func(toolID, toolName string, state breakerState) {
+		if h.metrics != nil {
+			h.metrics.SetCircuitBreakerState(toolID, toolName, int(state))
+		}
+	}, func(toolID, toolName string) {
+		if h.metrics != nil {
+			h.metrics.DeleteCircuitBreakerState(toolID, toolName)
+		}
+	})
+}
+
+// CircuitState returns the state of toolID's circuit breaker: "closed",
+// "half_open" or "open".
+func (h *Handler) CircuitState(toolID string) string {
+	return h.breakers.state(toolID).String()
+}
+
+// SetResponseCache enables the in-memory response cache for tools whose
+// cache policy is enabled.
+func (h *Handler) SetResponseCache(settings CacheSettings) {
+	h.cache = newResponseCache(settings, func(entries int, bytes int64) {
+		if h.metrics != nil {
+			h.metrics.SetCacheSize(entries, bytes)
+		}
+	})
+}
+
+// PurgeToolCache drops every cached response of toolID and returns how many
+// there were.
+func (h *Handler) PurgeToolCache(toolID string) int {
+	return h.cache.purgeTool(toolID)
+}
+
+// InvalidateTool drops any per-tool upstream state (such as a TLS transport,
+// circuit breaker, target health, cached responses or OAuth2 token) held for
+// toolID. It is called when the tool is updated or deleted.
+func (h *Handler) InvalidateTool(toolID string) {
+	h.transports.invalidate(toolID)
+	h.breakers.reset(toolID)
+	h.targets.invalidate(toolID)
+	h.cache.purgeTool(toolID)
+	h.tokens.forget(toolID)
+}
+
+// clientFor returns the HTTP client to use for tool: a dedicated one when the
+// tool has custom TLS settings, otherwise the shared default.
+func (h *Handler) clientFor(tool *registry.Tool) (*http.Client, error) {
+	if !hasTLSConfig(tool) {
+		return h.client, nil
+	}
+	t, err := h.transports.get(tool)
+	if err != nil {
+		return nil, err
+	}
+	return t.client, nil
+}
+
 // ServeHTTP handles proxy requests.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	toolID := chi.URLParam(r, "toolID")
@@ -104,15 +246,36 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check tool grants before any rate limit or budget accounting.
+	if h.toolAccess != nil {
+		accessAllowed, err := h.toolAccess.CheckToolAccess(r.Context(), tool.ID, agent.Team, agent.ID)
+		if err != nil {
+			// Fail closed: a deny grant must not turn into an allow while
+			// grants can't be read.
+			slog.Error("checking tool access failed", "tool", tool.ID, "agent", agent.ID, "error", err)
+			writeError(w, http.StatusServiceUnavailable, "access_check_failed", "unable to check tool access")
+			return
+		}
+		if !accessAllowed {
+			writeError(w, http.StatusForbidden, "tool_forbidden", "agent is not permitted to use this tool")
+			return
+		}
+	}
+
 	// Track active requests.
 	if h.metrics != nil {
 		h.metrics.IncActiveRequests(tool.ID)
 		defer h.metrics.DecActiveRequests(tool.ID)
 	}
 
-	// Check per-tool rate limits (global / team / agent scopes).
+	// Check per-tool rate limits (global / team / agent scopes). Requests wait
+	// for a token when either the tool or the agent opts in.
 	if h.toolRateLimits != nil {
-		tlAllowed, tlLimit, tlRemaining, tlResetAt, tlErr := h.toolRateLimits.CheckToolRateLimit(r.Context(), tool.ID, agent.Team, agent.ID)
+		maxWait := time.Duration(max(tool.RateLimitWaitMs, agent.RateLimitWaitMs)) * time.Millisecond
+		tlAllowed, tlLimit, tlRemaining, tlResetAt, tlRetryAfter, tlErr := h.toolRateLimits.CheckToolRateLimit(r.Context(), tool.ID, agent.Team, agent.ID, maxWait)
+		if tlErr != nil && r.Context().Err() != nil {
+			return // client went away while waiting
+		}
 		if tlErr == nil {
 			if tlLimit > 0 {
 				w.Header().Set("X-Tool-RateLimit-Limit", fmt.Sprintf("%d", tlLimit))
@@ -123,52 +286,90 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				if h.metrics != nil {
 					h.metrics.IncToolRateLimitRejection()
 				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(tlRetryAfter)))
 				writeError(w, http.StatusTooManyRequests, "tool_rate_limited", "tool rate limit exceeded")
 				return
 			}
 		}
 	}
 
-	// Check per-agent budget.
-	allowed, _, _, err := h.budgets.CheckBudget(r.Context(), agent.ID, tool.ID)
-	if err == nil && !allowed {
-		if h.metrics != nil {
-			h.metrics.IncBudgetRejection("agent")
-		}
-		writeError(w, http.StatusForbidden, "budget_exceeded", "agent budget exceeded for this tool")
+	// Reserve the estimated cost against the agent and global tool budgets,
+	// so concurrent requests can't spend the same remaining budget.
+	r, ok := h.reserveBudget(w, r, tool, agent)
+	if !ok {
 		return
 	}
+	defer h.releaseBudget(r)
 
-	// Check global tool budget.
-	globalAllowed, _, err := h.budgets.CheckToolGlobalBudget(r.Context(), tool.ID)
-	if err == nil && !globalAllowed {
-		if h.metrics != nil {
-			h.metrics.IncBudgetRejection("global")
-		}
-		writeError(w, http.StatusForbidden, "budget_exceeded", "global tool budget exceeded")
+	// Build the upstream path by stripping the /proxy/{toolID} prefix.
+	proxyPrefix := fmt.Sprintf("/proxy/%s", toolID)
+	upstreamPath := strings.TrimPrefix(r.URL.Path, proxyPrefix)
+	if upstreamPath == "" {
+		upstreamPath = "/"
+	}
+
+	// Serve fresh responses from the tool's response cache.
+	cl := h.lookupCache(tool, agent, r, upstreamPath)
+	if cl.hit != nil {
+		h.serveCached(w, r, tool, agent, cl.hit)
 		return
 	}
+	if cl.result == cacheBypass && h.metrics != nil {
+		h.metrics.IncCacheRequest(tool.ID, tool.Name, cacheBypass)
+	}
 
-	// Resolve template for API mode.
-	endpoint := tool.Endpoint
-	if tool.Mode == "api" {
-		resolved, err := registry.ResolveTemplate(tool.Endpoint, tool.Variables)
-		if err != nil {
-			writeError(w, http.StatusBadGateway, "proxy_error", "failed to resolve endpoint template")
+	// Collapse identical concurrent requests into one upstream call: the
+	// first becomes the leader and the others wait for its response.
+	var lead *flight
+	if key := coalesceKey(tool, r, upstreamPath); key != "" {
+		f, leader := h.flights.join(key)
+		if leader {
+			lead = f
+			defer h.flights.finish(key, f)
+		} else if h.waitCoalesced(w, r, tool, agent, f) {
 			return
 		}
-		endpoint = resolved
 	}
 
-	// Build the upstream path by stripping the /proxy/{toolID} prefix.
-	proxyPrefix := fmt.Sprintf("/proxy/%s", toolID)
-	upstreamPath := strings.TrimPrefix(r.URL.Path, proxyPrefix)
-	if upstreamPath == "" {
-		upstreamPath = "/"
+	// Wait for a slot under the tool's concurrency limits. Cache hits and
+	// coalesced requests above never reach the upstream, so they take none.
+	if h.concurrency != nil {
+		release, allowed, err := h.concurrency.Acquire(r.Context(), tool.ID, agent.Team, agent.ID)
+		if err == nil {
+			if !allowed {
+				h.writeConcurrencyLimited(w, r, tool, agent)
+				return
+			}
+			defer release()
+		}
+	}
+
+	// Choose the upstream target, resolving templates for API mode.
+	rt, err := h.targets.route(tool, upstreamPath, r.URL.RawQuery)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "proxy_error", "failed to resolve endpoint template")
+		return
+	}
+	defer rt.release()
+
+	// Fail fast while the tool's circuit breaker is open.
+	ticket, retryAfter, ok := h.breakers.allow(tool)
+	if !ok {
+		h.writeToolUnavailable(w, r, tool, agent, retryAfter)
+		return
+	}
+	defer ticket.done(outcomeSkipped)
+
+	// WebSocket upgrades bypass the HTTP client and tunnel the connection.
+	if isWebSocketUpgrade(r) {
+		h.serveWebSocket(w, r, tool, agent, rt, ticket)
+		return
 	}
-	targetURL := strings.TrimRight(endpoint, "/") + upstreamPath
-	if r.URL.RawQuery != "" {
-		targetURL += "?" + r.URL.RawQuery
+
+	client, err := h.clientFor(tool)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "proxy_error", "invalid tool TLS configuration")
+		return
 	}
 
 	// Enforce max request body size.
@@ -177,11 +378,49 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		body = io.LimitReader(r.Body, h.maxRequestSize+1)
 	}
 
-	outReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL, body)
+	// Signed requests hash the body and OAuth2 requests may be replayed once
+	// after a token refresh, so those auth types buffer the body up front.
+	if needsBufferedBody(tool.AuthType) && body != nil {
+		buf, err := io.ReadAll(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "failed to read request body")
+			return
+		}
+		if int64(len(buf)) > h.maxRequestSize {
+			writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", "request body exceeds the maximum size")
+			return
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	// Retries and failover to another target replay the body, so buffer it
+	// when either may happen. Bodies over the policy's limit are sent once.
+	policy := newRetryPolicy(tool.RetryPolicy)
+	retryable := policy.enabled() && canRetry(r)
+	if (retryable || len(rt.targets) > 1) && body != nil && !needsBufferedBody(tool.AuthType) {
+		limit := min(policy.maxBodyBytes, h.maxRequestSize)
+		buf, err := io.ReadAll(io.LimitReader(body, limit+1))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "failed to read request body")
+			return
+		}
+		if int64(len(buf)) > limit {
+			body = io.MultiReader(bytes.NewReader(buf), body)
+			retryable = false
+		} else {
+			body = bytes.NewReader(buf)
+		}
+	}
+
+	outReq, err := http.NewRequestWithContext(r.Context(), r.Method, rt.url(), body)
 	if err != nil {
 		writeError(w, http.StatusBadGateway, "proxy_error", "failed to build upstream request")
 		return
 	}
+	if err := h.egress.CheckHost(outReq.URL.Hostname()); err != nil {
+		h.writeEgressDenied(w, r, tool, agent)
+		return
+	}
 
 	// Forward headers, excluding Authorization, Host, Connection.
 	skipHeaders := map[string]bool{
@@ -198,49 +437,91 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Inject tool auth credentials.
-	switch tool.AuthType {
-	case "bearer":
-		outReq.Header.Set("Authorization", "Bearer "+tool.AuthConfig["key"])
-	case "header":
-		headerName := tool.AuthConfig["header_name"]
-		if headerName != "" {
-			outReq.Header.Set(headerName, tool.AuthConfig["key"])
+	// Ask the upstream whether a stale cached response is still valid,
+	// unless the agent made its own conditional request.
+	revalidating := cl.stale != nil && r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == ""
+	if revalidating {
+		if etag := cl.stale.header.Get("ETag"); etag != "" {
+			outReq.Header.Set("If-None-Match", etag)
 		}
-	case "query":
-		paramName := tool.AuthConfig["param_name"]
-		if paramName == "" {
-			paramName = "api_key"
+		if lastModified := cl.stale.header.Get("Last-Modified"); lastModified != "" {
+			outReq.Header.Set("If-Modified-Since", lastModified)
 		}
-		q := outReq.URL.Query()
-		q.Set(paramName, tool.AuthConfig["key"])
-		outReq.URL.RawQuery = q.Encode()
-	case "none":
-		// No auth injection.
 	}
 
-	// Execute the upstream request.
+	// Execute the upstream request. The deadline starts as the total proxy
+	// timeout; streamed responses switch it to an idle-read timeout below.
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	expires := time.Now().Add(h.timeout)
+	deadline := time.AfterFunc(h.timeout, func() { cancel(errUpstreamTimeout) })
+	defer deadline.Stop()
+	outReq = outReq.WithContext(ctx)
+
+	// Inject tool auth credentials.
+	if err := h.injectAuth(ctx, outReq, tool); err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			err = cause
+		}
+		h.writeAuthError(w, tool, err)
+		return
+	}
+
+	// Determine request size from Content-Length header, or 0.
+	requestSize := r.ContentLength
+	if requestSize < 0 {
+		requestSize = 0
+	}
+
 	start := time.Now()
-	resp, err := h.client.Do(outReq)
+	resp, attempts, err := h.doWithRetry(client, outReq, tool, rt, policy, retryable, expires)
 	latency := time.Since(start)
 
 	if h.metrics != nil {
 		h.metrics.ObserveUpstreamDuration(tool.ID, tool.Name, latency.Seconds())
+		h.metrics.ObserveUpstreamAttempts(tool.ID, tool.Name, attempts)
 	}
 
+	if errors.Is(err, egress.ErrDenied) {
+		h.writeEgressDenied(w, r, tool, agent)
+		return
+	}
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+	ticket.done(upstreamOutcome(r.Context(), statusCode, err))
 	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			err = cause
+		}
 		if h.metrics != nil {
 			h.metrics.IncProxyRequests(tool.ID, tool.Name, agent.ID, r.Method, 502)
 			h.metrics.IncUpstreamError(classifyUpstreamError(err), tool.ID, tool.Name)
 		}
-		h.recordTransaction(agent.ID, tool, r, 502, latency, 0, 0, false, "")
+		h.recordTransaction(tool, r, metering.Transaction{
+			AgentID:    agent.ID,
+			StatusCode: 502,
+			LatencyMs:  latency.Milliseconds(),
+			DurationMs: latency.Milliseconds(),
+			Attempts:   attempts,
+			Target:     rt.host(),
+			Error:      classifyUpstreamError(err),
+		}, "")
 		writeError(w, http.StatusBadGateway, "proxy_error", "upstream request failed")
 		return
 	}
 	defer resp.Body.Close()
 
+	if revalidating && resp.StatusCode == http.StatusNotModified {
+		resp = h.revalidated(cl, tool, resp)
+		cl.result = cacheRevalidated
+	}
 	if h.metrics != nil {
 		h.metrics.IncProxyRequests(tool.ID, tool.Name, agent.ID, r.Method, resp.StatusCode)
+		if cl.key != "" && cl.result != cacheBypass {
+			h.metrics.IncCacheRequest(tool.ID, tool.Name, cl.result)
+		}
 	}
 
 	// Capture the upstream cost header before copying headers.
@@ -252,26 +533,288 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add(key, v)
 		}
 	}
+	if cl.key != "" {
+		w.Header().Set(cacheHeader, strings.ToUpper(cl.result))
+	}
+
+	streaming := isStreamingResponse(resp)
+	rc := http.NewResponseController(w)
+	if streaming {
+		// Streams may legitimately outlive both the proxy timeout and the
+		// server's write timeout; only an idle upstream ends them early.
+		deadline.Reset(h.streamIdleTimeout)
+		_ = rc.SetWriteDeadline(time.Time{})
+	}
 	w.WriteHeader(resp.StatusCode)
 
-	// Copy response body.
-	responseSize, _ := io.Copy(w, resp.Body)
+	// Keep a copy of complete responses the cache may store or waiting
+	// coalesced requests may share.
+	var src io.Reader = resp.Body
+	var capture *captureReader
+	storable := cl.key != "" && !cl.noStore && cl.result == cacheMiss && resp.StatusCode == http.StatusOK
+	if (storable || lead != nil) && !streaming {
+		limit := int64(maxCoalescedBytes)
+		if lead == nil {
+			limit = h.cache.settings.MaxEntryBytes
+		}
+		capture = &captureReader{r: resp.Body, limit: limit}
+		src = capture
+	}
+
+	// Copy response body, flushing each chunk through to the agent when streaming.
+	responseSize, firstByte, readErr, writeErr := copyBody(w, rc, src, streaming, func() {
+		if streaming {
+			deadline.Reset(h.streamIdleTimeout)
+		}
+	})
+	duration := time.Since(start)
+	if capture != nil && readErr == nil && writeErr == nil && !capture.overflow {
+		if storable {
+			h.storeResponse(cl, tool, resp, capture.buf.Bytes())
+		}
+		if lead != nil {
+			lead.share(resp, capture.buf.Bytes(), reportedCostHeader, rt.host(), latency)
+		}
+	}
 
-	// Determine request size from Content-Length header, or 0.
-	requestSize := r.ContentLength
-	if requestSize < 0 {
-		requestSize = 0
+	tx := metering.Transaction{
+		AgentID:      agent.ID,
+		StatusCode:   resp.StatusCode,
+		LatencyMs:    latency.Milliseconds(),
+		DurationMs:   duration.Milliseconds(),
+		RequestSize:  requestSize,
+		ResponseSize: responseSize,
+		Success:      resp.StatusCode >= 200 && resp.StatusCode < 300,
+		Streamed:     streaming,
+		Attempts:     attempts,
+		Target:       rt.host(),
+	}
+	if !firstByte.IsZero() {
+		tx.TTFBMs = firstByte.Sub(start).Milliseconds()
+	}
+	switch {
+	case writeErr != nil || r.Context().Err() != nil:
+		tx.Error = "client_disconnected"
+	case readErr != nil:
+		if cause := context.Cause(ctx); cause != nil {
+			readErr = cause
+		}
+		tx.Error = classifyUpstreamError(readErr)
+		tx.Success = false
+		if h.metrics != nil {
+			h.metrics.IncUpstreamError(tx.Error, tool.ID, tool.Name)
+		}
+	}
+	h.recordTransaction(tool, r, tx, reportedCostHeader)
+}
+
+// injectAuth applies the tool's configured credentials to the outbound request.
+func (h *Handler) injectAuth(ctx context.Context, outReq *http.Request, tool *registry.Tool) error {
+	switch tool.AuthType {
+	case "bearer":
+		outReq.Header.Set("Authorization", "Bearer "+tool.AuthConfig["key"])
+	case "header":
+		headerName := tool.AuthConfig["header_name"]
+		if headerName != "" {
+			outReq.Header.Set(headerName, tool.AuthConfig["key"])
+		}
+	case "query":
+		paramName := tool.AuthConfig["param_name"]
+		if paramName == "" {
+			paramName = "api_key"
+		}
+		q := outReq.URL.Query()
+		q.Set(paramName, tool.AuthConfig["key"])
+		outReq.URL.RawQuery = q.Encode()
+	case "oauth2_client_credentials":
+		token, err := h.tokens.Token(ctx, tool)
+		if err != nil {
+			return err
+		}
+		outReq.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		outReq.SetBasicAuth(tool.AuthConfig["username"], tool.AuthConfig["password"])
+	case "aws_sigv4":
+		body, err := bufferedBody(outReq)
+		if err != nil {
+			return err
+		}
+		signSigV4(outReq, body, tool.AuthConfig, time.Now())
+	case "hmac":
+		body, err := bufferedBody(outReq)
+		if err != nil {
+			return err
+		}
+		return signHMAC(outReq, body, tool.AuthConfig, time.Now())
+	case "none":
+		// No auth injection.
+	}
+	return nil
+}
+
+// writeEgressDenied rejects a request whose upstream destination (or a
+// redirect from it) is blocked by the egress policy.
+func (h *Handler) writeEgressDenied(w http.ResponseWriter, r *http.Request, tool *registry.Tool, agent *auth.Agent) {
+	if h.metrics != nil {
+		h.metrics.IncProxyRequests(tool.ID, tool.Name, agent.ID, r.Method, http.StatusForbidden)
+		h.metrics.IncUpstreamError("egress_denied", tool.ID, tool.Name)
+	}
+	writeError(w, http.StatusForbidden, "egress_denied", "upstream destination is not permitted by the egress policy")
+}
+
+// writeToolUnavailable rejects a request while the tool's circuit breaker is
+// open, telling the agent when to try again.
+func (h *Handler) writeToolUnavailable(w http.ResponseWriter, r *http.Request, tool *registry.Tool, agent *auth.Agent, retryAfter time.Duration) {
+	if h.metrics != nil {
+		h.metrics.IncProxyRequests(tool.ID, tool.Name, agent.ID, r.Method, http.StatusServiceUnavailable)
+	}
+	secs := int((retryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+	writeError(w, http.StatusServiceUnavailable, "tool_unavailable", "tool is temporarily unavailable after repeated upstream failures")
+}
+
+// retryAfterSeconds rounds d up to the whole seconds of a Retry-After
+// header, at least 1.
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int((d+time.Second-1)/time.Second))
+}
+
+// writeConcurrencyLimited rejects a request that found the tool's
+// concurrency limit reached and could not wait for a slot.
+func (h *Handler) writeConcurrencyLimited(w http.ResponseWriter, r *http.Request, tool *registry.Tool, agent *auth.Agent) {
+	if h.metrics != nil {
+		h.metrics.IncProxyRequests(tool.ID, tool.Name, agent.ID, r.Method, http.StatusTooManyRequests)
+		h.metrics.IncConcurrencyRejection(tool.ID, tool.Name)
+	}
+	w.Header().Set("Retry-After", "1")
+	writeError(w, http.StatusTooManyRequests, "concurrency_limited", "too many requests to this tool are in flight")
+}
+
+// needsBufferedBody reports whether authType must see the whole request body
+// before the upstream request is sent.
+func needsBufferedBody(authType string) bool {
+	switch authType {
+	case "oauth2_client_credentials", "aws_sigv4", "hmac":
+		return true
 	}
+	return false
+}
 
-	success := resp.StatusCode >= 200 && resp.StatusCode < 300
-	h.recordTransaction(agent.ID, tool, r, resp.StatusCode, latency, requestSize, responseSize, success, reportedCostHeader)
+// bufferedBody returns a copy of the outbound body for signing. Requests
+// without a replayable body are treated as empty.
+func bufferedBody(outReq *http.Request) ([]byte, error) {
+	if outReq.GetBody == nil {
+		return nil, nil
+	}
+	rc, err := outReq.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// statusClientClosedRequest is the non-standard status (from nginx) recorded
+// when the client goes away before a response could be written.
+const statusClientClosedRequest = 499
+
+// writeAuthError reports that the tool's upstream credentials could not be
+// obtained. Only OAuth2 token endpoint failures count as token fetch errors,
+// and a client that cancelled its request gets no body.
+func (h *Handler) writeAuthError(w http.ResponseWriter, tool *registry.Tool, err error) {
+	if errors.Is(err, context.Canceled) {
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	if h.metrics != nil && tool.AuthType == "oauth2_client_credentials" {
+		h.metrics.IncTokenFetchError(tool.ID, tool.Name)
+	}
+	writeError(w, http.StatusBadGateway, "upstream_auth_failed", "failed to obtain upstream credentials")
 }
 
-func (h *Handler) recordTransaction(agentID string, tool *registry.Tool, r *http.Request, statusCode int, latency time.Duration, requestSize int64, responseSize int64, success bool, reportedCostHeader string) {
+// retryWithFreshToken replays req once with a newly fetched token when an
+// OAuth2 tool's upstream rejects the cached one with 401. resp is returned
+// unchanged when no retry applies or a fresh token cannot be obtained.
+func (h *Handler) retryWithFreshToken(client *http.Client, req *http.Request, resp *http.Response, tool *registry.Tool) (*http.Response, error) {
+	if tool.AuthType != "oauth2_client_credentials" || resp.StatusCode != http.StatusUnauthorized || req.GetBody == nil {
+		return resp, nil
+	}
+	h.tokens.Invalidate(tool.ID, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+
+	retry := req.Clone(req.Context())
+	if err := h.injectAuth(req.Context(), retry, tool); err != nil {
+		return resp, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return resp, nil
+	}
+	retry.Body = body
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return client.Do(retry)
+}
+
+// isStreamingResponse reports whether the upstream response should be relayed
+// incrementally: server-sent events, or a chunked body of unknown length.
+func isStreamingResponse(resp *http.Response) bool {
+	if strings.HasPrefix(strings.ToLower(resp.Header.Get("Content-Type")), "text/event-stream") {
+		return true
+	}
+	if resp.ContentLength < 0 {
+		for _, te := range resp.TransferEncoding {
+			if te == "chunked" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// copyBody copies src to w. When flush is set, every chunk is flushed to the
+// client as soon as it is written. onRead is called after each non-empty read.
+// It returns the bytes written, the time the first byte arrived, and any read
+// (upstream) or write (client) error.
+func copyBody(w io.Writer, rc *http.ResponseController, src io.Reader, flush bool, onRead func()) (written int64, firstByte time.Time, readErr, writeErr error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if firstByte.IsZero() {
+				firstByte = time.Now()
+			}
+			onRead()
+			nw, werr := w.Write(buf[:n])
+			written += int64(nw)
+			if werr != nil {
+				return written, firstByte, nil, werr
+			}
+			if flush {
+				if ferr := rc.Flush(); ferr != nil && !errors.Is(ferr, http.ErrNotSupported) {
+					return written, firstByte, nil, ferr
+				}
+			}
+		}
+		if err == io.EOF {
+			return written, firstByte, nil, nil
+		}
+		if err != nil {
+			return written, firstByte, err, nil
+		}
+	}
+}
+
+// recordTransaction fills in the tool, request and cost fields of tx and
+// hands it to the collector.
+func (h *Handler) recordTransaction(tool *registry.Tool, r *http.Request, tx metering.Transaction, reportedCostHeader string) {
 	cost := 0.0
 	costSource := "flat"
 
-	if reportedCostHeader != "" {
+	if tx.CostSource == "cache" {
+		// Served from the response cache without calling the upstream.
+		costSource = tx.CostSource
+	} else if reportedCostHeader != "" {
 		if parsed, err := strconv.ParseFloat(reportedCostHeader, 64); err == nil && parsed >= 0 {
 			cost = parsed
 			costSource = "reported"
@@ -282,20 +825,14 @@ func (h *Handler) recordTransaction(agentID string, tool *registry.Tool, r *http
 		cost = tool.PricingAmount
 	}
 
-	h.collector.Record(metering.Transaction{
-		AgentID:      agentID,
-		ToolID:       tool.ID,
-		Timestamp:    time.Now().UTC(),
-		Method:       r.Method,
-		Path:         r.URL.Path,
-		StatusCode:   statusCode,
-		LatencyMs:    latency.Milliseconds(),
-		RequestSize:  requestSize,
-		ResponseSize: responseSize,
-		Success:      success,
-		Cost:         cost,
-		CostSource:   costSource,
-	})
+	tx.ToolID = tool.ID
+	tx.Timestamp = time.Now().UTC()
+	tx.Method = r.Method
+	tx.Path = r.URL.Path
+	tx.Cost = cost
+	tx.CostSource = costSource
+	tx.ReservationID = h.claimBudget(r)
+	h.collector.Record(tx)
 }
 
 type proxyError struct {
@@ -4,14 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/alecgard/octroi/internal/agent"
 	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/config"
+	"github.com/alecgard/octroi/internal/egress"
 	"github.com/alecgard/octroi/internal/metering"
 	"github.com/alecgard/octroi/internal/registry"
 	"github.com/go-chi/chi/v5"
@@ -32,16 +38,37 @@ func (f *fakeToolStore) GetByID(_ context.Context, id string) (*registry.Tool, e
 }
 
 type fakeBudgetChecker struct {
-	agentAllowed bool
+	agentAllowed  bool
 	globalAllowed bool
+	exceeded      string              // returned by Reserve if set
+	reservationID string              // returned by Reserve when both budgets allow
+	budgets       []agent.BudgetUsage // returned by Reserve when both budgets allow
+
+	mu        sync.Mutex
+	estimates []float64
+	released  []string
 }
 
-func (f *fakeBudgetChecker) CheckBudget(_ context.Context, _, _ string) (bool, float64, float64, error) {
-	return f.agentAllowed, 100, 1000, nil
+func (f *fakeBudgetChecker) Reserve(_ context.Context, _, _ string, estimate float64) (agent.Reservation, error) {
+	switch {
+	case f.exceeded != "":
+		return agent.Reservation{Exceeded: f.exceeded}, nil
+	case !f.agentAllowed:
+		return agent.Reservation{Exceeded: "agent"}, nil
+	case !f.globalAllowed:
+		return agent.Reservation{Exceeded: "global"}, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.estimates = append(f.estimates, estimate)
+	return agent.Reservation{ID: f.reservationID, Budgets: f.budgets}, nil
 }
 
-func (f *fakeBudgetChecker) CheckToolGlobalBudget(_ context.Context, _ string) (bool, float64, error) {
-	return f.globalAllowed, 500, nil
+func (f *fakeBudgetChecker) Release(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.released = append(f.released, id)
+	return nil
 }
 
 type fakeCollector struct {
@@ -52,6 +79,15 @@ func (f *fakeCollector) Record(tx metering.Transaction) {
 	f.transactions = append(f.transactions, tx)
 }
 
+type fakeAccessChecker struct {
+	allowed map[string]bool // agent ID -> allowed
+	err     error
+}
+
+func (f *fakeAccessChecker) CheckToolAccess(_ context.Context, _, _, agentID string) (bool, error) {
+	return f.allowed[agentID], f.err
+}
+
 // --- Helpers ---
 
 func newTestAgent() *auth.Agent {
@@ -207,6 +243,172 @@ func TestBudgetExceeded(t *testing.T) {
 			t.Fatalf("expected 403, got %d", rr.Code)
 		}
 	})
+
+	for _, tt := range []struct {
+		exceeded string
+		message  string
+	}{
+		{"agent_total", "agent budget exceeded across all tools"},
+		{"team", "team budget exceeded"},
+	} {
+		t.Run(tt.exceeded+" budget exceeded", func(t *testing.T) {
+			budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true, exceeded: tt.exceeded}
+			router := setupRouter(NewHandler(store, budgets, collector, 5*time.Second, 1<<20))
+
+			req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/test", nil), newTestAgent())
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d", rr.Code)
+			}
+			var errResp proxyError
+			_ = json.NewDecoder(rr.Body).Decode(&errResp)
+			if errResp.Error.Code != "budget_exceeded" || errResp.Error.Message != tt.message {
+				t.Errorf("expected budget_exceeded with %q, got %+v", tt.message, errResp.Error)
+			}
+		})
+	}
+}
+
+func TestBudgetHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": newTestTool(upstream.URL)}}
+
+	tests := []struct {
+		name          string
+		budgets       []agent.BudgetUsage
+		wantRemaining string
+		wantWarning   string
+	}{
+		{name: "no budgets"},
+		{
+			name: "below thresholds",
+			budgets: []agent.BudgetUsage{
+				{Kind: "agent", Window: "daily", Limit: 10, Spent: 2},
+				{Kind: "global", Window: "monthly", Limit: 100, Spent: 40},
+			},
+			wantRemaining: "8",
+		},
+		{
+			name: "closest to its limit warns",
+			budgets: []agent.BudgetUsage{
+				{Kind: "agent", Window: "daily", Limit: 10, Spent: 5.5, Threshold: 50},
+				{Kind: "team", Window: "monthly", Limit: 50, Spent: 41.5, Threshold: 80},
+				{Kind: "global", Window: "daily", Limit: 100, Spent: 10},
+			},
+			wantRemaining: "4.5",
+			wantWarning:   "budget=team; window=monthly; used=83%; threshold=80%",
+		},
+		{
+			name: "never below zero",
+			budgets: []agent.BudgetUsage{
+				{Kind: "agent_total", Window: "daily", Limit: 1, Spent: 1.25, Threshold: 95},
+			},
+			wantRemaining: "0",
+			wantWarning:   "budget=agent_total; window=daily; used=125%; threshold=95%",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true, reservationID: "res-1", budgets: tt.budgets}
+			router := setupRouter(NewHandler(store, budgets, &fakeCollector{}, 5*time.Second, 1<<20))
+
+			req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/test", nil), newTestAgent())
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", rr.Code)
+			}
+			if got := rr.Header().Get("X-Octroi-Budget-Remaining"); got != tt.wantRemaining {
+				t.Errorf("remaining: got %q, want %q", got, tt.wantRemaining)
+			}
+			if got := rr.Header().Get("X-Octroi-Budget-Warning"); got != tt.wantWarning {
+				t.Errorf("warning: got %q, want %q", got, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestBudgetReservation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/reported" {
+			w.Header().Set("X-Octroi-Cost", "0.25")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.CostEstimate = 0.5
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+
+	t.Run("claimed by the transaction with the reported cost", func(t *testing.T) {
+		budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true, reservationID: "res-1"}
+		collector := &fakeCollector{}
+		router := setupRouter(NewHandler(store, budgets, collector, 5*time.Second, 1<<20))
+
+		req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/reported", nil), newTestAgent())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+		if len(budgets.estimates) != 1 || budgets.estimates[0] != 0.5 {
+			t.Errorf("expected the tool's estimate reserved, got %v", budgets.estimates)
+		}
+		if len(budgets.released) != 0 {
+			t.Errorf("expected nothing released, got %v", budgets.released)
+		}
+		if len(collector.transactions) != 1 || collector.transactions[0].ReservationID != "res-1" || collector.transactions[0].Cost != 0.25 {
+			t.Errorf("expected the transaction to carry the reservation at 0.25, got %+v", collector.transactions)
+		}
+	})
+
+	t.Run("released when the request is not metered", func(t *testing.T) {
+		budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true, reservationID: "res-2"}
+		handler := NewHandler(store, budgets, &fakeCollector{}, 5*time.Second, 1<<20)
+		// The default policy denies the loopback upstream.
+		policy, err := egress.New(config.EgressConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.SetEgressPolicy(policy)
+		router := setupRouter(handler)
+
+		req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/test", nil), newTestAgent())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", rr.Code)
+		}
+
+		if len(budgets.released) != 1 || budgets.released[0] != "res-2" {
+			t.Errorf("expected reservation released, got %v", budgets.released)
+		}
+	})
+
+	t.Run("flat price is the default estimate", func(t *testing.T) {
+		flat := newTestTool(upstream.URL)
+		budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+		router := setupRouter(NewHandler(&fakeToolStore{tools: map[string]*registry.Tool{"tool-1": flat}}, budgets, &fakeCollector{}, 5*time.Second, 1<<20))
+
+		req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/test", nil), newTestAgent())
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		if len(budgets.estimates) != 1 || budgets.estimates[0] != 0.01 {
+			t.Errorf("expected the flat price reserved, got %v", budgets.estimates)
+		}
+		if len(budgets.released) != 0 {
+			t.Errorf("expected no reservation to release, got %v", budgets.released)
+		}
+	})
 }
 
 func TestUpstreamError(t *testing.T) {
@@ -310,6 +512,43 @@ func TestAuthInjectionHeader(t *testing.T) {
 	}
 }
 
+func TestAuthInjectionBasic(t *testing.T) {
+	var gotUser, gotPass string
+	var gotOK bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotPass, gotOK = r.BasicAuth()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.AuthType = "basic"
+	tool.AuthConfig = map[string]string{
+		"username": "svc-user",
+		"password": "p@ss:word",
+	}
+
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &fakeCollector{}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+
+	router := setupRouter(handler)
+
+	req := httptest.NewRequest("GET", "/proxy/tool-1/resource", nil)
+	req.Header.Set("Authorization", "Bearer client-token")
+	req = withAgent(req, newTestAgent())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if !gotOK || gotUser != "svc-user" || gotPass != "p@ss:word" {
+		t.Errorf("expected basic credentials svc-user/p@ss:word, got %q/%q (ok=%v)", gotUser, gotPass, gotOK)
+	}
+}
+
 func TestAPIMode(t *testing.T) {
 	var receivedPath string
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -554,3 +793,330 @@ func TestQueryAuth(t *testing.T) {
 		}
 	})
 }
+
+func TestToolAccessForbidden(t *testing.T) {
+	var upstreamCalls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &fakeCollector{}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+	handler.SetToolAccessChecker(&fakeAccessChecker{allowed: map[string]bool{"agent-2": true}})
+	router := setupRouter(handler)
+
+	t.Run("denied agent", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/proxy/tool-1/resource", nil)
+		req = withAgent(req, newTestAgent())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", rr.Code)
+		}
+		var errResp proxyError
+		_ = json.NewDecoder(rr.Body).Decode(&errResp)
+		if errResp.Error.Code != "tool_forbidden" {
+			t.Errorf("expected error code tool_forbidden, got %s", errResp.Error.Code)
+		}
+		if upstreamCalls != 0 {
+			t.Errorf("expected no upstream calls, got %d", upstreamCalls)
+		}
+		if len(collector.transactions) != 0 {
+			t.Errorf("expected no transactions, got %d", len(collector.transactions))
+		}
+	})
+
+	t.Run("allowed agent", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/proxy/tool-1/resource", nil)
+		req = withAgent(req, &auth.Agent{ID: "agent-2", Name: "granted"})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+		if upstreamCalls != 1 {
+			t.Errorf("expected 1 upstream call, got %d", upstreamCalls)
+		}
+	})
+
+	t.Run("check fails closed", func(t *testing.T) {
+		handler.SetToolAccessChecker(&fakeAccessChecker{
+			allowed: map[string]bool{"agent-2": true},
+			err:     errors.New("connection refused"),
+		})
+		req := httptest.NewRequest("GET", "/proxy/tool-1/resource", nil)
+		req = withAgent(req, &auth.Agent{ID: "agent-2", Name: "granted"})
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503, got %d", rr.Code)
+		}
+		var errResp proxyError
+		_ = json.NewDecoder(rr.Body).Decode(&errResp)
+		if errResp.Error.Code != "access_check_failed" {
+			t.Errorf("expected error code access_check_failed, got %s", errResp.Error.Code)
+		}
+		if upstreamCalls != 1 {
+			t.Errorf("expected no further upstream calls, got %d", upstreamCalls)
+		}
+	})
+}
+
+type fakeConcurrencyLimiter struct {
+	allowed  bool
+	released int
+}
+
+func (f *fakeConcurrencyLimiter) Acquire(context.Context, string, string, string) (func(), bool, error) {
+	if !f.allowed {
+		return nil, false, nil
+	}
+	return func() { f.released++ }, true, nil
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	var upstreamCalls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": newTestTool(upstream.URL)}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	limiter := &fakeConcurrencyLimiter{}
+	handler := NewHandler(store, budgets, &fakeCollector{}, 5*time.Second, 1<<20)
+	handler.SetConcurrencyLimiter(limiter)
+	router := setupRouter(handler)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, withAgent(httptest.NewRequest("GET", "/proxy/tool-1/resource", nil), newTestAgent()))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	var errResp proxyError
+	_ = json.NewDecoder(rr.Body).Decode(&errResp)
+	if errResp.Error.Code != "concurrency_limited" || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected concurrency_limited with Retry-After, got %s", errResp.Error.Code)
+	}
+	if upstreamCalls != 0 {
+		t.Errorf("expected no upstream calls, got %d", upstreamCalls)
+	}
+
+	limiter.allowed = true
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, withAgent(httptest.NewRequest("GET", "/proxy/tool-1/resource", nil), newTestAgent()))
+	if rr.Code != http.StatusOK || limiter.released != 1 {
+		t.Errorf("expected slot released after the request, got %d and %d releases", rr.Code, limiter.released)
+	}
+}
+
+func TestEgressPolicy(t *testing.T) {
+	var upstreamCalls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://"+strings.Replace(r.Host, "127.0.0.1", "localhost", 1)+"/data", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+
+	do := func(t *testing.T, cfg config.EgressConfig, path string) (*httptest.ResponseRecorder, *fakeCollector) {
+		t.Helper()
+		policy, err := egress.New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		collector := &fakeCollector{}
+		handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+		handler.SetEgressPolicy(policy)
+		req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1"+path, nil), newTestAgent())
+		rr := httptest.NewRecorder()
+		setupRouter(handler).ServeHTTP(rr, req)
+		return rr, collector
+	}
+
+	t.Run("loopback upstream denied", func(t *testing.T) {
+		rr, collector := do(t, config.EgressConfig{}, "/data")
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", rr.Code)
+		}
+		var errResp proxyError
+		_ = json.NewDecoder(rr.Body).Decode(&errResp)
+		if errResp.Error.Code != "egress_denied" {
+			t.Errorf("expected error code egress_denied, got %s", errResp.Error.Code)
+		}
+		if upstreamCalls != 0 {
+			t.Errorf("expected no upstream calls, got %d", upstreamCalls)
+		}
+		if len(collector.transactions) != 0 {
+			t.Errorf("expected no transactions, got %d", len(collector.transactions))
+		}
+	})
+
+	t.Run("allowed cidr", func(t *testing.T) {
+		rr, _ := do(t, config.EgressConfig{AllowedCIDRs: []string{"127.0.0.0/8"}}, "/data")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rr.Code)
+		}
+	})
+
+	t.Run("redirect to denied host", func(t *testing.T) {
+		upstreamCalls = 0
+		rr, _ := do(t, config.EgressConfig{
+			AllowedCIDRs: []string{"127.0.0.0/8"},
+			DeniedHosts:  []string{"localhost"},
+		}, "/redirect")
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", rr.Code)
+		}
+		if upstreamCalls != 1 {
+			t.Errorf("expected only the redirecting call upstream, got %d", upstreamCalls)
+		}
+	})
+}
+
+// chanCollector delivers recorded transactions on a channel so tests driving a
+// real server can wait for the handler to finish metering.
+type chanCollector struct {
+	ch chan metering.Transaction
+}
+
+func (c *chanCollector) Record(tx metering.Transaction) {
+	c.ch <- tx
+}
+
+// newAgentServer serves the proxy handler on a real listener, injecting the
+// test agent so streaming and flushing behave as in production.
+func newAgentServer(handler *Handler) *httptest.Server {
+	router := setupRouter(handler)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, withAgent(r, newTestAgent()))
+	}))
+}
+
+func TestStreamingFlushesChunks(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		// Outlast the proxy's total timeout; only the idle timeout applies.
+		time.Sleep(150 * time.Millisecond)
+		_, _ = io.WriteString(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &chanCollector{ch: make(chan metering.Transaction, 1)}
+	handler := NewHandler(store, budgets, collector, 100*time.Millisecond, 1<<20)
+	handler.SetStreamIdleTimeout(2 * time.Second)
+
+	srv := newAgentServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/proxy/tool-1/events")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first event must arrive while the upstream is still blocked.
+	buf := make([]byte, len("data: first\n\n"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatalf("reading first event: %v", err)
+	}
+	if string(buf) != "data: first\n\n" {
+		t.Fatalf("unexpected first event %q", buf)
+	}
+	close(release)
+
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading rest of stream: %v", err)
+	}
+	if string(rest) != "data: second\n\n" {
+		t.Errorf("unexpected remaining stream %q", rest)
+	}
+
+	select {
+	case tx := <-collector.ch:
+		if !tx.Streamed || !tx.Success || tx.Error != "" {
+			t.Errorf("unexpected transaction: %+v", tx)
+		}
+		if tx.ResponseSize != int64(len("data: first\n\ndata: second\n\n")) {
+			t.Errorf("expected full stream size, got %d", tx.ResponseSize)
+		}
+		if tx.DurationMs < 150 || tx.TTFBMs > tx.DurationMs {
+			t.Errorf("unexpected timings: ttfb=%d duration=%d", tx.TTFBMs, tx.DurationMs)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction was not recorded")
+	}
+}
+
+func TestStreamingIdleTimeout(t *testing.T) {
+	done := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, "data: only\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(done)
+
+	tool := newTestTool(upstream.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &chanCollector{ch: make(chan metering.Transaction, 1)}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+	handler.SetStreamIdleTimeout(100 * time.Millisecond)
+
+	srv := newAgentServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/proxy/tool-1/events")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "data: only\n\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	select {
+	case tx := <-collector.ch:
+		if tx.Error != "timeout" || tx.Success {
+			t.Errorf("expected failed transaction with timeout error, got %+v", tx)
+		}
+		if tx.ResponseSize != int64(len(body)) {
+			t.Errorf("expected response size %d, got %d", len(body), tx.ResponseSize)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction was not recorded")
+	}
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alecgard/octroi/internal/egress"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// Defaults for retry_policy fields a tool leaves unset.
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 2 * time.Second
+	defaultRetryBodyBytes = 1 << 20
+)
+
+var (
+	defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	defaultRetryErrors   = []string{"connection_refused", "network"}
+)
+
+// idempotentMethods may be retried without an Idempotency-Key header.
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// retryPolicy is a tool's registry.RetryPolicy with defaults applied.
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	statuses       map[int]bool
+	errors         map[string]bool
+	maxBodyBytes   int64
+}
+
+func newRetryPolicy(p registry.RetryPolicy) retryPolicy {
+	rp := retryPolicy{
+		maxAttempts:    p.MaxAttempts,
+		initialBackoff: time.Duration(p.InitialBackoffMs) * time.Millisecond,
+		maxBackoff:     time.Duration(p.MaxBackoffMs) * time.Millisecond,
+		statuses:       make(map[int]bool),
+		errors:         make(map[string]bool),
+		maxBodyBytes:   p.MaxBodyBytes,
+	}
+	if rp.initialBackoff == 0 {
+		rp.initialBackoff = defaultInitialBackoff
+	}
+	if rp.maxBackoff == 0 {
+		rp.maxBackoff = max(defaultMaxBackoff, rp.initialBackoff)
+	}
+	if rp.maxBodyBytes == 0 {
+		rp.maxBodyBytes = defaultRetryBodyBytes
+	}
+	statuses := p.RetryOnStatus
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	for _, code := range statuses {
+		rp.statuses[code] = true
+	}
+	classes := p.RetryOnErrors
+	if len(classes) == 0 {
+		classes = defaultRetryErrors
+	}
+	for _, class := range classes {
+		rp.errors[class] = true
+	}
+	return rp
+}
+
+// enabled reports whether the policy allows more than one attempt.
+func (p retryPolicy) enabled() bool {
+	return p.maxAttempts > 1
+}
+
+// canRetry reports whether r is safe to replay: its method is idempotent or
+// the agent supplied an Idempotency-Key for the upstream to deduplicate on.
+func canRetry(r *http.Request) bool {
+	return idempotentMethods[r.Method] || r.Header.Get("Idempotency-Key") != ""
+}
+
+// backoff returns the delay before retry n (1-based): exponential growth
+// capped at maxBackoff, with "equal jitter" so the wait is at least half the
+// computed delay.
+func (p retryPolicy) backoff(n int) time.Duration {
+	d := p.maxBackoff
+	if n-1 < 32 {
+		if exp := p.initialBackoff << (n - 1); exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// shouldRetry decides whether an attempt's outcome is retryable, returning
+// the metrics reason and any wait the upstream asked for via Retry-After.
+func (p retryPolicy) shouldRetry(resp *http.Response, err error, now time.Time) (reason string, wait time.Duration, ok bool) {
+	if err != nil {
+		if errors.Is(err, egress.ErrDenied) {
+			return "", 0, false
+		}
+		class := classifyUpstreamError(err)
+		return class, 0, p.errors[class]
+	}
+	if !p.statuses[resp.StatusCode] {
+		return "", 0, false
+	}
+	return strconv.Itoa(resp.StatusCode), parseRetryAfter(resp.Header.Get("Retry-After"), now), true
+}
+
+// parseRetryAfter parses a Retry-After value given in seconds or as an HTTP
+// date. Missing or malformed values yield zero.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// doWithRetry sends req, retrying per policy while retryable is set. A retry
+// is skipped when its wait would run past expires, in which case the last
+// response or error is returned as is. Connections that could not be
+// established fail over to the tool's next target straight away, without
+// counting against the policy; retries move round the targets. It returns the
+// number of attempts made.
+func (h *Handler) doWithRetry(client *http.Client, req *http.Request, tool *registry.Tool, rt *route, policy retryPolicy, retryable bool, expires time.Time) (*http.Response, int, error) {
+	ctx := req.Context()
+	tries := 1
+	for attempt := 1; ; attempt++ {
+		rt.begin()
+		resp, err := client.Do(req)
+		if err == nil {
+			resp, err = h.retryWithFreshToken(client, req, resp, tool)
+		}
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		rt.observe(upstreamOutcome(ctx, statusCode, err))
+
+		if isConnectError(err) && replayable(req) && rt.failover() {
+			next, nerr := h.retarget(req, rt, tool)
+			if nerr != nil {
+				return resp, attempt, err
+			}
+			req = next
+			continue
+		}
+
+		if !retryable || tries >= policy.maxAttempts {
+			return resp, attempt, err
+		}
+		reason, wait, ok := policy.shouldRetry(resp, err, time.Now())
+		if !ok {
+			return resp, attempt, err
+		}
+		wait = max(wait, policy.backoff(tries))
+		if time.Until(expires) <= wait {
+			return resp, attempt, err
+		}
+
+		rt.rotate()
+		next, nerr := h.retarget(req, rt, tool)
+		if nerr != nil {
+			return resp, attempt, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if h.metrics != nil {
+			h.metrics.IncUpstreamRetry(tool.ID, tool.Name, reason)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, attempt, ctx.Err()
+		case <-timer.C:
+		}
+		tries++
+		req = next
+	}
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+func newRetryTool(endpoint string) *registry.Tool {
+	tool := newTestTool(endpoint)
+	tool.RetryPolicy = registry.RetryPolicy{MaxAttempts: 3, InitialBackoffMs: 1, MaxBackoffMs: 5}
+	return tool
+}
+
+func TestRetryOnStatus(t *testing.T) {
+	var calls atomic.Int32
+	var bodies []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": newRetryTool(upstream.URL)}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+
+	tests := []struct {
+		name         string
+		method       string
+		idempotency  string
+		wantStatus   int
+		wantAttempts int
+	}{
+		{"idempotent method retried", "GET", "", http.StatusOK, 3},
+		{"post not retried", "POST", "", http.StatusServiceUnavailable, 1},
+		{"post with idempotency key retried", "POST", "key-1", http.StatusOK, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			bodies = nil
+			collector := &fakeCollector{}
+			handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+			req := httptest.NewRequest(tt.method, "/proxy/tool-1/data", strings.NewReader("payload"))
+			if tt.idempotency != "" {
+				req.Header.Set("Idempotency-Key", tt.idempotency)
+			}
+			rr := httptest.NewRecorder()
+			setupRouter(handler).ServeHTTP(rr, withAgent(req, newTestAgent()))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if int(calls.Load()) != tt.wantAttempts {
+				t.Errorf("expected %d upstream calls, got %d", tt.wantAttempts, calls.Load())
+			}
+			for i, b := range bodies {
+				if b != "payload" {
+					t.Errorf("attempt %d: expected body replayed, got %q", i+1, b)
+				}
+			}
+			if len(collector.transactions) != 1 {
+				t.Fatalf("expected 1 transaction, got %d", len(collector.transactions))
+			}
+			if got := collector.transactions[0].Attempts; got != tt.wantAttempts {
+				t.Errorf("expected transaction attempts %d, got %d", tt.wantAttempts, got)
+			}
+		})
+	}
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": newRetryTool(upstream.URL)}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	// The upstream asks for a wait longer than the proxy timeout, so the 503
+	// is passed straight through instead of being retried.
+	handler := NewHandler(store, budgets, &fakeCollector{}, time.Second, 1<<20)
+	rr := httptest.NewRecorder()
+	setupRouter(handler).ServeHTTP(rr, withAgent(httptest.NewRequest("GET", "/proxy/tool-1/data", nil), newTestAgent()))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rr.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls.Load())
+	}
+	if rr.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected Retry-After passed through, got %q", rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestRetryOnConnectionError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := "http://" + ln.Addr().String()
+	ln.Close()
+
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": newRetryTool(endpoint)}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &fakeCollector{}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+	rr := httptest.NewRecorder()
+	setupRouter(handler).ServeHTTP(rr, withAgent(httptest.NewRequest("GET", "/proxy/tool-1/data", nil), newTestAgent()))
+
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rr.Code)
+	}
+	if len(collector.transactions) != 1 {
+		t.Fatalf("expected 1 transaction, got %d", len(collector.transactions))
+	}
+	tx := collector.transactions[0]
+	if tx.Attempts != 3 || tx.Error != "connection_refused" {
+		t.Errorf("expected 3 attempts with connection_refused, got %d %q", tx.Attempts, tx.Error)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := newRetryPolicy(registry.RetryPolicy{MaxAttempts: 5, InitialBackoffMs: 100, MaxBackoffMs: 400})
+	for n, ceiling := range map[int]time.Duration{1: 100, 2: 200, 3: 400, 4: 400, 40: 400} {
+		ceiling *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			d := p.backoff(n)
+			if d < ceiling/2 || d > ceiling {
+				t.Fatalf("backoff(%d) = %v, want within [%v, %v]", n, d, ceiling/2, ceiling)
+			}
+		}
+	}
+}
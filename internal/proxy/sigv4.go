@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+)
+
+// signSigV4 signs req in place with AWS Signature Version 4 using the
+// credentials in cfg (access_key_id, secret_access_key, optional
+// session_token, region, service). body must be the exact payload that will
+// be sent. The host, content-type and all x-amz-* headers are signed; other
+// headers are left out so intermediaries may rewrite them freely.
+func signSigV4(req *http.Request, body []byte, cfg map[string]string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	date := now.Format(sigV4DateFormat)
+	region, service := cfg["region"], cfg["service"]
+
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if token := cfg["session_token"]; token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	canonicalHeaders, signedHeaders := sigV4Headers(req.Header, host)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4CanonicalURI(req.URL, service),
+		sigV4CanonicalQuery(req.URL),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+cfg["secret_access_key"]), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, cfg["access_key_id"], scope, signedHeaders, signature))
+}
+
+// sigV4Headers builds the canonical header block and signed header list.
+func sigV4Headers(h http.Header, host string) (canonical, signed string) {
+	values := map[string]string{"host": host}
+	for key, vals := range h {
+		lower := strings.ToLower(key)
+		if lower != "content-type" && !strings.HasPrefix(lower, "x-amz-") {
+			continue
+		}
+		trimmed := make([]string, len(vals))
+		for i, v := range vals {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		values[lower] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(values[name])
+		b.WriteByte('\n')
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// sigV4CanonicalURI URI-encodes each path segment. Every service except S3
+// expects the segments encoded twice.
+func sigV4CanonicalURI(u *url.URL, service string) string {
+	path := u.Path
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		s = sigV4Escape(s)
+		if service != "s3" {
+			s = sigV4Escape(s)
+		}
+		segments[i] = s
+	}
+	return strings.Join(segments, "/")
+}
+
+// sigV4CanonicalQuery sorts and re-encodes the query string.
+func sigV4CanonicalQuery(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+	query, _ := url.ParseQuery(u.RawQuery)
+	escaped := make(map[string][]string, len(query))
+	keys := make([]string, 0, len(query))
+	for key, vals := range query {
+		ek := sigV4Escape(key)
+		keys = append(keys, ek)
+		for _, v := range vals {
+			escaped[ek] = append(escaped[ek], sigV4Escape(v))
+		}
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		vals := escaped[key]
+		sort.Strings(vals)
+		for _, v := range vals {
+			pairs = append(pairs, key+"="+v)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything except RFC 3986 unreserved characters.
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// Credentials and timestamp from the AWS SigV4 test suite.
+var sigV4TestConfig = map[string]string{
+	"access_key_id":     "AKIDEXAMPLE",
+	"secret_access_key": "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	"region":            "us-east-1",
+	"service":           "service",
+}
+
+var sigV4TestTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func TestSignSigV4Vectors(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		headers     map[string]string
+		body        string
+		cfg         map[string]string
+		wantSigned  string
+		wantSigHash string
+	}{
+		{
+			name:        "get-vanilla",
+			method:      "GET",
+			url:         "https://example.amazonaws.com/",
+			cfg:         sigV4TestConfig,
+			wantSigned:  "host;x-amz-date",
+			wantSigHash: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:        "post-vanilla",
+			method:      "POST",
+			url:         "https://example.amazonaws.com/",
+			cfg:         sigV4TestConfig,
+			wantSigned:  "host;x-amz-date",
+			wantSigHash: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:        "get-vanilla-query-order-key-case",
+			method:      "GET",
+			url:         "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			cfg:         sigV4TestConfig,
+			wantSigned:  "host;x-amz-date",
+			wantSigHash: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:    "iam-list-users",
+			method:  "GET",
+			url:     "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			cfg: map[string]string{
+				"access_key_id":     "AKIDEXAMPLE",
+				"secret_access_key": "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+				"region":            "us-east-1",
+				"service":           "iam",
+			},
+			wantSigned:  "content-type;host;x-amz-date",
+			wantSigHash: "5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			signSigV4(req, []byte(tt.body), tt.cfg, sigV4TestTime)
+
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q", got)
+			}
+			authz := req.Header.Get("Authorization")
+			wantPrefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/" + tt.cfg["service"] + "/aws4_request, "
+			if !strings.HasPrefix(authz, wantPrefix) {
+				t.Errorf("Authorization = %q, want prefix %q", authz, wantPrefix)
+			}
+			if !strings.Contains(authz, "SignedHeaders="+tt.wantSigned+",") {
+				t.Errorf("Authorization = %q, want SignedHeaders=%s", authz, tt.wantSigned)
+			}
+			if !strings.HasSuffix(authz, "Signature="+tt.wantSigHash) {
+				t.Errorf("Authorization = %q, want Signature=%s", authz, tt.wantSigHash)
+			}
+		})
+	}
+}
+
+func TestSignSigV4SessionTokenAndS3(t *testing.T) {
+	cfg := map[string]string{
+		"access_key_id":     "AKIDEXAMPLE",
+		"secret_access_key": "secret",
+		"session_token":     "session",
+		"region":            "eu-west-1",
+		"service":           "s3",
+	}
+	req, _ := http.NewRequest("PUT", "https://bucket.s3.amazonaws.com/my%20key", nil)
+	signSigV4(req, []byte("hello"), cfg, sigV4TestTime)
+
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "session" {
+		t.Errorf("X-Amz-Security-Token = %q", got)
+	}
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != sha256Hex([]byte("hello")) {
+		t.Errorf("X-Amz-Content-Sha256 = %q", got)
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,") {
+		t.Errorf("unexpected signed headers in %q", req.Header.Get("Authorization"))
+	}
+	if got := sigV4CanonicalURI(req.URL, "s3"); got != "/my%20key" {
+		t.Errorf("s3 canonical URI = %q", got)
+	}
+	if got := sigV4CanonicalURI(req.URL, "execute-api"); got != "/my%2520key" {
+		t.Errorf("double-encoded canonical URI = %q", got)
+	}
+}
+
+func TestProxySignsSigV4Body(t *testing.T) {
+	reqBody := bytes.Repeat([]byte("x"), 64<<10)
+
+	var gotAuth, gotAmzDate string
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotAmzDate = r.Header.Get("X-Amz-Date")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.AuthType = "aws_sigv4"
+	tool.AuthConfig = sigV4TestConfig
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	handler := NewHandler(store, budgets, &fakeCollector{}, 5*time.Second, 1<<20)
+	router := setupRouter(handler)
+
+	req := httptest.NewRequest("POST", "/proxy/tool-1/invoke", bytes.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	req = withAgent(req, newTestAgent())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if !bytes.Equal(gotBody, reqBody) {
+		t.Errorf("expected the full %d-byte body upstream, got %d bytes", len(reqBody), len(gotBody))
+	}
+	if gotAmzDate == "" || !strings.Contains(gotAuth, "SignedHeaders=content-type;host;x-amz-date,") {
+		t.Errorf("expected a SigV4 signature, got %q", gotAuth)
+	}
+
+	// Re-sign an identical request at the upstream's X-Amz-Date to check
+	// that the body hash was part of the signature.
+	when, _ := time.Parse(sigV4TimeFormat, gotAmzDate)
+	check, _ := http.NewRequest("POST", upstream.URL+"/invoke", nil)
+	check.Header.Set("Content-Type", "application/json")
+	signSigV4(check, reqBody, sigV4TestConfig, when)
+	if check.Header.Get("Authorization") != gotAuth {
+		t.Errorf("signature mismatch:\n got  %s\n want %s", gotAuth, check.Header.Get("Authorization"))
+	}
+}
+
+func TestProxyRejectsOversizedSignedBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("upstream should not be called")
+	}))
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.AuthType = "aws_sigv4"
+	tool.AuthConfig = sigV4TestConfig
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	handler := NewHandler(store, budgets, &fakeCollector{}, 5*time.Second, 1024)
+	router := setupRouter(handler)
+
+	req := httptest.NewRequest("POST", "/proxy/tool-1/invoke", bytes.NewReader(make([]byte, 1025)))
+	req = withAgent(req, newTestAgent())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecgard/octroi/internal/egress"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// Passive health checking: a target whose requests fail this many times in a
+// row is skipped for targetEjectDuration, unless every target is ejected.
+const (
+	targetFailureThreshold = 3
+	targetEjectDuration    = 30 * time.Second
+)
+
+// upstreamTarget is one resolved base URL a tool's requests may be sent to.
+type upstreamTarget struct {
+	base   string
+	host   string
+	weight int
+
+	outstanding atomic.Int64
+
+	// Guarded by targetPool.mu.
+	failures       int
+	unhealthyUntil time.Time
+	current        int // smooth weighted round-robin state
+}
+
+func newUpstreamTarget(base string, weight int) *upstreamTarget {
+	t := &upstreamTarget{base: strings.TrimRight(base, "/"), weight: max(weight, 1)}
+	if u, err := url.Parse(base); err == nil {
+		t.host = u.Host
+	}
+	return t
+}
+
+// toolTargets is the balancing state of one tool's targets.
+type toolTargets struct {
+	fingerprint string
+	strategy    string
+	targets     []*upstreamTarget
+	next        int
+}
+
+// targetPool keeps balancing and health state for tools with multiple
+// upstream targets.
+type targetPool struct {
+	now func() time.Time
+
+	mu    sync.Mutex
+	tools map[string]*toolTargets
+}
+
+func newTargetPool() *targetPool {
+	return &targetPool{now: time.Now, tools: make(map[string]*toolTargets)}
+}
+
+// invalidate forgets toolID's targets, e.g. after they were edited.
+func (p *targetPool) invalidate(toolID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tools, toolID)
+}
+
+// route picks the targets to try for one request to tool, in order.
+// Endpoint templates are resolved for API-mode tools.
+func (p *targetPool) route(tool *registry.Tool, path, rawQuery string) (*route, error) {
+	rt := &route{pool: p, path: path, rawQuery: rawQuery}
+	if len(tool.Targets) == 0 {
+		base, err := resolveTarget(tool, tool.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		rt.targets = []*upstreamTarget{newUpstreamTarget(base, 1)}
+		return rt, nil
+	}
+
+	bases := make([]string, len(tool.Targets))
+	var fp strings.Builder
+	fp.WriteString(tool.LBStrategy)
+	for i, t := range tool.Targets {
+		base, err := resolveTarget(tool, t.URL)
+		if err != nil {
+			return nil, err
+		}
+		bases[i] = base
+		fmt.Fprintf(&fp, "\n%s %d", base, t.Weight)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tt := p.tools[tool.ID]
+	if tt == nil || tt.fingerprint != fp.String() {
+		tt = &toolTargets{fingerprint: fp.String(), strategy: tool.LBStrategy}
+		for i, t := range tool.Targets {
+			tt.targets = append(tt.targets, newUpstreamTarget(bases[i], t.Weight))
+		}
+		p.tools[tool.ID] = tt
+	}
+	rt.targets = tt.order(p.now())
+	return rt, nil
+}
+
+func resolveTarget(tool *registry.Tool, raw string) (string, error) {
+	if tool.Mode != "api" {
+		return raw, nil
+	}
+	return registry.ResolveTemplate(raw, tool.Variables)
+}
+
+// order returns the healthy targets starting with the one the strategy picks,
+// followed by the rest as failover candidates. When every target is ejected
+// all of them are tried. The caller holds the pool's lock.
+func (t *toolTargets) order(now time.Time) []*upstreamTarget {
+	healthy := make([]*upstreamTarget, 0, len(t.targets))
+	for _, u := range t.targets {
+		if !now.Before(u.unhealthyUntil) {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = append(healthy, t.targets...)
+	}
+
+	first := t.pick(healthy)
+	order := make([]*upstreamTarget, 0, len(healthy))
+	for i := range healthy {
+		order = append(order, healthy[(first+i)%len(healthy)])
+	}
+	return order
+}
+
+// pick returns the index into healthy of the next target to use.
+func (t *toolTargets) pick(healthy []*upstreamTarget) int {
+	switch t.strategy {
+	case "weighted":
+		// Smooth weighted round-robin: targets are interleaved in
+		// proportion to their weights rather than sent in bursts.
+		total, best := 0, 0
+		for i, u := range healthy {
+			u.current += u.weight
+			total += u.weight
+			if u.current > healthy[best].current {
+				best = i
+			}
+		}
+		healthy[best].current -= total
+		return best
+	case "least_outstanding":
+		// Ties are broken round-robin so idle targets share the load.
+		start := t.next % len(healthy)
+		t.next++
+		best := start
+		for i := 1; i < len(healthy); i++ {
+			j := (start + i) % len(healthy)
+			if healthy[j].outstanding.Load() < healthy[best].outstanding.Load() {
+				best = j
+			}
+		}
+		return best
+	}
+	i := t.next % len(healthy)
+	t.next++
+	return i
+}
+
+// observe updates u's health with an attempt's outcome.
+func (p *targetPool) observe(u *upstreamTarget, o outcome) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch o {
+	case outcomeSuccess:
+		u.failures = 0
+	case outcomeFailure:
+		u.failures++
+		if u.failures >= targetFailureThreshold {
+			u.failures = 0
+			u.unhealthyUntil = p.now().Add(targetEjectDuration)
+		}
+	}
+}
+
+// route is the ordered list of targets for one proxied request.
+type route struct {
+	pool     *targetPool
+	path     string
+	rawQuery string
+	targets  []*upstreamTarget
+	pos      int
+
+	last *upstreamTarget // target of the latest attempt
+	held bool            // whether last counts as outstanding
+}
+
+// target returns the target the next attempt goes to.
+func (r *route) target() *upstreamTarget {
+	return r.targets[r.pos]
+}
+
+// url returns the upstream URL of the request on the current target.
+func (r *route) url() string {
+	u := r.target().base + r.path
+	if r.rawQuery != "" {
+		u += "?" + r.rawQuery
+	}
+	return u
+}
+
+// host returns the host of the target that served the latest attempt.
+func (r *route) host() string {
+	if r.last == nil {
+		return r.target().host
+	}
+	return r.last.host
+}
+
+// failover moves to the next untried target, reporting false when none are
+// left.
+func (r *route) failover() bool {
+	if r.pos+1 >= len(r.targets) {
+		return false
+	}
+	r.pos++
+	return true
+}
+
+// rotate moves to the next target, wrapping around, so that retries spread
+// over the tool's targets.
+func (r *route) rotate() {
+	r.pos = (r.pos + 1) % len(r.targets)
+}
+
+// begin marks an attempt on the current target as outstanding.
+func (r *route) begin() {
+	r.release()
+	r.last = r.target()
+	r.last.outstanding.Add(1)
+	r.held = true
+}
+
+// release ends the outstanding attempt, if any. It is called once the
+// response has been fully relayed.
+func (r *route) release() {
+	if r.held {
+		r.last.outstanding.Add(-1)
+		r.held = false
+	}
+}
+
+// observe records the outcome of the latest attempt against its target.
+func (r *route) observe(o outcome) {
+	if r.last != nil && len(r.targets) > 1 {
+		r.pool.observe(r.last, o)
+	}
+}
+
+// isConnectError reports whether err means the upstream connection was never
+// established, so the request can be sent elsewhere whatever its method.
+func isConnectError(err error) bool {
+	if err == nil || errors.Is(err, egress.ErrDenied) {
+		return false
+	}
+	class := classifyUpstreamError(err)
+	return class == "connection_refused" || class == "dns"
+}
+
+// replayable reports whether req's body can be sent again.
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retarget builds the next attempt of req against rt's current target. Tool
+// credentials are injected again because signatures cover the URL.
+func (h *Handler) retarget(req *http.Request, rt *route, tool *registry.Tool) (*http.Request, error) {
+	u, err := url.Parse(rt.url())
+	if err != nil {
+		return nil, err
+	}
+	next := req.Clone(req.Context())
+	next.URL = u
+	next.Host = ""
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	if err := h.injectAuth(req.Context(), next, tool); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+func newTargetTool(strategy string, targets ...registry.Target) *registry.Tool {
+	tool := newTestTool(targets[0].URL)
+	tool.Targets = targets
+	tool.LBStrategy = strategy
+	return tool
+}
+
+// picks routes n requests to tool and returns the chosen target hosts.
+func picks(t *testing.T, p *targetPool, tool *registry.Tool, n int) []string {
+	t.Helper()
+	var hosts []string
+	for i := 0; i < n; i++ {
+		rt, err := p.route(tool, "/", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, rt.target().host)
+	}
+	return hosts
+}
+
+func TestTargetStrategies(t *testing.T) {
+	p := newTargetPool()
+
+	rr := newTargetTool("round_robin",
+		registry.Target{URL: "http://a"}, registry.Target{URL: "http://b"}, registry.Target{URL: "http://c"})
+	if got := strings.Join(picks(t, p, rr, 4), ","); got != "a,b,c,a" {
+		t.Errorf("round_robin: got %s", got)
+	}
+
+	weighted := newTargetTool("weighted",
+		registry.Target{URL: "http://a", Weight: 5}, registry.Target{URL: "http://b", Weight: 1}, registry.Target{URL: "http://c", Weight: 1})
+	weighted.ID = "tool-2"
+	counts := map[string]int{}
+	for _, h := range picks(t, p, weighted, 7) {
+		counts[h]++
+	}
+	if counts["a"] != 5 || counts["b"] != 1 || counts["c"] != 1 {
+		t.Errorf("weighted: expected 5/1/1 split, got %v", counts)
+	}
+
+	least := newTargetTool("least_outstanding", registry.Target{URL: "http://a"}, registry.Target{URL: "http://b"})
+	least.ID = "tool-3"
+	busy, err := p.route(least, "/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy.begin()
+	for _, h := range picks(t, p, least, 3) {
+		if h == busy.target().host {
+			t.Fatalf("least_outstanding: picked busy target %s", h)
+		}
+	}
+	busy.release()
+}
+
+func TestTargetEjection(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := newTargetPool()
+	p.now = func() time.Time { return now }
+	tool := newTargetTool("round_robin", registry.Target{URL: "http://a"}, registry.Target{URL: "http://b"})
+
+	for i := 0; i < targetFailureThreshold; i++ {
+		rt, _ := p.route(tool, "/", "")
+		for rt.target().host != "a" {
+			rt, _ = p.route(tool, "/", "")
+		}
+		rt.begin()
+		rt.observe(outcomeFailure)
+		rt.release()
+	}
+	for _, h := range picks(t, p, tool, 4) {
+		if h != "b" {
+			t.Fatalf("expected ejected target skipped, got %s", h)
+		}
+	}
+
+	now = now.Add(targetEjectDuration)
+	if got := strings.Join(picks(t, p, tool, 2), ","); !strings.Contains(got, "a") {
+		t.Errorf("expected target back after %v, got %s", targetEjectDuration, got)
+	}
+
+	p.invalidate(tool.ID)
+	if _, ok := p.tools[tool.ID]; ok {
+		t.Error("expected invalidate to drop the tool's targets")
+	}
+}
+
+func TestTargetFailover(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := "http://" + ln.Addr().String()
+	ln.Close()
+
+	var bodies []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, r.URL.Path+" "+string(b))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tool := newTargetTool("round_robin", registry.Target{URL: down}, registry.Target{URL: upstream.URL})
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &fakeCollector{}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+
+	// POST is not retryable, but a refused connection never reached the
+	// upstream so it is still sent to the next target.
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/proxy/tool-1/data", strings.NewReader("payload"))
+	setupRouter(handler).ServeHTTP(rr, withAgent(req, newTestAgent()))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if len(bodies) != 1 || bodies[0] != "/data payload" {
+		t.Errorf("expected request replayed on the healthy target, got %v", bodies)
+	}
+	if len(collector.transactions) != 1 {
+		t.Fatalf("expected 1 transaction, got %d", len(collector.transactions))
+	}
+	tx := collector.transactions[0]
+	if want := strings.TrimPrefix(upstream.URL, "http://"); tx.Target != want {
+		t.Errorf("expected target %s, got %s", want, tx.Target)
+	}
+	if tx.Attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", tx.Attempts)
+	}
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecgard/octroi/internal/egress"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// tlsVersions maps tls_config min_version values to crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// toolTransport is a cached HTTP client for a tool with custom TLS settings.
+type toolTransport struct {
+	fingerprint string
+	tlsConfig   *tls.Config
+	transport   *http.Transport
+	client      *http.Client
+}
+
+// transportCache holds one transport per tool with custom TLS settings, so
+// client certificates and CA pools are parsed once and connections pooled.
+// Tools without TLS settings share the handler's default client.
+type transportCache struct {
+	policy *egress.Policy
+
+	mu      sync.Mutex
+	entries map[string]*toolTransport
+}
+
+func newTransportCache() *transportCache {
+	return &transportCache{entries: make(map[string]*toolTransport)}
+}
+
+// get returns the cached transport for tool, building it on first use or
+// when the tool's TLS settings no longer match the cached ones.
+func (c *transportCache) get(tool *registry.Tool) (*toolTransport, error) {
+	fp := tlsFingerprint(tool.TLSConfig)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[tool.ID]; e != nil {
+		if e.fingerprint == fp {
+			return e, nil
+		}
+		e.transport.CloseIdleConnections()
+		delete(c.entries, tool.ID)
+	}
+
+	tlsConfig, err := buildTLSConfig(tool.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	transport := newTransport(c.policy)
+	transport.TLSClientConfig = tlsConfig
+	e := &toolTransport{
+		fingerprint: fp,
+		tlsConfig:   tlsConfig,
+		transport:   transport,
+		client:      &http.Client{Transport: transport, CheckRedirect: c.policy.CheckRedirect},
+	}
+	c.entries[tool.ID] = e
+	return e, nil
+}
+
+// invalidate drops the cached transport for toolID and closes its idle
+// connections.
+func (c *transportCache) invalidate(toolID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[toolID]; e != nil {
+		e.transport.CloseIdleConnections()
+		delete(c.entries, toolID)
+	}
+}
+
+// newTransport returns a copy of the default transport whose dials, and the
+// destinations of requests sent through an environment proxy, are checked
+// against policy.
+func newTransport(policy *egress.Policy) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = policy.DialContext(&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+	transport.Proxy = policy.Proxy(transport.Proxy)
+	return transport
+}
+
+// buildTLSConfig turns a tool's tls_config into a *tls.Config.
+func buildTLSConfig(cfg map[string]string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: cfg["server_name"],
+		MinVersion: tls.VersionTLS12,
+	}
+	if v := cfg["min_version"]; v != "" {
+		version, ok := tlsVersions[v]
+		if !ok {
+			return nil, errors.New("unsupported tls min_version " + v)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if cfg["client_cert"] != "" || cfg["client_key"] != "" {
+		cert, err := tls.X509KeyPair([]byte(cfg["client_cert"]), []byte(cfg["client_key"]))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if ca := cfg["ca_bundle"]; ca != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(ca)) {
+			return nil, errors.New("no certificates found in ca_bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// tlsFingerprint identifies a tool's TLS settings.
+func tlsFingerprint(cfg map[string]string) string {
+	return strings.Join([]string{cfg["client_cert"], cfg["client_key"], cfg["ca_bundle"], cfg["server_name"], cfg["min_version"]}, "\x00")
+}
+
+// hasTLSConfig reports whether tool needs a dedicated transport.
+func hasTLSConfig(tool *registry.Tool) bool {
+	for _, v := range tool.TLSConfig {
+		if v != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// newClientCert returns a self-signed client certificate and key in PEM form.
+func newClientCert(t *testing.T, cn string) (certPEM, keyPEM string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM
+}
+
+// newMTLSServer starts a TLS upstream that requires a client certificate
+// signed by clientCA and echoes the client's common name.
+func newMTLSServer(t *testing.T, clientCA string) *httptest.Server {
+	t.Helper()
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(clientCA)) {
+		t.Fatal("bad client CA")
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client-CN", r.TLS.PeerCertificates[0].Subject.CommonName)
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	return srv
+}
+
+func serverCAPEM(srv *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+}
+
+func TestMutualTLS(t *testing.T) {
+	certPEM, keyPEM := newClientCert(t, "octroi-client")
+	upstream := newMTLSServer(t, certPEM)
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	handler := NewHandler(store, budgets, &fakeCollector{}, 5*time.Second, 1<<20)
+	router := setupRouter(handler)
+
+	do := func() *httptest.ResponseRecorder {
+		req := withAgent(httptest.NewRequest("GET", "/proxy/tool-1/data", nil), newTestAgent())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Without TLS settings the upstream's certificate is not trusted.
+	if rr := do(); rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 without tls_config, got %d", rr.Code)
+	}
+
+	tool.TLSConfig = map[string]string{
+		"client_cert": certPEM,
+		"client_key":  keyPEM,
+		"ca_bundle":   serverCAPEM(upstream),
+		"server_name": "example.com",
+		"min_version": "1.2",
+	}
+	rr := do()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 with client certificate, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("X-Client-CN"); got != "octroi-client" {
+		t.Errorf("expected client CN octroi-client, got %q", got)
+	}
+	first := handler.transports.entries["tool-1"]
+	if first == nil {
+		t.Fatal("expected a cached transport for tool-1")
+	}
+	do()
+	if handler.transports.entries["tool-1"] != first {
+		t.Error("expected the cached transport to be reused")
+	}
+
+	// Changing the settings rebuilds the transport.
+	delete(tool.TLSConfig, "client_cert")
+	delete(tool.TLSConfig, "client_key")
+	if rr := do(); rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 without a client certificate, got %d", rr.Code)
+	}
+	if handler.transports.entries["tool-1"] == first {
+		t.Error("expected a new transport after the TLS settings changed")
+	}
+
+	handler.InvalidateTool("tool-1")
+	if _, ok := handler.transports.entries["tool-1"]; ok {
+		t.Error("expected InvalidateTool to drop the cached transport")
+	}
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	if _, err := buildTLSConfig(map[string]string{"min_version": "1.4"}); err == nil {
+		t.Error("expected error for unsupported min_version")
+	}
+	if _, err := buildTLSConfig(map[string]string{"ca_bundle": "not pem"}); err == nil {
+		t.Error("expected error for an empty CA bundle")
+	}
+	cfg, err := buildTLSConfig(map[string]string{"min_version": "1.3", "server_name": "api.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 || cfg.ServerName != "api.internal" {
+		t.Errorf("unexpected config: min=%x server=%q", cfg.MinVersion, cfg.ServerName)
+	}
+}
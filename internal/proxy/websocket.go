@@ -0,0 +1,328 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alecgard/octroi/internal/auth"
+	"github.com/alecgard/octroi/internal/egress"
+	"github.com/alecgard/octroi/internal/metering"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// isWebSocketUpgrade reports whether r asks to upgrade to the WebSocket protocol.
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveWebSocket dials the upstream with the tool's credentials, completes the
+// upgrade handshake, then pipes frames in both directions until either side
+// closes. The whole session is metered as a single transaction. Targets are
+// tried in rt's order, failing over to the next when a connection can't be
+// established.
+func (h *Handler) serveWebSocket(w http.ResponseWriter, r *http.Request, tool *registry.Tool, agent *auth.Agent, rt *route, ticket *breakerTicket) {
+	var tlsConfig *tls.Config
+	if hasTLSConfig(tool) {
+		t, err := h.transports.get(tool)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, "proxy_error", "invalid tool TLS configuration")
+			return
+		}
+		tlsConfig = t.tlsConfig
+	}
+
+	start := time.Now()
+	var (
+		upstream    net.Conn
+		upstreamBuf *bufio.Reader
+		resp        *http.Response
+		err         error
+	)
+	for {
+		outReq, rerr := http.NewRequest(r.Method, rt.url(), nil)
+		if rerr != nil {
+			writeError(w, http.StatusBadGateway, "proxy_error", "failed to build upstream request")
+			return
+		}
+		for key, values := range r.Header {
+			if key == "Authorization" || key == "Host" {
+				continue
+			}
+			for _, v := range values {
+				outReq.Header.Add(key, v)
+			}
+		}
+		if err := h.egress.CheckHost(outReq.URL.Hostname()); err != nil {
+			h.writeEgressDenied(w, r, tool, agent)
+			return
+		}
+		if err := h.injectAuth(r.Context(), outReq, tool); err != nil {
+			h.writeAuthError(w, tool, err)
+			return
+		}
+
+		rt.begin()
+		upstream, upstreamBuf, resp, err = h.dialWebSocket(r.Context(), outReq, tlsConfig)
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		rt.observe(upstreamOutcome(r.Context(), statusCode, err))
+		if !isConnectError(err) || !rt.failover() {
+			break
+		}
+	}
+	latency := time.Since(start)
+
+	if h.metrics != nil {
+		h.metrics.ObserveUpstreamDuration(tool.ID, tool.Name, latency.Seconds())
+	}
+
+	if errors.Is(err, egress.ErrDenied) {
+		h.writeEgressDenied(w, r, tool, agent)
+		return
+	}
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+	ticket.done(upstreamOutcome(r.Context(), statusCode, err))
+	if err != nil {
+		if h.metrics != nil {
+			h.metrics.IncProxyRequests(tool.ID, tool.Name, agent.ID, r.Method, 502)
+			h.metrics.IncUpstreamError(classifyUpstreamError(err), tool.ID, tool.Name)
+		}
+		h.recordTransaction(tool, r, metering.Transaction{
+			AgentID:    agent.ID,
+			StatusCode: 502,
+			LatencyMs:  latency.Milliseconds(),
+			DurationMs: latency.Milliseconds(),
+			Target:     rt.host(),
+			Error:      classifyUpstreamError(err),
+		}, "")
+		writeError(w, http.StatusBadGateway, "proxy_error", "upstream websocket handshake failed")
+		return
+	}
+
+	if h.metrics != nil {
+		h.metrics.IncProxyRequests(tool.ID, tool.Name, agent.ID, r.Method, resp.StatusCode)
+	}
+
+	// The upstream refused the upgrade: relay its response as plain HTTP.
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer upstream.Close()
+		defer resp.Body.Close()
+		for key, values := range resp.Header {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		responseSize, _ := io.Copy(w, resp.Body)
+		h.recordTransaction(tool, r, metering.Transaction{
+			AgentID:      agent.ID,
+			StatusCode:   resp.StatusCode,
+			LatencyMs:    latency.Milliseconds(),
+			DurationMs:   time.Since(start).Milliseconds(),
+			ResponseSize: responseSize,
+			Target:       rt.host(),
+		}, resp.Header.Get("X-Octroi-Cost"))
+		return
+	}
+
+	client, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		upstream.Close()
+		writeError(w, http.StatusInternalServerError, "proxy_error", "websocket upgrade not supported")
+		return
+	}
+	// Hijacked connections keep the server's read/write deadlines; sessions
+	// are long-lived, so clear them.
+	_ = client.SetDeadline(time.Time{})
+
+	if err := resp.Write(client); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	var in, out frameCounter
+	var wg sync.WaitGroup
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			client.Close()
+			upstream.Close()
+		})
+	}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		_ = pipeFrames(upstream, clientBuf.Reader, &in)
+	}()
+	go func() {
+		defer wg.Done()
+		defer closeBoth()
+		_ = pipeFrames(client, upstreamBuf, &out)
+	}()
+	wg.Wait()
+
+	h.recordTransaction(tool, r, metering.Transaction{
+		AgentID:      agent.ID,
+		StatusCode:   http.StatusSwitchingProtocols,
+		LatencyMs:    latency.Milliseconds(),
+		DurationMs:   time.Since(start).Milliseconds(),
+		RequestSize:  in.bytes.Load(),
+		ResponseSize: out.bytes.Load(),
+		MessagesIn:   in.messages.Load(),
+		MessagesOut:  out.messages.Load(),
+		Success:      true,
+		Streamed:     true,
+		Target:       rt.host(),
+	}, resp.Header.Get("X-Octroi-Cost"))
+}
+
+// dialWebSocket opens a connection to the upstream named by req.URL (TLS for
+// https/wss, using tlsConfig when non-nil), writes the upgrade request and
+// reads the handshake response. The dial is abandoned if ctx, the client's
+// request context, is cancelled first.
+func (h *Handler) dialWebSocket(ctx context.Context, req *http.Request, tlsConfig *tls.Config) (net.Conn, *bufio.Reader, *http.Response, error) {
+	host := req.URL.Host
+	useTLS := req.URL.Scheme == "https" || req.URL.Scheme == "wss"
+	if req.URL.Port() == "" {
+		if useTLS {
+			host = net.JoinHostPort(req.URL.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(req.URL.Hostname(), "80")
+		}
+	}
+	switch req.URL.Scheme {
+	case "ws":
+		req.URL.Scheme = "http"
+	case "wss":
+		req.URL.Scheme = "https"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	dial := h.egress.DialContext(&net.Dialer{})
+	conn, err := dial(ctx, "tcp", host)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Bound the handshake by the proxy timeout; the session itself is not.
+	_ = conn.SetDeadline(time.Now().Add(h.timeout))
+	if useTLS {
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = req.URL.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, nil, err
+		}
+		conn = tlsConn
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, br, resp, nil
+}
+
+// frameCounter accumulates the bytes and complete data messages seen in one
+// direction of a WebSocket session.
+type frameCounter struct {
+	bytes    atomic.Int64
+	messages atomic.Int64
+}
+
+// pipeFrames copies WebSocket frames from src to dst, counting bytes and
+// finished data messages (control frames are not counted as messages). It
+// returns when src is exhausted or either side fails.
+func pipeFrames(dst io.Writer, src io.Reader, counter *frameCounter) error {
+	header := make([]byte, 14)
+	for {
+		if _, err := io.ReadFull(src, header[:2]); err != nil {
+			return err
+		}
+		fin := header[0]&0x80 != 0
+		opcode := header[0] & 0x0F
+		masked := header[1]&0x80 != 0
+		n := 2
+
+		var payloadLen uint64
+		switch l := header[1] & 0x7F; l {
+		case 126:
+			if _, err := io.ReadFull(src, header[n:n+2]); err != nil {
+				return err
+			}
+			payloadLen = uint64(binary.BigEndian.Uint16(header[n : n+2]))
+			n += 2
+		case 127:
+			if _, err := io.ReadFull(src, header[n:n+8]); err != nil {
+				return err
+			}
+			payloadLen = binary.BigEndian.Uint64(header[n : n+8])
+			n += 8
+		default:
+			payloadLen = uint64(l)
+		}
+		if masked {
+			if _, err := io.ReadFull(src, header[n:n+4]); err != nil {
+				return err
+			}
+			n += 4
+		}
+		if payloadLen > 1<<62 {
+			return fmt.Errorf("websocket frame too large")
+		}
+
+		if _, err := dst.Write(header[:n]); err != nil {
+			return err
+		}
+		copied, err := io.CopyN(dst, src, int64(payloadLen))
+		counter.bytes.Add(int64(n) + copied)
+		if err != nil {
+			return err
+		}
+		if fin && opcode < 0x8 {
+			counter.messages.Add(1)
+		}
+	}
+}
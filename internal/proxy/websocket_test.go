@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecgard/octroi/internal/metering"
+	"github.com/alecgard/octroi/internal/registry"
+)
+
+// wsFrame encodes a single final WebSocket frame, masked when mask is set.
+func wsFrame(opcode byte, payload []byte, mask bool) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(0x80 | opcode)
+	lenByte := byte(len(payload))
+	if mask {
+		lenByte |= 0x80
+	}
+	buf.WriteByte(lenByte)
+	if mask {
+		key := []byte{1, 2, 3, 4}
+		buf.Write(key)
+		for i, b := range payload {
+			buf.WriteByte(b ^ key[i%4])
+		}
+	} else {
+		buf.Write(payload)
+	}
+	return buf.Bytes()
+}
+
+// newEchoWebSocketServer accepts a WebSocket upgrade and echoes each data
+// frame back unmasked until the client closes. The Authorization header seen
+// on the handshake is sent on gotAuth.
+func newEchoWebSocketServer(t *testing.T, gotAuth chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		gotAuth <- r.Header.Get("Authorization")
+
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = brw.Flush()
+
+		for {
+			header := make([]byte, 6)
+			if _, err := io.ReadFull(brw, header); err != nil {
+				return
+			}
+			n := int(header[1] & 0x7F)
+			payload := make([]byte, n)
+			if _, err := io.ReadFull(brw, payload); err != nil {
+				return
+			}
+			for i := range payload {
+				payload[i] ^= header[2+i%4]
+			}
+			if header[0]&0x0F == 0x8 {
+				return
+			}
+			_, _ = conn.Write(wsFrame(header[0]&0x0F, payload, false))
+		}
+	}))
+}
+
+func TestWebSocketProxy(t *testing.T) {
+	gotAuth := make(chan string, 1)
+	upstream := newEchoWebSocketServer(t, gotAuth)
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	tool.AuthType = "bearer"
+	tool.AuthConfig = map[string]string{"key": "ws-secret"}
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &chanCollector{ch: make(chan metering.Transaction, 1)}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+
+	srv := newAgentServer(handler)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, _ = io.WriteString(conn, "GET /proxy/tool-1/socket HTTP/1.1\r\n"+
+		"Host: octroi.test\r\n"+
+		"Authorization: Bearer agent-key\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if auth := <-gotAuth; auth != "Bearer ws-secret" {
+		t.Errorf("expected injected bearer credentials, got %q", auth)
+	}
+
+	_, _ = conn.Write(wsFrame(0x1, []byte("hello"), true))
+	echo := make([]byte, 2+len("hello"))
+	if _, err := io.ReadFull(br, echo); err != nil {
+		t.Fatalf("reading echo: %v", err)
+	}
+	if string(echo[2:]) != "hello" {
+		t.Errorf("expected echo %q, got %q", "hello", echo[2:])
+	}
+
+	_, _ = conn.Write(wsFrame(0x8, nil, true))
+
+	select {
+	case tx := <-collector.ch:
+		if tx.StatusCode != http.StatusSwitchingProtocols || !tx.Success {
+			t.Errorf("unexpected transaction: %+v", tx)
+		}
+		// Client sent one text message plus a close frame; upstream echoed one.
+		if tx.MessagesIn != 1 || tx.MessagesOut != 1 {
+			t.Errorf("expected 1 message each way, got in=%d out=%d", tx.MessagesIn, tx.MessagesOut)
+		}
+		if tx.RequestSize != int64(6+5+6) || tx.ResponseSize != int64(2+5) {
+			t.Errorf("unexpected byte counts: in=%d out=%d", tx.RequestSize, tx.ResponseSize)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("transaction was not recorded")
+	}
+}
+
+func TestWebSocketRejectedByBudget(t *testing.T) {
+	gotAuth := make(chan string, 1)
+	upstream := newEchoWebSocketServer(t, gotAuth)
+	defer upstream.Close()
+
+	tool := newTestTool(upstream.URL)
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: false, globalAllowed: true}
+	collector := &fakeCollector{}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+	router := setupRouter(handler)
+
+	req := httptest.NewRequest("GET", "/proxy/tool-1/socket", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req = withAgent(req, newTestAgent())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rr.Code)
+	}
+	select {
+	case <-gotAuth:
+		t.Error("upstream should not be dialled when the budget is exceeded")
+	default:
+	}
+}
+
+func TestWebSocketFailover(t *testing.T) {
+	gotAuth := make(chan string, 1)
+	upstream := newEchoWebSocketServer(t, gotAuth)
+	defer upstream.Close()
+
+	// A listener that is closed straight away refuses connections.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "http://" + ln.Addr().String()
+	ln.Close()
+
+	tool := newTargetTool("round_robin", registry.Target{URL: dead}, registry.Target{URL: upstream.URL})
+	store := &fakeToolStore{tools: map[string]*registry.Tool{"tool-1": tool}}
+	budgets := &fakeBudgetChecker{agentAllowed: true, globalAllowed: true}
+	collector := &chanCollector{ch: make(chan metering.Transaction, 1)}
+	handler := NewHandler(store, budgets, collector, 5*time.Second, 1<<20)
+
+	srv := newAgentServer(handler)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, _ = io.WriteString(conn, "GET /proxy/tool-1/socket HTTP/1.1\r\n"+
+		"Host: octroi.test\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("reading handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101 from the second target, got %d", resp.StatusCode)
+	}
+	<-gotAuth
+
+	_, _ = conn.Write(wsFrame(0x8, nil, true))
+	select {
+	case tx := <-collector.ch:
+		if want := strings.TrimPrefix(upstream.URL, "http://"); tx.Target != want {
+			t.Errorf("expected the session metered against %s, got %s", want, tx.Target)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("transaction was not recorded")
+	}
+}
+
+func TestPipeFramesCountsMessages(t *testing.T) {
+	var src bytes.Buffer
+	src.Write([]byte{0x01, 0x02, 'a', 'b'}) // text, not final
+	src.Write([]byte{0x80, 0x01, 'c'})      // final continuation
+	src.Write(wsFrame(0x9, []byte("p"), false))
+	src.Write(wsFrame(0x2, []byte("bin"), false))
+
+	var dst bytes.Buffer
+	var counter frameCounter
+	if err := pipeFrames(&dst, &src, &counter); err != io.EOF {
+		t.Fatalf("expected io.EOF at end of input, got %v", err)
+	}
+	if got := counter.messages.Load(); got != 2 {
+		t.Errorf("expected 2 data messages, got %d", got)
+	}
+	if got := counter.bytes.Load(); got != int64(dst.Len()) || dst.Len() != 4+3+3+5 {
+		t.Errorf("expected all %d bytes copied, counted %d", dst.Len(), got)
+	}
+}
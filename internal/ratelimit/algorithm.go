@@ -0,0 +1,275 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Rate limiting algorithms a bucket can be enforced with.
+const (
+	// TokenBucket refills Rate tokens per window up to a capacity of Burst,
+	// one token per request.
+	TokenBucket = "token_bucket"
+	// SlidingWindow counts requests in fixed windows and weights the previous
+	// window's count by how much of it still overlaps the sliding window.
+	// Burst does not apply.
+	SlidingWindow = "sliding_window"
+	// GCRA is the generic cell rate algorithm: requests are spaced window/Rate
+	// apart, and up to Burst may arrive early.
+	GCRA = "gcra"
+)
+
+// ValidAlgorithm reports whether name is a known algorithm.
+func ValidAlgorithm(name string) bool {
+	switch name {
+	case TokenBucket, SlidingWindow, GCRA:
+		return true
+	}
+	return false
+}
+
+// state is the stored state of a bucket as of at. What the two numbers hold
+// depends on the algorithm:
+//
+//	token_bucket   — tokens left; prev unused
+//	gcra           — seconds until the theoretical arrival time; prev unused
+//	sliding_window — requests in the window holding at, and in the one before
+type state struct {
+	tokens float64
+	prev   float64
+	at     time.Time
+}
+
+// algorithm implements the arithmetic of one rate limiting algorithm over a
+// bucket's state. Except for fresh and advance, methods expect a state already
+// advanced to the current time.
+type algorithm interface {
+	// fresh returns the state of a new, unused bucket.
+	fresh(now time.Time, k BucketKey) state
+	// advance brings s forward to now. It never moves s back in time.
+	advance(s *state, now time.Time, window time.Duration, k BucketKey)
+	// wait returns how long until a request would be allowed.
+	wait(s state, window time.Duration, k BucketKey) time.Duration
+	// take records a request.
+	take(s *state, window time.Duration, k BucketKey)
+	// give undoes a take for a request that gave up.
+	give(s *state, window time.Duration, k BucketKey)
+	// rested reports whether s is as good as fresh, so dropping it loses
+	// nothing.
+	rested(s state, window time.Duration, k BucketKey) bool
+	// status returns the values for the X-RateLimit-* headers: the most
+	// requests that may be made back to back, how many of those are left, and
+	// how long until all of them are.
+	status(s state, window time.Duration, k BucketKey) (limit, remaining int, reset time.Duration)
+}
+
+// algorithmFor returns the implementation of the named algorithm, defaulting
+// to the token bucket.
+func algorithmFor(name string) algorithm {
+	switch name {
+	case SlidingWindow:
+		return slidingWindow{}
+	case GCRA:
+		return gcra{}
+	}
+	return tokenBucket{}
+}
+
+// seconds converts a fractional number of seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// clampRemaining floors n into [0, limit].
+func clampRemaining(n float64, limit int) int {
+	return int(math.Max(0, math.Min(math.Floor(n), float64(limit))))
+}
+
+type tokenBucket struct{}
+
+func (tokenBucket) fresh(now time.Time, k BucketKey) state {
+	return state{tokens: float64(k.capacity()), at: now}
+}
+
+func (tokenBucket) advance(s *state, now time.Time, window time.Duration, k BucketKey) {
+	s.tokens = refilled(s.tokens, now.Sub(s.at), window, k.Rate, k.capacity())
+	if now.After(s.at) {
+		s.at = now
+	}
+}
+
+func (tokenBucket) wait(s state, window time.Duration, k BucketKey) time.Duration {
+	return waitFor(s.tokens, window, k.Rate)
+}
+
+func (tokenBucket) take(s *state, _ time.Duration, _ BucketKey) {
+	s.tokens--
+}
+
+func (tokenBucket) give(s *state, window time.Duration, k BucketKey) {
+	s.tokens = refilled(s.tokens+1, 0, window, k.Rate, k.capacity())
+}
+
+func (tokenBucket) rested(s state, _ time.Duration, k BucketKey) bool {
+	return s.tokens >= float64(k.capacity())
+}
+
+func (tokenBucket) status(s state, window time.Duration, k BucketKey) (int, int, time.Duration) {
+	capacity := k.capacity()
+	var reset time.Duration
+	if deficit := float64(capacity) - s.tokens; deficit > 0 {
+		reset = time.Duration(deficit / float64(k.Rate) * float64(window))
+	}
+	return capacity, clampRemaining(s.tokens, capacity), reset
+}
+
+// refilled returns a token count after elapsed time at rate tokens per
+// window, capped at capacity.
+func refilled(tokens float64, elapsed, window time.Duration, rate, capacity int) float64 {
+	if elapsed > 0 {
+		tokens += elapsed.Seconds() * float64(rate) / window.Seconds()
+	}
+	return math.Min(tokens, float64(capacity))
+}
+
+// waitFor returns how long until a bucket holding tokens has a whole token.
+func waitFor(tokens float64, window time.Duration, rate int) time.Duration {
+	deficit := 1 - tokens
+	if deficit <= 0 {
+		return 0
+	}
+	return time.Duration(deficit / float64(rate) * float64(window))
+}
+
+// gcra keeps the time by which the bucket's theoretical arrival time (TAT)
+// runs ahead of now. Each request pushes it on by the emission interval
+// window/Rate; a request is allowed while it is at most Burst-1 intervals
+// ahead.
+type gcra struct{}
+
+// interval returns the emission interval in seconds.
+func (gcra) interval(window time.Duration, k BucketKey) float64 {
+	return window.Seconds() / float64(k.Rate)
+}
+
+func (gcra) fresh(now time.Time, _ BucketKey) state {
+	return state{at: now}
+}
+
+func (gcra) advance(s *state, now time.Time, _ time.Duration, _ BucketKey) {
+	if elapsed := now.Sub(s.at); elapsed > 0 {
+		s.tokens = math.Max(0, s.tokens-elapsed.Seconds())
+		s.at = now
+	}
+}
+
+func (g gcra) wait(s state, window time.Duration, k BucketKey) time.Duration {
+	tolerance := float64(k.capacity()-1) * g.interval(window, k)
+	return seconds(math.Max(0, s.tokens-tolerance))
+}
+
+func (g gcra) take(s *state, window time.Duration, k BucketKey) {
+	s.tokens += g.interval(window, k)
+}
+
+func (g gcra) give(s *state, window time.Duration, k BucketKey) {
+	s.tokens = math.Max(0, s.tokens-g.interval(window, k))
+}
+
+func (gcra) rested(s state, _ time.Duration, _ BucketKey) bool {
+	return s.tokens <= 0
+}
+
+func (g gcra) status(s state, window time.Duration, k BucketKey) (int, int, time.Duration) {
+	capacity := k.capacity()
+	interval := g.interval(window, k)
+	remaining := (float64(capacity)*interval - s.tokens) / interval
+	return capacity, clampRemaining(remaining, capacity), seconds(s.tokens)
+}
+
+// slidingWindow estimates the requests in the last window from fixed windows
+// aligned to the Unix epoch: all of the current window's count plus the
+// previous window's, scaled by the share of it still inside the last window.
+type slidingWindow struct{}
+
+// windowStart returns the start of the fixed window holding t.
+func windowStart(t time.Time, window time.Duration) time.Time {
+	ns := t.UnixNano()
+	return time.Unix(0, ns-ns%int64(window))
+}
+
+// elapsed returns how far through its fixed window s is, from 0 to 1.
+func (slidingWindow) elapsed(s state, window time.Duration) float64 {
+	return float64(s.at.Sub(windowStart(s.at, window))) / float64(window)
+}
+
+// estimate returns the estimated number of requests in the last window.
+func (w slidingWindow) estimate(s state, window time.Duration) float64 {
+	return s.prev*(1-w.elapsed(s, window)) + s.tokens
+}
+
+func (slidingWindow) fresh(now time.Time, _ BucketKey) state {
+	return state{at: now}
+}
+
+func (slidingWindow) advance(s *state, now time.Time, window time.Duration, _ BucketKey) {
+	if !now.After(s.at) {
+		return
+	}
+	from, to := windowStart(s.at, window), windowStart(now, window)
+	switch {
+	case to.Equal(from):
+	case to.Sub(from) == window:
+		s.prev, s.tokens = s.tokens, 0
+	default:
+		s.prev, s.tokens = 0, 0
+	}
+	s.at = now
+}
+
+func (w slidingWindow) wait(s state, window time.Duration, k BucketKey) time.Duration {
+	elapsed := w.elapsed(s, window)
+	if room := float64(k.Rate-1) - s.tokens; room >= 0 {
+		// The current window has room; wait for enough of the previous one
+		// to slide out.
+		if s.prev*(1-elapsed) <= room {
+			return 0
+		}
+		return time.Duration((1 - room/s.prev - elapsed) * float64(window))
+	}
+	// The current window alone is over the limit, so wait for it to become
+	// the previous window and slide out far enough.
+	next := 1 - float64(k.Rate-1)/s.tokens
+	return time.Duration((1 - elapsed + next) * float64(window))
+}
+
+func (slidingWindow) take(s *state, _ time.Duration, _ BucketKey) {
+	s.tokens++
+}
+
+func (slidingWindow) give(s *state, _ time.Duration, _ BucketKey) {
+	if s.tokens >= 1 {
+		s.tokens--
+	} else {
+		s.prev = math.Max(0, s.prev-1)
+	}
+}
+
+func (slidingWindow) rested(s state, _ time.Duration, _ BucketKey) bool {
+	return s.tokens == 0 && s.prev == 0
+}
+
+func (w slidingWindow) status(s state, window time.Duration, k BucketKey) (int, int, time.Duration) {
+	remaining := clampRemaining(float64(k.Rate)-w.estimate(s, window), k.Rate)
+	// Everything has slid out once the current window's requests have
+	// passed through the next window too.
+	var reset time.Duration
+	end := windowStart(s.at, window).Add(window)
+	switch {
+	case s.tokens > 0:
+		reset = end.Add(window).Sub(s.at)
+	case s.prev > 0:
+		reset = end.Sub(s.at)
+	}
+	return k.Rate, remaining, reset
+}
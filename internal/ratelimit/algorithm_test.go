@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	// 600/min refills a token every 100ms, but only 10 may be spent at once.
+	l := newTestLimiter(600, time.Minute, clock)
+	l.SetAlgorithm(TokenBucket, 10)
+
+	for i := 0; i < 10; i++ {
+		if !l.Allow("k", 0) {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	if l.Allow("k", 0) {
+		t.Fatal("request beyond the burst should be denied")
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	if !l.Allow("k", 0) {
+		t.Fatal("should be allowed after one refill interval")
+	}
+
+	limit, remaining, resetAt := l.Status("k", 0)
+	if limit != 10 || remaining != 0 {
+		t.Fatalf("expected 0 of 10 remaining, got %d of %d", remaining, limit)
+	}
+	if want := clock.Now().Add(time.Second); !resetAt.Equal(want) {
+		t.Fatalf("expected reset in 1s, got %v", resetAt.Sub(clock.Now()))
+	}
+}
+
+func TestGCRA(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	// One request a second, up to 3 early.
+	l := newTestLimiter(60, time.Minute, clock)
+	l.SetAlgorithm(GCRA, 3)
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow("k", 0) {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	if l.Allow("k", 0) {
+		t.Fatal("request beyond the burst should be denied")
+	}
+
+	clock.Advance(time.Second)
+	if !l.Allow("k", 0) {
+		t.Fatal("should be allowed one emission interval later")
+	}
+	if l.Allow("k", 0) {
+		t.Fatal("should be denied again straight after")
+	}
+
+	limit, remaining, resetAt := l.Status("k", 0)
+	if limit != 3 || remaining != 0 {
+		t.Fatalf("expected 0 of 3 remaining, got %d of %d", remaining, limit)
+	}
+	if want := clock.Now().Add(3 * time.Second); !resetAt.Equal(want) {
+		t.Fatalf("expected reset in 3s, got %v", resetAt.Sub(clock.Now()))
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, remaining, _ := l.Status("k", 0); remaining != 2 {
+		t.Fatalf("expected 2 remaining after 2s, got %d", remaining)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	// Start on a window boundary so the weighting is easy to follow.
+	clock := newFakeClock(time.Unix(600, 0))
+	l := newTestLimiter(4, time.Minute, clock)
+	l.SetAlgorithm(SlidingWindow, 0)
+
+	for i := 0; i < 4; i++ {
+		if !l.Allow("k", 0) {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	if l.Allow("k", 0) {
+		t.Fatal("request beyond the limit should be denied")
+	}
+
+	// A quarter into the next window, three quarters of the previous
+	// window's 4 requests still count.
+	clock.Advance(75 * time.Second)
+	if !l.Allow("k", 0) {
+		t.Fatal("should be allowed once part of the previous window slid out")
+	}
+	if l.Allow("k", 0) {
+		t.Fatal("should be denied with 3 + 1 requests in the window")
+	}
+
+	limit, remaining, resetAt := l.Status("k", 0)
+	if limit != 4 || remaining != 0 {
+		t.Fatalf("expected 0 of 4 remaining, got %d of %d", remaining, limit)
+	}
+	// This window's request only slides out at the end of the next one.
+	if want := time.Unix(780, 0); !resetAt.Equal(want) {
+		t.Fatalf("expected reset at %v, got %v", want, resetAt)
+	}
+
+	// Half way through the window only 2 of the previous 4 count, leaving
+	// room for the next request.
+	wait, ok, _ := l.backend.Reserve(context.Background(), clock.Now(), time.Minute, []BucketKey{l.bucketKey("k", 0)}, time.Minute)
+	if !ok || wait != 15*time.Second {
+		t.Fatalf("expected a 15s wait, got %v %v", wait, ok)
+	}
+}
+
+func TestSlidingWindowWaitsIntoNextWindow(t *testing.T) {
+	clock := newFakeClock(time.Unix(600, 0))
+	l := newTestLimiter(2, time.Minute, clock)
+	l.SetAlgorithm(SlidingWindow, 0)
+	l.Allow("k", 0)
+	l.Allow("k", 0)
+
+	// The window is full, so the next request must wait for the window to
+	// end and half of it to slide out.
+	wait, ok, _ := l.backend.Reserve(context.Background(), clock.Now(), time.Minute, []BucketKey{l.bucketKey("k", 0)}, 2*time.Minute)
+	if !ok || wait != 90*time.Second {
+		t.Fatalf("expected a 90s wait, got %v %v", wait, ok)
+	}
+}
+
+func TestValidAlgorithm(t *testing.T) {
+	for _, name := range []string{TokenBucket, SlidingWindow, GCRA} {
+		if !ValidAlgorithm(name) {
+			t.Errorf("expected %q valid", name)
+		}
+	}
+	if ValidAlgorithm("leaky_bucket") {
+		t.Error("expected unknown algorithm invalid")
+	}
+}
@@ -0,0 +1,234 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BucketKey identifies a bucket and the limit it enforces: Rate requests per
+// Window, by the named algorithm, allowing bursts of up to Burst.
+type BucketKey struct {
+	Key  string
+	Rate int
+	// Window is the period Rate applies to; 0 means the limiter window.
+	Window time.Duration
+	// Algorithm is one of TokenBucket, SlidingWindow or GCRA; empty means
+	// TokenBucket.
+	Algorithm string
+	// Burst is how many requests may be made back to back; 0 means Rate.
+	Burst int
+}
+
+// capacity returns the burst size.
+func (k BucketKey) capacity() int {
+	if k.Burst > 0 {
+		return k.Burst
+	}
+	return k.Rate
+}
+
+// withWindow returns keys with Window set to window where they have none.
+func withWindow(keys []BucketKey, window time.Duration) []BucketKey {
+	out := make([]BucketKey, len(keys))
+	for i, k := range keys {
+		if k.Window == 0 {
+			k.Window = window
+		}
+		out[i] = k
+	}
+	return out
+}
+
+// Backend stores rate limit buckets. Buckets start full and are created on first
+// use. Implementations must apply each call atomically across all the buckets
+// it names, so that concurrent callers (and, for shared backends, other
+// replicas) never take the same token twice.
+type Backend interface {
+	// Reserve refills the buckets in keys and, if each will hold a token
+	// within maxWait, takes one from every bucket and returns how long until
+	// the last of them is due. Buckets may go negative, so later callers
+	// queue behind earlier ones. When ok is false nothing is taken and wait
+	// is how long until the request would have been allowed. window applies
+	// to keys without a Window of their own, here and below.
+	Reserve(ctx context.Context, now time.Time, window time.Duration, keys []BucketKey, maxWait time.Duration) (wait time.Duration, ok bool, err error)
+	// Release returns the tokens taken by Reserve for a request that gave up.
+	Release(ctx context.Context, now time.Time, window time.Duration, keys []BucketKey) error
+	// Status returns a bucket's X-RateLimit-* values: its limit, the
+	// requests remaining and when it will be fully replenished.
+	Status(ctx context.Context, now time.Time, window time.Duration, key BucketKey) (limit, remaining int, resetAt time.Time, err error)
+	// Forget drops the named buckets, e.g. when the limit they enforced is
+	// removed.
+	Forget(ctx context.Context, keys []string) error
+	// ForgetMatching drops the buckets whose keys match any of patterns, in
+	// which * stands for any run of characters, e.g. when the tool or agent
+	// they belong to is deleted.
+	ForgetMatching(ctx context.Context, patterns []string) error
+	// Prune drops buckets that are at rest and have not been used for idle,
+	// and returns how many buckets remain.
+	Prune(ctx context.Context, now time.Time, window, idle time.Duration) (live int, err error)
+}
+
+// bucket tracks the state for a single key.
+type bucket struct {
+	key   string
+	state state
+	limit BucketKey
+}
+
+// MemoryBackend keeps buckets in a process-local map, bounded to a maximum
+// number of buckets with the least recently used evicted first.
+type MemoryBackend struct {
+	mu         sync.Mutex
+	buckets    map[string]*list.Element
+	lru        *list.List // of *bucket, most recently used first
+	maxBuckets int
+}
+
+// NewMemoryBackend creates an in-memory backend holding up to maxBuckets
+// buckets; 0 means no bound.
+func NewMemoryBackend(maxBuckets int) *MemoryBackend {
+	return &MemoryBackend{
+		buckets:    make(map[string]*list.Element),
+		lru:        list.New(),
+		maxBuckets: maxBuckets,
+	}
+}
+
+// getBucket returns the bucket for key advanced to now, creating one if it
+// doesn't exist. Must be called with m.mu held.
+func (m *MemoryBackend) getBucket(now time.Time, k BucketKey) *bucket {
+	alg := algorithmFor(k.Algorithm)
+	var b *bucket
+	if el, ok := m.buckets[k.Key]; ok {
+		m.lru.MoveToFront(el)
+		b = el.Value.(*bucket)
+		// State kept by another algorithm means nothing to this one.
+		if algorithmFor(b.limit.Algorithm) != alg {
+			b.state = alg.fresh(now, k)
+		}
+	} else {
+		b = &bucket{key: k.Key, state: alg.fresh(now, k)}
+		m.buckets[k.Key] = m.lru.PushFront(b)
+		if m.maxBuckets > 0 && m.lru.Len() > m.maxBuckets {
+			m.remove(m.lru.Back())
+		}
+	}
+	// Update the limit if it changed (e.g. agent config updated).
+	b.limit = k
+	alg.advance(&b.state, now, k.Window, k)
+	return b
+}
+
+// remove drops a bucket. Must be called with m.mu held.
+func (m *MemoryBackend) remove(el *list.Element) {
+	m.lru.Remove(el)
+	delete(m.buckets, el.Value.(*bucket).key)
+}
+
+func (m *MemoryBackend) Reserve(_ context.Context, now time.Time, window time.Duration, keys []BucketKey, maxWait time.Duration) (time.Duration, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys = withWindow(keys, window)
+	var wait time.Duration
+	buckets := make([]*bucket, len(keys))
+	for i, k := range keys {
+		buckets[i] = m.getBucket(now, k)
+		wait = max(wait, algorithmFor(k.Algorithm).wait(buckets[i].state, k.Window, k))
+	}
+	if wait > maxWait {
+		return wait, false, nil
+	}
+	for i, b := range buckets {
+		algorithmFor(keys[i].Algorithm).take(&b.state, keys[i].Window, keys[i])
+	}
+	return wait, true, nil
+}
+
+func (m *MemoryBackend) Release(_ context.Context, now time.Time, window time.Duration, keys []BucketKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range withWindow(keys, window) {
+		b := m.getBucket(now, k)
+		algorithmFor(k.Algorithm).give(&b.state, k.Window, k)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Status(_ context.Context, now time.Time, window time.Duration, k BucketKey) (int, int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k = withWindow([]BucketKey{k}, window)[0]
+	limit, remaining, reset := algorithmFor(k.Algorithm).status(m.getBucket(now, k).state, k.Window, k)
+	return limit, remaining, now.Add(reset), nil
+}
+
+func (m *MemoryBackend) Forget(_ context.Context, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := m.buckets[key]; ok {
+			m.remove(el)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBackend) ForgetMatching(_ context.Context, patterns []string) error {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		res[i] = keyPattern(p)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, el := range m.buckets {
+		for _, re := range res {
+			if re.MatchString(key) {
+				m.remove(el)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// keyPattern compiles a ForgetMatching pattern.
+func keyPattern(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+func (m *MemoryBackend) Prune(_ context.Context, now time.Time, _, idle time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Buckets are ordered by last use, so stop at the first recent one.
+	cutoff := now.Add(-idle)
+	for el := m.lru.Back(); el != nil; {
+		b := el.Value.(*bucket)
+		if b.state.at.After(cutoff) {
+			break
+		}
+		prev := el.Prev()
+		alg := algorithmFor(b.limit.Algorithm)
+		s := b.state
+		alg.advance(&s, now, b.limit.Window, b.limit)
+		if alg.rested(s, b.limit.Window, b.limit) {
+			m.remove(el)
+		}
+		el = prev
+	}
+	return m.lru.Len(), nil
+}
@@ -0,0 +1,232 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBackend runs the behaviour every Backend must share. Refills are kept
+// negligible with a long window, since shared backends use their own clock.
+func testBackend(t *testing.T, newBackend func(t *testing.T) Backend) {
+	const window = time.Hour
+	ctx := context.Background()
+
+	t.Run("buckets start full", func(t *testing.T) {
+		b := newBackend(t)
+		limit, remaining, _, err := b.Status(ctx, time.Now(), window, BucketKey{Key: "full", Rate: 5})
+		if err != nil || limit != 5 || remaining != 5 {
+			t.Fatalf("expected 5 of 5 remaining, got %d of %d %v", remaining, limit, err)
+		}
+	})
+
+	t.Run("reserve takes from all buckets or none", func(t *testing.T) {
+		b := newBackend(t)
+		keys := []BucketKey{{Key: "wide", Rate: 3}, {Key: "narrow", Rate: 1}}
+		if _, ok, err := b.Reserve(ctx, time.Now(), window, keys, 0); !ok || err != nil {
+			t.Fatalf("expected first reservation allowed, got %v %v", ok, err)
+		}
+		if _, ok, _ := b.Reserve(ctx, time.Now(), window, keys, 0); ok {
+			t.Fatal("expected reservation rejected once the narrow bucket is empty")
+		}
+		_, remaining, _, _ := b.Status(ctx, time.Now(), window, BucketKey{Key: "wide", Rate: 3})
+		if remaining != 2 {
+			t.Errorf("expected the rejected reservation to leave the wide bucket at 2, got %d", remaining)
+		}
+	})
+
+	t.Run("reserve waits for the slowest bucket", func(t *testing.T) {
+		b := newBackend(t)
+		b.Reserve(ctx, time.Now(), window, []BucketKey{{Key: "slow", Rate: 2}}, 0)
+		b.Reserve(ctx, time.Now(), window, []BucketKey{{Key: "slow", Rate: 2}}, 0)
+
+		// At 2 per hour the next token is due in about 30 minutes.
+		wait, ok, err := b.Reserve(ctx, time.Now(), window, []BucketKey{{Key: "slow", Rate: 2}}, time.Minute)
+		if ok {
+			t.Fatal("expected reservation rejected beyond maxWait")
+		}
+		if wait < 29*time.Minute || wait > 30*time.Minute {
+			t.Errorf("expected the rejection to report a wait of about 30m, got %v", wait)
+		}
+		wait, ok, err = b.Reserve(ctx, time.Now(), window, []BucketKey{{Key: "fast", Rate: 10}, {Key: "slow", Rate: 2}}, time.Hour)
+		if !ok || err != nil {
+			t.Fatalf("expected reservation allowed within maxWait, got %v %v", ok, err)
+		}
+		if wait < 29*time.Minute || wait > 30*time.Minute {
+			t.Errorf("expected a wait of about 30m, got %v", wait)
+		}
+	})
+
+	t.Run("release returns tokens", func(t *testing.T) {
+		b := newBackend(t)
+		keys := []BucketKey{{Key: "released", Rate: 1}}
+		b.Reserve(ctx, time.Now(), window, keys, 0)
+		if err := b.Release(ctx, time.Now(), window, keys); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, _ := b.Reserve(ctx, time.Now(), window, keys, 0); !ok {
+			t.Error("expected the released token to be available again")
+		}
+	})
+
+	t.Run("forget drops buckets", func(t *testing.T) {
+		b := newBackend(t)
+		keys := []BucketKey{{Key: "forgotten", Rate: 1}}
+		b.Reserve(ctx, time.Now(), window, keys, 0)
+		if err := b.Forget(ctx, []string{"forgotten"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, _ := b.Reserve(ctx, time.Now(), window, keys, 0); !ok {
+			t.Error("expected a forgotten bucket to start full again")
+		}
+	})
+
+	t.Run("forget matching drops buckets by pattern", func(t *testing.T) {
+		b := newBackend(t)
+		for _, key := range []string{"tool:t1:agent:a_1", "tool:t2:agent:a_1/60s", "tool:t1:agent:ab1"} {
+			b.Reserve(ctx, time.Now(), window, []BucketKey{{Key: key, Rate: 1}}, 0)
+		}
+		if err := b.ForgetMatching(ctx, []string{"tool:*:agent:a_1", "tool:*:agent:a_1/*"}); err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range map[string]bool{"tool:t1:agent:a_1": true, "tool:t2:agent:a_1/60s": true, "tool:t1:agent:ab1": false} {
+			if _, ok, _ := b.Reserve(ctx, time.Now(), window, []BucketKey{{Key: key, Rate: 1}}, 0); ok != want {
+				t.Errorf("%s: fresh = %v, want %v", key, ok, want)
+			}
+		}
+	})
+
+	t.Run("prune keeps buckets that are not full", func(t *testing.T) {
+		b := newBackend(t)
+		b.Reserve(ctx, time.Now(), window, []BucketKey{{Key: "drained", Rate: 2}}, 0)
+		if _, err := b.Prune(ctx, time.Now(), window, 0); err != nil {
+			t.Fatal(err)
+		}
+		_, remaining, _, _ := b.Status(ctx, time.Now(), window, BucketKey{Key: "drained", Rate: 2})
+		if remaining >= 2 {
+			t.Errorf("expected the drained bucket kept, got %d remaining", remaining)
+		}
+	})
+
+	t.Run("each algorithm allows its burst", func(t *testing.T) {
+		b := newBackend(t)
+		for _, k := range []BucketKey{
+			{Key: "bucket", Rate: 5, Algorithm: TokenBucket, Burst: 2},
+			{Key: "gcra", Rate: 5, Algorithm: GCRA, Burst: 2},
+			{Key: "sliding", Rate: 2, Algorithm: SlidingWindow},
+		} {
+			for i := 0; i < 2; i++ {
+				if _, ok, err := b.Reserve(ctx, time.Now(), window, []BucketKey{k}, 0); !ok || err != nil {
+					t.Fatalf("%s: expected request %d allowed, got %v %v", k.Algorithm, i+1, ok, err)
+				}
+			}
+			if _, ok, _ := b.Reserve(ctx, time.Now(), window, []BucketKey{k}, 0); ok {
+				t.Errorf("%s: expected request 3 rejected", k.Algorithm)
+			}
+			limit, remaining, resetAt, _ := b.Status(ctx, time.Now(), window, k)
+			if limit != 2 || remaining != 0 || !resetAt.After(time.Now()) {
+				t.Errorf("%s: expected 0 of 2 remaining with a future reset, got %d of %d at %v", k.Algorithm, remaining, limit, resetAt)
+			}
+		}
+	})
+
+	t.Run("keys may have their own window", func(t *testing.T) {
+		b := newBackend(t)
+		k := BucketKey{Key: "daily", Rate: 2, Window: 24 * time.Hour}
+		b.Reserve(ctx, time.Now(), window, []BucketKey{k}, 0)
+		// At 2 a day the spent token takes 12 hours to refill.
+		_, _, resetAt, _ := b.Status(ctx, time.Now(), window, k)
+		if reset := time.Until(resetAt); reset < 11*time.Hour || reset > 12*time.Hour {
+			t.Errorf("expected a reset in about 12h, got %v", reset)
+		}
+	})
+
+	t.Run("changing algorithm starts afresh", func(t *testing.T) {
+		b := newBackend(t)
+		k := BucketKey{Key: "switched", Rate: 1}
+		b.Reserve(ctx, time.Now(), window, []BucketKey{k}, 0)
+		k.Algorithm = GCRA
+		if _, ok, _ := b.Reserve(ctx, time.Now(), window, []BucketKey{k}, 0); !ok {
+			t.Error("expected a bucket moved to another algorithm to start at rest")
+		}
+	})
+
+	t.Run("concurrent reservations never exceed the rate", func(t *testing.T) {
+		b := newBackend(t)
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			allowed int
+		)
+		for i := 0; i < 40; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, ok, err := b.Reserve(ctx, time.Now(), window, []BucketKey{{Key: "contended", Rate: 10}, {Key: "other", Rate: 100}}, 0)
+				if err != nil {
+					t.Error(err)
+				}
+				if ok {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if allowed != 10 {
+			t.Errorf("expected exactly 10 allowed, got %d", allowed)
+		}
+	})
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackend(t, func(*testing.T) Backend { return NewMemoryBackend(0) })
+}
+
+func TestMemoryBackendEvictsLeastRecentlyUsed(t *testing.T) {
+	b := NewMemoryBackend(2)
+	now := time.Now()
+	take := func(key string) {
+		b.Reserve(context.Background(), now, time.Hour, []BucketKey{{Key: key, Rate: 1}}, 0)
+	}
+	take("a")
+	take("b")
+	take("a") // a is now more recent than b
+	take("c") // evicts b
+
+	if len(b.buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(b.buckets))
+	}
+	if _, ok := b.buckets["b"]; ok {
+		t.Error("expected the least recently used bucket evicted")
+	}
+	if _, ok := b.buckets["a"]; !ok {
+		t.Error("expected the recently used bucket kept")
+	}
+}
+
+func TestMemoryBackendPrunesIdleFullBuckets(t *testing.T) {
+	b := NewMemoryBackend(0)
+	start := time.Now()
+	ctx := context.Background()
+	b.Reserve(ctx, start, time.Minute, []BucketKey{{Key: "idle", Rate: 2}}, 0)
+	for i := 0; i < 5; i++ {
+		b.Reserve(ctx, start, time.Minute, []BucketKey{{Key: "deep", Rate: 1}}, 10*time.Minute) // down to -4 tokens
+	}
+	b.Reserve(ctx, start.Add(2*time.Minute), time.Minute, []BucketKey{{Key: "recent", Rate: 2}}, 0)
+
+	// Untouched for three windows, "idle" has refilled but "deep" is still
+	// short; "recent" was used one window ago.
+	live, err := b.Prune(ctx, start.Add(3*time.Minute), time.Minute, 3*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live != 2 {
+		t.Errorf("expected 2 live buckets, got %d", live)
+	}
+	if _, ok := b.buckets["idle"]; ok {
+		t.Error("expected idle full bucket pruned")
+	}
+}
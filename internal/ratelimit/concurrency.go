@@ -0,0 +1,269 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ConcurrencyResolver returns a tool's concurrency limits at global, team and
+// agent scope. A zero value means no limit for that scope.
+type ConcurrencyResolver interface {
+	Resolve(ctx context.Context, toolID, team, agentID string) (global, teamLimit, agentLimit int, err error)
+}
+
+// ConcurrencyMetrics is the subset of metrics.Metrics the concurrency limiter
+// reports to.
+type ConcurrencyMetrics interface {
+	SetConcurrencyQueueDepth(toolID string, depth int)
+	ObserveConcurrencyWait(toolID string, seconds float64)
+}
+
+// ToolConcurrencyLimiter bounds the requests in flight to a tool at global,
+// team and agent scope. A request over any limit waits in a bounded FIFO
+// queue for up to the queue timeout, or is rejected straight away when
+// queueSize is zero. A freed slot is handed to the request at the head of the
+// queue rather than raced for. Slots are held in memory, so limits apply per
+// instance, not across replicas.
+type ToolConcurrencyLimiter struct {
+	limits       ConcurrencyResolver
+	queueSize    int
+	queueTimeout time.Duration
+	metrics      ConcurrencyMetrics
+
+	mu       sync.Mutex
+	inFlight map[string]int                  // scope key -> requests in flight
+	queues   map[string][]*concurrencyWaiter // scope key -> requests waiting for it, oldest first
+	depth    map[string]int                  // tool ID -> requests waiting
+	seq      uint64                          // arrival order of queued requests
+}
+
+// NewToolConcurrencyLimiter creates a limiter that queues up to queueSize
+// requests per limit for at most queueTimeout each.
+func NewToolConcurrencyLimiter(limits ConcurrencyResolver, queueSize int, queueTimeout time.Duration) *ToolConcurrencyLimiter {
+	return &ToolConcurrencyLimiter{
+		limits:       limits,
+		queueSize:    queueSize,
+		queueTimeout: queueTimeout,
+		inFlight:     make(map[string]int),
+		queues:       make(map[string][]*concurrencyWaiter),
+		depth:        make(map[string]int),
+	}
+}
+
+// SetMetrics sets the optional metrics recorder.
+func (l *ToolConcurrencyLimiter) SetMetrics(m ConcurrencyMetrics) {
+	l.metrics = m
+}
+
+// concurrencyScope is one limit that applies to a request.
+type concurrencyScope struct {
+	key   string
+	limit int
+}
+
+// concurrencyWaiter is a request queued for a slot. ready is closed once it
+// has been handed its slots.
+type concurrencyWaiter struct {
+	toolID  string
+	scopes  []concurrencyScope
+	key     string // scope key the request is queued on
+	seq     uint64
+	start   time.Time
+	ready   chan struct{}
+	granted bool
+}
+
+// Acquire takes a slot under every limit that applies to the request,
+// waiting in the queue if one is full. allowed is false when the queue is
+// full, the queue timeout passes or ctx is cancelled first. When allowed,
+// release must be called once the request is done.
+func (l *ToolConcurrencyLimiter) Acquire(ctx context.Context, toolID, team, agentID string) (release func(), allowed bool, err error) {
+	global, teamLimit, agentLimit, err := l.limits.Resolve(ctx, toolID, team, agentID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var scopes []concurrencyScope
+	if agentLimit > 0 {
+		scopes = append(scopes, concurrencyScope{fmt.Sprintf("tool:%s:agent:%s", toolID, agentID), agentLimit})
+	}
+	if teamLimit > 0 && team != "" {
+		scopes = append(scopes, concurrencyScope{fmt.Sprintf("tool:%s:team:%s", toolID, team), teamLimit})
+	}
+	if global > 0 {
+		scopes = append(scopes, concurrencyScope{fmt.Sprintf("tool:%s", toolID), global})
+	}
+	if len(scopes) == 0 {
+		return func() {}, true, nil
+	}
+
+	l.mu.Lock()
+	blocked := l.blocked(scopes)
+	if blocked == "" {
+		l.take(scopes)
+		l.mu.Unlock()
+		return l.releaser(scopes), true, nil
+	}
+	if len(l.queues[blocked]) >= l.queueSize {
+		l.mu.Unlock()
+		return nil, false, nil
+	}
+	l.seq++
+	w := &concurrencyWaiter{
+		toolID: toolID,
+		scopes: scopes,
+		seq:    l.seq,
+		start:  time.Now(),
+		ready:  make(chan struct{}),
+	}
+	l.enqueue(blocked, w)
+	l.depth[toolID]++
+	l.reportDepth(toolID)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return l.releaser(scopes), true, nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	l.abandon(w)
+	return nil, false, nil
+}
+
+// blocked returns the key of the first scope with no free slot or with
+// requests already queued for it, or "". The caller holds l.mu.
+func (l *ToolConcurrencyLimiter) blocked(scopes []concurrencyScope) string {
+	for _, s := range scopes {
+		if l.inFlight[s.key] >= s.limit || len(l.queues[s.key]) > 0 {
+			return s.key
+		}
+	}
+	return ""
+}
+
+// full returns the key of the first scope with no free slot, or "". The
+// caller holds l.mu.
+func (l *ToolConcurrencyLimiter) full(scopes []concurrencyScope) string {
+	for _, s := range scopes {
+		if l.inFlight[s.key] >= s.limit {
+			return s.key
+		}
+	}
+	return ""
+}
+
+// take counts a request in flight under every scope. The caller holds l.mu.
+func (l *ToolConcurrencyLimiter) take(scopes []concurrencyScope) {
+	for _, s := range scopes {
+		l.inFlight[s.key]++
+	}
+}
+
+// enqueue queues w on key, behind every request that arrived before it. The
+// caller holds l.mu.
+func (l *ToolConcurrencyLimiter) enqueue(key string, w *concurrencyWaiter) {
+	q := l.queues[key]
+	i := len(q)
+	for i > 0 && q[i-1].seq > w.seq {
+		i--
+	}
+	w.key = key
+	l.queues[key] = slices.Insert(q, i, w)
+}
+
+// unqueue removes w from the queue it waits on. The caller holds l.mu.
+func (l *ToolConcurrencyLimiter) unqueue(w *concurrencyWaiter) {
+	q := slices.DeleteFunc(l.queues[w.key], func(o *concurrencyWaiter) bool { return o == w })
+	if len(q) == 0 {
+		delete(l.queues, w.key)
+	} else {
+		l.queues[w.key] = q
+	}
+}
+
+// handOff gives slots freed under key to the requests queued for it, oldest
+// first. A request at the head that is still held back by another full limit
+// moves to that limit's queue, keeping its place by arrival. The caller holds
+// l.mu.
+func (l *ToolConcurrencyLimiter) handOff(key string) {
+	for len(l.queues[key]) > 0 {
+		w := l.queues[key][0]
+		full := l.full(w.scopes)
+		if full == key {
+			return
+		}
+		l.unqueue(w)
+		if full != "" {
+			l.enqueue(full, w)
+			continue
+		}
+		l.take(w.scopes)
+		w.granted = true
+		close(w.ready)
+		l.dequeued(w)
+	}
+}
+
+// dequeued updates the queue metrics for a request that left the queue. The
+// caller holds l.mu.
+func (l *ToolConcurrencyLimiter) dequeued(w *concurrencyWaiter) {
+	if l.depth[w.toolID]--; l.depth[w.toolID] <= 0 {
+		delete(l.depth, w.toolID)
+	}
+	l.reportDepth(w.toolID)
+	if l.metrics != nil {
+		l.metrics.ObserveConcurrencyWait(w.toolID, time.Since(w.start).Seconds())
+	}
+}
+
+// abandon removes a request that gave up waiting from the queue. If it was
+// handed its slots in the meantime they are released to the next in line.
+func (l *ToolConcurrencyLimiter) abandon(w *concurrencyWaiter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.granted {
+		l.free(w.scopes)
+		return
+	}
+	l.unqueue(w)
+	l.dequeued(w)
+}
+
+// reportDepth exports the queue depth of toolID. The caller holds l.mu.
+func (l *ToolConcurrencyLimiter) reportDepth(toolID string) {
+	if l.metrics != nil {
+		l.metrics.SetConcurrencyQueueDepth(toolID, l.depth[toolID])
+	}
+}
+
+// free releases a request's slots and hands them on to queued requests. The
+// caller holds l.mu.
+func (l *ToolConcurrencyLimiter) free(scopes []concurrencyScope) {
+	for _, s := range scopes {
+		if l.inFlight[s.key]--; l.inFlight[s.key] <= 0 {
+			delete(l.inFlight, s.key)
+		}
+	}
+	for _, s := range scopes {
+		l.handOff(s.key)
+	}
+}
+
+// releaser returns a function that frees the request's slots. Calls after the
+// first are no-ops.
+func (l *ToolConcurrencyLimiter) releaser(scopes []concurrencyScope) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.free(scopes)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ToolConcurrencyOverride represents a team- or agent-scoped limit on the
+// requests a tool may have in flight.
+type ToolConcurrencyOverride struct {
+	ID             string `json:"id"`
+	ToolID         string `json:"tool_id"`
+	Scope          string `json:"scope"`
+	ScopeID        string `json:"scope_id"`
+	MaxConcurrency int    `json:"max_concurrency"`
+}
+
+// ToolConcurrencyLimitStore provides CRUD for tool_concurrency_limits and
+// resolution of effective limits.
+type ToolConcurrencyLimitStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewToolConcurrencyLimitStore creates a new ToolConcurrencyLimitStore.
+func NewToolConcurrencyLimitStore(pool *pgxpool.Pool) *ToolConcurrencyLimitStore {
+	return &ToolConcurrencyLimitStore{pool: pool}
+}
+
+// ListByTool returns all concurrency limit overrides for the given tool.
+func (s *ToolConcurrencyLimitStore) ListByTool(ctx context.Context, toolID string) ([]ToolConcurrencyOverride, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT id, tool_id, scope, scope_id, max_concurrency
+		 FROM tool_concurrency_limits WHERE tool_id = $1 ORDER BY scope, scope_id`, toolID)
+	if err != nil {
+		return nil, fmt.Errorf("listing tool concurrency limits: %w", err)
+	}
+	defer rows.Close()
+
+	var overrides []ToolConcurrencyOverride
+	for rows.Next() {
+		var o ToolConcurrencyOverride
+		if err := rows.Scan(&o.ID, &o.ToolID, &o.Scope, &o.ScopeID, &o.MaxConcurrency); err != nil {
+			return nil, fmt.Errorf("scanning tool concurrency limit: %w", err)
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// Set upserts a concurrency limit override for a tool+scope+scopeID combination.
+func (s *ToolConcurrencyLimitStore) Set(ctx context.Context, toolID, scope, scopeID string, maxConcurrency int) error {
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO tool_concurrency_limits (tool_id, scope, scope_id, max_concurrency)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (tool_id, scope, scope_id) DO UPDATE SET max_concurrency = EXCLUDED.max_concurrency`,
+		toolID, scope, scopeID, maxConcurrency)
+	if err != nil {
+		return fmt.Errorf("upserting tool concurrency limit: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a concurrency limit override for a tool+scope+scopeID combination.
+func (s *ToolConcurrencyLimitStore) Delete(ctx context.Context, toolID, scope, scopeID string) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM tool_concurrency_limits WHERE tool_id = $1 AND scope = $2 AND scope_id = $3`,
+		toolID, scope, scopeID)
+	if err != nil {
+		return fmt.Errorf("deleting tool concurrency limit: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// Resolve returns the effective concurrency limits for a tool across all
+// three scopes. global comes from tools.max_concurrency, team and agent from
+// tool_concurrency_limits. A zero value means no limit for that scope.
+func (s *ToolConcurrencyLimitStore) Resolve(ctx context.Context, toolID, team, agentID string) (global, teamLimit, agentLimit int, err error) {
+	err = s.pool.QueryRow(ctx, `
+		SELECT
+			COALESCE(t.max_concurrency, 0),
+			COALESCE((SELECT tcl.max_concurrency FROM tool_concurrency_limits tcl
+			          WHERE tcl.tool_id = t.id AND tcl.scope = 'team' AND tcl.scope_id = $2), 0),
+			COALESCE((SELECT tcl.max_concurrency FROM tool_concurrency_limits tcl
+			          WHERE tcl.tool_id = t.id AND tcl.scope = 'agent' AND tcl.scope_id = $3), 0)
+		FROM tools t
+		WHERE t.id = $1`,
+		toolID, team, agentID,
+	).Scan(&global, &teamLimit, &agentLimit)
+	if err != nil {
+		err = fmt.Errorf("resolving tool concurrency limits: %w", err)
+	}
+	return
+}
@@ -0,0 +1,230 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	global, team, agent int
+}
+
+func (f fakeResolver) Resolve(context.Context, string, string, string) (int, int, int, error) {
+	return f.global, f.team, f.agent, nil
+}
+
+type fakeConcurrencyMetrics struct {
+	mu    sync.Mutex
+	depth map[string]int
+	waits int
+}
+
+func (f *fakeConcurrencyMetrics) SetConcurrencyQueueDepth(toolID string, depth int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.depth[toolID] = depth
+}
+
+func (f *fakeConcurrencyMetrics) ObserveConcurrencyWait(string, float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waits++
+}
+
+func (f *fakeConcurrencyMetrics) queueDepth(toolID string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.depth[toolID]
+}
+
+func TestConcurrencyScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits fakeResolver
+		team   string
+		agent  string
+		want   bool // whether a request from team/agent fits next to one from team-a/agent-1
+	}{
+		{"no limits", fakeResolver{}, "team-a", "agent-1", true},
+		{"agent limit", fakeResolver{agent: 1}, "team-a", "agent-1", false},
+		{"agent limit is per agent", fakeResolver{agent: 1}, "team-a", "agent-2", true},
+		{"team limit", fakeResolver{team: 1}, "team-a", "agent-2", false},
+		{"team limit is per team", fakeResolver{team: 1}, "team-b", "agent-2", true},
+		{"team limit ignored without team", fakeResolver{team: 1}, "", "agent-2", true},
+		{"global limit", fakeResolver{global: 1}, "team-b", "agent-2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewToolConcurrencyLimiter(tt.limits, 0, time.Second)
+			release, ok, err := l.Acquire(context.Background(), "tool-1", "team-a", "agent-1")
+			if err != nil || !ok {
+				t.Fatalf("expected first request allowed, got %v %v", ok, err)
+			}
+			defer release()
+
+			release2, ok, _ := l.Acquire(context.Background(), "tool-1", tt.team, tt.agent)
+			if ok != tt.want {
+				t.Errorf("expected allowed=%v, got %v", tt.want, ok)
+			}
+			if ok {
+				release2()
+			}
+		})
+	}
+}
+
+func TestConcurrencyReleaseFreesSlot(t *testing.T) {
+	l := NewToolConcurrencyLimiter(fakeResolver{global: 1}, 0, time.Second)
+	release, _, _ := l.Acquire(context.Background(), "tool-1", "", "agent-1")
+	release()
+	release() // a second call must not free another slot
+
+	release, ok, _ := l.Acquire(context.Background(), "tool-1", "", "agent-1")
+	if !ok {
+		t.Fatal("expected slot free after release")
+	}
+	defer release()
+	if _, ok, _ := l.Acquire(context.Background(), "tool-1", "", "agent-2"); ok {
+		t.Error("expected double release not to free extra slots")
+	}
+}
+
+func TestConcurrencyQueue(t *testing.T) {
+	m := &fakeConcurrencyMetrics{depth: map[string]int{}}
+	l := NewToolConcurrencyLimiter(fakeResolver{global: 1}, 1, 5*time.Second)
+	l.SetMetrics(m)
+
+	release, _, _ := l.Acquire(context.Background(), "tool-1", "", "agent-1")
+
+	acquired := make(chan func())
+	go func() {
+		r, ok, _ := l.Acquire(context.Background(), "tool-1", "", "agent-2")
+		if !ok {
+			r = nil
+		}
+		acquired <- r
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.queueDepth("tool-1") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the request to queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue holds one request; the next is rejected at once.
+	if _, ok, _ := l.Acquire(context.Background(), "tool-1", "", "agent-3"); ok {
+		t.Error("expected request rejected while the queue is full")
+	}
+
+	release()
+	r := <-acquired
+	if r == nil {
+		t.Fatal("expected queued request to get the freed slot")
+	}
+	r()
+	if m.queueDepth("tool-1") != 0 || m.waits != 1 {
+		t.Errorf("expected queue drained and wait observed, got depth %d, %d waits", m.queueDepth("tool-1"), m.waits)
+	}
+}
+
+func TestConcurrencyQueueTimeout(t *testing.T) {
+	l := NewToolConcurrencyLimiter(fakeResolver{agent: 1}, 5, 20*time.Millisecond)
+	release, _, _ := l.Acquire(context.Background(), "tool-1", "", "agent-1")
+	defer release()
+
+	start := time.Now()
+	if _, ok, _ := l.Acquire(context.Background(), "tool-1", "", "agent-1"); ok {
+		t.Fatal("expected request rejected after the queue timeout")
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("expected the request to wait for the queue timeout")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok, _ := l.Acquire(ctx, "tool-1", "", "agent-1"); ok {
+		t.Error("expected cancelled request rejected")
+	}
+	if len(l.queues) != 0 {
+		t.Errorf("expected abandoned requests removed from the queue, got %v", l.queues)
+	}
+}
+
+func TestConcurrencyQueueIsFIFO(t *testing.T) {
+	m := &fakeConcurrencyMetrics{depth: map[string]int{}}
+	l := NewToolConcurrencyLimiter(fakeResolver{global: 1}, 3, 5*time.Second)
+	l.SetMetrics(m)
+
+	release, _, _ := l.Acquire(context.Background(), "tool-1", "", "agent-0")
+
+	// Queue three requests one at a time so their arrival order is known.
+	order := make(chan int, 3)
+	releases := make(chan func(), 3)
+	for i := 1; i <= 3; i++ {
+		go func() {
+			r, ok, _ := l.Acquire(context.Background(), "tool-1", "", "agent-1")
+			if !ok {
+				t.Errorf("request %d: expected to get a slot", i)
+				return
+			}
+			order <- i
+			releases <- r
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for m.queueDepth("tool-1") != i {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for request %d to queue", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	release()
+	for want := 1; want <= 3; want++ {
+		if got := <-order; got != want {
+			t.Fatalf("expected request %d to get the slot next, got %d", want, got)
+		}
+		(<-releases)()
+	}
+}
+
+func TestConcurrencyNewRequestWaitsBehindQueue(t *testing.T) {
+	l := NewToolConcurrencyLimiter(fakeResolver{global: 1}, 1, 5*time.Second)
+	release, _, _ := l.Acquire(context.Background(), "tool-1", "", "agent-1")
+
+	acquired := make(chan func())
+	go func() {
+		r, _, _ := l.Acquire(context.Background(), "tool-1", "", "agent-2")
+		acquired <- r
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		n := len(l.queues["tool:tool-1"])
+		l.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the request to queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The freed slot goes to the queued request, not to a newcomer.
+	release()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok, _ := l.Acquire(ctx, "tool-1", "", "agent-3"); ok {
+		t.Error("expected a new request not to take the slot handed to the queue")
+	}
+	if r := <-acquired; r == nil {
+		t.Error("expected the queued request to get the freed slot")
+	} else {
+		r()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/alecgard/octroi/internal/auth"
 )
@@ -15,12 +16,15 @@ import (
 //
 // Rate-limit headers are always set on the response:
 //
-//	X-RateLimit-Limit     — maximum requests allowed in the window
-//	X-RateLimit-Remaining — tokens remaining in the current window
-//	X-RateLimit-Reset     — Unix timestamp when the bucket is fully replenished
+//	X-RateLimit-Limit     — requests allowed back to back: the burst size, or
+//	                        the rate for a sliding window
+//	X-RateLimit-Remaining — how many of those are left
+//	X-RateLimit-Reset     — Unix timestamp when all of them are available again
 //
 // When the limit is exceeded the middleware responds with HTTP 429 and a JSON
-// error body.
+// error body. Agents with a RateLimitWaitMs instead wait for the next token
+// if it refills within that time; a request whose client goes away while
+// waiting is dropped.
 func Middleware(limiter *Limiter, onReject ...func()) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -40,7 +44,18 @@ func Middleware(limiter *Limiter, onReject ...func()) func(http.Handler) http.Ha
 			w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
 			w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", resetAt.Unix()))
 
-			if !limiter.Allow(key, customRate) {
+			allowed := false
+			if maxWait := time.Duration(agent.RateLimitWaitMs) * time.Millisecond; maxWait > 0 {
+				ok, err := limiter.Wait(r.Context(), key, customRate, maxWait)
+				if err != nil {
+					return
+				}
+				allowed = ok
+			} else {
+				allowed = limiter.Allow(key, customRate)
+			}
+
+			if !allowed {
 				for _, fn := range onReject {
 					fn()
 				}
@@ -0,0 +1,272 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresBackend keeps token buckets in the rate_limit_buckets table so that
+// every replica sharing the database enforces the same limits. Each call runs
+// in one transaction that locks the buckets it touches, in key order, and all
+// refill arithmetic uses the database clock so replicas with skewed clocks
+// agree. A bucket's updated_at never moves backwards, so a refill is never
+// counted twice.
+type PostgresBackend struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresBackend creates a backend using the given pool.
+func NewPostgresBackend(pool *pgxpool.Pool) *PostgresBackend {
+	return &PostgresBackend{pool: pool}
+}
+
+// storedAlgorithm returns the name k's algorithm is stored under.
+func storedAlgorithm(k BucketKey) string {
+	if k.Algorithm == "" {
+		return TokenBucket
+	}
+	return k.Algorithm
+}
+
+// lockBuckets creates any missing buckets in keys, at rest, then locks them
+// all and returns their states advanced to the database time, in the order of
+// keys.
+func lockBuckets(ctx context.Context, tx pgx.Tx, keys []BucketKey) ([]state, error) {
+	names := make([]string, len(keys))
+	tokens := make([]float64, len(keys))
+	algorithms := make([]string, len(keys))
+	limits := make(map[string]BucketKey, len(keys))
+	for i, k := range keys {
+		names[i] = k.Key
+		tokens[i] = algorithmFor(k.Algorithm).fresh(time.Time{}, k).tokens
+		algorithms[i] = storedAlgorithm(k)
+		limits[k.Key] = k
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO rate_limit_buckets (key, tokens, algorithm, updated_at)
+		 SELECT key, tokens, algorithm, clock_timestamp()
+		 FROM unnest($1::text[], $2::float8[], $3::text[]) AS t(key, tokens, algorithm)
+		 ORDER BY key
+		 ON CONFLICT (key) DO NOTHING`,
+		names, tokens, algorithms); err != nil {
+		return nil, fmt.Errorf("creating rate limit buckets: %w", err)
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT key, tokens, prev, algorithm, updated_at, clock_timestamp() FROM rate_limit_buckets
+		 WHERE key = ANY($1) ORDER BY key FOR UPDATE`, names)
+	if err != nil {
+		return nil, fmt.Errorf("locking rate limit buckets: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]state, len(keys))
+	var now time.Time
+	for rows.Next() {
+		var (
+			key, stored string
+			s           state
+		)
+		if err := rows.Scan(&key, &s.tokens, &s.prev, &stored, &s.at, &now); err != nil {
+			return nil, fmt.Errorf("scanning rate limit bucket: %w", err)
+		}
+		k := limits[key]
+		alg := algorithmFor(k.Algorithm)
+		// State kept by another algorithm means nothing to this one.
+		if stored != storedAlgorithm(k) {
+			s = alg.fresh(s.at, k)
+		}
+		alg.advance(&s, now, k.Window, k)
+		states[key] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating rate limit buckets: %w", err)
+	}
+
+	out := make([]state, len(keys))
+	for i, k := range keys {
+		out[i] = states[k.Key]
+	}
+	return out, nil
+}
+
+// saveBuckets writes back the states and limits of keys.
+func saveBuckets(ctx context.Context, tx pgx.Tx, keys []BucketKey, states []state) error {
+	var (
+		names      = make([]string, len(keys))
+		tokens     = make([]float64, len(keys))
+		prevs      = make([]float64, len(keys))
+		rates      = make([]int32, len(keys))
+		bursts     = make([]int32, len(keys))
+		algorithms = make([]string, len(keys))
+		windows    = make([]float64, len(keys))
+		ats        = make([]time.Time, len(keys))
+	)
+	for i, k := range keys {
+		names[i] = k.Key
+		tokens[i] = states[i].tokens
+		prevs[i] = states[i].prev
+		rates[i] = int32(k.Rate)
+		bursts[i] = int32(k.capacity())
+		algorithms[i] = storedAlgorithm(k)
+		windows[i] = k.Window.Seconds()
+		ats[i] = states[i].at
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE rate_limit_buckets b
+		 SET tokens = t.tokens, prev = t.prev, rate = t.rate, burst = t.burst, algorithm = t.algorithm,
+		     window_secs = t.window_secs, updated_at = GREATEST(b.updated_at, t.at)
+		 FROM unnest($1::text[], $2::float8[], $3::float8[], $4::int[], $5::int[], $6::text[], $7::float8[], $8::timestamptz[])
+		      AS t(key, tokens, prev, rate, burst, algorithm, window_secs, at)
+		 WHERE b.key = t.key`,
+		names, tokens, prevs, rates, bursts, algorithms, windows, ats); err != nil {
+		return fmt.Errorf("updating rate limit buckets: %w", err)
+	}
+	return nil
+}
+
+// sortedKeys returns keys in lock order without duplicates.
+func sortedKeys(keys []BucketKey) []BucketKey {
+	sorted := make([]BucketKey, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if !seen[k.Key] {
+			seen[k.Key] = true
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
+// Reserve implements Backend. now is ignored in favour of the database clock.
+func (p *PostgresBackend) Reserve(ctx context.Context, _ time.Time, window time.Duration, keys []BucketKey, maxWait time.Duration) (wait time.Duration, ok bool, err error) {
+	keys = sortedKeys(withWindow(keys, window))
+	err = pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		states, err := lockBuckets(ctx, tx, keys)
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			wait = max(wait, algorithmFor(k.Algorithm).wait(states[i], k.Window, k))
+		}
+		if wait > maxWait {
+			return nil
+		}
+		for i, k := range keys {
+			algorithmFor(k.Algorithm).take(&states[i], k.Window, k)
+		}
+		if err := saveBuckets(ctx, tx, keys, states); err != nil {
+			return err
+		}
+		ok = true
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return wait, ok, nil
+}
+
+// Release implements Backend.
+func (p *PostgresBackend) Release(ctx context.Context, _ time.Time, window time.Duration, keys []BucketKey) error {
+	keys = sortedKeys(withWindow(keys, window))
+	return pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		states, err := lockBuckets(ctx, tx, keys)
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			algorithmFor(k.Algorithm).give(&states[i], k.Window, k)
+		}
+		return saveBuckets(ctx, tx, keys, states)
+	})
+}
+
+// Status implements Backend. It reads without locking; a bucket that does not
+// exist yet is at rest. resetAt is by the database clock.
+func (p *PostgresBackend) Status(ctx context.Context, _ time.Time, window time.Duration, k BucketKey) (limit, remaining int, resetAt time.Time, err error) {
+	var (
+		stored string
+		s      state
+		now    time.Time
+	)
+	k = withWindow([]BucketKey{k}, window)[0]
+	alg := algorithmFor(k.Algorithm)
+	err = p.pool.QueryRow(ctx,
+		`SELECT tokens, prev, algorithm, updated_at, clock_timestamp() FROM rate_limit_buckets WHERE key = $1`, k.Key,
+	).Scan(&s.tokens, &s.prev, &stored, &s.at, &now)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		now = time.Now()
+		s = alg.fresh(now, k)
+	case err != nil:
+		return 0, 0, time.Time{}, fmt.Errorf("reading rate limit bucket: %w", err)
+	case stored != storedAlgorithm(k):
+		s = alg.fresh(now, k)
+	}
+	alg.advance(&s, now, k.Window, k)
+	limit, remaining, reset := alg.status(s, k.Window, k)
+	return limit, remaining, now.Add(reset), nil
+}
+
+// Forget implements Backend.
+func (p *PostgresBackend) Forget(ctx context.Context, keys []string) error {
+	if _, err := p.pool.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE key = ANY($1)`, keys); err != nil {
+		return fmt.Errorf("deleting rate limit buckets: %w", err)
+	}
+	return nil
+}
+
+// ForgetMatching implements Backend.
+func (p *PostgresBackend) ForgetMatching(ctx context.Context, patterns []string) error {
+	like := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		like[i] = likePattern.Replace(pattern)
+	}
+	if _, err := p.pool.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE key LIKE ANY($1)`, like); err != nil {
+		return fmt.Errorf("deleting rate limit buckets: %w", err)
+	}
+	return nil
+}
+
+// likePattern turns a ForgetMatching pattern into a LIKE pattern.
+var likePattern = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`)
+
+// Prune implements Backend, judging idleness by the database clock. With
+// several replicas each prunes the shared table; the deletes are idempotent.
+// Whether a bucket is at rest is worked out in SQL for each algorithm, as
+// the algorithm's advance and rested would, over the bucket's own window or
+// else the given one.
+func (p *PostgresBackend) Prune(ctx context.Context, _ time.Time, window, idle time.Duration) (int, error) {
+	if _, err := p.pool.Exec(ctx,
+		`DELETE FROM rate_limit_buckets b
+		 USING (SELECT key,
+		               COALESCE(window_secs, $2) AS w,
+		               EXTRACT(EPOCH FROM now() - updated_at) AS idle,
+		               floor(EXTRACT(EPOCH FROM now()) / COALESCE(window_secs, $2))
+		                 - floor(EXTRACT(EPOCH FROM updated_at) / COALESCE(window_secs, $2)) AS windows
+		        FROM rate_limit_buckets) AS t
+		 WHERE b.key = t.key
+		   AND b.updated_at <= now() - make_interval(secs => $1)
+		   AND CASE b.algorithm
+		       WHEN 'gcra' THEN b.tokens <= t.idle
+		       WHEN 'sliding_window' THEN t.windows >= 2 OR b.tokens = 0 AND (b.prev = 0 OR t.windows >= 1)
+		       ELSE b.tokens + t.idle * b.rate / t.w >= b.burst
+		       END`,
+		idle.Seconds(), window.Seconds()); err != nil {
+		return 0, fmt.Errorf("pruning rate limit buckets: %w", err)
+	}
+	var live int
+	if err := p.pool.QueryRow(ctx, `SELECT count(*) FROM rate_limit_buckets`).Scan(&live); err != nil {
+		return 0, fmt.Errorf("counting rate limit buckets: %w", err)
+	}
+	return live, nil
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// replicaBackend spreads calls over several PostgresBackends with their own
+// pools, as separate gateway replicas would, and namespaces keys per test.
+type replicaBackend struct {
+	replicas []*PostgresBackend
+	next     atomic.Uint64
+	prefix   string
+}
+
+func (r *replicaBackend) pick() *PostgresBackend {
+	return r.replicas[r.next.Add(1)%uint64(len(r.replicas))]
+}
+
+func (r *replicaBackend) keys(keys []BucketKey) []BucketKey {
+	out := make([]BucketKey, len(keys))
+	for i, k := range keys {
+		k.Key = r.prefix + k.Key
+		out[i] = k
+	}
+	return out
+}
+
+func (r *replicaBackend) Reserve(ctx context.Context, now time.Time, window time.Duration, keys []BucketKey, maxWait time.Duration) (time.Duration, bool, error) {
+	return r.pick().Reserve(ctx, now, window, r.keys(keys), maxWait)
+}
+
+func (r *replicaBackend) Release(ctx context.Context, now time.Time, window time.Duration, keys []BucketKey) error {
+	return r.pick().Release(ctx, now, window, r.keys(keys))
+}
+
+func (r *replicaBackend) Status(ctx context.Context, now time.Time, window time.Duration, k BucketKey) (int, int, time.Time, error) {
+	return r.pick().Status(ctx, now, window, r.keys([]BucketKey{k})[0])
+}
+
+func (r *replicaBackend) Forget(ctx context.Context, keys []string) error {
+	prefixed := make([]string, len(keys))
+	for i, k := range keys {
+		prefixed[i] = r.prefix + k
+	}
+	return r.pick().Forget(ctx, prefixed)
+}
+
+func (r *replicaBackend) ForgetMatching(ctx context.Context, patterns []string) error {
+	prefixed := make([]string, len(patterns))
+	for i, p := range patterns {
+		prefixed[i] = r.prefix + p
+	}
+	return r.pick().ForgetMatching(ctx, prefixed)
+}
+
+func (r *replicaBackend) Prune(ctx context.Context, now time.Time, window, idle time.Duration) (int, error) {
+	return r.pick().Prune(ctx, now, window, idle)
+}
+
+// TestPostgresBackend runs against the migrated database named by
+// OCTROI_TEST_DATABASE_URL, e.g. the docker compose Postgres.
+func TestPostgresBackend(t *testing.T) {
+	url := os.Getenv("OCTROI_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("OCTROI_TEST_DATABASE_URL not set")
+	}
+
+	var replicas []*PostgresBackend
+	for i := 0; i < 3; i++ {
+		pool, err := pgxpool.New(context.Background(), url)
+		if err != nil {
+			t.Fatalf("connecting to postgres: %v", err)
+		}
+		t.Cleanup(pool.Close)
+		replicas = append(replicas, NewPostgresBackend(pool))
+	}
+
+	run := time.Now().UnixNano()
+	testBackend(t, func(t *testing.T) Backend {
+		prefix := fmt.Sprintf("test:%d:%s:", run, t.Name())
+		t.Cleanup(func() {
+			replicas[0].pool.Exec(context.Background(), `DELETE FROM rate_limit_buckets WHERE key LIKE $1`, prefix+"%")
+		})
+		return &replicaBackend{replicas: replicas, prefix: prefix}
+	})
+}
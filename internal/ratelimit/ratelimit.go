@@ -1,34 +1,124 @@
 package ratelimit
 
 import (
-	"sync"
+	"context"
+	"log/slog"
 	"time"
 )
 
-// bucket tracks the token state for a single key.
-type bucket struct {
-	tokens     float64
-	lastRefill time.Time
-	rate       int
+// MaxWait caps how long a request may be held waiting for a token.
+const MaxWait = time.Minute
+
+// ValidWaitMs reports whether ms is an allowed rate limit wait in
+// milliseconds: 0 (reject at once) up to MaxWait.
+func ValidWaitMs(ms int) bool {
+	return ms >= 0 && time.Duration(ms)*time.Millisecond <= MaxWait
 }
 
-// Limiter implements a token-bucket rate limiter keyed by arbitrary string
-// identifiers (e.g. agent ID, tool ID).
+// Limiter implements a rate limiter keyed by arbitrary string identifiers
+// (e.g. agent ID, tool ID), using a token bucket unless configured otherwise.
+// Buckets are kept by a Backend: in process memory by default, or in Postgres
+// to share them between replicas.
 type Limiter struct {
-	mu          sync.Mutex
-	buckets     map[string]*bucket
+	backend     Backend
+	metrics     LimiterMetrics
 	defaultRate int
 	window      time.Duration
+	algorithm   string
+	burst       int
 	now         func() time.Time // injectable clock for testing
+	sleep       func(ctx context.Context, d time.Duration) error
+}
+
+// LimiterMetrics is the subset of metrics.Metrics the limiter reports to.
+type LimiterMetrics interface {
+	SetRateLimitBuckets(n int)
 }
 
-// New creates a Limiter that allows defaultRate requests per window.
+// New creates a Limiter that allows defaultRate requests per window, with
+// buckets held in memory without bound.
 func New(defaultRate int, window time.Duration) *Limiter {
 	return &Limiter{
-		buckets:     make(map[string]*bucket),
+		backend:     NewMemoryBackend(0),
 		defaultRate: defaultRate,
 		window:      window,
 		now:         time.Now,
+		sleep:       sleepContext,
+	}
+}
+
+// SetBackend replaces the default bucket store, e.g. with a bounded
+// MemoryBackend or a PostgresBackend. It must be called before the limiter is
+// used.
+func (l *Limiter) SetBackend(b Backend) {
+	l.backend = b
+}
+
+// SetAlgorithm sets the algorithm and burst size used for buckets that don't
+// choose their own. A burst of 0 lets each bucket burst up to its rate.
+func (l *Limiter) SetAlgorithm(algorithm string, burst int) {
+	l.algorithm = algorithm
+	l.burst = burst
+}
+
+// SetMetrics sets the optional metrics recorder.
+func (l *Limiter) SetMetrics(m LimiterMetrics) {
+	l.metrics = m
+}
+
+// Start prunes buckets that are full and unused for idle once per window,
+// reporting the live bucket count, until ctx is cancelled.
+func (l *Limiter) Start(ctx context.Context, idle time.Duration) {
+	ticker := time.NewTicker(l.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.prune(ctx, idle)
+		}
+	}
+}
+
+// prune runs one pruning pass.
+func (l *Limiter) prune(ctx context.Context, idle time.Duration) {
+	live, err := l.backend.Prune(ctx, l.now(), l.window, idle)
+	if err != nil {
+		slog.Warn("pruning rate limit buckets failed", "error", err)
+		return
+	}
+	if l.metrics != nil {
+		l.metrics.SetRateLimitBuckets(live)
+	}
+}
+
+// Forget drops the buckets for keys, so a limit that was removed leaves no
+// state behind.
+func (l *Limiter) Forget(keys ...string) {
+	if err := l.backend.Forget(context.Background(), keys); err != nil {
+		slog.Warn("forgetting rate limit buckets failed", "keys", keys, "error", err)
+	}
+}
+
+// ForgetMatching drops the buckets whose keys match any of patterns, in which
+// * stands for any run of characters.
+func (l *Limiter) ForgetMatching(patterns ...string) {
+	if err := l.backend.ForgetMatching(context.Background(), patterns); err != nil {
+		slog.Warn("forgetting rate limit buckets failed", "patterns", patterns, "error", err)
+	}
+}
+
+// sleepContext blocks for d or until ctx is done, returning ctx's error in the
+// latter case.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -40,83 +130,105 @@ func (l *Limiter) effectiveRate(customRate int) int {
 	return l.defaultRate
 }
 
-// getBucket returns the bucket for key, creating one if it doesn't exist.
-// Must be called with l.mu held.
-func (l *Limiter) getBucket(key string, rate int) *bucket {
-	b, ok := l.buckets[key]
-	if !ok {
-		b = &bucket{
-			tokens:     float64(rate),
-			lastRefill: l.now(),
-			rate:       rate,
-		}
-		l.buckets[key] = b
-	}
-	// Update the rate if it changed (e.g. agent config updated).
-	b.rate = rate
-	return b
+// bucketKey returns the bucket for key at customRate with the default
+// algorithm.
+func (l *Limiter) bucketKey(key string, customRate int) BucketKey {
+	return l.withDefaults(BucketKey{Key: key, Rate: l.effectiveRate(customRate)})
 }
 
-// refill adds tokens to the bucket based on elapsed time since the last refill.
-// Must be called with l.mu held.
-func (l *Limiter) refill(b *bucket) {
-	now := l.now()
-	elapsed := now.Sub(b.lastRefill).Seconds()
-	if elapsed <= 0 {
-		return
+// withDefaults fills in the default algorithm and burst where k has none.
+func (l *Limiter) withDefaults(k BucketKey) BucketKey {
+	if k.Algorithm == "" {
+		k.Algorithm = l.algorithm
 	}
-
-	// Tokens accumulate at rate/window per second.
-	refillRate := float64(b.rate) / l.window.Seconds()
-	b.tokens += elapsed * refillRate
-	if b.tokens > float64(b.rate) {
-		b.tokens = float64(b.rate)
+	if k.Burst == 0 {
+		k.Burst = l.burst
 	}
-	b.lastRefill = now
+	return k
 }
 
 // Allow checks whether a request identified by key is permitted. If customRate
 // is positive it overrides the default rate for this key. Returns true and
-// consumes one token when allowed, false when the limit is exceeded.
+// consumes one token when allowed, false when the limit is exceeded. If the
+// backend fails the request is allowed.
 func (l *Limiter) Allow(key string, customRate int) bool {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	_, ok, err := l.backend.Reserve(context.Background(), l.now(), l.window, []BucketKey{l.bucketKey(key, customRate)}, 0)
+	if err != nil {
+		slog.Warn("rate limit check failed", "key", key, "error", err)
+		return true
+	}
+	return ok
+}
 
-	rate := l.effectiveRate(customRate)
-	b := l.getBucket(key, rate)
-	l.refill(b)
+// Wait is like Allow, but when the bucket is empty it blocks until the next
+// token refills, provided that takes no longer than maxWait. It returns false
+// straight away if the token would arrive later than that, and ctx's error if
+// ctx is done while waiting.
+func (l *Limiter) Wait(ctx context.Context, key string, customRate int, maxWait time.Duration) (bool, error) {
+	_, ok, err := l.waitAll(ctx, []BucketKey{l.bucketKey(key, customRate)}, maxWait)
+	if err != nil && ctx.Err() == nil {
+		slog.Warn("rate limit check failed", "key", key, "error", err)
+		return true, nil
+	}
+	return ok, err
+}
 
-	if b.tokens < 1 {
-		return false
+// waitAll takes a token from every bucket in keys, waiting for the slowest
+// to refill if that takes no longer than maxWait. Tokens are taken from all
+// buckets or none; when none are, retryAfter is how long until they could
+// have been.
+func (l *Limiter) waitAll(ctx context.Context, keys []BucketKey, maxWait time.Duration) (retryAfter time.Duration, ok bool, err error) {
+	wait, ok, err := l.backend.Reserve(ctx, l.now(), l.window, keys, maxWait)
+	if err != nil {
+		return 0, false, err
+	}
+	if !ok {
+		return wait, false, nil
+	}
+	if wait <= 0 {
+		return 0, true, nil
+	}
+	if err := l.sleep(ctx, wait); err != nil {
+		// Give the tokens back with a context that is still live.
+		if rerr := l.backend.Release(context.WithoutCancel(ctx), l.now(), l.window, keys); rerr != nil {
+			slog.Warn("returning rate limit tokens failed", "error", rerr)
+		}
+		return 0, false, err
 	}
-	b.tokens--
-	return true
+	return 0, true, nil
 }
 
-// Status returns the current rate-limit state for key. limit is the maximum
-// number of tokens, remaining is the number of tokens left (floored to int),
-// and resetAt is the time at which the bucket will be fully replenished.
+// Status returns the current rate-limit state for key. limit is the number of
+// requests that may be made back to back from rest (the burst size, or the
+// rate for a sliding window), remaining is how many of those are left, and
+// resetAt is the time at which all of them will be again.
 func (l *Limiter) Status(key string, customRate int) (limit int, remaining int, resetAt time.Time) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	rate := l.effectiveRate(customRate)
-	b := l.getBucket(key, rate)
-	l.refill(b)
+	return l.status(l.bucketKey(key, customRate))
+}
 
-	limit = rate
-	remaining = int(b.tokens)
-	if remaining < 0 {
-		remaining = 0
+// status is Status for a bucket key with its defaults filled in. If the
+// backend fails the bucket is reported as at rest.
+func (l *Limiter) status(k BucketKey) (limit int, remaining int, resetAt time.Time) {
+	now := l.now()
+	limit, remaining, resetAt, err := l.backend.Status(context.Background(), now, l.window, k)
+	if err != nil {
+		slog.Warn("reading rate limit status failed", "key", k.Key, "error", err)
+		k = withWindow([]BucketKey{k}, l.window)[0]
+		alg := algorithmFor(k.Algorithm)
+		limit, remaining, _ = alg.status(alg.fresh(now, k), k.Window, k)
+		resetAt = now
 	}
+	return limit, remaining, resetAt
+}
 
-	// Time until full replenishment from current level.
-	deficit := float64(rate) - b.tokens
-	if deficit <= 0 {
-		resetAt = l.now()
-	} else {
-		refillRate := float64(rate) / l.window.Seconds()
-		resetAt = l.now().Add(time.Duration(deficit/refillRate*1e9) * time.Nanosecond)
+// tightest returns the status of the most restrictive of keys: the one with
+// the fewest requests left, and of those the one furthest from resetting.
+func (l *Limiter) tightest(keys []BucketKey) (limit int, remaining int, resetAt time.Time) {
+	for i, k := range keys {
+		lim, rem, rst := l.status(k)
+		if i == 0 || rem < remaining || rem == remaining && rst.After(resetAt) {
+			limit, remaining, resetAt = lim, rem, rst
+		}
 	}
-	return
+	return limit, remaining, resetAt
 }
@@ -1,6 +1,7 @@
 package ratelimit
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -235,3 +236,195 @@ func TestStatusFullBucketResetIsNow(t *testing.T) {
 		t.Fatalf("full bucket resetAt should equal now, got diff %v", resetAt.Sub(now))
 	}
 }
+
+func TestWait(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	l := newTestLimiter(2, time.Minute, clock)
+	var slept []time.Duration
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		clock.Advance(d)
+		return nil
+	}
+
+	// Tokens on hand are taken without waiting.
+	for i := 0; i < 2; i++ {
+		if ok, err := l.Wait(context.Background(), "w", 0, time.Minute); !ok || err != nil {
+			t.Fatalf("request %d should be allowed, got %v %v", i+1, ok, err)
+		}
+	}
+	if len(slept) != 0 {
+		t.Fatalf("expected no wait while tokens remain, slept %v", slept)
+	}
+
+	// At 2/min the next token refills in 30 seconds.
+	if ok, _ := l.Wait(context.Background(), "w", 0, 10*time.Second); ok {
+		t.Fatal("expected request rejected when the token is further off than maxWait")
+	}
+	if len(slept) != 0 {
+		t.Fatalf("expected no wait for a request that cannot succeed, slept %v", slept)
+	}
+	if ok, _ := l.Wait(context.Background(), "w", 0, time.Minute); !ok {
+		t.Fatal("expected request allowed after waiting")
+	}
+	if len(slept) != 1 || slept[0] != 30*time.Second {
+		t.Fatalf("expected a 30s wait, slept %v", slept)
+	}
+}
+
+func TestWaitQueuesBehindEarlierWaiters(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	l := newTestLimiter(2, time.Minute, clock)
+	l.Allow("w", 0)
+	l.Allow("w", 0)
+
+	// Reservations are taken in order, so each waiter is due one refill
+	// interval after the one before it.
+	for i, want := range []time.Duration{30 * time.Second, time.Minute} {
+		wait, ok, _ := l.backend.Reserve(context.Background(), clock.Now(), time.Minute, []BucketKey{{Key: "w", Rate: 2}}, time.Minute)
+		if !ok || wait != want {
+			t.Fatalf("waiter %d: expected %v, got %v %v", i+1, want, wait, ok)
+		}
+	}
+	if _, ok, _ := l.backend.Reserve(context.Background(), clock.Now(), time.Minute, []BucketKey{{Key: "w", Rate: 2}}, time.Minute); ok {
+		t.Fatal("expected third waiter rejected beyond maxWait")
+	}
+}
+
+func TestWaitCancelledReturnsToken(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	l := newTestLimiter(1, time.Minute, clock)
+	l.Allow("w", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if ok, err := l.Wait(ctx, "w", 0, time.Minute); ok || err != context.Canceled {
+		t.Fatalf("expected cancelled wait to fail with context.Canceled, got %v %v", ok, err)
+	}
+
+	// The abandoned reservation no longer delays the next request.
+	clock.Advance(time.Minute)
+	if !l.Allow("w", 0) {
+		t.Fatal("expected token refilled after the cancelled wait")
+	}
+}
+
+func TestForgetOverride(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	l := newTestLimiter(1, time.Minute, clock)
+	trl := NewToolRateLimiter(nil, l)
+
+	key := toolBucketKey("tool-1", "agent", "agent-1")
+	if key != "tool:tool-1:agent:agent-1" {
+		t.Fatalf("unexpected bucket key %q", key)
+	}
+	l.Allow(key, 1)
+	if l.Allow(key, 1) {
+		t.Fatal("expected bucket drained")
+	}
+
+	window := WindowLimit{Limit: 1, WindowSeconds: 1}
+	windowKey := windowBucketKey(key, window)
+	l.Allow(windowKey, 1)
+
+	trl.ForgetOverride(ToolRateOverride{ToolID: "tool-1", Scope: "agent", ScopeID: "agent-1", Windows: []WindowLimit{window}})
+	if !l.Allow(key, 1) {
+		t.Error("expected a fresh bucket after the override was forgotten")
+	}
+	if !l.Allow(windowKey, 1) {
+		t.Error("expected a fresh window bucket after the override was forgotten")
+	}
+}
+
+func TestForgetToolAndAgent(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	l := newTestLimiter(1, time.Minute, clock)
+	trl := NewToolRateLimiter(nil, l)
+
+	window := WindowLimit{Limit: 1, WindowSeconds: 1}
+	agentKey := toolBucketKey("tool-2", "agent", "agent-1")
+	keys := []string{
+		toolBucketKey("tool-1", "global", ""),
+		windowBucketKey(toolBucketKey("tool-1", "global", ""), window),
+		toolBucketKey("tool-1", "team", "ml"),
+		toolBucketKey("tool-1", "agent", "agent-2"),
+		"agent-1",
+		agentKey,
+		windowBucketKey(agentKey, window),
+		toolBucketKey("tool-2", "agent", "agent-2"),
+	}
+	for _, key := range keys {
+		l.Allow(key, 1)
+	}
+
+	trl.ForgetTool("tool-1")
+	trl.ForgetAgent("agent-1")
+	for i, key := range keys {
+		want := i < len(keys)-1 // only agent-2's bucket on tool-2 is kept
+		if got := l.Allow(key, 1); got != want {
+			t.Errorf("%s: fresh bucket = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestOverrideWindows(t *testing.T) {
+	clock := newFakeClock(time.Now())
+	l := newTestLimiter(60, time.Minute, clock)
+	ctx := context.Background()
+
+	// 2 a second and 3 a day, with no per-minute rate.
+	checks := overrideBuckets("tool-1", "agent", "agent-1", ToolRateOverride{
+		Windows: []WindowLimit{{Limit: 2, WindowSeconds: 1}, {Limit: 3, WindowSeconds: 86400}},
+	})
+	if len(checks) != 2 || checks[0].Key != "tool:tool-1:agent:agent-1/1s" || checks[1].Key != "tool:tool-1:agent:agent-1/86400s" {
+		t.Fatalf("unexpected buckets %+v", checks)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, ok, _ := l.waitAll(ctx, checks, 0); !ok {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	retryAfter, ok, _ := l.waitAll(ctx, checks, 0)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Fatalf("expected per-second rejection retrying after 500ms, got %v %v", ok, retryAfter)
+	}
+
+	clock.Advance(time.Second)
+	if _, ok, _ := l.waitAll(ctx, checks, 0); !ok {
+		t.Fatal("should be allowed once the per-second window refills")
+	}
+
+	// The day's 3 requests are spent, so the daily window is the tightest.
+	clock.Advance(time.Second)
+	retryAfter, ok, _ = l.waitAll(ctx, checks, 0)
+	if ok || retryAfter < 7*time.Hour {
+		t.Fatalf("expected daily rejection retrying after about 8h, got %v %v", ok, retryAfter)
+	}
+	limit, remaining, resetAt := l.tightest(checks)
+	if limit != 3 || remaining != 0 || resetAt.Sub(clock.Now()) < 23*time.Hour {
+		t.Fatalf("expected the daily window reported, got %d of %d resetting in %v", remaining, limit, resetAt.Sub(clock.Now()))
+	}
+}
+
+func TestValidWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []WindowLimit
+		want    bool
+	}{
+		{"none", nil, true},
+		{"second and day", []WindowLimit{{10, 1}, {10000, 86400}}, true},
+		{"zero limit", []WindowLimit{{0, 1}}, false},
+		{"zero window", []WindowLimit{{10, 0}}, false},
+		{"longer than 31 days", []WindowLimit{{10, MaxWindowSeconds + 1}}, false},
+		{"duplicate window", []WindowLimit{{10, 60}, {20, 60}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidWindows(tt.windows); got != tt.want {
+				t.Errorf("ValidWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -17,62 +17,100 @@ func NewToolRateLimiter(store *ToolRateLimitStore, limiter *Limiter) *ToolRateLi
 	return &ToolRateLimiter{store: store, limiter: limiter}
 }
 
-// CheckToolRateLimit resolves the applicable rates for the tool and checks all
-// non-zero buckets. All buckets must allow for the request to proceed. Returns
-// the tightest limit info for response headers.
-func (trl *ToolRateLimiter) CheckToolRateLimit(ctx context.Context, toolID, team, agentID string) (allowed bool, limit, remaining int, resetAt time.Time, err error) {
-	globalRate, teamRate, agentRate, err := trl.store.Resolve(ctx, toolID, team, agentID)
+// CheckToolRateLimit resolves the applicable limits for the tool and checks all
+// non-zero buckets: each scope's rate limit per limiter window and each of its
+// extra windows, with its override's algorithm or the limiter's default. All
+// buckets must allow for the request to proceed. Returns the most restrictive
+// limit info for response headers, and on rejection how long until the request
+// would be allowed. With a positive maxWait the request instead waits, up to
+// maxWait, for every bucket to have a token, and err is ctx's error if ctx is
+// done first. Backend errors are returned too.
+func (trl *ToolRateLimiter) CheckToolRateLimit(ctx context.Context, toolID, team, agentID string, maxWait time.Duration) (allowed bool, limit, remaining int, resetAt time.Time, retryAfter time.Duration, err error) {
+	global, teamLimit, agentLimit, err := trl.store.Resolve(ctx, toolID, team, agentID)
 	if err != nil {
-		return false, 0, 0, time.Time{}, err
+		return false, 0, 0, time.Time{}, 0, err
 	}
 
+	checks := overrideBuckets(toolID, "global", "", global)
+	if team != "" {
+		checks = append(checks, overrideBuckets(toolID, "team", team, teamLimit)...)
+	}
+	checks = append(checks, overrideBuckets(toolID, "agent", agentID, agentLimit)...)
+
 	// No tool-level rate limits configured at all.
-	if globalRate == 0 && teamRate == 0 && agentRate == 0 {
-		return true, 0, 0, time.Time{}, nil
+	if len(checks) == 0 {
+		return true, 0, 0, time.Time{}, 0, nil
 	}
 
-	type scopeCheck struct {
-		key  string
-		rate int
+	for i, c := range checks {
+		checks[i] = trl.limiter.withDefaults(c)
 	}
 
-	var checks []scopeCheck
-	if globalRate > 0 {
-		checks = append(checks, scopeCheck{
-			key:  fmt.Sprintf("tool:%s", toolID),
-			rate: globalRate,
-		})
+	// All buckets must allow; tokens are taken from all of them or none.
+	retryAfter, allowed, err = trl.limiter.waitAll(ctx, checks, maxWait)
+	if err != nil {
+		return false, 0, 0, time.Time{}, 0, err
 	}
-	if teamRate > 0 && team != "" {
-		checks = append(checks, scopeCheck{
-			key:  fmt.Sprintf("tool:%s:team:%s", toolID, team),
-			rate: teamRate,
-		})
+
+	limit, remaining, resetAt = trl.limiter.tightest(checks)
+	return allowed, limit, remaining, resetAt, retryAfter, nil
+}
+
+// toolBucketKey returns the limiter key of a tool's bucket at the given
+// scope: "global", or "team" or "agent" with the team name or agent ID.
+func toolBucketKey(toolID, scope, scopeID string) string {
+	if scope == "global" {
+		return fmt.Sprintf("tool:%s", toolID)
 	}
-	if agentRate > 0 {
-		checks = append(checks, scopeCheck{
-			key:  fmt.Sprintf("tool:%s:agent:%s", toolID, agentID),
-			rate: agentRate,
+	return fmt.Sprintf("tool:%s:%s:%s", toolID, scope, scopeID)
+}
+
+// windowBucketKey returns the limiter key of one of a scope's extra windows.
+func windowBucketKey(scopeKey string, w WindowLimit) string {
+	return fmt.Sprintf("%s/%ds", scopeKey, w.WindowSeconds)
+}
+
+// overrideBuckets returns the buckets enforcing o at the given scope. Extra
+// windows use o's algorithm but allow bursts of their whole limit.
+func overrideBuckets(toolID, scope, scopeID string, o ToolRateOverride) []BucketKey {
+	key := toolBucketKey(toolID, scope, scopeID)
+	var buckets []BucketKey
+	if o.RateLimit > 0 {
+		buckets = append(buckets, BucketKey{Key: key, Rate: o.RateLimit, Algorithm: o.Algorithm, Burst: o.Burst})
+	}
+	for _, w := range o.Windows {
+		buckets = append(buckets, BucketKey{
+			Key:       windowBucketKey(key, w),
+			Rate:      w.Limit,
+			Window:    time.Duration(w.WindowSeconds) * time.Second,
+			Algorithm: o.Algorithm,
+			Burst:     w.Limit,
 		})
 	}
+	return buckets
+}
 
-	if len(checks) == 0 {
-		return true, 0, 0, time.Time{}, nil
+// ForgetOverride drops the buckets of a deleted team or agent override. It
+// suits ToolRateLimitStore.OnDelete.
+func (trl *ToolRateLimiter) ForgetOverride(deleted ToolRateOverride) {
+	key := toolBucketKey(deleted.ToolID, deleted.Scope, deleted.ScopeID)
+	keys := []string{key}
+	for _, w := range deleted.Windows {
+		keys = append(keys, windowBucketKey(key, w))
 	}
+	trl.limiter.Forget(keys...)
+}
 
-	// All buckets must allow. Track the tightest for headers.
-	allowed = true
-	for _, c := range checks {
-		if !trl.limiter.Allow(c.key, c.rate) {
-			allowed = false
-		}
-		l, r, rst := trl.limiter.Status(c.key, c.rate)
-		if limit == 0 || l < limit {
-			limit = l
-			remaining = r
-			resetAt = rst
-		}
-	}
+// ForgetTool drops every bucket of a deleted tool: its global limit and its
+// team and agent overrides, with their extra windows.
+func (trl *ToolRateLimiter) ForgetTool(toolID string) {
+	key := toolBucketKey(toolID, "global", "")
+	trl.limiter.ForgetMatching(key, key+"/*", key+":*")
+}
 
-	return allowed, limit, remaining, resetAt, nil
+// ForgetAgent drops every bucket of a deleted agent: its own rate limit,
+// which Middleware keys by its ID, and its overrides on any tool.
+func (trl *ToolRateLimiter) ForgetAgent(agentID string) {
+	key := toolBucketKey("*", "agent", agentID)
+	trl.limiter.ForgetMatching(agentID, key, key+"/*")
 }
@@ -2,6 +2,8 @@ package ratelimit
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/jackc/pgx/v5"
@@ -9,17 +11,77 @@ import (
 )
 
 // ToolRateOverride represents a team- or agent-scoped rate limit override for a tool.
+// RateLimit applies per limiter window, and each of Windows is enforced as
+// well; either may be left out. An empty Algorithm and zero Burst fall back to
+// the configured defaults.
 type ToolRateOverride struct {
-	ID        string `json:"id"`
-	ToolID    string `json:"tool_id"`
-	Scope     string `json:"scope"`
-	ScopeID   string `json:"scope_id"`
-	RateLimit int    `json:"rate_limit"`
+	ID        string        `json:"id"`
+	ToolID    string        `json:"tool_id"`
+	Scope     string        `json:"scope"`
+	ScopeID   string        `json:"scope_id"`
+	RateLimit int           `json:"rate_limit"`
+	Algorithm string        `json:"algorithm,omitempty"`
+	Burst     int           `json:"burst,omitempty"`
+	Windows   []WindowLimit `json:"windows"`
+}
+
+// WindowLimit is a limit of Limit requests per WindowSeconds, such as 10 a
+// second or 10,000 a day.
+type WindowLimit struct {
+	Limit         int `json:"limit"`
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// MaxWindows caps how many windows one scope may define.
+const MaxWindows = 10
+
+// MaxWindowSeconds caps a window at 31 days.
+const MaxWindowSeconds = 31 * 24 * 60 * 60
+
+// ValidWindows reports whether windows are usable: at most MaxWindows, each
+// with a positive limit and a distinct window of one second to
+// MaxWindowSeconds.
+func ValidWindows(windows []WindowLimit) bool {
+	if len(windows) > MaxWindows {
+		return false
+	}
+	seen := make(map[int]bool, len(windows))
+	for _, w := range windows {
+		if w.Limit <= 0 || w.WindowSeconds <= 0 || w.WindowSeconds > MaxWindowSeconds || seen[w.WindowSeconds] {
+			return false
+		}
+		seen[w.WindowSeconds] = true
+	}
+	return true
 }
 
 // ToolRateLimitStore provides CRUD for tool_rate_limits and resolution of effective rates.
 type ToolRateLimitStore struct {
-	pool *pgxpool.Pool
+	pool     *pgxpool.Pool
+	onDelete []func(deleted ToolRateOverride)
+}
+
+// marshalWindows encodes windows for a JSONB column.
+func marshalWindows(windows []WindowLimit) ([]byte, error) {
+	if windows == nil {
+		windows = []WindowLimit{}
+	}
+	b, err := json.Marshal(windows)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling rate limit windows: %w", err)
+	}
+	return b, nil
+}
+
+// unmarshalWindows decodes a JSONB windows column.
+func unmarshalWindows(raw []byte) ([]WindowLimit, error) {
+	windows := []WindowLimit{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &windows); err != nil {
+			return nil, fmt.Errorf("unmarshalling rate limit windows: %w", err)
+		}
+	}
+	return windows, nil
 }
 
 // NewToolRateLimitStore creates a new ToolRateLimitStore.
@@ -30,7 +92,7 @@ func NewToolRateLimitStore(pool *pgxpool.Pool) *ToolRateLimitStore {
 // ListByTool returns all rate limit overrides for the given tool.
 func (s *ToolRateLimitStore) ListByTool(ctx context.Context, toolID string) ([]ToolRateOverride, error) {
 	rows, err := s.pool.Query(ctx,
-		`SELECT id, tool_id, scope, scope_id, rate_limit
+		`SELECT id, tool_id, scope, scope_id, rate_limit, COALESCE(algorithm, ''), COALESCE(burst, 0), windows
 		 FROM tool_rate_limits WHERE tool_id = $1 ORDER BY scope, scope_id`, toolID)
 	if err != nil {
 		return nil, fmt.Errorf("listing tool rate limits: %w", err)
@@ -39,59 +101,100 @@ func (s *ToolRateLimitStore) ListByTool(ctx context.Context, toolID string) ([]T
 
 	var overrides []ToolRateOverride
 	for rows.Next() {
-		var o ToolRateOverride
-		if err := rows.Scan(&o.ID, &o.ToolID, &o.Scope, &o.ScopeID, &o.RateLimit); err != nil {
+		var (
+			o       ToolRateOverride
+			windows []byte
+		)
+		if err := rows.Scan(&o.ID, &o.ToolID, &o.Scope, &o.ScopeID, &o.RateLimit, &o.Algorithm, &o.Burst, &windows); err != nil {
 			return nil, fmt.Errorf("scanning tool rate limit: %w", err)
 		}
+		if o.Windows, err = unmarshalWindows(windows); err != nil {
+			return nil, err
+		}
 		overrides = append(overrides, o)
 	}
 	return overrides, rows.Err()
 }
 
 // Set upserts a rate limit override for a tool+scope+scopeID combination.
-func (s *ToolRateLimitStore) Set(ctx context.Context, toolID, scope, scopeID string, rate int) error {
-	_, err := s.pool.Exec(ctx,
-		`INSERT INTO tool_rate_limits (tool_id, scope, scope_id, rate_limit)
-		 VALUES ($1, $2, $3, $4)
-		 ON CONFLICT (tool_id, scope, scope_id) DO UPDATE SET rate_limit = EXCLUDED.rate_limit`,
-		toolID, scope, scopeID, rate)
+// o.ID is ignored.
+func (s *ToolRateLimitStore) Set(ctx context.Context, o ToolRateOverride) error {
+	windows, err := marshalWindows(o.Windows)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx,
+		`INSERT INTO tool_rate_limits (tool_id, scope, scope_id, rate_limit, algorithm, burst, windows)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, 0), $7)
+		 ON CONFLICT (tool_id, scope, scope_id) DO UPDATE
+		 SET rate_limit = EXCLUDED.rate_limit, algorithm = EXCLUDED.algorithm, burst = EXCLUDED.burst,
+		     windows = EXCLUDED.windows`,
+		o.ToolID, o.Scope, o.ScopeID, o.RateLimit, o.Algorithm, o.Burst, windows)
 	if err != nil {
 		return fmt.Errorf("upserting tool rate limit: %w", err)
 	}
 	return nil
 }
 
+// OnDelete registers fn to be called with each deleted override, so callers
+// holding state for it (such as limiter buckets) can drop it.
+func (s *ToolRateLimitStore) OnDelete(fn func(deleted ToolRateOverride)) {
+	s.onDelete = append(s.onDelete, fn)
+}
+
 // Delete removes a rate limit override for a tool+scope+scopeID combination.
 func (s *ToolRateLimitStore) Delete(ctx context.Context, toolID, scope, scopeID string) error {
-	tag, err := s.pool.Exec(ctx,
-		`DELETE FROM tool_rate_limits WHERE tool_id = $1 AND scope = $2 AND scope_id = $3`,
-		toolID, scope, scopeID)
+	deleted := ToolRateOverride{ToolID: toolID, Scope: scope, ScopeID: scopeID}
+	var windows []byte
+	err := s.pool.QueryRow(ctx,
+		`DELETE FROM tool_rate_limits WHERE tool_id = $1 AND scope = $2 AND scope_id = $3
+		 RETURNING id, rate_limit, windows`,
+		toolID, scope, scopeID).Scan(&deleted.ID, &deleted.RateLimit, &windows)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("deleting tool rate limit: %w", err)
 	}
-	if tag.RowsAffected() == 0 {
-		return pgx.ErrNoRows
+	if deleted.Windows, err = unmarshalWindows(windows); err != nil {
+		return err
+	}
+	for _, fn := range s.onDelete {
+		fn(deleted)
 	}
 	return nil
 }
 
-// Resolve returns the effective rate limits for a tool across all three scopes.
-// globalRate comes from tools.rate_limit, teamRate and agentRate from tool_rate_limits.
-// A zero value means no limit is configured for that scope.
-func (s *ToolRateLimitStore) Resolve(ctx context.Context, toolID, team, agentID string) (globalRate, teamRate, agentRate int, err error) {
+// Resolve returns the effective rate limits for a tool across all three
+// scopes. The global limit comes from the tool's rate_limit and
+// rate_limit_windows and always uses the default algorithm; the team and agent
+// limits come from tool_rate_limits. A zero RateLimit with no Windows means no
+// limit is configured for that scope.
+func (s *ToolRateLimitStore) Resolve(ctx context.Context, toolID, team, agentID string) (global, teamLimit, agentLimit ToolRateOverride, err error) {
+	var globalWindows, teamWindows, agentWindows []byte
 	err = s.pool.QueryRow(ctx, `
 		SELECT
-			COALESCE(t.rate_limit, 0),
-			COALESCE((SELECT trl.rate_limit FROM tool_rate_limits trl
-			          WHERE trl.tool_id = t.id AND trl.scope = 'team' AND trl.scope_id = $2), 0),
-			COALESCE((SELECT trl.rate_limit FROM tool_rate_limits trl
-			          WHERE trl.tool_id = t.id AND trl.scope = 'agent' AND trl.scope_id = $3), 0)
+			COALESCE(t.rate_limit, 0), t.rate_limit_windows,
+			COALESCE(tr.rate_limit, 0), COALESCE(tr.algorithm, ''), COALESCE(tr.burst, 0), tr.windows,
+			COALESCE(ar.rate_limit, 0), COALESCE(ar.algorithm, ''), COALESCE(ar.burst, 0), ar.windows
 		FROM tools t
+		LEFT JOIN tool_rate_limits tr ON tr.tool_id = t.id AND tr.scope = 'team' AND tr.scope_id = $2
+		LEFT JOIN tool_rate_limits ar ON ar.tool_id = t.id AND ar.scope = 'agent' AND ar.scope_id = $3
 		WHERE t.id = $1`,
 		toolID, team, agentID,
-	).Scan(&globalRate, &teamRate, &agentRate)
+	).Scan(&global.RateLimit, &globalWindows,
+		&teamLimit.RateLimit, &teamLimit.Algorithm, &teamLimit.Burst, &teamWindows,
+		&agentLimit.RateLimit, &agentLimit.Algorithm, &agentLimit.Burst, &agentWindows)
 	if err != nil {
 		err = fmt.Errorf("resolving tool rate limits: %w", err)
+		return
+	}
+	if global.Windows, err = unmarshalWindows(globalWindows); err != nil {
+		return
+	}
+	if teamLimit.Windows, err = unmarshalWindows(teamWindows); err != nil {
+		return
 	}
+	agentLimit.Windows, err = unmarshalWindows(agentWindows)
 	return
 }
@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// grantEffectSQL returns the SQL expression that resolves the effective grant
+// ('allow' or 'deny') for the tool referenced by toolRef. An agent grant wins
+// over a team grant. With no matching grant, a tool that has any allow grants
+// is treated as an allowlist (deny); otherwise it is open to everyone (allow).
+func grantEffectSQL(toolRef string, teamArg, agentArg int) string {
+	return fmt.Sprintf(`COALESCE(
+		(SELECT g.effect FROM tool_grants g
+		 WHERE g.tool_id = %[1]s AND g.scope = 'agent' AND g.scope_id = $%[3]d),
+		(SELECT g.effect FROM tool_grants g
+		 WHERE g.tool_id = %[1]s AND g.scope = 'team' AND g.scope_id = $%[2]d),
+		CASE WHEN EXISTS (SELECT 1 FROM tool_grants g
+		                  WHERE g.tool_id = %[1]s AND g.effect = 'allow')
+		     THEN 'deny' ELSE 'allow' END)`, toolRef, teamArg, agentArg)
+}
+
+// Where a grant was set: by an org admin through the admin API, or by a team
+// admin through the member API.
+const (
+	GrantSourceAdmin  = "admin"
+	GrantSourceMember = "member"
+)
+
+// ErrAdminGrant is returned when a team admin tries to change or delete a
+// grant an org admin set.
+var ErrAdminGrant = errors.New("grant was set by an org admin")
+
+// GrantStore provides CRUD for tool_grants and resolution of tool access.
+type GrantStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewGrantStore creates a new GrantStore.
+func NewGrantStore(pool *pgxpool.Pool) *GrantStore {
+	return &GrantStore{pool: pool}
+}
+
+// ListByTool returns all grants for the given tool.
+func (s *GrantStore) ListByTool(ctx context.Context, toolID string) ([]Grant, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT id, tool_id, scope, scope_id, effect, source, created_at
+		 FROM tool_grants WHERE tool_id = $1 ORDER BY scope, scope_id`, toolID)
+	if err != nil {
+		return nil, fmt.Errorf("listing tool grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []Grant
+	for rows.Next() {
+		var g Grant
+		if err := rows.Scan(&g.ID, &g.ToolID, &g.Scope, &g.ScopeID, &g.Effect, &g.Source, &g.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning tool grant: %w", err)
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// Get returns the grant for a tool+scope+scopeID combination.
+func (s *GrantStore) Get(ctx context.Context, toolID, scope, scopeID string) (*Grant, error) {
+	var g Grant
+	err := s.pool.QueryRow(ctx,
+		`SELECT id, tool_id, scope, scope_id, effect, source, created_at
+		 FROM tool_grants WHERE tool_id = $1 AND scope = $2 AND scope_id = $3`,
+		toolID, scope, scopeID,
+	).Scan(&g.ID, &g.ToolID, &g.Scope, &g.ScopeID, &g.Effect, &g.Source, &g.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("getting tool grant: %w", err)
+	}
+	return &g, nil
+}
+
+// Set upserts a grant for a tool+scope+scopeID combination, recording source
+// as where it was set. A member grant does not replace an admin one; Set
+// returns ErrAdminGrant instead.
+func (s *GrantStore) Set(ctx context.Context, toolID, scope, scopeID, effect, source string) (*Grant, error) {
+	var g Grant
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO tool_grants (tool_id, scope, scope_id, effect, source)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (tool_id, scope, scope_id) DO UPDATE
+		   SET effect = EXCLUDED.effect, source = EXCLUDED.source
+		   WHERE EXCLUDED.source = 'admin' OR tool_grants.source = EXCLUDED.source
+		 RETURNING id, tool_id, scope, scope_id, effect, source, created_at`,
+		toolID, scope, scopeID, effect, source,
+	).Scan(&g.ID, &g.ToolID, &g.Scope, &g.ScopeID, &g.Effect, &g.Source, &g.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAdminGrant
+	}
+	if err != nil {
+		return nil, fmt.Errorf("upserting tool grant: %w", err)
+	}
+	return &g, nil
+}
+
+// Delete removes the grant for a tool+scope+scopeID combination. An admin
+// may delete any grant, a member only those members set; for the rest Delete
+// returns ErrAdminGrant.
+func (s *GrantStore) Delete(ctx context.Context, toolID, scope, scopeID, source string) error {
+	var found string
+	err := s.pool.QueryRow(ctx,
+		`WITH g AS (
+		   SELECT id, source FROM tool_grants
+		   WHERE tool_id = $1 AND scope = $2 AND scope_id = $3
+		   FOR UPDATE
+		 ), d AS (
+		   DELETE FROM tool_grants
+		   WHERE id IN (SELECT id FROM g WHERE $4 = 'admin' OR source = $4)
+		 )
+		 SELECT source FROM g`,
+		toolID, scope, scopeID, source,
+	).Scan(&found)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return pgx.ErrNoRows
+	}
+	if err != nil {
+		return fmt.Errorf("deleting tool grant: %w", err)
+	}
+	if source != GrantSourceAdmin && found != source {
+		return ErrAdminGrant
+	}
+	return nil
+}
+
+// CheckToolAccess reports whether the agent (a member of team) may call the tool.
+func (s *GrantStore) CheckToolAccess(ctx context.Context, toolID, team, agentID string) (bool, error) {
+	var effect string
+	err := s.pool.QueryRow(ctx,
+		fmt.Sprintf(`SELECT %s FROM tools t WHERE t.id = $1`, grantEffectSQL("t.id", 2, 3)),
+		toolID, team, agentID,
+	).Scan(&effect)
+	if err != nil {
+		return false, fmt.Errorf("checking tool access: %w", err)
+	}
+	return effect == "allow", nil
+}
@@ -0,0 +1,178 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// openTestPool connects to the migrated database named by
+// OCTROI_TEST_DATABASE_URL, e.g. the docker compose Postgres, and skips the
+// test when it is not set.
+func openTestPool(tb testing.TB) *pgxpool.Pool {
+	tb.Helper()
+	url := os.Getenv("OCTROI_TEST_DATABASE_URL")
+	if url == "" {
+		tb.Skip("OCTROI_TEST_DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		tb.Fatalf("connecting to postgres: %v", err)
+	}
+	tb.Cleanup(pool.Close)
+	return pool
+}
+
+func TestCheckToolAccessPrecedence(t *testing.T) {
+	pool := openTestPool(t)
+	ctx := context.Background()
+	store := NewGrantStore(pool)
+
+	type grant struct{ scope, scopeID, effect string }
+	type access struct {
+		team, agentID string
+		want          bool
+	}
+	tests := []struct {
+		name   string
+		grants []grant
+		checks []access
+	}{
+		{
+			name: "no grants is open to everyone",
+			checks: []access{
+				{"ml", "agent-ml", true},
+				{"web", "agent-web", true},
+			},
+		},
+		{
+			name:   "team deny blocks only that team",
+			grants: []grant{{"team", "ml", "deny"}},
+			checks: []access{
+				{"ml", "agent-ml", false},
+				{"web", "agent-web", true},
+			},
+		},
+		{
+			name:   "any allow grant makes the tool an allow list",
+			grants: []grant{{"team", "ml", "allow"}},
+			checks: []access{
+				{"ml", "agent-ml", true},
+				{"web", "agent-web", false},
+				{"", "agent-none", false},
+			},
+		},
+		{
+			name:   "agent allow in an allow list admits another team's agent",
+			grants: []grant{{"team", "ml", "allow"}, {"agent", "agent-web", "allow"}},
+			checks: []access{
+				{"web", "agent-web", true},
+				{"web", "agent-web-2", false},
+			},
+		},
+		{
+			name:   "agent allow beats team deny",
+			grants: []grant{{"team", "ml", "deny"}, {"agent", "agent-ml", "allow"}},
+			checks: []access{
+				{"ml", "agent-ml", true},
+				{"ml", "agent-ml-2", false},
+				// The agent allow also turns the tool into an allow list.
+				{"web", "agent-web", false},
+			},
+		},
+		{
+			name:   "agent deny beats team allow",
+			grants: []grant{{"team", "ml", "allow"}, {"agent", "agent-ml", "deny"}},
+			checks: []access{
+				{"ml", "agent-ml", false},
+				{"ml", "agent-ml-2", true},
+			},
+		},
+		{
+			name:   "agent deny on an open tool",
+			grants: []grant{{"agent", "agent-ml", "deny"}},
+			checks: []access{
+				{"ml", "agent-ml", false},
+				{"ml", "agent-ml-2", true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var toolID string
+			err := pool.QueryRow(ctx,
+				`INSERT INTO tools (name, description, endpoint)
+				 VALUES ('grant-test', 'test', 'https://example.com') RETURNING id`,
+			).Scan(&toolID)
+			if err != nil {
+				t.Fatalf("inserting tool: %v", err)
+			}
+			t.Cleanup(func() { pool.Exec(ctx, `DELETE FROM tools WHERE id = $1`, toolID) })
+
+			for _, g := range tt.grants {
+				if _, err := store.Set(ctx, toolID, g.scope, g.scopeID, g.effect, GrantSourceAdmin); err != nil {
+					t.Fatalf("setting %s grant for %s %s: %v", g.effect, g.scope, g.scopeID, err)
+				}
+			}
+			for _, c := range tt.checks {
+				got, err := store.CheckToolAccess(ctx, toolID, c.team, c.agentID)
+				if err != nil {
+					t.Fatalf("checking access for %s: %v", c.agentID, err)
+				}
+				if got != c.want {
+					t.Errorf("agent %s in team %q: allowed = %v, want %v", c.agentID, c.team, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGrantSource(t *testing.T) {
+	pool := openTestPool(t)
+	ctx := context.Background()
+	store := NewGrantStore(pool)
+
+	var toolID string
+	err := pool.QueryRow(ctx,
+		`INSERT INTO tools (name, description, endpoint)
+		 VALUES ('grant-source-test', 'test', 'https://example.com') RETURNING id`,
+	).Scan(&toolID)
+	if err != nil {
+		t.Fatalf("inserting tool: %v", err)
+	}
+	t.Cleanup(func() { pool.Exec(ctx, `DELETE FROM tools WHERE id = $1`, toolID) })
+
+	if _, err := store.Set(ctx, toolID, "team", "ml", "deny", GrantSourceAdmin); err != nil {
+		t.Fatalf("setting admin deny: %v", err)
+	}
+	if _, err := store.Set(ctx, toolID, "team", "ml", "deny", GrantSourceMember); !errors.Is(err, ErrAdminGrant) {
+		t.Errorf("member replacing admin grant: err = %v, want ErrAdminGrant", err)
+	}
+	if err := store.Delete(ctx, toolID, "team", "ml", GrantSourceMember); !errors.Is(err, ErrAdminGrant) {
+		t.Errorf("member deleting admin grant: err = %v, want ErrAdminGrant", err)
+	}
+	if g, err := store.Get(ctx, toolID, "team", "ml"); err != nil || g.Source != GrantSourceAdmin {
+		t.Fatalf("admin grant after member attempts = %+v, %v", g, err)
+	}
+
+	g, err := store.Set(ctx, toolID, "agent", "agent-ml", "deny", GrantSourceMember)
+	if err != nil {
+		t.Fatalf("setting member deny: %v", err)
+	}
+	if g.Source != GrantSourceMember {
+		t.Errorf("source = %q, want %q", g.Source, GrantSourceMember)
+	}
+	if err := store.Delete(ctx, toolID, "agent", "agent-ml", GrantSourceMember); err != nil {
+		t.Errorf("member deleting member grant: %v", err)
+	}
+	if err := store.Delete(ctx, toolID, "agent", "agent-ml", GrantSourceMember); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("deleting missing grant: err = %v, want pgx.ErrNoRows", err)
+	}
+	if err := store.Delete(ctx, toolID, "team", "ml", GrantSourceAdmin); err != nil {
+		t.Errorf("admin deleting admin grant: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// HealthStore records the history of active tool health checks.
+type HealthStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewHealthStore creates a new HealthStore.
+func NewHealthStore(pool *pgxpool.Pool) *HealthStore {
+	return &HealthStore{pool: pool}
+}
+
+// Record stores the result of one health check.
+func (s *HealthStore) Record(ctx context.Context, c HealthCheck) error {
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO tool_health_checks (tool_id, checked_at, up, status_code, latency_ms, error)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		c.ToolID, c.CheckedAt, c.Up, c.StatusCode, c.LatencyMs, c.Error)
+	if err != nil {
+		return fmt.Errorf("recording health check: %w", err)
+	}
+	return nil
+}
+
+// ListByTool returns the most recent health checks of a tool, newest first.
+func (s *HealthStore) ListByTool(ctx context.Context, toolID string, limit int) ([]HealthCheck, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT id, tool_id, checked_at, up, status_code, latency_ms, error
+		 FROM tool_health_checks WHERE tool_id = $1
+		 ORDER BY checked_at DESC, id DESC LIMIT $2`, toolID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing health checks: %w", err)
+	}
+	defer rows.Close()
+
+	checks := []HealthCheck{}
+	for rows.Next() {
+		var c HealthCheck
+		if err := rows.Scan(&c.ID, &c.ToolID, &c.CheckedAt, &c.Up, &c.StatusCode, &c.LatencyMs, &c.Error); err != nil {
+			return nil, fmt.Errorf("scanning health check: %w", err)
+		}
+		checks = append(checks, c)
+	}
+	return checks, rows.Err()
+}
+
+// Latest returns the most recent health check of every tool that has one,
+// keyed by tool ID.
+func (s *HealthStore) Latest(ctx context.Context) (map[string]HealthCheck, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT DISTINCT ON (tool_id) id, tool_id, checked_at, up, status_code, latency_ms, error
+		 FROM tool_health_checks ORDER BY tool_id, checked_at DESC, id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("listing latest health checks: %w", err)
+	}
+	defer rows.Close()
+
+	latest := make(map[string]HealthCheck)
+	for rows.Next() {
+		var c HealthCheck
+		if err := rows.Scan(&c.ID, &c.ToolID, &c.CheckedAt, &c.Up, &c.StatusCode, &c.LatencyMs, &c.Error); err != nil {
+			return nil, fmt.Errorf("scanning health check: %w", err)
+		}
+		latest[c.ToolID] = c
+	}
+	return latest, rows.Err()
+}
+
+// Prune deletes health checks older than before and returns how many were
+// removed.
+func (s *HealthStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM tool_health_checks WHERE checked_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("pruning health checks: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -1,6 +1,10 @@
 package registry
 
-import "time"
+import (
+	"time"
+
+	"github.com/alecgard/octroi/internal/ratelimit"
+)
 
 // Tool represents a tool registered in the Octroi gateway.
 type Tool struct {
@@ -9,17 +13,66 @@ type Tool struct {
 	Description     string            `json:"description"`
 	Mode            string            `json:"mode"`
 	Endpoint        string            `json:"-"`
+	Targets         []Target          `json:"-"`
+	LBStrategy      string            `json:"-"`
+	HealthPath      string            `json:"-"`
 	AuthType        string            `json:"auth_type"`
 	AuthConfig      map[string]string `json:"-"`
 	Variables       map[string]string `json:"-"`
+	TLSConfig       map[string]string `json:"-"`
+	RetryPolicy     RetryPolicy       `json:"retry_policy"`
+	CachePolicy     CachePolicy       `json:"cache_policy"`
+	Coalesce        bool              `json:"coalesce_requests"` // collapse identical concurrent GETs into one upstream call
 	PricingModel    string            `json:"pricing_model"`
 	PricingAmount   float64           `json:"pricing_amount"`
 	PricingCurrency string            `json:"pricing_currency"`
+	CostEstimate    float64           `json:"cost_estimate"` // reserved against budgets while a call is in flight; 0 uses pricing_amount
 	RateLimit       int               `json:"rate_limit"`
+	RateWindows     []RateWindow      `json:"rate_limit_windows"` // limits enforced alongside rate_limit, e.g. per second and per day
+	RateLimitWaitMs int               `json:"rate_limit_wait_ms"` // hold rate-limited requests up to this long instead of rejecting
+	MaxConcurrency  int               `json:"max_concurrency"`    // requests in flight across all agents; 0 is unlimited
 	BudgetLimit     float64           `json:"budget_limit"`
 	BudgetWindow    string            `json:"budget_window"`
 	CreatedAt       time.Time         `json:"created_at"`
 	UpdatedAt       time.Time         `json:"updated_at"`
+
+	// Status is the result of the latest active health check ("up", "down"
+	// or "unknown"). It is filled in by the API and not stored.
+	Status string `json:"status,omitempty"`
+}
+
+// RateWindow is a limit of requests per number of seconds that a tool
+// enforces alongside its rate limit, such as 10,000 a day.
+type RateWindow = ratelimit.WindowLimit
+
+// Target is one of several upstream base URLs for a tool, such as a regional
+// replica. When a tool has targets, the proxy balances across them instead of
+// using Endpoint.
+type Target struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight,omitempty"` // relative share for the weighted strategy; 0 means 1
+}
+
+// RetryPolicy controls how the proxy retries a failed upstream call. Only
+// idempotent requests are retried. A MaxAttempts of 0 or 1 disables retries;
+// other zero fields take the proxy's defaults.
+type RetryPolicy struct {
+	MaxAttempts      int      `json:"max_attempts"` // total attempts, including the first
+	InitialBackoffMs int      `json:"initial_backoff_ms,omitempty"`
+	MaxBackoffMs     int      `json:"max_backoff_ms,omitempty"`
+	RetryOnStatus    []int    `json:"retry_on_status,omitempty"` // upstream status codes to retry
+	RetryOnErrors    []string `json:"retry_on_errors,omitempty"` // upstream error classes to retry
+	MaxBodyBytes     int64    `json:"max_body_bytes,omitempty"`  // larger request bodies are not replayed
+}
+
+// CachePolicy enables the proxy's response cache for a tool's GET requests.
+// Upstream Cache-Control, Expires and ETag headers decide what is cached and
+// for how long unless TTLSeconds overrides them.
+type CachePolicy struct {
+	Enabled     bool     `json:"enabled"`
+	Scope       string   `json:"scope,omitempty"`        // "agent" (default) caches per agent, "tool" shares across agents
+	TTLSeconds  int      `json:"ttl_seconds,omitempty"`  // freshness to use instead of the upstream's
+	VaryHeaders []string `json:"vary_headers,omitempty"` // request headers that are part of the cache key
 }
 
 // CreateToolInput holds the fields required to create a new tool.
@@ -28,13 +81,24 @@ type CreateToolInput struct {
 	Description     string            `json:"description"`
 	Mode            string            `json:"mode"`
 	Endpoint        string            `json:"endpoint"`
+	Targets         []Target          `json:"targets"`
+	LBStrategy      string            `json:"lb_strategy"`
+	HealthPath      string            `json:"health_path"`
 	AuthType        string            `json:"auth_type"`
 	AuthConfig      map[string]string `json:"auth_config"`
 	Variables       map[string]string `json:"variables"`
+	TLSConfig       map[string]string `json:"tls_config"`
+	RetryPolicy     RetryPolicy       `json:"retry_policy"`
+	CachePolicy     CachePolicy       `json:"cache_policy"`
+	Coalesce        bool              `json:"coalesce_requests"`
 	PricingModel    string            `json:"pricing_model"`
 	PricingAmount   float64           `json:"pricing_amount"`
 	PricingCurrency string            `json:"pricing_currency"`
+	CostEstimate    float64           `json:"cost_estimate"`
 	RateLimit       int               `json:"rate_limit"`
+	RateWindows     []RateWindow      `json:"rate_limit_windows"`
+	RateLimitWaitMs int               `json:"rate_limit_wait_ms"`
+	MaxConcurrency  int               `json:"max_concurrency"`
 	BudgetLimit     float64           `json:"budget_limit"`
 	BudgetWindow    string            `json:"budget_window"`
 }
@@ -46,13 +110,24 @@ type UpdateToolInput struct {
 	Description     *string            `json:"description"`
 	Mode            *string            `json:"mode"`
 	Endpoint        *string            `json:"endpoint"`
+	Targets         *[]Target          `json:"targets"`
+	LBStrategy      *string            `json:"lb_strategy"`
+	HealthPath      *string            `json:"health_path"`
 	AuthType        *string            `json:"auth_type"`
 	AuthConfig      *map[string]string `json:"auth_config"`
 	Variables       *map[string]string `json:"variables"`
+	TLSConfig       *map[string]string `json:"tls_config"`
+	RetryPolicy     *RetryPolicy       `json:"retry_policy"`
+	CachePolicy     *CachePolicy       `json:"cache_policy"`
+	Coalesce        *bool              `json:"coalesce_requests"`
 	PricingModel    *string            `json:"pricing_model"`
 	PricingAmount   *float64           `json:"pricing_amount"`
 	PricingCurrency *string            `json:"pricing_currency"`
+	CostEstimate    *float64           `json:"cost_estimate"`
 	RateLimit       *int               `json:"rate_limit"`
+	RateWindows     *[]RateWindow      `json:"rate_limit_windows"`
+	RateLimitWaitMs *int               `json:"rate_limit_wait_ms"`
+	MaxConcurrency  *int               `json:"max_concurrency"`
 	BudgetLimit     *float64           `json:"budget_limit"`
 	BudgetWindow    *string            `json:"budget_window"`
 }
@@ -62,4 +137,31 @@ type ToolListParams struct {
 	Cursor string `json:"cursor"`
 	Limit  int    `json:"limit"`
 	Query  string `json:"query"`
+
+	// When AgentID is set, only tools the agent (and its Team) is entitled to
+	// call are returned.
+	AgentID string `json:"agent_id"`
+	Team    string `json:"team"`
+}
+
+// HealthCheck is the result of one active health probe of a tool.
+type HealthCheck struct {
+	ID         int64     `json:"id"`
+	ToolID     string    `json:"tool_id"`
+	CheckedAt  time.Time `json:"checked_at"`
+	Up         bool      `json:"up"`
+	StatusCode int       `json:"status_code"`
+	LatencyMs  int64     `json:"latency_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Grant represents a team- or agent-scoped access rule for a tool.
+type Grant struct {
+	ID        string    `json:"id"`
+	ToolID    string    `json:"tool_id"`
+	Scope     string    `json:"scope"`
+	ScopeID   string    `json:"scope_id"`
+	Effect    string    `json:"effect"`
+	Source    string    `json:"source"` // GrantSourceAdmin or GrantSourceMember
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -2,9 +2,16 @@ package registry
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"net/url"
 	"strings"
+
+	"github.com/alecgard/octroi/internal/budget/window"
+	"github.com/alecgard/octroi/internal/egress"
+	"github.com/alecgard/octroi/internal/ratelimit"
 )
 
 // Validation errors returned by the Service layer.
@@ -12,9 +19,22 @@ var (
 	ErrNameRequired        = errors.New("name is required")
 	ErrDescriptionRequired = errors.New("description is required")
 	ErrEndpointInvalid     = errors.New("endpoint must be a valid URL")
-	ErrAuthTypeInvalid     = errors.New("auth_type must be one of: none, bearer, header, query")
+	ErrAuthTypeInvalid     = errors.New("auth_type must be one of: none, bearer, header, query, basic, hmac, oauth2_client_credentials, aws_sigv4")
+	ErrAuthConfigInvalid   = errors.New("auth_config is missing or has invalid fields for auth_type")
+	ErrTLSConfigInvalid    = errors.New("tls_config has an invalid certificate, key, CA bundle or min_version")
+	ErrRetryPolicyInvalid  = errors.New("retry_policy has invalid attempts, backoff, status codes or error classes")
+	ErrCachePolicyInvalid  = errors.New("cache_policy has an invalid scope, ttl_seconds or vary_headers")
+	ErrTargetsInvalid      = errors.New("targets must be valid URLs with non-negative weights")
+	ErrLBStrategyInvalid   = errors.New("lb_strategy must be one of: round_robin, weighted, least_outstanding")
+	ErrHealthPathInvalid   = errors.New("health_path must be a path starting with /")
+	ErrConcurrencyInvalid  = errors.New("max_concurrency must not be negative")
+	ErrCostEstimateInvalid = errors.New("cost_estimate must not be negative")
+	ErrRateWaitInvalid     = errors.New("rate_limit_wait_ms must be between 0 and 60000")
+	ErrRateWindowsInvalid  = errors.New("rate_limit_windows must have positive limits and distinct window_seconds of at most 31 days, up to 10 windows")
+	ErrBudgetWindowInvalid = errors.New("budget_window must be daily, weekly, monthly or monthly:<day>, optionally with @<time zone>, or rolling:<n>h or rolling:<n>d up to 31 days")
 	ErrModeInvalid         = errors.New("mode must be one of: service, api")
 	ErrVariablesMissing    = errors.New("variables do not satisfy all template placeholders")
+	ErrEndpointDenied      = errors.New("endpoint is not permitted by the egress policy")
 )
 
 // validAuthTypes is the set of accepted auth_type values.
@@ -23,6 +43,11 @@ var validAuthTypes = map[string]bool{
 	"bearer": true,
 	"header": true,
 	"query":  true,
+	"basic":  true,
+	"hmac":   true,
+
+	"oauth2_client_credentials": true,
+	"aws_sigv4":                 true,
 }
 
 // validModes is the set of accepted mode values.
@@ -31,9 +56,75 @@ var validModes = map[string]bool{
 	"api":     true,
 }
 
+// validLBStrategies is the set of accepted lb_strategy values.
+var validLBStrategies = map[string]bool{
+	"round_robin":       true,
+	"weighted":          true,
+	"least_outstanding": true,
+}
+
+// validTLSVersions is the set of accepted tls_config min_version values.
+var validTLSVersions = map[string]bool{
+	"1.0": true,
+	"1.1": true,
+	"1.2": true,
+	"1.3": true,
+}
+
+// MaxRetryAttempts caps retry_policy.max_attempts.
+const MaxRetryAttempts = 10
+
+// validRetryErrors is the set of upstream error classes retry_policy may
+// retry on. Timeouts are excluded: the proxy timeout bounds all attempts.
+var validRetryErrors = map[string]bool{
+	"connection_refused": true,
+	"network":            true,
+	"dns":                true,
+	"other":              true,
+}
+
+// MaxCacheTTLSeconds caps cache_policy.ttl_seconds.
+const MaxCacheTTLSeconds = 7 * 24 * 60 * 60
+
+// validCacheScopes is the set of accepted cache_policy scope values; empty
+// means "agent".
+var validCacheScopes = map[string]bool{
+	"":      true,
+	"agent": true,
+	"tool":  true,
+}
+
 // Service provides validated business logic over the registry Store.
 type Service struct {
-	store *Store
+	store    *Store
+	egress   *egress.Policy
+	onChange []func(toolID string)
+	onDelete []func(toolID string)
+}
+
+// SetEgressPolicy makes Create and Update reject tools whose endpoint or
+// OAuth2 token URL the policy blocks.
+func (s *Service) SetEgressPolicy(p *egress.Policy) {
+	s.egress = p
+}
+
+// OnToolChange registers fn to be called after a tool is updated or deleted,
+// so callers holding per-tool state (such as proxy transports) can drop it.
+func (s *Service) OnToolChange(fn func(toolID string)) {
+	s.onChange = append(s.onChange, fn)
+}
+
+// OnToolDelete registers fn to be called after a tool is deleted, so callers
+// holding state that outlives updates (such as rate limit buckets) can drop
+// it.
+func (s *Service) OnToolDelete(fn func(toolID string)) {
+	s.onDelete = append(s.onDelete, fn)
+}
+
+func (s *Service) notifyChange(toolID string) {
+	for _, fn := range s.onChange {
+		fn(toolID)
+	}
 }
 
 // NewService creates a new Service wrapping the given Store.
@@ -55,9 +146,19 @@ func (s *Service) Create(ctx context.Context, input CreateToolInput) (*Tool, err
 	if input.Variables == nil {
 		input.Variables = map[string]string{}
 	}
+	if input.LBStrategy == "" {
+		input.LBStrategy = "round_robin"
+	}
+	// A tool defined only by its targets uses the first one as its endpoint.
+	if strings.TrimSpace(input.Endpoint) == "" && len(input.Targets) > 0 {
+		input.Endpoint = input.Targets[0].URL
+	}
 	if err := validateCreate(input); err != nil {
 		return nil, err
 	}
+	if err := s.checkEgress(ctx, input.Mode, endpointURLs(input.Endpoint, input.Targets), input.Variables, input.AuthType, input.AuthConfig); err != nil {
+		return nil, err
+	}
 	return s.store.Create(ctx, input)
 }
 
@@ -76,10 +177,27 @@ func (s *Service) Update(ctx context.Context, id string, input UpdateToolInput)
 	if err := validateUpdate(input); err != nil {
 		return nil, err
 	}
-	// Cross-field validation for API mode: when endpoint or variables change,
-	// we need to validate the template against the full set of variables.
-	if input.Mode != nil || input.Endpoint != nil || input.Variables != nil {
-		existing, err := s.store.GetByID(ctx, id)
+	if input.TLSConfig != nil {
+		if err := validateTLSConfig(*input.TLSConfig); err != nil {
+			return nil, err
+		}
+	}
+	if input.RetryPolicy != nil {
+		if err := validateRetryPolicy(*input.RetryPolicy); err != nil {
+			return nil, err
+		}
+	}
+	if input.CachePolicy != nil {
+		if err := validateCachePolicy(*input.CachePolicy); err != nil {
+			return nil, err
+		}
+	}
+	// Cross-field validation for API mode: when endpoint, targets or variables
+	// change, we need to validate the templates against the full set of variables.
+	var existing *Tool
+	if input.Mode != nil || input.Endpoint != nil || input.Targets != nil || input.Variables != nil {
+		var err error
+		existing, err = s.store.GetByID(ctx, id)
 		if err != nil {
 			return nil, err
 		}
@@ -87,31 +205,142 @@ func (s *Service) Update(ctx context.Context, id string, input UpdateToolInput)
 		if input.Mode != nil {
 			mode = *input.Mode
 		}
+		variables := existing.Variables
+		if input.Variables != nil {
+			variables = *input.Variables
+		}
 		if mode == "api" {
 			endpoint := existing.Endpoint
 			if input.Endpoint != nil {
 				endpoint = *input.Endpoint
 			}
-			variables := existing.Variables
-			if input.Variables != nil {
-				variables = *input.Variables
-			}
 			if err := validateAPIEndpoint(endpoint, variables); err != nil {
 				return nil, err
 			}
 		}
+		targets := existing.Targets
+		if input.Targets != nil {
+			targets = *input.Targets
+		}
+		if err := validateTargets(mode, targets, variables); err != nil {
+			return nil, err
+		}
+	}
+	// Auth config must satisfy the auth type, whichever of the two changes.
+	if input.AuthType != nil || input.AuthConfig != nil {
+		if existing == nil && (input.AuthType == nil || input.AuthConfig == nil) {
+			var err error
+			existing, err = s.store.GetByID(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var authType string
+		var authConfig map[string]string
+		if existing != nil {
+			authType, authConfig = existing.AuthType, existing.AuthConfig
+		}
+		if input.AuthType != nil {
+			authType = *input.AuthType
+		}
+		if input.AuthConfig != nil {
+			authConfig = *input.AuthConfig
+		}
+		if err := validateAuthConfig(authType, authConfig); err != nil {
+			return nil, err
+		}
+	}
+	if s.egress != nil && (input.Mode != nil || input.Endpoint != nil || input.Targets != nil ||
+		input.Variables != nil || input.AuthType != nil || input.AuthConfig != nil) {
+		if existing == nil {
+			var err error
+			existing, err = s.store.GetByID(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		mode, endpoint, targets, variables := existing.Mode, existing.Endpoint, existing.Targets, existing.Variables
+		authType, authConfig := existing.AuthType, existing.AuthConfig
+		if input.Mode != nil {
+			mode = *input.Mode
+		}
+		if input.Endpoint != nil {
+			endpoint = *input.Endpoint
+		}
+		if input.Targets != nil {
+			targets = *input.Targets
+		}
+		if input.Variables != nil {
+			variables = *input.Variables
+		}
+		if input.AuthType != nil {
+			authType = *input.AuthType
+		}
+		if input.AuthConfig != nil {
+			authConfig = *input.AuthConfig
+		}
+		if err := s.checkEgress(ctx, mode, endpointURLs(endpoint, targets), variables, authType, authConfig); err != nil {
+			return nil, err
+		}
+	}
+	tool, err := s.store.Update(ctx, id, input)
+	if err != nil {
+		return nil, err
 	}
-	return s.store.Update(ctx, id, input)
+	s.notifyChange(id)
+	return tool, nil
 }
 
 // Delete removes a tool by its ID.
 func (s *Service) Delete(ctx context.Context, id string) error {
-	return s.store.Delete(ctx, id)
+	if err := s.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.notifyChange(id)
+	for _, fn := range s.onDelete {
+		fn(id)
+	}
+	return nil
 }
 
 // Search performs a text search across tools.
-func (s *Service) Search(ctx context.Context, query string, limit int, cursor string) ([]*Tool, string, error) {
-	return s.store.Search(ctx, query, limit, cursor)
+func (s *Service) Search(ctx context.Context, params ToolListParams) ([]*Tool, string, error) {
+	return s.store.Search(ctx, params)
+}
+
+// checkEgress rejects a tool whose resolved endpoints, or OAuth2 token URL,
+// the egress policy would refuse to connect to.
+func (s *Service) checkEgress(ctx context.Context, mode string, endpoints []string, variables map[string]string, authType string, authConfig map[string]string) error {
+	if s.egress == nil {
+		return nil
+	}
+	urls := make([]string, 0, len(endpoints)+1)
+	for _, endpoint := range endpoints {
+		if mode == "api" {
+			if resolved, err := ResolveTemplate(endpoint, variables); err == nil {
+				endpoint = resolved
+			}
+		}
+		urls = append(urls, endpoint)
+	}
+	if authType == "oauth2_client_credentials" {
+		urls = append(urls, authConfig["token_url"])
+	}
+	for _, u := range urls {
+		if err := s.egress.CheckURL(ctx, u); err != nil {
+			return fmt.Errorf("%w: %v", ErrEndpointDenied, err)
+		}
+	}
+	return nil
+}
+
+// endpointURLs lists a tool's endpoint followed by its target URLs.
+func endpointURLs(endpoint string, targets []Target) []string {
+	urls := []string{endpoint}
+	for _, t := range targets {
+		urls = append(urls, t.URL)
+	}
+	return urls
 }
 
 // validateCreate checks that all required fields are present and valid.
@@ -134,10 +363,103 @@ func validateCreate(input CreateToolInput) error {
 			return err
 		}
 	}
+	if err := validateTargets(input.Mode, input.Targets, input.Variables); err != nil {
+		return err
+	}
+	if input.LBStrategy != "" && !validLBStrategies[input.LBStrategy] {
+		return ErrLBStrategyInvalid
+	}
+	if err := validateHealthPath(input.HealthPath); err != nil {
+		return err
+	}
+	if input.MaxConcurrency < 0 {
+		return ErrConcurrencyInvalid
+	}
+	if input.CostEstimate < 0 {
+		return ErrCostEstimateInvalid
+	}
+	if !ratelimit.ValidWaitMs(input.RateLimitWaitMs) {
+		return ErrRateWaitInvalid
+	}
+	if !ratelimit.ValidWindows(input.RateWindows) {
+		return ErrRateWindowsInvalid
+	}
+	if _, err := window.Parse(input.BudgetWindow); err != nil {
+		return ErrBudgetWindowInvalid
+	}
 	if input.AuthType != "" {
 		if !validAuthTypes[input.AuthType] {
 			return ErrAuthTypeInvalid
 		}
+		if err := validateAuthConfig(input.AuthType, input.AuthConfig); err != nil {
+			return err
+		}
+	}
+	if err := validateRetryPolicy(input.RetryPolicy); err != nil {
+		return err
+	}
+	if err := validateCachePolicy(input.CachePolicy); err != nil {
+		return err
+	}
+	return validateTLSConfig(input.TLSConfig)
+}
+
+// hmacTemplateVars are the placeholders an hmac header_template may use.
+var hmacTemplateVars = map[string]bool{
+	"signature": true,
+	"timestamp": true,
+	"algorithm": true,
+	"key_id":    true,
+}
+
+// validateAuthConfig checks that cfg holds the fields authType needs.
+func validateAuthConfig(authType string, cfg map[string]string) error {
+	switch authType {
+	case "basic":
+		if cfg["username"] == "" {
+			return ErrAuthConfigInvalid
+		}
+	case "hmac":
+		if cfg["secret"] == "" {
+			return ErrAuthConfigInvalid
+		}
+		switch cfg["algorithm"] {
+		case "", "sha1", "sha256", "sha512":
+		default:
+			return ErrAuthConfigInvalid
+		}
+		if enc := cfg["encoding"]; enc != "" && enc != "hex" && enc != "base64" {
+			return ErrAuthConfigInvalid
+		}
+		if tmpl := cfg["header_template"]; tmpl != "" {
+			vars := ExtractTemplateVars(tmpl)
+			hasSignature := false
+			for _, v := range vars {
+				if !hmacTemplateVars[v] {
+					return ErrAuthConfigInvalid
+				}
+				hasSignature = hasSignature || v == "signature"
+			}
+			if !hasSignature {
+				return ErrAuthConfigInvalid
+			}
+		}
+	case "oauth2_client_credentials":
+		if validateEndpoint(cfg["token_url"]) != nil {
+			return ErrAuthConfigInvalid
+		}
+		if strings.TrimSpace(cfg["client_id"]) == "" || cfg["client_secret"] == "" {
+			return ErrAuthConfigInvalid
+		}
+		if style := cfg["auth_style"]; style != "" && style != "header" && style != "params" {
+			return ErrAuthConfigInvalid
+		}
+	case "aws_sigv4":
+		for _, field := range []string{"access_key_id", "secret_access_key", "region", "service"} {
+			if strings.TrimSpace(cfg[field]) == "" {
+				return ErrAuthConfigInvalid
+			}
+		}
 	}
 	return nil
 }
@@ -159,6 +481,31 @@ func validateUpdate(input UpdateToolInput) error {
 			return err
 		}
 	}
+	if input.LBStrategy != nil && !validLBStrategies[*input.LBStrategy] {
+		return ErrLBStrategyInvalid
+	}
+	if input.HealthPath != nil {
+		if err := validateHealthPath(*input.HealthPath); err != nil {
+			return err
+		}
+	}
+	if input.MaxConcurrency != nil && *input.MaxConcurrency < 0 {
+		return ErrConcurrencyInvalid
+	}
+	if input.CostEstimate != nil && *input.CostEstimate < 0 {
+		return ErrCostEstimateInvalid
+	}
+	if input.RateLimitWaitMs != nil && !ratelimit.ValidWaitMs(*input.RateLimitWaitMs) {
+		return ErrRateWaitInvalid
+	}
+	if input.RateWindows != nil && !ratelimit.ValidWindows(*input.RateWindows) {
+		return ErrRateWindowsInvalid
+	}
+	if input.BudgetWindow != nil {
+		if _, err := window.Parse(*input.BudgetWindow); err != nil {
+			return ErrBudgetWindowInvalid
+		}
+	}
 	if input.AuthType != nil {
 		if !validAuthTypes[*input.AuthType] {
 			return ErrAuthTypeInvalid
@@ -167,6 +514,40 @@ func validateUpdate(input UpdateToolInput) error {
 	return nil
 }
 
+// validateHealthPath checks that path, if set, is an absolute path (with an
+// optional query) to append to the tool's endpoint.
+func validateHealthPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	u, err := url.Parse(path)
+	if err != nil || !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || u.Host != "" || u.Fragment != "" {
+		return ErrHealthPathInvalid
+	}
+	return nil
+}
+
+// validateTargets checks each target's URL (resolving templates in API mode)
+// and weight.
+func validateTargets(mode string, targets []Target, variables map[string]string) error {
+	for _, t := range targets {
+		if t.Weight < 0 {
+			return ErrTargetsInvalid
+		}
+		if mode == "api" {
+			if err := validateAPIEndpoint(t.URL, variables); err != nil {
+				if errors.Is(err, ErrVariablesMissing) {
+					return err
+				}
+				return ErrTargetsInvalid
+			}
+		} else if validateEndpoint(t.URL) != nil {
+			return ErrTargetsInvalid
+		}
+	}
+	return nil
+}
+
 // validateAPIEndpoint resolves the template with variables and validates the resulting URL.
 func validateAPIEndpoint(endpoint string, variables map[string]string) error {
 	if strings.TrimSpace(endpoint) == "" {
@@ -191,3 +572,68 @@ func validateEndpoint(endpoint string) error {
 	return nil
 }
 
+// validateRetryPolicy checks that a retry policy's bounds are sane and that it
+// only names retryable status codes and known error classes.
+func validateRetryPolicy(p RetryPolicy) error {
+	if p.MaxAttempts < 0 || p.MaxAttempts > MaxRetryAttempts {
+		return ErrRetryPolicyInvalid
+	}
+	if p.InitialBackoffMs < 0 || p.MaxBackoffMs < 0 || p.MaxBodyBytes < 0 {
+		return ErrRetryPolicyInvalid
+	}
+	if p.MaxBackoffMs > 0 && p.InitialBackoffMs > p.MaxBackoffMs {
+		return ErrRetryPolicyInvalid
+	}
+	for _, code := range p.RetryOnStatus {
+		if code < 400 || code > 599 {
+			return ErrRetryPolicyInvalid
+		}
+	}
+	for _, class := range p.RetryOnErrors {
+		if !validRetryErrors[class] {
+			return ErrRetryPolicyInvalid
+		}
+	}
+	return nil
+}
+
+// validateCachePolicy checks the cache scope, TTL override and vary headers.
+// Authorization is never part of the key: the proxy replaces it with the
+// tool's credentials.
+func validateCachePolicy(p CachePolicy) error {
+	if !validCacheScopes[p.Scope] {
+		return ErrCachePolicyInvalid
+	}
+	if p.TTLSeconds < 0 || p.TTLSeconds > MaxCacheTTLSeconds {
+		return ErrCachePolicyInvalid
+	}
+	for _, h := range p.VaryHeaders {
+		if strings.TrimSpace(h) == "" || strings.ContainsAny(h, " \t\r\n:") || strings.EqualFold(h, "Authorization") {
+			return ErrCachePolicyInvalid
+		}
+	}
+	return nil
+}
+
+// validateTLSConfig checks that any PEM material in cfg parses and that the
+// client certificate and key are supplied together.
+func validateTLSConfig(cfg map[string]string) error {
+	cert, key := cfg["client_cert"], cfg["client_key"]
+	if (cert == "") != (key == "") {
+		return ErrTLSConfigInvalid
+	}
+	if cert != "" {
+		if _, err := tls.X509KeyPair([]byte(cert), []byte(key)); err != nil {
+			return ErrTLSConfigInvalid
+		}
+	}
+	if ca := cfg["ca_bundle"]; ca != "" {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(ca)) {
+			return ErrTLSConfigInvalid
+		}
+	}
+	if v := cfg["min_version"]; v != "" && !validTLSVersions[v] {
+		return ErrTLSConfigInvalid
+	}
+	return nil
+}
@@ -20,34 +20,52 @@ type Store struct {
 }
 
 // NewStore creates a new Store backed by the given connection pool.
-// An optional cipher encrypts auth_config at rest; nil disables encryption.
+// An optional cipher encrypts auth_config and tls_config at rest; nil
+// disables encryption.
 func NewStore(pool *pgxpool.Pool, cipher *crypto.Cipher) *Store {
 	return &Store{pool: pool, cipher: cipher}
 }
 
 // toolColumns is the full list of columns used in SELECT statements.
-const toolColumns = `id, name, description, mode, endpoint, auth_type, auth_config, variables,
-	pricing_model, pricing_amount, pricing_currency, rate_limit,
-	budget_limit, budget_window, created_at, updated_at`
+const toolColumns = `id, name, description, mode, endpoint, targets, lb_strategy, health_path, auth_type, auth_config, variables, tls_config,
+	retry_policy, cache_policy, coalesce_requests, pricing_model, pricing_amount, pricing_currency, cost_estimate,
+	rate_limit, rate_limit_windows, rate_limit_wait_ms, max_concurrency, budget_limit, budget_window, created_at, updated_at`
 
-// scanTool scans a single tool row into a Tool struct, decrypting auth_config if a cipher is set.
+// scanTool scans a single tool row into a Tool struct, decrypting auth_config
+// and tls_config if a cipher is set.
 func (s *Store) scanTool(row pgx.Row) (*Tool, error) {
 	var t Tool
 	var authConfigRaw []byte
 	var variablesJSON []byte
+	var tlsConfigRaw string
+	var retryPolicyJSON []byte
+	var cachePolicyJSON []byte
+	var targetsJSON []byte
+	var rateLimitWindowsJSON []byte
 	err := row.Scan(
 		&t.ID,
 		&t.Name,
 		&t.Description,
 		&t.Mode,
 		&t.Endpoint,
+		&targetsJSON,
+		&t.LBStrategy,
+		&t.HealthPath,
 		&t.AuthType,
 		&authConfigRaw,
 		&variablesJSON,
+		&tlsConfigRaw,
+		&retryPolicyJSON,
+		&cachePolicyJSON,
+		&t.Coalesce,
 		&t.PricingModel,
 		&t.PricingAmount,
 		&t.PricingCurrency,
+		&t.CostEstimate,
 		&t.RateLimit,
+		&rateLimitWindowsJSON,
+		&t.RateLimitWaitMs,
+		&t.MaxConcurrency,
 		&t.BudgetLimit,
 		&t.BudgetWindow,
 		&t.CreatedAt,
@@ -57,20 +75,13 @@ func (s *Store) scanTool(row pgx.Row) (*Tool, error) {
 		return nil, err
 	}
 
-	t.AuthConfig = make(map[string]string)
-	if len(authConfigRaw) > 0 {
-		authJSON := string(authConfigRaw)
-		// Decrypt if cipher is configured. For unencrypted (plain JSON) data,
-		// Decrypt on a nil cipher is a no-op and returns the string as-is.
-		decrypted, err := s.cipher.Decrypt(authJSON)
-		if err != nil {
-			// If decryption fails, the data may be plain JSON (pre-encryption).
-			// Fall back to using the raw value.
-			decrypted = authJSON
-		}
-		if err := json.Unmarshal([]byte(decrypted), &t.AuthConfig); err != nil {
-			return nil, fmt.Errorf("unmarshalling auth_config: %w", err)
-		}
+	t.AuthConfig, err = s.decryptConfig(string(authConfigRaw))
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling auth_config: %w", err)
+	}
+	t.TLSConfig, err = s.decryptConfig(tlsConfigRaw)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling tls_config: %w", err)
 	}
 
 	t.Variables = make(map[string]string)
@@ -79,29 +90,111 @@ func (s *Store) scanTool(row pgx.Row) (*Tool, error) {
 			return nil, fmt.Errorf("unmarshalling variables: %w", err)
 		}
 	}
+	if len(targetsJSON) > 0 {
+		if err := json.Unmarshal(targetsJSON, &t.Targets); err != nil {
+			return nil, fmt.Errorf("unmarshalling targets: %w", err)
+		}
+	}
+	t.RateWindows = []RateWindow{}
+	if len(rateLimitWindowsJSON) > 0 {
+		if err := json.Unmarshal(rateLimitWindowsJSON, &t.RateWindows); err != nil {
+			return nil, fmt.Errorf("unmarshalling rate_limit_windows: %w", err)
+		}
+	}
+	if len(retryPolicyJSON) > 0 {
+		if err := json.Unmarshal(retryPolicyJSON, &t.RetryPolicy); err != nil {
+			return nil, fmt.Errorf("unmarshalling retry_policy: %w", err)
+		}
+	}
+	if len(cachePolicyJSON) > 0 {
+		if err := json.Unmarshal(cachePolicyJSON, &t.CachePolicy); err != nil {
+			return nil, fmt.Errorf("unmarshalling cache_policy: %w", err)
+		}
+	}
 	return &t, nil
 }
 
+// decryptConfig decodes a stored (possibly encrypted) JSON string map.
+func (s *Store) decryptConfig(raw string) (map[string]string, error) {
+	cfg := make(map[string]string)
+	if raw == "" {
+		return cfg, nil
+	}
+	// Decrypt if cipher is configured. For unencrypted (plain JSON) data,
+	// Decrypt on a nil cipher is a no-op and returns the string as-is.
+	decrypted, err := s.cipher.Decrypt(raw)
+	if err != nil {
+		// If decryption fails, the data may be plain JSON (pre-encryption).
+		// Fall back to using the raw value.
+		decrypted = raw
+	}
+	if err := json.Unmarshal([]byte(decrypted), &cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// encryptConfig encodes a string map as JSON and encrypts it for storage.
+func (s *Store) encryptConfig(cfg map[string]string) (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("marshalling: %w", err)
+	}
+	stored, err := s.cipher.Encrypt(string(data))
+	if err != nil {
+		return "", fmt.Errorf("encrypting: %w", err)
+	}
+	return stored, nil
+}
+
 // Create inserts a new tool and returns the full row.
 func (s *Store) Create(ctx context.Context, input CreateToolInput) (*Tool, error) {
-	authConfigJSON, err := json.Marshal(input.AuthConfig)
+	authConfigStored, err := s.encryptConfig(input.AuthConfig)
 	if err != nil {
-		return nil, fmt.Errorf("marshalling auth_config: %w", err)
+		return nil, fmt.Errorf("storing auth_config: %w", err)
 	}
-	authConfigStored, err := s.cipher.Encrypt(string(authConfigJSON))
-	if err != nil {
-		return nil, fmt.Errorf("encrypting auth_config: %w", err)
+	tlsConfigStored := ""
+	if len(input.TLSConfig) > 0 {
+		tlsConfigStored, err = s.encryptConfig(input.TLSConfig)
+		if err != nil {
+			return nil, fmt.Errorf("storing tls_config: %w", err)
+		}
 	}
 	variablesJSON, err := json.Marshal(input.Variables)
 	if err != nil {
 		return nil, fmt.Errorf("marshalling variables: %w", err)
 	}
+	retryPolicyJSON, err := json.Marshal(input.RetryPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling retry_policy: %w", err)
+	}
+	cachePolicyJSON, err := json.Marshal(input.CachePolicy)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling cache_policy: %w", err)
+	}
+	targets := input.Targets
+	if targets == nil {
+		targets = []Target{}
+	}
+	targetsJSON, err := json.Marshal(targets)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling targets: %w", err)
+	}
+	rateLimitWindows := input.RateWindows
+	if rateLimitWindows == nil {
+		rateLimitWindows = []RateWindow{}
+	}
+	rateLimitWindowsJSON, err := json.Marshal(rateLimitWindows)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling rate_limit_windows: %w", err)
+	}
 
 	query := fmt.Sprintf(`INSERT INTO tools
-		(name, description, mode, endpoint, auth_type, auth_config, variables,
-		 pricing_model, pricing_amount, pricing_currency, rate_limit,
-		 budget_limit, budget_window)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		(name, description, mode, endpoint, targets, lb_strategy, health_path, auth_type, auth_config, variables,
+		 tls_config, retry_policy, cache_policy, coalesce_requests, pricing_model, pricing_amount,
+		 pricing_currency, cost_estimate, rate_limit, rate_limit_windows, rate_limit_wait_ms, max_concurrency, budget_limit,
+		 budget_window)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
 		RETURNING %s`, toolColumns)
 
 	row := s.pool.QueryRow(ctx, query,
@@ -109,13 +202,24 @@ func (s *Store) Create(ctx context.Context, input CreateToolInput) (*Tool, error
 		input.Description,
 		input.Mode,
 		input.Endpoint,
+		targetsJSON,
+		input.LBStrategy,
+		input.HealthPath,
 		input.AuthType,
 		[]byte(authConfigStored),
 		variablesJSON,
+		tlsConfigStored,
+		retryPolicyJSON,
+		cachePolicyJSON,
+		input.Coalesce,
 		input.PricingModel,
 		input.PricingAmount,
 		input.PricingCurrency,
+		input.CostEstimate,
 		input.RateLimit,
+		rateLimitWindowsJSON,
+		input.RateLimitWaitMs,
+		input.MaxConcurrency,
 		input.BudgetLimit,
 		input.BudgetWindow,
 	)
@@ -182,6 +286,13 @@ func (s *Store) List(ctx context.Context, params ToolListParams) ([]*Tool, strin
 		argIdx++
 	}
 
+	if params.AgentID != "" {
+		whereClauses = append(whereClauses,
+			fmt.Sprintf("%s = 'allow'", grantEffectSQL("tools.id", argIdx, argIdx+1)))
+		args = append(args, params.Team, params.AgentID)
+		argIdx += 2
+	}
+
 	where := ""
 	if len(whereClauses) > 0 {
 		where = "WHERE " + strings.Join(whereClauses, " AND ")
@@ -245,24 +356,56 @@ func (s *Store) Update(ctx context.Context, id string, input UpdateToolInput) (*
 		args = append(args, *input.Endpoint)
 		argIdx++
 	}
+	if input.Targets != nil {
+		targets := *input.Targets
+		if targets == nil {
+			targets = []Target{}
+		}
+		targetsJSON, err := json.Marshal(targets)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling targets: %w", err)
+		}
+		setClauses = append(setClauses, fmt.Sprintf("targets = $%d", argIdx))
+		args = append(args, targetsJSON)
+		argIdx++
+	}
+	if input.LBStrategy != nil {
+		setClauses = append(setClauses, fmt.Sprintf("lb_strategy = $%d", argIdx))
+		args = append(args, *input.LBStrategy)
+		argIdx++
+	}
+	if input.HealthPath != nil {
+		setClauses = append(setClauses, fmt.Sprintf("health_path = $%d", argIdx))
+		args = append(args, *input.HealthPath)
+		argIdx++
+	}
 	if input.AuthType != nil {
 		setClauses = append(setClauses, fmt.Sprintf("auth_type = $%d", argIdx))
 		args = append(args, *input.AuthType)
 		argIdx++
 	}
 	if input.AuthConfig != nil {
-		authConfigJSON, err := json.Marshal(*input.AuthConfig)
-		if err != nil {
-			return nil, fmt.Errorf("marshalling auth_config: %w", err)
-		}
-		authConfigStored, err := s.cipher.Encrypt(string(authConfigJSON))
+		authConfigStored, err := s.encryptConfig(*input.AuthConfig)
 		if err != nil {
-			return nil, fmt.Errorf("encrypting auth_config: %w", err)
+			return nil, fmt.Errorf("storing auth_config: %w", err)
 		}
 		setClauses = append(setClauses, fmt.Sprintf("auth_config = $%d", argIdx))
 		args = append(args, []byte(authConfigStored))
 		argIdx++
 	}
+	if input.TLSConfig != nil {
+		tlsConfigStored := ""
+		if len(*input.TLSConfig) > 0 {
+			var err error
+			tlsConfigStored, err = s.encryptConfig(*input.TLSConfig)
+			if err != nil {
+				return nil, fmt.Errorf("storing tls_config: %w", err)
+			}
+		}
+		setClauses = append(setClauses, fmt.Sprintf("tls_config = $%d", argIdx))
+		args = append(args, tlsConfigStored)
+		argIdx++
+	}
 	if input.Variables != nil {
 		variablesJSON, err := json.Marshal(*input.Variables)
 		if err != nil {
@@ -272,6 +415,29 @@ func (s *Store) Update(ctx context.Context, id string, input UpdateToolInput) (*
 		args = append(args, variablesJSON)
 		argIdx++
 	}
+	if input.RetryPolicy != nil {
+		retryPolicyJSON, err := json.Marshal(*input.RetryPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling retry_policy: %w", err)
+		}
+		setClauses = append(setClauses, fmt.Sprintf("retry_policy = $%d", argIdx))
+		args = append(args, retryPolicyJSON)
+		argIdx++
+	}
+	if input.CachePolicy != nil {
+		cachePolicyJSON, err := json.Marshal(*input.CachePolicy)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling cache_policy: %w", err)
+		}
+		setClauses = append(setClauses, fmt.Sprintf("cache_policy = $%d", argIdx))
+		args = append(args, cachePolicyJSON)
+		argIdx++
+	}
+	if input.Coalesce != nil {
+		setClauses = append(setClauses, fmt.Sprintf("coalesce_requests = $%d", argIdx))
+		args = append(args, *input.Coalesce)
+		argIdx++
+	}
 	if input.PricingModel != nil {
 		setClauses = append(setClauses, fmt.Sprintf("pricing_model = $%d", argIdx))
 		args = append(args, *input.PricingModel)
@@ -287,11 +453,39 @@ func (s *Store) Update(ctx context.Context, id string, input UpdateToolInput) (*
 		args = append(args, *input.PricingCurrency)
 		argIdx++
 	}
+	if input.CostEstimate != nil {
+		setClauses = append(setClauses, fmt.Sprintf("cost_estimate = $%d", argIdx))
+		args = append(args, *input.CostEstimate)
+		argIdx++
+	}
 	if input.RateLimit != nil {
 		setClauses = append(setClauses, fmt.Sprintf("rate_limit = $%d", argIdx))
 		args = append(args, *input.RateLimit)
 		argIdx++
 	}
+	if input.RateWindows != nil {
+		rateLimitWindows := *input.RateWindows
+		if rateLimitWindows == nil {
+			rateLimitWindows = []RateWindow{}
+		}
+		rateLimitWindowsJSON, err := json.Marshal(rateLimitWindows)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling rate_limit_windows: %w", err)
+		}
+		setClauses = append(setClauses, fmt.Sprintf("rate_limit_windows = $%d", argIdx))
+		args = append(args, rateLimitWindowsJSON)
+		argIdx++
+	}
+	if input.RateLimitWaitMs != nil {
+		setClauses = append(setClauses, fmt.Sprintf("rate_limit_wait_ms = $%d", argIdx))
+		args = append(args, *input.RateLimitWaitMs)
+		argIdx++
+	}
+	if input.MaxConcurrency != nil {
+		setClauses = append(setClauses, fmt.Sprintf("max_concurrency = $%d", argIdx))
+		args = append(args, *input.MaxConcurrency)
+		argIdx++
+	}
 	if input.BudgetLimit != nil {
 		setClauses = append(setClauses, fmt.Sprintf("budget_limit = $%d", argIdx))
 		args = append(args, *input.BudgetLimit)
@@ -320,6 +514,26 @@ func (s *Store) Update(ctx context.Context, id string, input UpdateToolInput) (*
 	return s.scanTool(row)
 }
 
+// ListHealthChecked returns every tool with a health_path set.
+func (s *Store) ListHealthChecked(ctx context.Context) ([]*Tool, error) {
+	query := fmt.Sprintf(`SELECT %s FROM tools WHERE health_path <> '' ORDER BY created_at, id`, toolColumns)
+	rows, err := s.pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("listing health-checked tools: %w", err)
+	}
+	defer rows.Close()
+
+	var tools []*Tool
+	for rows.Next() {
+		t, err := s.scanTool(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning tool: %w", err)
+		}
+		tools = append(tools, t)
+	}
+	return tools, rows.Err()
+}
+
 // Delete removes a tool by its ID.
 func (s *Store) Delete(ctx context.Context, id string) error {
 	tag, err := s.pool.Exec(ctx, `DELETE FROM tools WHERE id = $1`, id)
@@ -334,7 +548,8 @@ func (s *Store) Delete(ctx context.Context, id string) error {
 
 // Search performs a text search on name and description using ILIKE.
 // Results use cursor-based pagination.
-func (s *Store) Search(ctx context.Context, query string, limit int, cursor string) ([]*Tool, string, error) {
+func (s *Store) Search(ctx context.Context, params ToolListParams) ([]*Tool, string, error) {
+	query, limit, cursor := params.Query, params.Limit, params.Cursor
 	if limit <= 0 {
 		limit = 20
 	}
@@ -363,6 +578,13 @@ func (s *Store) Search(ctx context.Context, query string, limit int, cursor stri
 		argIdx++
 	}
 
+	if params.AgentID != "" {
+		whereClauses = append(whereClauses,
+			fmt.Sprintf("%s = 'allow'", grantEffectSQL("tools.id", argIdx, argIdx+1)))
+		args = append(args, params.Team, params.AgentID)
+		argIdx += 2
+	}
+
 	where := ""
 	if len(whereClauses) > 0 {
 		where = "WHERE " + strings.Join(whereClauses, " AND ")
@@ -1,12 +1,18 @@
 package registry
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
+
+	"github.com/alecgard/octroi/internal/config"
+	"github.com/alecgard/octroi/internal/egress"
 )
 
 func strPtr(s string) *string    { return &s }
 func float64Ptr(f float64) *float64 { return &f }
+func intPtr(i int) *int              { return &i }
 
 func TestValidateCreate(t *testing.T) {
 	tests := []struct {
@@ -126,6 +132,234 @@ func TestValidateCreate(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "auth_type oauth2_client_credentials is valid",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "oauth2_client_credentials",
+				AuthConfig: map[string]string{
+					"token_url":     "https://auth.example.com/oauth/token",
+					"client_id":     "client",
+					"client_secret": "secret",
+					"scopes":        "read write",
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "oauth2 without token_url",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "oauth2_client_credentials",
+				AuthConfig:  map[string]string{"client_id": "client", "client_secret": "secret"},
+			},
+			wantErr: ErrAuthConfigInvalid,
+		},
+		{
+			name: "auth_type basic is valid",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "basic",
+				AuthConfig:  map[string]string{"username": "svc", "password": "secret"},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "basic without username",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "basic",
+				AuthConfig:  map[string]string{"password": "secret"},
+			},
+			wantErr: ErrAuthConfigInvalid,
+		},
+		{
+			name: "auth_type hmac is valid",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "hmac",
+				AuthConfig: map[string]string{
+					"secret":          "secret",
+					"algorithm":       "sha512",
+					"header_template": "HMAC {key_id}:{signature}",
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "hmac with unknown algorithm",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "hmac",
+				AuthConfig:  map[string]string{"secret": "secret", "algorithm": "md5"},
+			},
+			wantErr: ErrAuthConfigInvalid,
+		},
+		{
+			name: "hmac template without signature",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "hmac",
+				AuthConfig:  map[string]string{"secret": "secret", "header_template": "{timestamp}"},
+			},
+			wantErr: ErrAuthConfigInvalid,
+		},
+		{
+			name: "tls_config min_version is valid",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				TLSConfig:   map[string]string{"min_version": "1.3", "server_name": "api.internal"},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "tls_config unknown min_version",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				TLSConfig:   map[string]string{"min_version": "1.4"},
+			},
+			wantErr: ErrTLSConfigInvalid,
+		},
+		{
+			name: "tls_config client_cert without key",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				TLSConfig:   map[string]string{"client_cert": "-----BEGIN CERTIFICATE-----"},
+			},
+			wantErr: ErrTLSConfigInvalid,
+		},
+		{
+			name: "tls_config unparseable ca_bundle",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				TLSConfig:   map[string]string{"ca_bundle": "not a certificate"},
+			},
+			wantErr: ErrTLSConfigInvalid,
+		},
+		{
+			name: "auth_type aws_sigv4 is valid",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "aws_sigv4",
+				AuthConfig: map[string]string{
+					"access_key_id":     "AKIDEXAMPLE",
+					"secret_access_key": "secret",
+					"region":            "us-east-1",
+					"service":           "execute-api",
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "aws_sigv4 without region",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "aws_sigv4",
+				AuthConfig: map[string]string{
+					"access_key_id":     "AKIDEXAMPLE",
+					"secret_access_key": "secret",
+					"service":           "execute-api",
+				},
+			},
+			wantErr: ErrAuthConfigInvalid,
+		},
+		{
+			name: "oauth2 without client_secret",
+			input: CreateToolInput{
+				Name:        "my-tool",
+				Description: "A useful tool",
+				Endpoint:    "https://api.example.com/v1",
+				AuthType:    "oauth2_client_credentials",
+				AuthConfig:  map[string]string{"token_url": "https://auth.example.com/token", "client_id": "client"},
+			},
+			wantErr: ErrAuthConfigInvalid,
+		},
+		{
+			name: "weekly budget window in a time zone",
+			input: CreateToolInput{
+				Name:         "my-tool",
+				Description:  "A useful tool",
+				Endpoint:     "https://api.example.com/v1",
+				BudgetWindow: "weekly@Europe/London",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "rolling budget window",
+			input: CreateToolInput{
+				Name:         "my-tool",
+				Description:  "A useful tool",
+				Endpoint:     "https://api.example.com/v1",
+				BudgetWindow: "rolling:36h",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "budget window anchored on a day of the month",
+			input: CreateToolInput{
+				Name:         "my-tool",
+				Description:  "A useful tool",
+				Endpoint:     "https://api.example.com/v1",
+				BudgetWindow: "monthly:15@America/New_York",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "unknown budget window",
+			input: CreateToolInput{
+				Name:         "my-tool",
+				Description:  "A useful tool",
+				Endpoint:     "https://api.example.com/v1",
+				BudgetWindow: "yearly",
+			},
+			wantErr: ErrBudgetWindowInvalid,
+		},
+		{
+			name: "budget window in an unknown time zone",
+			input: CreateToolInput{
+				Name:         "my-tool",
+				Description:  "A useful tool",
+				Endpoint:     "https://api.example.com/v1",
+				BudgetWindow: "daily@Mars/Olympus_Mons",
+			},
+			wantErr: ErrBudgetWindowInvalid,
+		},
+		{
+			name: "rolling budget window over 31 days",
+			input: CreateToolInput{
+				Name:         "my-tool",
+				Description:  "A useful tool",
+				Endpoint:     "https://api.example.com/v1",
+				BudgetWindow: "rolling:32d",
+			},
+			wantErr: ErrBudgetWindowInvalid,
+		},
 	}
 
 	for _, tt := range tests {
@@ -194,7 +428,7 @@ func TestValidateUpdate(t *testing.T) {
 		{
 			name: "invalid auth_type update",
 			input: UpdateToolInput{
-				AuthType: strPtr("basic"),
+				AuthType: strPtr("digest"),
 			},
 			wantErr: ErrAuthTypeInvalid,
 		},
@@ -317,6 +551,114 @@ func TestServiceCreateValidation(t *testing.T) {
 			},
 			wantErr: ErrAuthTypeInvalid,
 		},
+		{
+			name: "rejects too many retry attempts",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Endpoint:    "https://example.com",
+				RetryPolicy: RetryPolicy{MaxAttempts: MaxRetryAttempts + 1},
+			},
+			wantErr: ErrRetryPolicyInvalid,
+		},
+		{
+			name: "rejects retry on timeout",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Endpoint:    "https://example.com",
+				RetryPolicy: RetryPolicy{MaxAttempts: 3, RetryOnErrors: []string{"timeout"}},
+			},
+			wantErr: ErrRetryPolicyInvalid,
+		},
+		{
+			name: "rejects invalid target url",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Targets:     []Target{{URL: "https://a.example.com"}, {URL: "not-a-url"}},
+			},
+			wantErr: ErrTargetsInvalid,
+		},
+		{
+			name: "rejects negative target weight",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Targets:     []Target{{URL: "https://a.example.com", Weight: -1}},
+			},
+			wantErr: ErrTargetsInvalid,
+		},
+		{
+			name: "rejects unknown lb_strategy",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Endpoint:    "https://example.com",
+				LBStrategy:  "random",
+			},
+			wantErr: ErrLBStrategyInvalid,
+		},
+		{
+			name: "rejects unknown cache scope",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Endpoint:    "https://example.com",
+				CachePolicy: CachePolicy{Enabled: true, Scope: "global"},
+			},
+			wantErr: ErrCachePolicyInvalid,
+		},
+		{
+			name: "rejects authorization as cache vary header",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Endpoint:    "https://example.com",
+				CachePolicy: CachePolicy{Enabled: true, VaryHeaders: []string{"authorization"}},
+			},
+			wantErr: ErrCachePolicyInvalid,
+		},
+		{
+			name: "rejects negative max_concurrency",
+			input: CreateToolInput{
+				Name:           "tool",
+				Description:    "desc",
+				Endpoint:       "https://example.com",
+				MaxConcurrency: -1,
+			},
+			wantErr: ErrConcurrencyInvalid,
+		},
+		{
+			name: "rejects negative cost_estimate",
+			input: CreateToolInput{
+				Name:         "tool",
+				Description:  "desc",
+				Endpoint:     "https://example.com",
+				CostEstimate: -0.5,
+			},
+			wantErr: ErrCostEstimateInvalid,
+		},
+		{
+			name: "rejects rate_limit_wait_ms above max",
+			input: CreateToolInput{
+				Name:            "tool",
+				Description:     "desc",
+				Endpoint:        "https://example.com",
+				RateLimitWaitMs: 60001,
+			},
+			wantErr: ErrRateWaitInvalid,
+		},
+		{
+			name: "rejects duplicate rate_limit_windows",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Endpoint:    "https://example.com",
+				RateWindows: []RateWindow{{Limit: 10, WindowSeconds: 1}, {Limit: 20, WindowSeconds: 1}},
+			},
+			wantErr: ErrRateWindowsInvalid,
+		},
 	}
 
 	for _, tt := range tests {
@@ -357,6 +699,61 @@ func TestServiceUpdateValidation(t *testing.T) {
 			input:   UpdateToolInput{AuthType: strPtr("unknown")},
 			wantErr: ErrAuthTypeInvalid,
 		},
+		{
+			name:    "rejects retry status outside 4xx/5xx",
+			input:   UpdateToolInput{RetryPolicy: &RetryPolicy{MaxAttempts: 2, RetryOnStatus: []int{200}}},
+			wantErr: ErrRetryPolicyInvalid,
+		},
+		{
+			name:    "rejects initial backoff above max",
+			input:   UpdateToolInput{RetryPolicy: &RetryPolicy{MaxAttempts: 2, InitialBackoffMs: 500, MaxBackoffMs: 100}},
+			wantErr: ErrRetryPolicyInvalid,
+		},
+		{
+			name:    "rejects unknown lb_strategy",
+			input:   UpdateToolInput{LBStrategy: strPtr("random")},
+			wantErr: ErrLBStrategyInvalid,
+		},
+		{
+			name:    "rejects health_path without leading slash",
+			input:   UpdateToolInput{HealthPath: strPtr("healthz")},
+			wantErr: ErrHealthPathInvalid,
+		},
+		{
+			name:    "rejects absolute health_path url",
+			input:   UpdateToolInput{HealthPath: strPtr("//evil.example.com/health")},
+			wantErr: ErrHealthPathInvalid,
+		},
+		{
+			name:    "rejects cache ttl above max",
+			input:   UpdateToolInput{CachePolicy: &CachePolicy{Enabled: true, TTLSeconds: MaxCacheTTLSeconds + 1}},
+			wantErr: ErrCachePolicyInvalid,
+		},
+		{
+			name:    "rejects negative max_concurrency",
+			input:   UpdateToolInput{MaxConcurrency: intPtr(-1)},
+			wantErr: ErrConcurrencyInvalid,
+		},
+		{
+			name:    "rejects negative cost_estimate",
+			input:   UpdateToolInput{CostEstimate: float64Ptr(-1)},
+			wantErr: ErrCostEstimateInvalid,
+		},
+		{
+			name:    "rejects negative rate_limit_wait_ms",
+			input:   UpdateToolInput{RateLimitWaitMs: intPtr(-1)},
+			wantErr: ErrRateWaitInvalid,
+		},
+		{
+			name:    "rejects rate_limit_windows without a limit",
+			input:   UpdateToolInput{RateWindows: &[]RateWindow{{WindowSeconds: 86400}}},
+			wantErr: ErrRateWindowsInvalid,
+		},
+		{
+			name:    "rejects budget_window anchored past the end of a month",
+			input:   UpdateToolInput{BudgetWindow: strPtr("monthly:32")},
+			wantErr: ErrBudgetWindowInvalid,
+		},
 	}
 
 	for _, tt := range tests {
@@ -369,6 +766,62 @@ func TestServiceUpdateValidation(t *testing.T) {
 	}
 }
 
+func TestServiceCreateEgressDenied(t *testing.T) {
+	policy, err := egress.New(config.EgressConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(nil)
+	svc.SetEgressPolicy(policy)
+
+	tests := []struct {
+		name  string
+		input CreateToolInput
+	}{
+		{
+			name: "loopback endpoint",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Endpoint:    "http://127.0.0.1:9000",
+			},
+		},
+		{
+			name: "api mode resolves to metadata address",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Mode:        "api",
+				Endpoint:    "http://{host}/latest",
+				Variables:   map[string]string{"host": "169.254.169.254"},
+			},
+		},
+		{
+			name: "private oauth2 token url",
+			input: CreateToolInput{
+				Name:        "tool",
+				Description: "desc",
+				Endpoint:    "https://93.184.216.34",
+				AuthType:    "oauth2_client_credentials",
+				AuthConfig: map[string]string{
+					"token_url":     "http://10.0.0.5/token",
+					"client_id":     "id",
+					"client_secret": "secret",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.Create(context.Background(), tt.input)
+			if !errors.Is(err, ErrEndpointDenied) {
+				t.Errorf("Service.Create() error = %v, want ErrEndpointDenied", err)
+			}
+		})
+	}
+}
+
 func mustParseTime(t *testing.T, s string) time.Time {
 	t.Helper()
 	ts, err := time.Parse(time.RFC3339Nano, s)
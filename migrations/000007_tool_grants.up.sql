@@ -0,0 +1,11 @@
+CREATE TABLE tool_grants (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tool_id UUID NOT NULL REFERENCES tools(id) ON DELETE CASCADE,
+    scope TEXT NOT NULL CHECK (scope IN ('team', 'agent')),
+    scope_id TEXT NOT NULL,
+    effect TEXT NOT NULL CHECK (effect IN ('allow', 'deny')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE(tool_id, scope, scope_id)
+);
+
+CREATE INDEX idx_tool_grants_tool ON tool_grants(tool_id);
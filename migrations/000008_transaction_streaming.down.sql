@@ -0,0 +1,3 @@
+ALTER TABLE transactions DROP COLUMN IF EXISTS streamed;
+ALTER TABLE transactions DROP COLUMN IF EXISTS duration_ms;
+ALTER TABLE transactions DROP COLUMN IF EXISTS ttfb_ms;
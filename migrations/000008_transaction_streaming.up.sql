@@ -0,0 +1,3 @@
+ALTER TABLE transactions ADD COLUMN ttfb_ms BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN duration_ms BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN streamed BOOLEAN NOT NULL DEFAULT false;
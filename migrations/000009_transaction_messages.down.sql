@@ -0,0 +1,2 @@
+ALTER TABLE transactions DROP COLUMN IF EXISTS messages_out;
+ALTER TABLE transactions DROP COLUMN IF EXISTS messages_in;
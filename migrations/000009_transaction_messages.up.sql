@@ -0,0 +1,2 @@
+ALTER TABLE transactions ADD COLUMN messages_in BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN messages_out BIGINT NOT NULL DEFAULT 0;
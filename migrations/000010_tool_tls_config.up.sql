@@ -0,0 +1 @@
+ALTER TABLE tools ADD COLUMN tls_config TEXT NOT NULL DEFAULT '';
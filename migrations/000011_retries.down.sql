@@ -0,0 +1,2 @@
+ALTER TABLE transactions DROP COLUMN IF EXISTS attempts;
+ALTER TABLE tools DROP COLUMN IF EXISTS retry_policy;
@@ -0,0 +1,2 @@
+ALTER TABLE tools ADD COLUMN retry_policy JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE transactions ADD COLUMN attempts INT NOT NULL DEFAULT 1;
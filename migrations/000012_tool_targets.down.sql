@@ -0,0 +1,3 @@
+ALTER TABLE transactions DROP COLUMN IF EXISTS target;
+ALTER TABLE tools DROP COLUMN IF EXISTS lb_strategy;
+ALTER TABLE tools DROP COLUMN IF EXISTS targets;
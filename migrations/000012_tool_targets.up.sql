@@ -0,0 +1,3 @@
+ALTER TABLE tools ADD COLUMN targets JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE tools ADD COLUMN lb_strategy TEXT NOT NULL DEFAULT 'round_robin';
+ALTER TABLE transactions ADD COLUMN target TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tool_health_checks;
+ALTER TABLE tools DROP COLUMN IF EXISTS health_path;
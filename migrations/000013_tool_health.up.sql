@@ -0,0 +1,13 @@
+ALTER TABLE tools ADD COLUMN health_path TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE tool_health_checks (
+    id BIGSERIAL PRIMARY KEY,
+    tool_id UUID NOT NULL REFERENCES tools(id) ON DELETE CASCADE,
+    checked_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    up BOOLEAN NOT NULL,
+    status_code INT NOT NULL DEFAULT 0,
+    latency_ms BIGINT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_tool_health_checks_tool_time ON tool_health_checks(tool_id, checked_at DESC);
@@ -0,0 +1 @@
+ALTER TABLE tools ADD COLUMN cache_policy JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+ALTER TABLE transactions DROP COLUMN IF EXISTS coalesced;
+ALTER TABLE tools DROP COLUMN IF EXISTS coalesce_requests;
@@ -0,0 +1,2 @@
+ALTER TABLE tools ADD COLUMN coalesce_requests BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE transactions ADD COLUMN coalesced BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tool_concurrency_limits;
+ALTER TABLE tools DROP COLUMN IF EXISTS max_concurrency;
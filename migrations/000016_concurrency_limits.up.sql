@@ -0,0 +1,12 @@
+ALTER TABLE tools ADD COLUMN max_concurrency INT NOT NULL DEFAULT 0;
+
+CREATE TABLE tool_concurrency_limits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tool_id UUID NOT NULL REFERENCES tools(id) ON DELETE CASCADE,
+    scope TEXT NOT NULL CHECK (scope IN ('team', 'agent')),
+    scope_id TEXT NOT NULL,
+    max_concurrency INT NOT NULL CHECK (max_concurrency > 0),
+    UNIQUE(tool_id, scope, scope_id)
+);
+
+CREATE INDEX idx_tool_concurrency_limits_tool ON tool_concurrency_limits(tool_id);
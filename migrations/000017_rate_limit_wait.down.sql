@@ -0,0 +1,2 @@
+ALTER TABLE tools DROP COLUMN IF EXISTS rate_limit_wait_ms;
+ALTER TABLE agents DROP COLUMN IF EXISTS rate_limit_wait_ms;
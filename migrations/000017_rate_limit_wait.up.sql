@@ -0,0 +1,2 @@
+ALTER TABLE agents ADD COLUMN rate_limit_wait_ms INT NOT NULL DEFAULT 0;
+ALTER TABLE tools ADD COLUMN rate_limit_wait_ms INT NOT NULL DEFAULT 0;
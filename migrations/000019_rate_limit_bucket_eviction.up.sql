@@ -0,0 +1,3 @@
+ALTER TABLE rate_limit_buckets ADD COLUMN rate INT NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_rate_limit_buckets_updated_at ON rate_limit_buckets(updated_at);
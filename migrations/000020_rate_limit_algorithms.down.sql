@@ -0,0 +1,5 @@
+ALTER TABLE rate_limit_buckets DROP COLUMN IF EXISTS prev;
+ALTER TABLE rate_limit_buckets DROP COLUMN IF EXISTS burst;
+ALTER TABLE rate_limit_buckets DROP COLUMN IF EXISTS algorithm;
+ALTER TABLE tool_rate_limits DROP COLUMN IF EXISTS burst;
+ALTER TABLE tool_rate_limits DROP COLUMN IF EXISTS algorithm;
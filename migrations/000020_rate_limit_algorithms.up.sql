@@ -0,0 +1,10 @@
+ALTER TABLE tool_rate_limits
+    ADD COLUMN algorithm TEXT CHECK (algorithm IN ('token_bucket', 'sliding_window', 'gcra')),
+    ADD COLUMN burst INT CHECK (burst > 0);
+
+ALTER TABLE rate_limit_buckets
+    ADD COLUMN algorithm TEXT NOT NULL DEFAULT 'token_bucket',
+    ADD COLUMN burst INT NOT NULL DEFAULT 0,
+    ADD COLUMN prev DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+UPDATE rate_limit_buckets SET burst = rate;
@@ -0,0 +1,8 @@
+ALTER TABLE rate_limit_buckets DROP COLUMN IF EXISTS window_secs;
+
+DELETE FROM tool_rate_limits WHERE rate_limit = 0;
+ALTER TABLE tool_rate_limits DROP CONSTRAINT IF EXISTS tool_rate_limits_rate_limit_check;
+ALTER TABLE tool_rate_limits ADD CONSTRAINT tool_rate_limits_rate_limit_check CHECK (rate_limit > 0);
+ALTER TABLE tool_rate_limits DROP COLUMN IF EXISTS windows;
+
+ALTER TABLE tools DROP COLUMN IF EXISTS rate_limit_windows;
@@ -0,0 +1,8 @@
+ALTER TABLE tools ADD COLUMN rate_limit_windows JSONB NOT NULL DEFAULT '[]';
+
+-- An override may now consist of windows alone.
+ALTER TABLE tool_rate_limits ADD COLUMN windows JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE tool_rate_limits DROP CONSTRAINT tool_rate_limits_rate_limit_check;
+ALTER TABLE tool_rate_limits ADD CONSTRAINT tool_rate_limits_rate_limit_check CHECK (rate_limit >= 0);
+
+ALTER TABLE rate_limit_buckets ADD COLUMN window_secs DOUBLE PRECISION;
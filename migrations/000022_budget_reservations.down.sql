@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS budget_reservations;
+
+ALTER TABLE tools DROP COLUMN IF EXISTS cost_estimate;
@@ -0,0 +1,17 @@
+-- Cost held against budgets before a call is made; 0 uses the flat price.
+ALTER TABLE tools ADD COLUMN cost_estimate NUMERIC(12,6) NOT NULL DEFAULT 0;
+
+-- Spend held for requests in flight. It counts against budgets until the
+-- request's transaction is written, which deletes it, or it expires.
+CREATE TABLE budget_reservations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    tool_id UUID NOT NULL REFERENCES tools(id) ON DELETE CASCADE,
+    amount NUMERIC(12,6) NOT NULL CHECK (amount >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_budget_reservations_agent_tool ON budget_reservations(agent_id, tool_id);
+CREATE INDEX idx_budget_reservations_tool ON budget_reservations(tool_id);
+CREATE INDEX idx_budget_reservations_expires ON budget_reservations(expires_at);
@@ -0,0 +1,3 @@
+DELETE FROM budget_reservations WHERE committed_at IS NOT NULL;
+ALTER TABLE budget_reservations DROP COLUMN IF EXISTS replica;
+ALTER TABLE budget_reservations DROP COLUMN IF EXISTS committed_at;
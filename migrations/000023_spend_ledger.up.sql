@@ -0,0 +1,6 @@
+-- Reservations are kept once their transaction is written, marked committed,
+-- so other replicas count them until their spend ledgers reconcile.
+ALTER TABLE budget_reservations ADD COLUMN committed_at TIMESTAMPTZ;
+ALTER TABLE budget_reservations ADD COLUMN replica TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_budget_reservations_committed ON budget_reservations(committed_at);
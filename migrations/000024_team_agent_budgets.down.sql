@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_agents_team;
+DROP TABLE IF EXISTS team_budgets;
+DROP TABLE IF EXISTS agent_budgets;
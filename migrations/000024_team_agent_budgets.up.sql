@@ -0,0 +1,15 @@
+-- Spending limits for an agent across all of its tools.
+CREATE TABLE agent_budgets (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    daily_limit NUMERIC(12,6) NOT NULL DEFAULT 0,
+    monthly_limit NUMERIC(12,6) NOT NULL DEFAULT 0
+);
+
+-- Spending limits for all of a team's agents across all tools.
+CREATE TABLE team_budgets (
+    team TEXT PRIMARY KEY CHECK (team <> ''),
+    daily_limit NUMERIC(12,6) NOT NULL DEFAULT 0,
+    monthly_limit NUMERIC(12,6) NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_agents_team ON agents(team);
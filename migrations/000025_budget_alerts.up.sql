@@ -0,0 +1,11 @@
+-- Warning thresholds already reached per budget and window, so each alert is
+-- raised once across all replicas.
+CREATE TABLE budget_alerts (
+    budget TEXT NOT NULL,
+    window_start TIMESTAMPTZ NOT NULL,
+    threshold INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (budget, window_start, threshold)
+);
+
+CREATE INDEX idx_budget_alerts_window_start ON budget_alerts(window_start);
@@ -0,0 +1,3 @@
+ALTER TABLE team_budgets DROP COLUMN IF EXISTS budget_window, DROP COLUMN IF EXISTS window_limit;
+ALTER TABLE agent_budgets DROP COLUMN IF EXISTS budget_window, DROP COLUMN IF EXISTS window_limit;
+ALTER TABLE agent_tool_budgets DROP COLUMN IF EXISTS budget_window, DROP COLUMN IF EXISTS window_limit;
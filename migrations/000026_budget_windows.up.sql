@@ -0,0 +1,18 @@
+-- Tool budget windows other than daily and monthly used to count as
+-- monthly. They are now rejected, so make the old behaviour explicit.
+UPDATE tools SET budget_window = 'monthly'
+WHERE budget_window IS NOT NULL AND budget_window NOT IN ('', 'daily', 'monthly');
+
+-- A limit over a configurable window (weekly, rolling, anchored monthly or
+-- in a time zone) alongside the daily and monthly limits.
+ALTER TABLE agent_tool_budgets
+    ADD COLUMN window_limit NUMERIC(12,6) NOT NULL DEFAULT 0,
+    ADD COLUMN budget_window TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE agent_budgets
+    ADD COLUMN window_limit NUMERIC(12,6) NOT NULL DEFAULT 0,
+    ADD COLUMN budget_window TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE team_budgets
+    ADD COLUMN window_limit NUMERIC(12,6) NOT NULL DEFAULT 0,
+    ADD COLUMN budget_window TEXT NOT NULL DEFAULT '';
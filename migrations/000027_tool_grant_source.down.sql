@@ -0,0 +1 @@
+ALTER TABLE tool_grants DROP COLUMN IF EXISTS source;
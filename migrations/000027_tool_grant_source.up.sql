@@ -0,0 +1,5 @@
+-- Who set each grant: an org admin through the admin API, or a team admin
+-- through the member API. Team admins may only remove grants they set, so
+-- grants set before this are treated as the org admins'.
+ALTER TABLE tool_grants
+    ADD COLUMN source TEXT NOT NULL DEFAULT 'admin' CHECK (source IN ('admin', 'member'));